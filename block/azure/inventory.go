@@ -0,0 +1,161 @@
+package azure
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/Azure/azure-storage-blob-go/azblob"
+	"github.com/treeverse/lakefs/block"
+	"github.com/treeverse/lakefs/block/inventory"
+	inventoryazure "github.com/treeverse/lakefs/inventory/azure"
+	inventorys3 "github.com/treeverse/lakefs/inventory/s3"
+	"github.com/treeverse/lakefs/logging"
+)
+
+const (
+	BlockstoreType      = "azure"
+	manifestReadRetries = 3
+)
+
+var (
+	ErrInventoryNotSorted       = errors.New("got unsorted azure inventory")
+	ErrInvalidInventoryURL      = errors.New("invalid azure inventory manifest URL")
+	ErrUnknownSourceContainer   = errors.New("azure inventory manifest has no source container")
+	ErrMultipleSourceContainers = errors.New("azure inventory manifest covers several containers")
+)
+
+// Manifest describes a blob inventory of Azure Blob Storage. Its inventory files are stored in the container of the
+// manifest, and list the blobs of a single source container.
+type Manifest struct {
+	URL                string
+	SourceContainer    string
+	Files              []inventoryFile
+	Format             string
+	FileSchema         string
+	inventoryContainer string
+}
+
+type inventoryFile struct {
+	Blob string `json:"blob"`
+	Size int64  `json:"size"`
+}
+
+// manifestFile is the manifest written by Azure next to the inventory files of each run of an inventory rule.
+type manifestFile struct {
+	Files          []inventoryFile `json:"files"`
+	RuleDefinition struct {
+		Filters struct {
+			PrefixMatch []string `json:"prefixMatch"`
+		} `json:"filters"`
+		Format       string   `json:"format"`
+		SchemaFields []string `json:"schemaFields"`
+	} `json:"ruleDefinition"`
+}
+
+// GenerateInventory reads the inventory described by the manifest found at manifestURL, an azure://container/blob URL
+// of a container of the account of serviceURL. There is no Azure block adapter to generate it, so blobs are imported
+// from Azure inventories by calling GenerateInventory directly. Its files are downloaded to local temporary files,
+// removed once read, and parsed by the readers of S3 inventories. If shouldSort is set, the objects of all files are
+// iterated in key order. Iterating stops with the error of ctx once it is done.
+func GenerateInventory(ctx context.Context, logger logging.Logger, serviceURL azblob.ServiceURL, manifestURL string, shouldSort bool) (block.Inventory, error) {
+	if logger == nil {
+		logger = logging.Default()
+	}
+	m, err := loadManifest(ctx, serviceURL, manifestURL)
+	if err != nil {
+		return nil, err
+	}
+	return &Inventory{
+		Manifest:   m,
+		ctx:        ctx,
+		logger:     logger,
+		shouldSort: shouldSort,
+		reader:     inventoryazure.NewReader(ctx, serviceURL, m.SourceContainer, logger),
+	}, nil
+}
+
+func loadManifest(ctx context.Context, serviceURL azblob.ServiceURL, manifestURL string) (*Manifest, error) {
+	u, err := url.Parse(manifestURL)
+	if err != nil {
+		return nil, err
+	}
+	blob := strings.TrimPrefix(u.Path, "/")
+	if u.Scheme != BlockstoreType || u.Host == "" || blob == "" {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidInventoryURL, manifestURL)
+	}
+	res, err := serviceURL.NewContainerURL(u.Host).NewBlobURL(blob).Download(ctx, 0, azblob.CountToEnd, azblob.BlobAccessConditions{}, false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest from %s: %w", manifestURL, err)
+	}
+	body := res.Body(azblob.RetryReaderOptions{MaxRetryRequests: manifestReadRetries})
+	defer func() {
+		_ = body.Close()
+	}()
+	var mf manifestFile
+	if err = json.NewDecoder(body).Decode(&mf); err != nil {
+		return nil, fmt.Errorf("failed to decode manifest from %s: %w", manifestURL, err)
+	}
+	format := inventorys3.NormalizeFormat(mf.RuleDefinition.Format)
+	if format != inventorys3.ParquetFormatName && format != inventorys3.OrcFormatName {
+		return nil, fmt.Errorf("%w. got format: %s", inventorys3.ErrUnsupportedInventoryFormat, mf.RuleDefinition.Format)
+	}
+	sourceContainer, err := manifestSourceContainer(mf.RuleDefinition.Filters.PrefixMatch)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", err, manifestURL)
+	}
+	return &Manifest{
+		URL:                manifestURL,
+		SourceContainer:    sourceContainer,
+		Files:              mf.Files,
+		Format:             format,
+		FileSchema:         strings.Join(mf.RuleDefinition.SchemaFields, ", "),
+		inventoryContainer: u.Host,
+	}, nil
+}
+
+// manifestSourceContainer returns the container listed by an inventory rule. Azure inventories have no container
+// column, so it is read from the prefixes matched by the rule, each starting with the name of a container.
+func manifestSourceContainer(prefixes []string) (string, error) {
+	var container string
+	for _, prefix := range prefixes {
+		c := strings.SplitN(prefix, "/", 2)[0]
+		if container != "" && c != container {
+			return "", ErrMultipleSourceContainers
+		}
+		container = c
+	}
+	if container == "" {
+		return "", ErrUnknownSourceContainer
+	}
+	return container, nil
+}
+
+type Inventory struct {
+	Manifest   *Manifest
+	ctx        context.Context
+	logger     logging.Logger
+	shouldSort bool
+	reader     inventorys3.IReader
+}
+
+func (inv *Inventory) Iterator() block.InventoryIterator {
+	keys := make([]string, len(inv.Manifest.Files))
+	for i, f := range inv.Manifest.Files {
+		keys[i] = f.Blob
+	}
+	it := inventorys3.NewInventoryIterator(inv.reader, inv.Manifest.Format, inv.Manifest.FileSchema,
+		inv.Manifest.inventoryContainer, keys, inventorys3.WithSorted(inv.shouldSort))
+	return inventory.NewIterator(inv.ctx, it, BlockstoreType, inv.shouldSort, ErrInventoryNotSorted)
+}
+
+func (inv *Inventory) SourceName() string {
+	return inv.Manifest.SourceContainer
+}
+
+func (inv *Inventory) InventoryURL() string {
+	return inv.Manifest.URL
+}
@@ -0,0 +1,197 @@
+package azure_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-storage-blob-go/azblob"
+	"github.com/go-openapi/swag"
+	"github.com/go-test/deep"
+	"github.com/treeverse/lakefs/block/azure"
+	inventorys3 "github.com/treeverse/lakefs/inventory/s3"
+	"github.com/treeverse/lakefs/inventory/testutil"
+	"github.com/treeverse/lakefs/logging"
+	"github.com/xitongsys/parquet-go-source/writerfile"
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+const manifest = `{
+  "destinationContainer": "inventory",
+  "files": [{"blob": "2021/01/01/rule/data1.parquet", "size": 1}, {"blob": "2021/01/01/rule/data2.parquet", "size": 1}],
+  "ruleDefinition": {
+    "filters": {"blobTypes": ["blockBlob"], "prefixMatch": ["source-container/prefix1", "source-container/prefix2"]},
+    "format": "Parquet",
+    "schemaFields": ["Name", "Content-Length", "Last-Modified", "Etag", "IsCurrentVersion", "Deleted"]
+  }
+}`
+
+type azureParquetRow struct {
+	Name             string  `parquet:"name=Name, type=UTF8"`
+	ContentLength    *int64  `parquet:"name=Content-Length, type=INT_64"`
+	LastModified     *int64  `parquet:"name=Last-Modified, type=TIMESTAMP_MILLIS"`
+	Etag             *string `parquet:"name=Etag, type=UTF8"`
+	IsCurrentVersion *bool   `parquet:"name=IsCurrentVersion, type=BOOLEAN"`
+	Deleted          *bool   `parquet:"name=Deleted, type=BOOLEAN"`
+}
+
+var lastModified = time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func azureParquet(t *testing.T, names ...string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	pw, err := writer.NewParquetWriter(writerfile.NewWriterFile(&buf), new(azureParquetRow), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	millis := lastModified.UnixNano() / int64(time.Millisecond)
+	for _, name := range names {
+		row := azureParquetRow{
+			Name:             name,
+			ContentLength:    swag.Int64(10),
+			LastModified:     &millis,
+			Etag:             swag.String("etag-" + name),
+			IsCurrentVersion: swag.Bool(true),
+			Deleted:          swag.Bool(name == "deleted"),
+		}
+		if err = pw.Write(row); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = pw.WriteStop(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func newServiceURL(t *testing.T) azblob.ServiceURL {
+	t.Helper()
+	return testutil.NewFakeAzureServiceURL(t, map[string][]byte{
+		"/inventory/2021/01/01/rule-manifest.json":  []byte(manifest),
+		"/inventory/2021/01/01/rule/data1.parquet":  azureParquet(t, "k2", "deleted", "k4"),
+		"/inventory/2021/01/01/rule/data2.parquet":  azureParquet(t, "k1", "k3"),
+		"/inventory/2021/01/01/bad-manifest.json":   []byte(`{"ruleDefinition": {"format": "Csv", "filters": {"prefixMatch": ["c1"]}}}`),
+		"/inventory/2021/01/01/multi-manifest.json": []byte(`{"ruleDefinition": {"format": "Parquet", "filters": {"prefixMatch": ["c1/a", "c2/b"]}}}`),
+		"/inventory/2021/01/01/any-manifest.json":   []byte(`{"ruleDefinition": {"format": "Parquet"}}`),
+	})
+}
+
+// withTempDir makes the local temporary files of the test be created in a new directory, and returns it.
+func withTempDir(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	prev, ok := os.LookupEnv("TMPDIR")
+	if err := os.Setenv("TMPDIR", dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if ok {
+			_ = os.Setenv("TMPDIR", prev)
+		} else {
+			_ = os.Unsetenv("TMPDIR")
+		}
+	})
+	return dir
+}
+
+func TestGenerateInventory(t *testing.T) {
+	serviceURL := newServiceURL(t)
+	testdata := []struct {
+		name       string
+		shouldSort bool
+		expected   []string
+	}{
+		{name: "unsorted", expected: []string{"azure://source-container/k2", "azure://source-container/k4", "azure://source-container/k1", "azure://source-container/k3"}},
+		{name: "sorted", shouldSort: true, expected: []string{"azure://source-container/k1", "azure://source-container/k2", "azure://source-container/k3", "azure://source-container/k4"}},
+	}
+	for _, test := range testdata {
+		t.Run(test.name, func(t *testing.T) {
+			dir := withTempDir(t)
+			inv, err := azure.GenerateInventory(context.Background(), logging.Default(), serviceURL, "azure://inventory/2021/01/01/rule-manifest.json", test.shouldSort)
+			if err != nil {
+				t.Fatalf("failed to generate inventory: %v", err)
+			}
+			if inv.SourceName() != "source-container" {
+				t.Fatalf("unexpected source name: %s", inv.SourceName())
+			}
+			it := inv.Iterator()
+			var addresses []string
+			for it.Next() {
+				obj := it.Get()
+				addresses = append(addresses, obj.PhysicalAddress)
+				if obj.Size != 10 || obj.Checksum != "etag-"+obj.Key || !obj.LastModified.Equal(lastModified) {
+					t.Fatalf("unexpected object: %+v", obj)
+				}
+			}
+			if it.Err() != nil {
+				t.Fatal(it.Err())
+			}
+			if diff := deep.Equal(addresses, test.expected); diff != nil {
+				t.Fatalf("unexpected objects: %s", diff)
+			}
+			files, err := ioutil.ReadDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(files) != 0 {
+				t.Fatalf("expected local inventory files to be removed, got %d files", len(files))
+			}
+		})
+	}
+}
+
+func TestGenerateInventoryCanceled(t *testing.T) {
+	dir := withTempDir(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	inv, err := azure.GenerateInventory(ctx, logging.Default(), newServiceURL(t), "azure://inventory/2021/01/01/rule-manifest.json", false)
+	if err != nil {
+		t.Fatalf("failed to generate inventory: %v", err)
+	}
+	it := inv.Iterator()
+	if !it.Next() {
+		t.Fatalf("expected an object, got error %v", it.Err())
+	}
+	cancel()
+	for it.Next() {
+	}
+	if !errors.Is(it.Err(), context.Canceled) {
+		t.Fatalf("expected error %v, got %v", context.Canceled, it.Err())
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 0 {
+		t.Fatalf("expected local inventory files to be removed, got %d files", len(files))
+	}
+}
+
+func TestGenerateInventoryErrors(t *testing.T) {
+	serviceURL := newServiceURL(t)
+	testdata := []struct {
+		name        string
+		manifestURL string
+		expectedErr error
+	}{
+		{name: "not azure", manifestURL: "gs://inventory/2021/01/01/rule-manifest.json", expectedErr: azure.ErrInvalidInventoryURL},
+		{name: "no blob", manifestURL: "azure://inventory", expectedErr: azure.ErrInvalidInventoryURL},
+		{name: "unsupported format", manifestURL: "azure://inventory/2021/01/01/bad-manifest.json", expectedErr: inventorys3.ErrUnsupportedInventoryFormat},
+		{name: "several containers", manifestURL: "azure://inventory/2021/01/01/multi-manifest.json", expectedErr: azure.ErrMultipleSourceContainers},
+		{name: "no container", manifestURL: "azure://inventory/2021/01/01/any-manifest.json", expectedErr: azure.ErrUnknownSourceContainer},
+	}
+	for _, test := range testdata {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := azure.GenerateInventory(context.Background(), logging.Default(), serviceURL, test.manifestURL, false)
+			if !errors.Is(err, test.expectedErr) {
+				t.Fatalf("expected error %v, got %v", test.expectedErr, err)
+			}
+		})
+	}
+	if _, err := azure.GenerateInventory(context.Background(), logging.Default(), serviceURL, "azure://inventory/missing/manifest.json", false); err == nil {
+		t.Fatal("expected missing manifest to fail")
+	}
+}
@@ -0,0 +1,113 @@
+package gs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/treeverse/lakefs/block"
+	"github.com/treeverse/lakefs/block/inventory"
+	inventorygs "github.com/treeverse/lakefs/inventory/gs"
+	inventorys3 "github.com/treeverse/lakefs/inventory/s3"
+	"github.com/treeverse/lakefs/logging"
+)
+
+var (
+	ErrInventoryNotSorted  = errors.New("got unsorted gs inventory")
+	ErrInvalidInventoryURL = errors.New("invalid gs inventory manifest URL")
+)
+
+// Manifest describes an inventory stored in Google Cloud Storage. It has the format of the manifest.json of S3
+// inventories, and the inventory files it lists are stored in the bucket of the manifest.
+type Manifest struct {
+	URL             string          `json:"-"`
+	SourceBucket    string          `json:"sourceBucket"`
+	Files           []inventoryFile `json:"files"`
+	Format          string          `json:"fileFormat"`
+	FileSchema      string          `json:"fileSchema"`
+	inventoryBucket string
+}
+
+type inventoryFile struct {
+	Key string `json:"key"`
+}
+
+// GenerateInventory reads the inventory described by the manifest found at manifestURL, a gs://bucket/key URL. Its
+// files are downloaded to local temporary files and parsed by the readers of S3 inventories. If shouldSort is set, the
+// objects of all files are iterated in key order. Iterating stops with the error of ctx once it is done.
+func (a *Adapter) GenerateInventory(ctx context.Context, logger logging.Logger, manifestURL string, shouldSort bool) (block.Inventory, error) {
+	if logger == nil {
+		logger = logging.Default()
+	}
+	m, err := a.loadManifest(ctx, manifestURL)
+	if err != nil {
+		return nil, err
+	}
+	return &Inventory{
+		Manifest:   m,
+		ctx:        ctx,
+		logger:     logger,
+		shouldSort: shouldSort,
+		reader:     inventorygs.NewReader(ctx, a.client, logger),
+	}, nil
+}
+
+func (a *Adapter) loadManifest(ctx context.Context, manifestURL string) (*Manifest, error) {
+	u, err := url.Parse(manifestURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != BlockstoreType || u.Host == "" {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidInventoryURL, manifestURL)
+	}
+	r, err := a.client.Bucket(u.Host).Object(strings.TrimPrefix(u.Path, "/")).NewReader(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest from %s: %w", manifestURL, err)
+	}
+	defer func() {
+		_ = r.Close()
+	}()
+	var m Manifest
+	if err = json.NewDecoder(r).Decode(&m); err != nil {
+		return nil, fmt.Errorf("failed to decode manifest from %s: %w", manifestURL, err)
+	}
+	m.Format = inventorys3.NormalizeFormat(m.Format)
+	switch m.Format {
+	case inventorys3.OrcFormatName, inventorys3.ParquetFormatName, inventorys3.CSVFormatName,
+		inventorys3.AvroFormatName, inventorys3.ApacheAvroFormatName:
+	default:
+		return nil, fmt.Errorf("%w. got format: %s", inventorys3.ErrUnsupportedInventoryFormat, m.Format)
+	}
+	m.URL = manifestURL
+	m.inventoryBucket = u.Host
+	return &m, nil
+}
+
+type Inventory struct {
+	Manifest   *Manifest
+	ctx        context.Context
+	logger     logging.Logger
+	shouldSort bool
+	reader     inventorys3.IReader
+}
+
+func (inv *Inventory) Iterator() block.InventoryIterator {
+	keys := make([]string, len(inv.Manifest.Files))
+	for i, f := range inv.Manifest.Files {
+		keys[i] = f.Key
+	}
+	it := inventorys3.NewInventoryIterator(inv.reader, inv.Manifest.Format, inv.Manifest.FileSchema,
+		inv.Manifest.inventoryBucket, keys, inventorys3.WithSorted(inv.shouldSort))
+	return inventory.NewIterator(inv.ctx, it, BlockstoreType, inv.shouldSort, ErrInventoryNotSorted)
+}
+
+func (inv *Inventory) SourceName() string {
+	return inv.Manifest.SourceBucket
+}
+
+func (inv *Inventory) InventoryURL() string {
+	return inv.Manifest.URL
+}
@@ -0,0 +1,155 @@
+package gs_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-test/deep"
+	"github.com/treeverse/lakefs/block/gs"
+	inventorys3 "github.com/treeverse/lakefs/inventory/s3"
+	"github.com/treeverse/lakefs/inventory/testutil"
+	"github.com/treeverse/lakefs/logging"
+)
+
+const manifest = `{
+  "sourceBucket": "example-source-bucket",
+  "fileFormat": "CSV",
+  "fileSchema": "Bucket, Key, Size, IsLatest",
+  "files": [{"key": "inventory/data1.csv.gz"}, {"key": "inventory/data2.csv.gz"}]
+}`
+
+func gzipped(t *testing.T, rows string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write([]byte(rows)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestGenerateInventory(t *testing.T) {
+	objects := map[string][]byte{
+		"/inventory-bucket/inventory/manifest.json": []byte(manifest),
+		"/inventory-bucket/inventory/data1.csv.gz":  gzipped(t, "\"b\",\"k2\",\"20\",\"true\"\n\"b\",\"k4\",\"40\",\"false\"\n"),
+		"/inventory-bucket/inventory/data2.csv.gz":  gzipped(t, "\"b\",\"k1\",\"10\",\"true\"\n\"b\",\"k3\",\"30\",\"true\"\n"),
+	}
+	adapter := gs.NewAdapter(testutil.NewFakeGCSClient(t, objects))
+	testdata := []struct {
+		name       string
+		shouldSort bool
+		expected   []string
+	}{
+		{name: "unsorted", expected: []string{"gs://b/k2", "gs://b/k1", "gs://b/k3"}},
+		{name: "sorted", shouldSort: true, expected: []string{"gs://b/k1", "gs://b/k2", "gs://b/k3"}},
+	}
+	for _, test := range testdata {
+		t.Run(test.name, func(t *testing.T) {
+			inv, err := adapter.GenerateInventory(context.Background(), logging.Default(), "gs://inventory-bucket/inventory/manifest.json", test.shouldSort)
+			if err != nil {
+				t.Fatalf("failed to generate inventory: %v", err)
+			}
+			if inv.SourceName() != "example-source-bucket" {
+				t.Fatalf("unexpected source name: %s", inv.SourceName())
+			}
+			it := inv.Iterator()
+			var addresses []string
+			var size int64
+			for it.Next() {
+				addresses = append(addresses, it.Get().PhysicalAddress)
+				size += it.Get().Size
+			}
+			if it.Err() != nil {
+				t.Fatal(it.Err())
+			}
+			if diff := deep.Equal(addresses, test.expected); diff != nil {
+				t.Fatalf("unexpected objects: %s", diff)
+			}
+			if size != 60 {
+				t.Fatalf("unexpected total size. expected=60, got=%d", size)
+			}
+		})
+	}
+}
+
+func TestGenerateInventoryErrors(t *testing.T) {
+	adapter := gs.NewAdapter(testutil.NewFakeGCSClient(t, map[string][]byte{
+		"/inventory-bucket/inventory/manifest.json": bytes.Replace([]byte(manifest), []byte(`"CSV"`), []byte(`"JSON"`), 1),
+	}))
+	testdata := []struct {
+		name        string
+		manifestURL string
+		expectedErr error
+	}{
+		{name: "not gs", manifestURL: "s3://inventory-bucket/inventory/manifest.json", expectedErr: gs.ErrInvalidInventoryURL},
+		{name: "unsupported format", manifestURL: "gs://inventory-bucket/inventory/manifest.json", expectedErr: inventorys3.ErrUnsupportedInventoryFormat},
+	}
+	for _, test := range testdata {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := adapter.GenerateInventory(context.Background(), logging.Default(), test.manifestURL, false)
+			if !errors.Is(err, test.expectedErr) {
+				t.Fatalf("expected error %v, got %v", test.expectedErr, err)
+			}
+		})
+	}
+	if _, err := adapter.GenerateInventory(context.Background(), logging.Default(), "gs://inventory-bucket/missing/manifest.json", false); err == nil {
+		t.Fatal("expected missing manifest to fail")
+	}
+}
+
+func TestInventoryIteratorLastModified(t *testing.T) {
+	const lastModifiedManifest = `{
+  "sourceBucket": "example-source-bucket",
+  "fileFormat": "CSV",
+  "fileSchema": "Bucket, Key, Size, LastModifiedDate",
+  "files": [{"key": "inventory/data.csv.gz"}]
+}`
+	adapter := gs.NewAdapter(testutil.NewFakeGCSClient(t, map[string][]byte{
+		"/inventory-bucket/inventory/manifest.json": []byte(lastModifiedManifest),
+		"/inventory-bucket/inventory/data.csv.gz":   gzipped(t, "\"b\",\"k1\",\"10\",\"2021-01-02T03:04:05.678Z\"\n"),
+	}))
+	inv, err := adapter.GenerateInventory(context.Background(), logging.Default(), "gs://inventory-bucket/inventory/manifest.json", false)
+	if err != nil {
+		t.Fatalf("failed to generate inventory: %v", err)
+	}
+	it := inv.Iterator()
+	if !it.Next() {
+		t.Fatalf("expected an object, got error %v", it.Err())
+	}
+	expected := time.Date(2021, 1, 2, 3, 4, 5, 678*int(time.Millisecond), time.UTC)
+	if !it.Get().LastModified.Equal(expected) {
+		t.Fatalf("unexpected last modified time. expected=%s, got=%s", expected, it.Get().LastModified)
+	}
+}
+
+func TestInventoryIteratorCancel(t *testing.T) {
+	adapter := gs.NewAdapter(testutil.NewFakeGCSClient(t, map[string][]byte{
+		"/inventory-bucket/inventory/manifest.json": []byte(manifest),
+		"/inventory-bucket/inventory/data1.csv.gz":  gzipped(t, "\"b\",\"k2\",\"20\",\"true\"\n\"b\",\"k4\",\"40\",\"true\"\n"),
+		"/inventory-bucket/inventory/data2.csv.gz":  gzipped(t, "\"b\",\"k1\",\"10\",\"true\"\n"),
+	}))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	inv, err := adapter.GenerateInventory(ctx, logging.Default(), "gs://inventory-bucket/inventory/manifest.json", false)
+	if err != nil {
+		t.Fatalf("failed to generate inventory: %v", err)
+	}
+	it := inv.Iterator()
+	if !it.Next() {
+		t.Fatalf("expected an object, got error %v", it.Err())
+	}
+	cancel()
+	if it.Next() {
+		t.Fatalf("expected iteration to stop once cancelled, got %s", it.Get().Key)
+	}
+	if !errors.Is(it.Err(), context.Canceled) {
+		t.Fatalf("expected error %v, got %v", context.Canceled, it.Err())
+	}
+}
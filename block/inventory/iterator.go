@@ -0,0 +1,96 @@
+// Package inventory holds the iteration shared by the block adapters whose inventories are read by the inventory
+// readers of package inventory/s3.
+package inventory
+
+import (
+	"context"
+	"time"
+
+	"github.com/treeverse/lakefs/block"
+	"github.com/treeverse/lakefs/cmdutils"
+	inventorys3 "github.com/treeverse/lakefs/inventory/s3"
+)
+
+// Iterator iterates over the current versions of the objects read by an inventory iterator. The files of the
+// inventory are released once all their objects are iterated, or on error.
+type Iterator struct {
+	ctx            context.Context
+	it             *inventorys3.InventoryIterator
+	blockstoreType string
+	shouldSort     bool
+	errNotSorted   error
+	err            error
+	val            *block.InventoryObject
+	progress       *cmdutils.Progress
+}
+
+// NewIterator returns an iterator over the objects read by it, whose physical addresses are blockstoreType URLs.
+// Iterating stops with the error of ctx once it is done. If shouldSort is set, objects must be read in key order, and
+// an object with a smaller key than the previous one stops iterating with errNotSorted.
+func NewIterator(ctx context.Context, it *inventorys3.InventoryIterator, blockstoreType string, shouldSort bool, errNotSorted error) *Iterator {
+	return &Iterator{
+		ctx:            ctx,
+		it:             it,
+		blockstoreType: blockstoreType,
+		shouldSort:     shouldSort,
+		errNotSorted:   errNotSorted,
+		progress:       cmdutils.NewProgress("Inventory Objects Read", 0),
+	}
+}
+
+func (it *Iterator) Next() bool {
+	if it.err != nil {
+		return false
+	}
+	for it.it.Next() {
+		if err := it.ctx.Err(); err != nil {
+			it.err = err
+			_ = it.it.Close()
+			return false
+		}
+		obj := it.it.Get()
+		if (obj.IsLatest != nil && !*obj.IsLatest) ||
+			(obj.IsDeleteMarker != nil && *obj.IsDeleteMarker) {
+			continue
+		}
+		if it.shouldSort && it.val != nil && obj.Key < it.val.Key {
+			it.err = it.errNotSorted
+			_ = it.it.Close()
+			return false
+		}
+		res := block.InventoryObject{
+			Bucket:          obj.Bucket,
+			Key:             obj.Key,
+			PhysicalAddress: it.blockstoreType + "://" + obj.Bucket + "/" + obj.Key,
+		}
+		if obj.Size != nil {
+			res.Size = *obj.Size
+		}
+		if obj.LastModifiedMillis != nil {
+			res.LastModified = time.Unix(0, *obj.LastModifiedMillis*int64(time.Millisecond))
+		}
+		if obj.Checksum != nil {
+			res.Checksum = *obj.Checksum
+		}
+		it.progress.Incr()
+		it.val = &res
+		return true
+	}
+	it.err = it.it.Err()
+	if err := it.it.Close(); it.err == nil {
+		it.err = err
+	}
+	return false
+}
+
+func (it *Iterator) Err() error {
+	return it.err
+}
+
+func (it *Iterator) Get() *block.InventoryObject {
+	return it.val
+}
+
+func (it *Iterator) Progress() []*cmdutils.Progress {
+	return []*cmdutils.Progress{it.progress}
+}
@@ -51,6 +51,7 @@ type Adapter struct {
 	uploadIDTranslator    block.UploadIDTranslator
 	streamingChunkSize    int
 	streamingChunkTimeout time.Duration
+	verifyManifest        bool // verify inventory manifests against their manifest.checksum
 }
 
 func WithHTTPClient(c *http.Client) func(a *Adapter) {
@@ -83,6 +84,14 @@ func WithTranslator(t block.UploadIDTranslator) func(a *Adapter) {
 	}
 }
 
+// WithVerifyManifestChecksum sets whether inventory manifests are verified against the MD5 that S3 writes to the
+// manifest.checksum next to them. It is off by default.
+func WithVerifyManifestChecksum(v bool) func(a *Adapter) {
+	return func(a *Adapter) {
+		a.verifyManifest = v
+	}
+}
+
 func NewAdapter(s3 s3iface.S3API, opts ...func(a *Adapter)) *Adapter {
 	a := &Adapter{
 		s3:                    s3,
@@ -106,6 +115,7 @@ func (a *Adapter) WithContext(ctx context.Context) block.Adapter {
 		uploadIDTranslator:    a.uploadIDTranslator,
 		streamingChunkSize:    a.streamingChunkSize,
 		streamingChunkTimeout: a.streamingChunkTimeout,
+		verifyManifest:        a.verifyManifest,
 	}
 }
 
@@ -0,0 +1,111 @@
+package s3
+
+import (
+	"context"
+
+	inventorys3 "github.com/treeverse/lakefs/inventory/s3"
+)
+
+// diffCursor iterates the current, non-deleted objects of an inventory, checking that they are sorted by key.
+type diffCursor struct {
+	it  *inventorys3.InventoryIterator
+	obj *inventorys3.InventoryObject
+	err error
+}
+
+func newDiffCursor(inv *Inventory) *diffCursor {
+	it := inventorys3.NewInventoryIterator(inv.reader, inv.Manifest.Format, inv.Manifest.FileSchema, inv.Manifest.inventoryBucket, inv.FileKeys())
+	c := &diffCursor{it: it}
+	c.next()
+	return c
+}
+
+// next moves the cursor to the following object, leaving obj nil once the inventory is done or on failure.
+func (c *diffCursor) next() {
+	prev := c.obj
+	c.obj = nil
+	for c.it.Next() {
+		obj := c.it.Get()
+		if (obj.IsLatest != nil && !*obj.IsLatest) || (obj.IsDeleteMarker != nil && *obj.IsDeleteMarker) {
+			continue
+		}
+		if prev != nil && obj.Key < prev.Key {
+			c.err = ErrInventoryNotSorted
+			return
+		}
+		c.obj = &obj
+		return
+	}
+	c.err = c.it.Err()
+}
+
+// objectChanged reports whether the etag or the size of an object differs between two inventories.
+func objectChanged(oldObj, newObj *inventorys3.InventoryObject) bool {
+	if (oldObj.Checksum == nil) != (newObj.Checksum == nil) || (oldObj.Checksum != nil && *oldObj.Checksum != *newObj.Checksum) {
+		return true
+	}
+	return (oldObj.Size == nil) != (newObj.Size == nil) || (oldObj.Size != nil && *oldObj.Size != *newObj.Size)
+}
+
+// DiffManifests streams the delta between the current objects of oldInv and newInv: objects found only in newInv are
+// sent on added, objects found only in oldInv on removed, and objects whose etag or size differ are sent, as found in
+// newInv, on changed. Both inventories must be sorted by key; they are merged while read, so that only one object of
+// each is held in memory. Since a single routine sends on all three channels, they must be consumed concurrently.
+// The channels are closed once both inventories are read or on failure, in which case the error is sent on the error
+// channel before it is closed.
+func DiffManifests(ctx context.Context, oldInv, newInv *Inventory) (added, removed, changed <-chan inventorys3.InventoryObject, errs <-chan error) {
+	addedCh := make(chan inventorys3.InventoryObject)
+	removedCh := make(chan inventorys3.InventoryObject)
+	changedCh := make(chan inventorys3.InventoryObject)
+	errCh := make(chan error, 1)
+	go func() {
+		defer close(errCh)
+		defer close(changedCh)
+		defer close(removedCh)
+		defer close(addedCh)
+		oldCursor := newDiffCursor(oldInv)
+		defer func() {
+			if err := oldCursor.it.Close(); err != nil {
+				oldInv.logger.Errorf("failed to close inventory file. err=%s", err)
+			}
+		}()
+		newCursor := newDiffCursor(newInv)
+		defer func() {
+			if err := newCursor.it.Close(); err != nil {
+				newInv.logger.Errorf("failed to close inventory file. err=%s", err)
+			}
+		}()
+		for (oldCursor.obj != nil || newCursor.obj != nil) && oldCursor.err == nil && newCursor.err == nil {
+			var ch chan inventorys3.InventoryObject
+			var obj *inventorys3.InventoryObject
+			switch {
+			case newCursor.obj == nil || (oldCursor.obj != nil && oldCursor.obj.Key < newCursor.obj.Key):
+				ch, obj = removedCh, oldCursor.obj
+				oldCursor.next()
+			case oldCursor.obj == nil || newCursor.obj.Key < oldCursor.obj.Key:
+				ch, obj = addedCh, newCursor.obj
+				newCursor.next()
+			default:
+				if objectChanged(oldCursor.obj, newCursor.obj) {
+					ch, obj = changedCh, newCursor.obj
+				}
+				oldCursor.next()
+				newCursor.next()
+			}
+			if ch != nil {
+				select {
+				case ch <- *obj:
+				case <-ctx.Done():
+					errCh <- ctx.Err()
+					return
+				}
+			}
+		}
+		if oldCursor.err != nil {
+			errCh <- oldCursor.err
+		} else if newCursor.err != nil {
+			errCh <- newCursor.err
+		}
+	}()
+	return addedCh, removedCh, changedCh, errCh
+}
@@ -1,22 +1,40 @@
 package s3
 
 import (
+	"bufio"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"math/rand"
 	"net/url"
+	"path"
 	"sort"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/arn"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/hashicorp/go-multierror"
 	"github.com/treeverse/lakefs/block"
 	inventorys3 "github.com/treeverse/lakefs/inventory/s3"
 	"github.com/treeverse/lakefs/logging"
 )
 
-var ErrInventoryFilesRangesOverlap = errors.New("got s3 inventory with files covering overlapping ranges")
+const exportFlushInterval = 1000
+
+var (
+	ErrInventoryFilesRangesOverlap = errors.New("got s3 inventory with files covering overlapping ranges")
+	ErrInventoryFileInaccessible   = errors.New("inventory file is missing or inaccessible")
+	ErrNoManifests                 = errors.New("no inventory manifest found under prefix")
+	ErrInconsistentManifests       = errors.New("inventory manifests under prefix are inconsistent")
+	ErrMultipleInventoryDeliveries = errors.New("inventory manifests under prefix belong to several deliveries")
+	ErrInventoryFormatMismatch     = errors.New("inventory file extension does not match the manifest format")
+)
 
 type Manifest struct {
 	URL                string          `json:"-"`
@@ -24,33 +42,158 @@ type Manifest struct {
 	SourceBucket       string          `json:"sourceBucket"`
 	Files              []inventoryFile `json:"files"` // inventory list files, each contains a list of objects
 	Format             string          `json:"fileFormat"`
+	FileSchema         string          `json:"fileSchema"`
 	CreationTimestamp  string          `json:"creationTimestamp"`
 	inventoryBucket    string
 }
 
-type inventoryFile struct {
-	Key string `json:"key"` // an s3 key for an inventory list file
+// CreatedAt returns the time at which the inventory was generated, or the zero time if it is missing from the manifest.
+// S3 inventories report it in milliseconds since the epoch.
+func (m *Manifest) CreatedAt() time.Time {
+	if m.CreationTimestamp == "" {
+		return time.Time{}
+	}
+	millis, err := strconv.ParseInt(m.CreationTimestamp, 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.Unix(0, millis*int64(time.Millisecond)).UTC()
 }
 
-func (a *Adapter) GenerateInventory(ctx context.Context, logger logging.Logger, manifestURL string, shouldSort bool) (block.Inventory, error) {
-	return GenerateInventory(logger, manifestURL, a.s3, inventorys3.NewReader(ctx, a.s3, logger), shouldSort)
+// DestinationBucket returns the name of the bucket the inventory was delivered to, parsed from the destinationBucket
+// ARN of the manifest, or an empty string if it is missing.
+func (m *Manifest) DestinationBucket() string {
+	bucketArn, err := arn.Parse(m.InventoryBucketArn)
+	if err != nil {
+		// not an ARN, possibly a bare bucket name
+		return m.InventoryBucketArn
+	}
+	return bucketArn.Resource
 }
 
-func GenerateInventory(logger logging.Logger, manifestURL string, s3 s3iface.S3API, inventoryReader inventorys3.IReader, shouldSort bool) (block.Inventory, error) {
-	if logger == nil {
-		logger = logging.Default()
+// AccountID returns the account of the destinationBucket ARN of the manifest, or an empty string if the ARN has none.
+// Manifests carry no other account field, and S3 leaves the account out of the ARNs of the buckets it delivers
+// inventories to, as in arn:aws:s3:::bucket, so it is empty for every manifest written by S3. It is only set for
+// manifests written by other tools with an ARN holding an account.
+func (m *Manifest) AccountID() string {
+	bucketArn, err := arn.Parse(m.InventoryBucketArn)
+	if err != nil {
+		return ""
 	}
-	m, err := loadManifest(manifestURL, s3)
+	return bucketArn.AccountID
+}
+
+type inventoryFile struct {
+	Key         string `json:"key"`         // an s3 key for an inventory list file
+	Size        int64  `json:"size"`        // size of the file in bytes, if declared
+	MD5checksum string `json:"MD5checksum"` // md5 checksum of the file, if declared
+}
+
+// FileChecksums returns the sizes and checksums the manifest declares for its inventory files, by their key.
+func (m *Manifest) FileChecksums() map[string]inventorys3.FileChecksum {
+	res := make(map[string]inventorys3.FileChecksum, len(m.Files))
+	for _, f := range m.Files {
+		res[f.Key] = inventorys3.FileChecksum{Size: f.Size, MD5: f.MD5checksum}
+	}
+	return res
+}
+
+// GenerateInventory reads the inventory found at manifestURL. Downloaded inventory files are verified against the
+// checksums declared by its manifest, and the manifest itself is verified against its manifest.checksum if the adapter
+// was created WithVerifyManifestChecksum.
+func (a *Adapter) GenerateInventory(ctx context.Context, logger logging.Logger, manifestURL string, shouldSort bool) (block.Inventory, error) {
+	m, err := loadManifest(ctx, manifestURL, a.s3, a.verifyManifest)
 	if err != nil {
 		return nil, err
 	}
-	if shouldSort {
-		err = sortManifest(m, logger, inventoryReader)
+	inventoryReader := inventorys3.NewReader(ctx, a.s3, logger)
+	return newInventory(logger, m, a.s3, inventoryReader, shouldSort)
+}
+
+// GenerateInventory reads the inventory found at manifestURL with inventoryReader. The same reader may be used for
+// several manifests: readers which verify checksums are given those declared by every manifest.
+func GenerateInventory(ctx context.Context, logger logging.Logger, manifestURL string, s3 s3iface.S3API, inventoryReader inventorys3.IReader, shouldSort bool) (block.Inventory, error) {
+	m, err := loadManifest(ctx, manifestURL, s3, false)
+	if err != nil {
+		return nil, err
 	}
+	return newInventory(logger, m, s3, inventoryReader, shouldSort)
+}
+
+// GenerateInventoryFromPrefix reads an inventory delivered as several manifests: all manifest.json objects found under
+// prefix are merged into a single manifest listing the files of all of them, which must share a format, a schema and
+// an inventory bucket. The other fields are taken from the first manifest listed. The manifests must belong to a single
+// delivery of the inventory, with the same source bucket and creation time: a prefix spanning several deliveries, such
+// as the prefix of an inventory configuration, fails with ErrMultipleInventoryDeliveries. Each manifest is verified
+// against its manifest.checksum if the adapter was created WithVerifyManifestChecksum.
+func (a *Adapter) GenerateInventoryFromPrefix(ctx context.Context, logger logging.Logger, bucket string, prefix string, shouldSort bool) (block.Inventory, error) {
+	m, err := loadManifestsFromPrefix(ctx, a.s3, bucket, prefix, a.verifyManifest)
 	if err != nil {
 		return nil, err
 	}
-	return &Inventory{Manifest: m, logger: logger, shouldSort: shouldSort, reader: inventoryReader}, nil
+	return newInventory(logger, m, a.s3, inventorys3.NewReader(ctx, a.s3, logger), shouldSort)
+}
+
+// loadManifestsFromPrefix loads the manifests found under prefix and merges them, as described by
+// GenerateInventoryFromPrefix.
+func loadManifestsFromPrefix(ctx context.Context, svc s3iface.S3API, bucket string, prefix string, verifyChecksum bool) (*Manifest, error) {
+	var keys []string
+	err := svc.ListObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{Bucket: aws.String(bucket), Prefix: aws.String(prefix)},
+		func(page *s3.ListObjectsV2Output, _ bool) bool {
+			for _, obj := range page.Contents {
+				if path.Base(aws.StringValue(obj.Key)) == manifestFileName {
+					keys = append(keys, aws.StringValue(obj.Key))
+				}
+			}
+			return true
+		})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list manifests under s3://%s/%s: %w", bucket, prefix, err)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%w: s3://%s/%s", ErrNoManifests, bucket, prefix)
+	}
+	var merged *Manifest
+	for _, key := range keys {
+		m, err := loadManifest(ctx, fmt.Sprintf("s3://%s/%s", bucket, key), svc, verifyChecksum)
+		if err != nil {
+			return nil, err
+		}
+		if merged == nil {
+			merged = m
+			continue
+		}
+		if m.SourceBucket != merged.SourceBucket || m.CreationTimestamp != merged.CreationTimestamp {
+			return nil, fmt.Errorf("%w: %s and %s", ErrMultipleInventoryDeliveries, merged.URL, m.URL)
+		}
+		if m.Format != merged.Format || m.FileSchema != merged.FileSchema || m.inventoryBucket != merged.inventoryBucket {
+			return nil, fmt.Errorf("%w: %s does not match %s", ErrInconsistentManifests, m.URL, merged.URL)
+		}
+		merged.Files = append(merged.Files, m.Files...)
+	}
+	merged.URL = fmt.Sprintf("s3://%s/%s", bucket, prefix)
+	return merged, nil
+}
+
+// checksumsReader is implemented by inventory readers which verify downloaded files against the checksums declared
+// by their manifest.
+type checksumsReader interface {
+	AddFileChecksums(bucket string, checksums map[string]inventorys3.FileChecksum)
+}
+
+func newInventory(logger logging.Logger, m *Manifest, s3 s3iface.S3API, inventoryReader inventorys3.IReader, shouldSort bool) (block.Inventory, error) {
+	if logger == nil {
+		logger = logging.Default()
+	}
+	if cr, ok := inventoryReader.(checksumsReader); ok {
+		cr.AddFileChecksums(m.inventoryBucket, m.FileChecksums())
+	}
+	if shouldSort {
+		if err := sortManifest(m, logger, inventoryReader); err != nil {
+			return nil, err
+		}
+	}
+	return &Inventory{Manifest: m, logger: logger, shouldSort: shouldSort, reader: inventoryReader, s3: s3}, nil
 }
 
 type Inventory struct {
@@ -58,6 +201,7 @@ type Inventory struct {
 	logger     logging.Logger
 	shouldSort bool
 	reader     inventorys3.IReader
+	s3         s3iface.S3API
 }
 
 func (inv *Inventory) Iterator() block.InventoryIterator {
@@ -68,26 +212,457 @@ func (inv *Inventory) SourceName() string {
 	return inv.Manifest.SourceBucket
 }
 
+// SourceBucket returns the bucket described by the inventory.
+func (inv *Inventory) SourceBucket() string {
+	return inv.Manifest.SourceBucket
+}
+
+// DestinationBucket returns the bucket the inventory was delivered to, or an empty string if the manifest omits it.
+func (inv *Inventory) DestinationBucket() string {
+	return inv.Manifest.DestinationBucket()
+}
+
+// AccountID returns the account declared by the destination bucket ARN of the manifest, or an empty string if absent,
+// which is always the case for inventories delivered by S3. See Manifest.AccountID.
+func (inv *Inventory) AccountID() string {
+	return inv.Manifest.AccountID()
+}
+
+// CreatedAt returns the time at which the inventory was generated, or the zero time if unknown.
+func (inv *Inventory) CreatedAt() time.Time {
+	return inv.Manifest.CreatedAt()
+}
+
+// FileKeys returns the keys of the inventory files listed in the manifest, in the order the iterator reads them.
+// The order is the same on every call, so workers can statically partition the keys among them by index and read
+// each subset with the inventory reader.
+func (inv *Inventory) FileKeys() []string {
+	keys := make([]string, len(inv.Manifest.Files))
+	for i, f := range inv.Manifest.Files {
+		keys[i] = f.Key
+	}
+	return keys
+}
+
+// ManifestFileCount returns the number of inventory files listed in the manifest.
+func (inv *Inventory) ManifestFileCount() int {
+	return len(inv.Manifest.Files)
+}
+
+// IsEmpty returns true if the manifest lists no inventory files, as is the case for inventories of empty buckets.
+func (inv *Inventory) IsEmpty() bool {
+	return len(inv.Manifest.Files) == 0
+}
+
+// Format returns the format of the inventory files, as declared by the manifest.
+func (inv *Inventory) Format() string {
+	return inv.Manifest.Format
+}
+
+// IsVersioned returns true if the manifest declares a version_id column, in which case the inventory lists every
+// version of the objects, with a row for each.
+func (inv *Inventory) IsVersioned() bool {
+	return inventorys3.IsVersionedSchema(inv.Manifest.FileSchema)
+}
+
+// SupportsPushdown returns true if the inventory files carry statistics by which parts of them are skipped when read
+// with a key prefix or modified range.
+func (inv *Inventory) SupportsPushdown() bool {
+	return inventorys3.SupportsPushdown(inv.Manifest.Format)
+}
+
 func (inv *Inventory) InventoryURL() string {
 	return inv.Manifest.URL
 }
 
-func loadManifest(manifestURL string, s3svc s3iface.S3API) (*Manifest, error) {
+// Validate checks that the inventory format is supported and that every file listed in the manifest can be accessed,
+// without reading the files themselves. All inaccessible files are reported in the returned error. The buckets holding
+// the files are checked first, and a missing or forbidden one fails with inventorys3.ErrInventoryBucketUnavailable.
+func (inv *Inventory) Validate(ctx context.Context) error {
+	if err := validateFormat(inv.Manifest.Format); err != nil {
+		return err
+	}
+	checkedBuckets := make(map[string]bool)
+	for _, f := range inv.Manifest.Files {
+		bucket, _ := inventorys3.FileLocation(inv.Manifest.inventoryBucket, f.Key)
+		if checkedBuckets[bucket] {
+			continue
+		}
+		checkedBuckets[bucket] = true
+		if err := inventorys3.CheckBucket(ctx, inv.s3, bucket); err != nil {
+			return err
+		}
+	}
+	var combinedErr error
+	for _, f := range inv.Manifest.Files {
+		bucket, key := inventorys3.FileLocation(inv.Manifest.inventoryBucket, f.Key)
+		_, err := inv.s3.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(key),
+		})
+		if err != nil {
+			combinedErr = multierror.Append(combinedErr,
+				fmt.Errorf("%w: s3://%s/%s: %s", ErrInventoryFileInaccessible, bucket, key, err))
+		}
+	}
+	return combinedErr
+}
+
+// ExportJSONL writes the objects of all inventory files listed in the manifest to w, as one JSON object per line.
+// Output is buffered and flushed every exportFlushInterval objects.
+func (inv *Inventory) ExportJSONL(ctx context.Context, w io.Writer) error {
+	it := inventorys3.NewInventoryIterator(inv.reader, inv.Manifest.Format, inv.Manifest.FileSchema, inv.Manifest.inventoryBucket, inv.FileKeys())
+	defer func() {
+		if err := it.Close(); err != nil {
+			inv.logger.Errorf("failed to close inventory file. err=%s", err)
+		}
+	}()
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	count := 0
+	for it.Next() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		obj := it.Get()
+		if err := enc.Encode(&obj); err != nil {
+			return err
+		}
+		count++
+		if count%exportFlushInterval == 0 {
+			if err := bw.Flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := it.Err(); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// keysReader is implemented by inventory readers which can read the object keys alone.
+type keysReader interface {
+	Keys(ctx context.Context, format string, schema string, bucket string, fileKeys []string) (<-chan string, <-chan error)
+}
+
+// Keys streams the keys of the objects in all inventory files listed in the manifest, in the order of ExportJSONL.
+// Readers which support it read the key column alone. The keys channel is closed once all files are read or on
+// failure, in which case the error is sent on the error channel before it is closed.
+func (inv *Inventory) Keys(ctx context.Context) (<-chan string, <-chan error) {
+	if kr, ok := inv.reader.(keysReader); ok {
+		return kr.Keys(ctx, inv.Manifest.Format, inv.Manifest.FileSchema, inv.Manifest.inventoryBucket, inv.FileKeys())
+	}
+	keys := make(chan string)
+	errs := make(chan error, 1)
+	go func() {
+		defer close(errs)
+		defer close(keys)
+		it := inventorys3.NewInventoryIterator(inv.reader, inv.Manifest.Format, inv.Manifest.FileSchema, inv.Manifest.inventoryBucket, inv.FileKeys())
+		defer func() {
+			if err := it.Close(); err != nil {
+				inv.logger.Errorf("failed to close inventory file. err=%s", err)
+			}
+		}()
+		for it.Next() {
+			select {
+			case keys <- it.Get().Key:
+			case <-ctx.Done():
+				errs <- ctx.Err()
+				return
+			}
+		}
+		if err := it.Err(); err != nil {
+			errs <- err
+		}
+	}()
+	return keys, errs
+}
+
+// countReader is implemented by inventory readers which count the objects of inventory files without decoding them,
+// when their format allows it.
+type countReader interface {
+	Count(ctx context.Context, format string, schema string, bucket string, fileKeys []string) (int64, error)
+}
+
+// Count returns the number of objects in the inventory. With readers which cannot count objects by themselves, the row
+// counts declared by ORC and Parquet files are summed, and the objects of files of other formats are iterated.
+func (inv *Inventory) Count(ctx context.Context) (int64, error) {
+	if cr, ok := inv.reader.(countReader); ok {
+		return cr.Count(ctx, inv.Manifest.Format, inv.Manifest.FileSchema, inv.Manifest.inventoryBucket, inv.FileKeys())
+	}
+	if inv.Manifest.Format != inventorys3.OrcFormatName && inv.Manifest.Format != inventorys3.ParquetFormatName {
+		return inv.countObjects(ctx)
+	}
+	var total int64
+	for _, f := range inv.Manifest.Files {
+		if err := ctx.Err(); err != nil {
+			return 0, err
+		}
+		mr, err := inv.reader.GetMetadataReader(inv.Manifest.Format, inv.Manifest.FileSchema, inv.Manifest.inventoryBucket, f.Key)
+		if err != nil {
+			return 0, err
+		}
+		total += mr.GetNumRows()
+		if err := mr.Close(); err != nil {
+			return 0, err
+		}
+	}
+	return total, nil
+}
+
+func (inv *Inventory) countObjects(ctx context.Context) (int64, error) {
+	it := inventorys3.NewInventoryIterator(inv.reader, inv.Manifest.Format, inv.Manifest.FileSchema, inv.Manifest.inventoryBucket, inv.FileKeys())
+	defer func() {
+		if err := it.Close(); err != nil {
+			inv.logger.Errorf("failed to close inventory file. err=%s", err)
+		}
+	}()
+	var n int64
+	for it.Next() {
+		if err := ctx.Err(); err != nil {
+			return 0, err
+		}
+		n++
+	}
+	return n, it.Err()
+}
+
+// planReader is implemented by inventory readers which can plan reading inventory files from their statistics.
+type planReader interface {
+	Plan(ctx context.Context, format string, bucket string, fileKeys []string) (inventorys3.ImportPlan, error)
+}
+
+// Plan returns the inventory files which the reader of the inventory would read, with their estimated rows and sizes,
+// without reading their objects. Readers which cannot plan are assumed to read all files, with unknown rows and the
+// sizes declared by the manifest.
+func (inv *Inventory) Plan(ctx context.Context) (inventorys3.ImportPlan, error) {
+	if pr, ok := inv.reader.(planReader); ok {
+		return pr.Plan(ctx, inv.Manifest.Format, inv.Manifest.inventoryBucket, inv.FileKeys())
+	}
+	var plan inventorys3.ImportPlan
+	for _, f := range inv.Manifest.Files {
+		plan.Files = append(plan.Files, inventorys3.PlannedFile{Key: f.Key, EstimatedRows: -1, Size: f.Size})
+		plan.EstimatedBytes += f.Size
+	}
+	return plan, nil
+}
+
+// TotalSize returns the total size in bytes of the inventory files listed in the manifest. The sizes declared by the
+// manifest are used, and files with no declared size are checked on S3.
+func (inv *Inventory) TotalSize(ctx context.Context) (int64, error) {
+	var total int64
+	for _, f := range inv.Manifest.Files {
+		if f.Size > 0 {
+			total += f.Size
+			continue
+		}
+		bucket, key := inventorys3.FileLocation(inv.Manifest.inventoryBucket, f.Key)
+		headObject, err := inv.s3.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(key),
+		})
+		if err != nil {
+			return 0, fmt.Errorf("%w: s3://%s/%s: %s", ErrInventoryFileInaccessible, bucket, key, err)
+		}
+		total += aws.Int64Value(headObject.ContentLength)
+	}
+	return total, nil
+}
+
+// InventoryStats summarizes the objects of an inventory.
+type InventoryStats struct {
+	ObjectCount int64
+	// TotalSize, MinSize, MaxSize and AverageSize are computed over the objects with a size
+	TotalSize   int64
+	MinSize     int64
+	MaxSize     int64
+	AverageSize float64
+	// DeleteMarkerCount is the number of objects which are delete markers, included in ObjectCount
+	DeleteMarkerCount int64
+	// PrefixCounts holds the number of objects by the first segment of their key, up to its first "/".
+	// Objects with no "/" in their key are counted under the empty prefix.
+	PrefixCounts map[string]int64
+}
+
+// Stats summarizes the objects of the inventory, reading all inventory files once.
+func (inv *Inventory) Stats(ctx context.Context) (InventoryStats, error) {
+	it := inventorys3.NewInventoryIterator(inv.reader, inv.Manifest.Format, inv.Manifest.FileSchema, inv.Manifest.inventoryBucket, inv.FileKeys())
+	defer func() {
+		if err := it.Close(); err != nil {
+			inv.logger.Errorf("failed to close inventory file. err=%s", err)
+		}
+	}()
+	stats := InventoryStats{PrefixCounts: make(map[string]int64)}
+	var sizedCount int64
+	for it.Next() {
+		if err := ctx.Err(); err != nil {
+			return InventoryStats{}, err
+		}
+		obj := it.Get()
+		stats.ObjectCount++
+		if obj.IsDeleteMarker != nil && *obj.IsDeleteMarker {
+			stats.DeleteMarkerCount++
+		}
+		prefix := ""
+		if i := strings.IndexByte(obj.Key, '/'); i >= 0 {
+			prefix = obj.Key[:i]
+		}
+		stats.PrefixCounts[prefix]++
+		if obj.Size == nil {
+			continue
+		}
+		size := *obj.Size
+		if sizedCount == 0 || size < stats.MinSize {
+			stats.MinSize = size
+		}
+		if size > stats.MaxSize {
+			stats.MaxSize = size
+		}
+		stats.TotalSize += size
+		sizedCount++
+	}
+	if err := it.Err(); err != nil {
+		return InventoryStats{}, err
+	}
+	if sizedCount > 0 {
+		stats.AverageSize = float64(stats.TotalSize) / float64(sizedCount)
+	}
+	return stats, nil
+}
+
+// StaleObjects compares a random sample of sampleSize objects of the inventory with their current state in the source
+// bucket, and returns the sampled objects which changed or were deleted since the inventory was generated.
+// Objects are sampled among all current, non-deleted objects of the inventory, so all inventory files are read.
+func (inv *Inventory) StaleObjects(ctx context.Context, sampleSize int) ([]inventorys3.InventoryObject, error) {
+	sample, err := inv.sample(ctx, sampleSize)
+	if err != nil {
+		return nil, err
+	}
+	var stale []inventorys3.InventoryObject
+	for _, obj := range sample {
+		headObject, err := inv.s3.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+			Bucket: aws.String(inv.Manifest.SourceBucket),
+			Key:    aws.String(obj.Key),
+		})
+		if inventorys3.IsNotFoundError(err) {
+			stale = append(stale, obj)
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to check s3://%s/%s: %w", inv.Manifest.SourceBucket, obj.Key, err)
+		}
+		if obj.Checksum != nil && strings.Trim(aws.StringValue(headObject.ETag), `"`) != strings.Trim(*obj.Checksum, `"`) {
+			stale = append(stale, obj)
+		}
+	}
+	return stale, nil
+}
+
+// sample returns up to sampleSize objects chosen uniformly at random among the current, non-deleted objects of the
+// inventory, in their inventory order.
+func (inv *Inventory) sample(ctx context.Context, sampleSize int) ([]inventorys3.InventoryObject, error) {
+	it := inventorys3.NewInventoryIterator(inv.reader, inv.Manifest.Format, inv.Manifest.FileSchema, inv.Manifest.inventoryBucket, inv.FileKeys())
+	defer func() {
+		if err := it.Close(); err != nil {
+			inv.logger.Errorf("failed to close inventory file. err=%s", err)
+		}
+	}()
+	type sampled struct {
+		index int
+		obj   inventorys3.InventoryObject
+	}
+	reservoir := make([]sampled, 0, sampleSize)
+	seen := 0
+	for it.Next() {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		obj := it.Get()
+		if (obj.IsDeleteMarker != nil && *obj.IsDeleteMarker) || (obj.IsLatest != nil && !*obj.IsLatest) {
+			continue
+		}
+		if len(reservoir) < sampleSize {
+			reservoir = append(reservoir, sampled{index: seen, obj: obj})
+		} else if j := rand.Intn(seen + 1); j < sampleSize { //nolint:gosec
+			reservoir[j] = sampled{index: seen, obj: obj}
+		}
+		seen++
+	}
+	if err := it.Err(); err != nil {
+		return nil, err
+	}
+	sort.Slice(reservoir, func(i, j int) bool {
+		return reservoir[i].index < reservoir[j].index
+	})
+	res := make([]inventorys3.InventoryObject, len(reservoir))
+	for i, s := range reservoir {
+		res[i] = s.obj
+	}
+	return res, nil
+}
+
+func validateFormat(format string) error {
+	switch format {
+	case "":
+		return inventorys3.ErrMissingInventoryFormat
+	case inventorys3.OrcFormatName, inventorys3.ParquetFormatName, inventorys3.CSVFormatName,
+		inventorys3.AvroFormatName, inventorys3.ApacheAvroFormatName:
+		return nil
+	default:
+		return fmt.Errorf("%w. got format: %s", inventorys3.ErrUnsupportedInventoryFormat, format)
+	}
+}
+
+// fileExtensionFormats maps the extensions of inventory files to the formats they indicate.
+var fileExtensionFormats = []struct {
+	extension string
+	formats   []string
+}{
+	{extension: ".orc", formats: []string{inventorys3.OrcFormatName}},
+	{extension: ".parquet", formats: []string{inventorys3.ParquetFormatName}},
+	{extension: ".csv", formats: []string{inventorys3.CSVFormatName}},
+	{extension: ".csv.gz", formats: []string{inventorys3.CSVFormatName}},
+	{extension: ".csv.zst", formats: []string{inventorys3.CSVFormatName}},
+	{extension: ".avro", formats: []string{inventorys3.AvroFormatName, inventorys3.ApacheAvroFormatName}},
+}
+
+// validateFileFormats checks that the files of the manifest whose extensions indicate a format all match the format
+// declared by the manifest. Files with no known extension are not checked.
+func validateFileFormats(m *Manifest) error {
+	for _, f := range m.Files {
+		key := strings.ToLower(f.Key)
+		for _, ext := range fileExtensionFormats {
+			if !strings.HasSuffix(key, ext.extension) {
+				continue
+			}
+			matches := false
+			for _, format := range ext.formats {
+				matches = matches || format == m.Format
+			}
+			if !matches {
+				return fmt.Errorf("%w: file %s in a manifest of format %s", ErrInventoryFormatMismatch, f.Key, m.Format)
+			}
+		}
+	}
+	return nil
+}
+
+func loadManifest(ctx context.Context, manifestURL string, s3svc s3iface.S3API, verifyChecksum bool) (*Manifest, error) {
 	u, err := url.Parse(manifestURL)
 	if err != nil {
 		return nil, err
 	}
-	output, err := s3svc.GetObject(&s3.GetObjectInput{Bucket: &u.Host, Key: &u.Path})
+	m, err := parseManifest(ctx, s3svc, u.Host, u.Path, verifyChecksum)
 	if err != nil {
 		return nil, fmt.Errorf("%w: failed to read manifest.json from %s", err, manifestURL)
 	}
-	var m Manifest
-	err = json.NewDecoder(output.Body).Decode(&m)
-	if err != nil {
+	m.Format = inventorys3.NormalizeFormat(m.Format)
+	if err := validateFormat(m.Format); err != nil {
 		return nil, err
 	}
-	if m.Format != inventorys3.OrcFormatName && m.Format != inventorys3.ParquetFormatName {
-		return nil, fmt.Errorf("%w. got format: %s", inventorys3.ErrUnsupportedInventoryFormat, m.Format)
+	if err := validateFileFormats(m); err != nil {
+		return nil, err
 	}
 	m.URL = manifestURL
 	inventoryBucketArn, err := arn.Parse(m.InventoryBucketArn)
@@ -95,14 +670,14 @@ func loadManifest(manifestURL string, s3svc s3iface.S3API) (*Manifest, error) {
 		return nil, fmt.Errorf("failed to parse inventory bucket arn: %w", err)
 	}
 	m.inventoryBucket = inventoryBucketArn.Resource
-	return &m, nil
+	return m, nil
 }
 
 func sortManifest(m *Manifest, logger logging.Logger, reader inventorys3.IReader) error {
 	firstKeyByInventoryFile := make(map[string]string)
 	lastKeyByInventoryFile := make(map[string]string)
 	for _, f := range m.Files {
-		mr, err := reader.GetMetadataReader(m.Format, m.inventoryBucket, f.Key)
+		mr, err := reader.GetMetadataReader(m.Format, m.FileSchema, m.inventoryBucket, f.Key)
 		if err != nil {
 			return fmt.Errorf("failed to sort inventory files in manifest: %w", err)
 		}
@@ -3,7 +3,6 @@ package s3
 import (
 	"errors"
 	"fmt"
-	"strconv"
 	"time"
 
 	"github.com/treeverse/lakefs/block"
@@ -25,12 +24,11 @@ type InventoryIterator struct {
 }
 
 func NewInventoryIterator(inv *Inventory) *InventoryIterator {
-	creationTimestamp, err := strconv.ParseInt(inv.Manifest.CreationTimestamp, 10, 64)
-	if err != nil {
+	t := inv.CreatedAt()
+	if t.IsZero() {
 		inv.logger.Errorf("failed to get creation timestamp from manifest")
-		creationTimestamp = 0
+		t = time.Unix(0, 0)
 	}
-	t := time.Unix(creationTimestamp/int64(time.Second/time.Millisecond), 0)
 	return &InventoryIterator{
 		Inventory:             inv,
 		inventoryFileIndex:    -1,
@@ -75,13 +73,13 @@ func (it *InventoryIterator) moveToNextInventoryFile() bool {
 	it.inventoryFileIndex += 1
 	it.inventoryFileProgress.Incr()
 	it.logger.Debugf("moving to next manifest file: %s", it.Manifest.Files[it.inventoryFileIndex].Key)
-	it.buffer = nil
+	it.buffer = it.buffer[:0]
 	return true
 }
 
 func (it *InventoryIterator) fillBuffer() bool {
 	it.logger.Debug("start reading rows from inventory to buffer")
-	rdr, err := it.reader.GetFileReader(it.Manifest.Format, it.Manifest.inventoryBucket, it.Manifest.Files[it.inventoryFileIndex].Key)
+	rdr, err := it.reader.GetFileReader(it.Manifest.Format, it.Manifest.FileSchema, it.Manifest.inventoryBucket, it.Manifest.Files[it.inventoryFileIndex].Key)
 	if err != nil {
 		it.err = err
 		return false
@@ -94,12 +92,19 @@ func (it *InventoryIterator) fillBuffer() bool {
 			it.logger.Errorf("failed to close manifest file reader. file=%s, err=%w", it.Manifest.Files[it.inventoryFileIndex].Key, err)
 		}
 	}()
-	it.buffer = make([]inventorys3.InventoryObject, rdr.GetNumRows())
-	err = rdr.Read(&it.buffer)
+	batch := make([]inventorys3.InventoryObject, rdr.GetNumRows())
+	err = rdr.Read(&batch)
+	if errors.Is(err, inventorys3.ErrNoMoreRows) {
+		// no object of the file passed the filters of the reader
+		batch, err = batch[:0], nil
+	}
 	if err != nil {
 		it.err = err
 		return false
 	}
+	// readers reading WithPooledBuffers reuse the slice they read into once closed, so the objects are kept in a
+	// slice of the iterator instead
+	it.buffer = append(it.buffer[:0], batch...)
 	return true
 }
 
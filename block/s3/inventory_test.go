@@ -1,18 +1,35 @@
 package s3_test
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"regexp"
+	"sort"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
 	s3sdk "github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3iface"
 	"github.com/go-openapi/swag"
+	"github.com/go-test/deep"
+	"github.com/johannesboyne/gofakes3"
+	"github.com/johannesboyne/gofakes3/backend/s3mem"
 	"github.com/treeverse/lakefs/block"
 	"github.com/treeverse/lakefs/block/s3"
 	inventorys3 "github.com/treeverse/lakefs/inventory/s3"
@@ -32,6 +49,7 @@ func rows(keys []string, lastModified map[string]time.Time) []*inventorys3.Inven
 			res[i].Key = key
 			res[i].IsLatest = swag.Bool(!strings.Contains(key, "_expired"))
 			res[i].IsDeleteMarker = swag.Bool(strings.Contains(key, "_del"))
+			res[i].Checksum = swag.String("etag-" + key)
 			if lastModified != nil {
 				res[i].LastModifiedMillis = swag.Int64(lastModified[key].Unix() * 1000)
 			}
@@ -66,6 +84,13 @@ var fileContents = map[string][]string{
 	"f_overlap3":    {"fo_row2", "fo_row6"},
 	"f_overlap4":    {"fo_row1", "fo_row4"},
 	"f_overlap5":    {"fo_row2", "fo_row4"},
+	"f_prefixes1":   {"a/row1", "a/row2_del", "b/c/row3"},
+	"f_prefixes2":   {"b/row4", "row5"},
+	"f_diff_old1":   {"d_row1", "d_row2", "d_row3"},
+	"f_diff_old2":   {"d_row5", "d_row6_del", "d_row7"},
+	"f_diff_new1":   {"d_row2", "d_row3", "d_row4"},
+	"f_diff_new2":   {"d_row5", "d_row6", "d_row7", "d_row8"},
+	"f_diff_desc":   {"d_row3", "d_row2"},
 }
 
 func TestIterator(t *testing.T) {
@@ -168,7 +193,7 @@ func TestIterator(t *testing.T) {
 			FilesByManifestURL: map[string][]string{manifestURL: test.InventoryFiles},
 		}
 		reader := &mockInventoryReader{openFiles: make(map[string]bool), lastModified: lastModified}
-		inv, err := s3.GenerateInventory(logging.Default(), manifestURL, s3api, reader, test.ShouldSort)
+		inv, err := s3.GenerateInventory(context.Background(), logging.Default(), manifestURL, s3api, reader, test.ShouldSort)
 		if err != nil {
 			if errors.Is(err, test.ErrExpected) {
 				continue
@@ -207,6 +232,18 @@ func TestIterator(t *testing.T) {
 type mockInventoryReader struct {
 	openFiles    map[string]bool
 	lastModified map[string]time.Time
+	sizes        map[string]int64
+	checksums    map[string]inventorys3.FileChecksum // by bucket and key
+	readCalls    int
+}
+
+func (m *mockInventoryReader) AddFileChecksums(bucket string, checksums map[string]inventorys3.FileChecksum) {
+	if m.checksums == nil {
+		return // checksums not recorded by the test
+	}
+	for key, checksum := range checksums {
+		m.checksums[bucket+"/"+key] = checksum
+	}
 }
 
 type mockInventoryFileReader struct {
@@ -247,6 +284,7 @@ func (m *mockInventoryFileReader) Close() error {
 }
 
 func (m *mockInventoryFileReader) Read(dstInterface interface{}) error {
+	m.inventoryReader.readCalls++
 	res := make([]inventorys3.InventoryObject, 0, len(m.rows))
 	dst := dstInterface.(*[]inventorys3.InventoryObject)
 	for i := m.nextIdx; i < len(m.rows) && i < m.nextIdx+len(*dst); i++ {
@@ -260,24 +298,49 @@ func (m *mockInventoryFileReader) Read(dstInterface interface{}) error {
 	return nil
 }
 
+func (m *mockInventoryFileReader) Rewind() error {
+	m.nextIdx = 0
+	return nil
+}
+
+func (m *mockInventoryFileReader) BytesRead() int64 {
+	return 0
+}
+
 func (m *mockInventoryFileReader) GetNumRows() int64 {
 	return int64(len(m.rows))
 }
 
-func (m *mockInventoryReader) GetFileReader(_ string, _ string, key string) (inventorys3.FileReader, error) {
+func (m *mockInventoryReader) GetFileReader(_ string, _ string, _ string, key string) (inventorys3.FileReader, error) {
 	m.openFiles[key] = true
-	return &mockInventoryFileReader{rows: rows(fileContents[key], m.lastModified), inventoryReader: m, key: key}, nil
+	fileRows := rows(fileContents[key], m.lastModified)
+	for _, row := range fileRows {
+		if row == nil {
+			continue
+		}
+		if size, ok := m.sizes[row.Key]; ok {
+			row.Size = swag.Int64(size)
+		}
+	}
+	return &mockInventoryFileReader{rows: fileRows, inventoryReader: m, key: key}, nil
 }
 
-func (m *mockInventoryReader) GetMetadataReader(_ string, _ string, key string) (inventorys3.MetadataReader, error) {
+func (m *mockInventoryReader) GetMetadataReader(_ string, _ string, _ string, key string) (inventorys3.MetadataReader, error) {
 	m.openFiles[key] = true
 	return &mockInventoryFileReader{rows: rows(fileContents[key], m.lastModified), inventoryReader: m, key: key}, nil
 }
+func (m *mockS3Client) GetObjectWithContext(_ aws.Context, input *s3sdk.GetObjectInput, _ ...request.Option) (*s3sdk.GetObjectOutput, error) {
+	return m.GetObject(input)
+}
+
 func (m *mockS3Client) GetObject(input *s3sdk.GetObjectInput) (*s3sdk.GetObjectOutput, error) {
 	output := s3sdk.GetObjectOutput{}
 	manifestURL := fmt.Sprintf("s3://%s%s", *input.Bucket, *input.Key)
 	if !manifestExists(manifestURL) {
-		return &output, nil
+		return nil, awserr.New(s3sdk.ErrCodeNoSuchKey, "no such key", nil)
+	}
+	if m.Malformed {
+		return output.SetBody(ioutil.NopCloser(strings.NewReader(`{"sourceBucket" : "lakefs-example-data", "files": [{"key": `))), nil
 	}
 	inventoryFileNames := m.FilesByManifestURL[manifestURL]
 	if inventoryFileNames == nil {
@@ -286,9 +349,11 @@ func (m *mockS3Client) GetObject(input *s3sdk.GetObjectInput) (*s3sdk.GetObjectO
 	inventoryFiles := make([]interface{}, 0, len(inventoryFileNames))
 	for _, filename := range inventoryFileNames {
 		inventoryFiles = append(inventoryFiles, struct {
-			Key string `json:"key"`
+			Key  string `json:"key"`
+			Size int64  `json:"size,omitempty"`
 		}{
-			Key: filename,
+			Key:  filename,
+			Size: m.DeclaredSizes[filename],
 		})
 	}
 	filesJSON, err := json.Marshal(inventoryFiles)
@@ -307,16 +372,990 @@ func (m *mockS3Client) GetObject(input *s3sdk.GetObjectInput) (*s3sdk.GetObjectO
   "fileFormat" : "Parquet",
   "fileSchema" : "message s3.inventory {  required binary bucket (STRING);  required binary key (STRING);  optional binary version_id (STRING);  optional boolean is_latest;  optional boolean is_delete_marker;  optional int64 size;  optional int64 last_modified_date (TIMESTAMP(MILLIS,true));  optional binary e_tag (STRING);  optional binary storage_class (STRING);  optional boolean is_multipart_uploaded;}",
   "files" : %s}`, destBucket, filesJSON))
-	return output.SetBody(ioutil.NopCloser(reader)), nil
+	if !strings.HasSuffix(manifestURL, ".gz") {
+		return output.SetBody(ioutil.NopCloser(reader)), nil
+	}
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := io.Copy(w, reader); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return output.SetBody(ioutil.NopCloser(&buf)), nil
+}
+
+func (m *mockS3Client) HeadBucketWithContext(_ aws.Context, input *s3sdk.HeadBucketInput, _ ...request.Option) (*s3sdk.HeadBucketOutput, error) {
+	if err := m.HeadBucketErrs[*input.Bucket]; err != nil {
+		return nil, err
+	}
+	return &s3sdk.HeadBucketOutput{}, nil
+}
+
+func (m *mockS3Client) HeadObjectWithContext(_ aws.Context, input *s3sdk.HeadObjectInput, _ ...request.Option) (*s3sdk.HeadObjectOutput, error) {
+	m.HeadObjectCalls++
+	if m.HeadObjectErr != nil {
+		return nil, m.HeadObjectErr
+	}
+	if m.MissingFiles[*input.Key] {
+		return nil, awserr.NewRequestFailure(awserr.New("NotFound", "not found", nil), http.StatusNotFound, "")
+	}
+	return &s3sdk.HeadObjectOutput{
+		ContentLength: aws.Int64(m.FileSizes[*input.Key]),
+		ETag:          aws.String(`"` + m.ETags[*input.Key] + `"`),
+	}, nil
 }
 
 type mockS3Client struct {
 	s3iface.S3API
 	FilesByManifestURL map[string][]string
 	DestBucket         string
+	Malformed          bool
+	MissingFiles       map[string]bool
+	DeclaredSizes      map[string]int64  // sizes declared in the manifest
+	FileSizes          map[string]int64  // sizes returned by HeadObject
+	ETags              map[string]string // etags returned by HeadObject, quoted as S3 does
+	HeadObjectErr      error
+	HeadObjectCalls    int
+	HeadBucketErrs     map[string]error // errors returned by HeadBucket, by bucket
 }
 
 func manifestExists(manifestURL string) bool {
-	match, _ := regexp.MatchString("s3://example-bucket/manifest[0-9]+.json", manifestURL)
+	match, _ := regexp.MatchString("s3://example-bucket/manifest[0-9]+.json(.gz)?", manifestURL)
 	return match
 }
+
+func TestParseManifest(t *testing.T) {
+	s3api := &mockS3Client{
+		FilesByManifestURL: map[string][]string{"s3://example-bucket/manifest1.json.gz": {"f1", "f2", "f3"}},
+	}
+	m, err := s3.ParseManifest(context.Background(), s3api, "example-bucket", "/manifest1.json.gz")
+	if err != nil {
+		t.Fatalf("failed to parse gzipped manifest: %v", err)
+	}
+	if len(m.Files) != 3 || m.Files[2].Key != "f3" {
+		t.Fatalf("unexpected files in manifest: %v", m.Files)
+	}
+	if m.SourceBucket != "lakefs-example-data" || m.Format != inventorys3.ParquetFormatName {
+		t.Fatalf("unexpected manifest fields: source bucket=%s, format=%s", m.SourceBucket, m.Format)
+	}
+	s3api.Malformed = true
+	_, err = s3.ParseManifest(context.Background(), s3api, "example-bucket", "/manifest1.json")
+	if !errors.Is(err, s3.ErrMalformedManifest) {
+		t.Fatalf("expected error %v, got %v", s3.ErrMalformedManifest, err)
+	}
+}
+
+// fileS3Client serves the contents of a local file for any object.
+type fileS3Client struct {
+	s3iface.S3API
+	path string
+}
+
+func (c *fileS3Client) GetObjectWithContext(_ aws.Context, _ *s3sdk.GetObjectInput, _ ...request.Option) (*s3sdk.GetObjectOutput, error) {
+	data, err := ioutil.ReadFile(c.path)
+	if err != nil {
+		return nil, err
+	}
+	return &s3sdk.GetObjectOutput{Body: ioutil.NopCloser(bytes.NewReader(data))}, nil
+}
+
+func TestManifestCreationTimestamp(t *testing.T) {
+	s3api := &fileS3Client{path: "testdata/manifest.json"}
+	m, err := s3.ParseManifest(context.Background(), s3api, "example-inventory-destination-bucket", "manifest.json")
+	if err != nil {
+		t.Fatalf("failed to parse manifest: %v", err)
+	}
+	inv := &s3.Inventory{Manifest: m}
+	if inv.SourceBucket() != "example-source-bucket" {
+		t.Fatalf("unexpected source bucket. expected=%s, got=%s", "example-source-bucket", inv.SourceBucket())
+	}
+	expectedCreatedAt := time.Date(2018, 1, 3, 2, 0, 0, 0, time.UTC)
+	if !inv.CreatedAt().Equal(expectedCreatedAt) {
+		t.Fatalf("unexpected creation time. expected=%s, got=%s", expectedCreatedAt, inv.CreatedAt())
+	}
+	if m.Format != inventorys3.CSVFormatName || len(m.Files) != 1 {
+		t.Fatalf("unexpected manifest fields: format=%s, files=%v", m.Format, m.Files)
+	}
+	expectedChecksums := map[string]inventorys3.FileChecksum{
+		"Inventory/example-source-bucket/2016-11-06T21-32Z/files/939c6d46-85a9-4ba8-87bd-9db705a579ce.csv.gz": {
+			Size: 2147483647,
+			MD5:  "f11166069f1990abeb9c97ace9cdfabc",
+		},
+	}
+	if diff := deep.Equal(m.FileChecksums(), expectedChecksums); diff != nil {
+		t.Fatalf("unexpected file checksums: %s", diff)
+	}
+	m.CreationTimestamp = ""
+	if !inv.CreatedAt().IsZero() {
+		t.Fatalf("expected zero creation time for missing timestamp, got %s", inv.CreatedAt())
+	}
+}
+
+// objectsS3Client serves objects from memory, by their key.
+type objectsS3Client struct {
+	s3iface.S3API
+	objects map[string][]byte
+}
+
+func (c *objectsS3Client) GetObjectWithContext(_ aws.Context, input *s3sdk.GetObjectInput, _ ...request.Option) (*s3sdk.GetObjectOutput, error) {
+	data, ok := c.objects[*input.Key]
+	if !ok {
+		return nil, awserr.New(s3sdk.ErrCodeNoSuchKey, "no such key", nil)
+	}
+	return &s3sdk.GetObjectOutput{Body: ioutil.NopCloser(bytes.NewReader(data))}, nil
+}
+
+func (c *objectsS3Client) ListObjectsV2PagesWithContext(_ aws.Context, input *s3sdk.ListObjectsV2Input, fn func(*s3sdk.ListObjectsV2Output, bool) bool, _ ...request.Option) error {
+	keys := make([]string, 0, len(c.objects))
+	for key := range c.objects {
+		key = strings.TrimPrefix(key, "/")
+		if strings.HasPrefix(key, aws.StringValue(input.Prefix)) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	page := &s3sdk.ListObjectsV2Output{}
+	for _, key := range keys {
+		page.Contents = append(page.Contents, &s3sdk.Object{Key: aws.String(key)})
+	}
+	fn(page, true)
+	return nil
+}
+
+func TestManifestChecksum(t *testing.T) {
+	manifest, err := ioutil.ReadFile("testdata/manifest.json")
+	if err != nil {
+		t.Fatalf("failed to read manifest: %v", err)
+	}
+	sum := md5.Sum(manifest)
+	checksum := hex.EncodeToString(sum[:])
+	const manifestURL = "s3://example-inventory-destination-bucket/inventory/manifest.json"
+	testdata := []struct {
+		name     string
+		verify   bool
+		checksum *string
+		fail     bool
+		err      error
+	}{
+		{name: "match", verify: true, checksum: swag.String(checksum + "\n")},
+		{name: "match_upper_case", verify: true, checksum: swag.String(strings.ToUpper(checksum))},
+		{name: "mismatch", verify: true, checksum: swag.String("f11166069f1990abeb9c97ace9cdfabc"), fail: true, err: s3.ErrManifestChecksumMismatch},
+		{name: "missing_checksum", verify: true, checksum: nil, fail: true},
+		{name: "mismatch_not_verified", verify: false, checksum: swag.String("f11166069f1990abeb9c97ace9cdfabc")},
+		{name: "missing_not_verified", verify: false, checksum: nil},
+	}
+	for _, test := range testdata {
+		t.Run(test.name, func(t *testing.T) {
+			svc := &objectsS3Client{objects: map[string][]byte{"/inventory/manifest.json": manifest}}
+			if test.checksum != nil {
+				svc.objects["/inventory/manifest.checksum"] = []byte(*test.checksum)
+			}
+			adapter := s3.NewAdapter(svc, s3.WithVerifyManifestChecksum(test.verify))
+			inv, err := adapter.GenerateInventory(context.Background(), logging.Default(), manifestURL, false)
+			if test.fail {
+				if err == nil || (test.err != nil && !errors.Is(err, test.err)) {
+					t.Fatalf("expected error %v, got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("failed to generate inventory: %v", err)
+			}
+			if inv.(*s3.Inventory).SourceBucket() != "example-source-bucket" {
+				t.Fatalf("unexpected source bucket: %s", inv.(*s3.Inventory).SourceBucket())
+			}
+		})
+	}
+}
+
+func TestManifestFormat(t *testing.T) {
+	manifest, err := ioutil.ReadFile("testdata/manifest.json")
+	if err != nil {
+		t.Fatalf("failed to read manifest: %v", err)
+	}
+	const manifestURL = "s3://example-inventory-destination-bucket/inventory/manifest.json"
+	testdata := []struct {
+		name           string
+		formatField    string
+		fileExtension  string
+		expectedFormat string
+		err            error
+	}{
+		{name: "exact", formatField: `"fileFormat": "CSV",`, fileExtension: ".csv.gz", expectedFormat: inventorys3.CSVFormatName},
+		{name: "lower_case", formatField: `"fileFormat": "orc",`, fileExtension: ".orc", expectedFormat: inventorys3.OrcFormatName},
+		{name: "mixed_case", formatField: `"fileFormat": "apache avro",`, fileExtension: ".avro", expectedFormat: inventorys3.ApacheAvroFormatName},
+		{name: "padded", formatField: `"fileFormat": "  Parquet\t",`, fileExtension: ".parquet", expectedFormat: inventorys3.ParquetFormatName},
+		{name: "no_extension", formatField: `"fileFormat": "ORC",`, expectedFormat: inventorys3.OrcFormatName},
+		{name: "empty", formatField: `"fileFormat": " ",`, err: inventorys3.ErrMissingInventoryFormat},
+		{name: "missing", formatField: "", err: inventorys3.ErrMissingInventoryFormat},
+		{name: "unsupported", formatField: `"fileFormat": "json",`, err: inventorys3.ErrUnsupportedInventoryFormat},
+		{name: "extension_mismatch", formatField: `"fileFormat": "ORC",`, fileExtension: ".parquet", err: s3.ErrInventoryFormatMismatch},
+	}
+	for _, test := range testdata {
+		t.Run(test.name, func(t *testing.T) {
+			data := bytes.Replace(manifest, []byte(`"fileFormat": "CSV",`), []byte(test.formatField), 1)
+			data = bytes.Replace(data, []byte(`.csv.gz"`), []byte(test.fileExtension+`"`), 1)
+			svc := &objectsS3Client{objects: map[string][]byte{"/inventory/manifest.json": data}}
+			inv, err := s3.NewAdapter(svc).GenerateInventory(context.Background(), logging.Default(), manifestURL, false)
+			if test.err != nil {
+				if !errors.Is(err, test.err) {
+					t.Fatalf("expected error %v, got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("failed to generate inventory: %v", err)
+			}
+			if format := inv.(*s3.Inventory).Format(); format != test.expectedFormat {
+				t.Fatalf("unexpected format. expected=%s, got=%s", test.expectedFormat, format)
+			}
+		})
+	}
+}
+
+func TestManifestDestination(t *testing.T) {
+	manifest, err := ioutil.ReadFile("testdata/manifest.json")
+	if err != nil {
+		t.Fatalf("failed to read manifest: %v", err)
+	}
+	const destinationField = `"destinationBucket": "arn:aws:s3:::example-inventory-destination-bucket",`
+	testdata := []struct {
+		name              string
+		destinationField  string
+		expectedBucket    string
+		expectedAccountID string
+	}{
+		// S3 delivers manifests with an ARN holding no account
+		{name: "s3_arn", destinationField: destinationField, expectedBucket: "example-inventory-destination-bucket"},
+		{name: "s3_arn_other_partition", destinationField: `"destinationBucket": "arn:aws-cn:s3:::cn-inventory-bucket",`, expectedBucket: "cn-inventory-bucket"},
+		{name: "arn_with_account", destinationField: `"destinationBucket": "arn:aws:s3::123456789012:audited-bucket",`, expectedBucket: "audited-bucket", expectedAccountID: "123456789012"},
+		{name: "bucket_name", destinationField: `"destinationBucket": "plain-bucket",`, expectedBucket: "plain-bucket"},
+		{name: "missing", destinationField: ""},
+	}
+	for _, test := range testdata {
+		t.Run(test.name, func(t *testing.T) {
+			data := bytes.Replace(manifest, []byte(destinationField), []byte(test.destinationField), 1)
+			svc := &objectsS3Client{objects: map[string][]byte{"manifest.json": data}}
+			m, err := s3.ParseManifest(context.Background(), svc, "example-inventory-destination-bucket", "manifest.json")
+			if err != nil {
+				t.Fatalf("failed to parse manifest: %v", err)
+			}
+			inv := &s3.Inventory{Manifest: m}
+			if bucket := inv.DestinationBucket(); bucket != test.expectedBucket {
+				t.Fatalf("unexpected destination bucket. expected=%s, got=%s", test.expectedBucket, bucket)
+			}
+			if accountID := inv.AccountID(); accountID != test.expectedAccountID {
+				t.Fatalf("unexpected account id. expected=%s, got=%s", test.expectedAccountID, accountID)
+			}
+			// readers of the inventory get them from its iterator
+			it := &s3.InventoryIterator{Inventory: inv}
+			if it.DestinationBucket() != test.expectedBucket || it.AccountID() != test.expectedAccountID {
+				t.Fatalf("unexpected destination of iterator. bucket=%s, account id=%s", it.DestinationBucket(), it.AccountID())
+			}
+		})
+	}
+}
+
+func TestGenerateInventorySharedReader(t *testing.T) {
+	reader := &mockInventoryReader{openFiles: make(map[string]bool), checksums: make(map[string]inventorys3.FileChecksum)}
+	manifests := []struct {
+		url    string
+		s3api  *mockS3Client
+		files  []string
+		bucket string
+	}{
+		{url: "s3://example-bucket/manifest1.json", files: []string{"f1", "f2"}, bucket: "inventory-bucket-1"},
+		{url: "s3://example-bucket/manifest2.json", files: []string{"f3"}, bucket: "inventory-bucket-2"},
+	}
+	for i, m := range manifests {
+		s3api := &mockS3Client{
+			FilesByManifestURL: map[string][]string{m.url: m.files},
+			DestBucket:         m.bucket,
+			DeclaredSizes:      map[string]int64{m.files[0]: int64(i + 1)},
+		}
+		inv, err := s3.GenerateInventory(context.Background(), logging.Default(), m.url, s3api, reader, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := deep.Equal(inv.(*s3.Inventory).FileKeys(), m.files); diff != nil {
+			t.Fatalf("unexpected files in manifest %s: %s", m.url, diff)
+		}
+	}
+	expectedChecksums := map[string]inventorys3.FileChecksum{
+		"inventory-bucket-1/f1": {Size: 1},
+		"inventory-bucket-1/f2": {},
+		"inventory-bucket-2/f3": {Size: 2},
+	}
+	if diff := deep.Equal(reader.checksums, expectedChecksums); diff != nil {
+		t.Fatalf("unexpected checksums given to the reader: %s", diff)
+	}
+}
+
+func TestInventoryFileKeys(t *testing.T) {
+	manifestURL := "s3://example-bucket/manifest1.json"
+	s3api := &mockS3Client{
+		FilesByManifestURL: map[string][]string{manifestURL: {"f2", "f1", "f3"}},
+	}
+	reader := &mockInventoryReader{openFiles: make(map[string]bool)}
+	inv, err := s3.GenerateInventory(context.Background(), logging.Default(), manifestURL, s3api, reader, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s3inv := inv.(*s3.Inventory)
+	expected := []string{"f1", "f2", "f3"}
+	for i := 0; i < 2; i++ {
+		keys := s3inv.FileKeys()
+		if strings.Join(keys, ",") != strings.Join(expected, ",") {
+			t.Fatalf("unexpected file keys. expected=%v, got=%v", expected, keys)
+		}
+		// modifying the returned keys doesn't affect the inventory
+		keys[0] = "modified"
+	}
+	if s3inv.ManifestFileCount() != len(expected) {
+		t.Fatalf("unexpected file count. expected=%d, got=%d", len(expected), s3inv.ManifestFileCount())
+	}
+}
+
+func TestInventoryEmptyManifest(t *testing.T) {
+	manifestURL := "s3://example-bucket/manifest1.json"
+	s3api := &mockS3Client{
+		FilesByManifestURL: map[string][]string{manifestURL: {}},
+	}
+	reader := &mockInventoryReader{openFiles: make(map[string]bool)}
+	for _, shouldSort := range []bool{false, true} {
+		inv, err := s3.GenerateInventory(context.Background(), logging.Default(), manifestURL, s3api, reader, shouldSort)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s3inv := inv.(*s3.Inventory)
+		if !s3inv.IsEmpty() {
+			t.Fatalf("expected inventory to be empty, got files %v", s3inv.FileKeys())
+		}
+		if err = s3inv.Validate(context.Background()); err != nil {
+			t.Fatalf("unexpected error validating empty inventory: %v", err)
+		}
+		it := inv.Iterator()
+		if it.Next() {
+			t.Fatalf("expected no objects in empty inventory, got %v", it.Get())
+		}
+		if it.Err() != nil {
+			t.Fatalf("unexpected error from empty inventory iterator: %v", it.Err())
+		}
+	}
+	inv, err := s3.GenerateInventory(context.Background(), logging.Default(), "s3://example-bucket/manifest2.json", &mockS3Client{}, reader, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inv.(*s3.Inventory).IsEmpty() {
+		t.Fatal("expected inventory with files not to be empty")
+	}
+}
+
+func TestInventoryFormat(t *testing.T) {
+	testdata := map[string]bool{
+		inventorys3.OrcFormatName:        true,
+		inventorys3.ParquetFormatName:    true,
+		inventorys3.CSVFormatName:        false,
+		inventorys3.ApacheAvroFormatName: false,
+	}
+	for format, expectedPushdown := range testdata {
+		inv := &s3.Inventory{Manifest: &s3.Manifest{Format: format}}
+		if inv.Format() != format {
+			t.Fatalf("unexpected format. expected=%s, got=%s", format, inv.Format())
+		}
+		if inv.SupportsPushdown() != expectedPushdown {
+			t.Fatalf("unexpected pushdown support for format %s. expected=%t, got=%t", format, expectedPushdown, inv.SupportsPushdown())
+		}
+	}
+}
+
+func TestInventoryExportJSONL(t *testing.T) {
+	manifestURL := "s3://example-bucket/manifest1.json"
+	files := []string{"f1", "f2", "empty_file", "f4", "f7"}
+	s3api := &mockS3Client{
+		FilesByManifestURL: map[string][]string{manifestURL: files},
+	}
+	reader := &mockInventoryReader{openFiles: make(map[string]bool)}
+	inv, err := s3.GenerateInventory(context.Background(), logging.Default(), manifestURL, s3api, reader, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s3inv := inv.(*s3.Inventory)
+	var buf bytes.Buffer
+	if err = s3inv.ExportJSONL(context.Background(), &buf); err != nil {
+		t.Fatal(err)
+	}
+	var expected []string
+	for _, f := range files {
+		expected = append(expected, fileContents[f]...)
+	}
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != len(expected) {
+		t.Fatalf("unexpected number of lines. expected=%d, got=%d", len(expected), len(lines))
+	}
+	for i, line := range lines {
+		var obj inventorys3.InventoryObject
+		if err := json.Unmarshal([]byte(line), &obj); err != nil {
+			t.Fatalf("failed to decode line %d: %s", i, err)
+		}
+		if obj.Key != expected[i] {
+			t.Fatalf("unexpected key at line %d. expected=%s, got=%s", i, expected[i], obj.Key)
+		}
+	}
+	if len(reader.openFiles) != 0 {
+		t.Fatalf("some files stayed open: %v", reader.openFiles)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err = s3inv.ExportJSONL(ctx, &buf); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected error %v, got %v", context.Canceled, err)
+	}
+
+	manifestURL = "s3://example-bucket/manifest2.json"
+	s3api.FilesByManifestURL[manifestURL] = []string{"f1", "err_file1"}
+	inv, err = s3.GenerateInventory(context.Background(), logging.Default(), manifestURL, s3api, reader, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = inv.(*s3.Inventory).ExportJSONL(context.Background(), &buf); !errors.Is(err, ErrReadFile) {
+		t.Fatalf("expected error %v, got %v", ErrReadFile, err)
+	}
+}
+
+func TestInventoryKeys(t *testing.T) {
+	manifestURL := "s3://example-bucket/manifest1.json"
+	files := []string{"f1", "f2", "empty_file", "f4", "f7"}
+	s3api := &mockS3Client{
+		FilesByManifestURL: map[string][]string{manifestURL: files},
+	}
+	reader := &mockInventoryReader{openFiles: make(map[string]bool)}
+	inv, err := s3.GenerateInventory(context.Background(), logging.Default(), manifestURL, s3api, reader, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var expected []string
+	for _, f := range files {
+		expected = append(expected, fileContents[f]...)
+	}
+	keys, errs := inv.(*s3.Inventory).Keys(context.Background())
+	var res []string
+	for key := range keys {
+		res = append(res, key)
+	}
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+	if diff := deep.Equal(res, expected); diff != nil {
+		t.Fatalf("unexpected keys: %s", diff)
+	}
+	if len(reader.openFiles) != 0 {
+		t.Fatalf("expected all files to be closed, got %v", reader.openFiles)
+	}
+}
+
+func TestInventoryTotalSize(t *testing.T) {
+	manifestURL := "s3://example-bucket/manifest1.json"
+	s3api := &mockS3Client{
+		FilesByManifestURL: map[string][]string{manifestURL: {"f1", "f2", "f3"}},
+		DeclaredSizes:      map[string]int64{"f1": 100, "f3": 300},
+		FileSizes:          map[string]int64{"f1": 1, "f2": 200, "f3": 3},
+	}
+	reader := &mockInventoryReader{openFiles: make(map[string]bool)}
+	inv, err := s3.GenerateInventory(context.Background(), logging.Default(), manifestURL, s3api, reader, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s3inv := inv.(*s3.Inventory)
+	size, err := s3inv.TotalSize(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size != 600 {
+		t.Fatalf("unexpected total size. expected=%d, got=%d", 600, size)
+	}
+	if s3api.HeadObjectCalls != 1 {
+		t.Fatalf("expected only the file with no declared size to be checked, got %d calls", s3api.HeadObjectCalls)
+	}
+
+	s3api.MissingFiles = map[string]bool{"f2": true}
+	if _, err = s3inv.TotalSize(context.Background()); !errors.Is(err, s3.ErrInventoryFileInaccessible) {
+		t.Fatalf("expected error %v, got %v", s3.ErrInventoryFileInaccessible, err)
+	}
+}
+
+func TestInventoryStats(t *testing.T) {
+	manifestURL := "s3://example-bucket/manifest1.json"
+	s3api := &mockS3Client{
+		FilesByManifestURL: map[string][]string{manifestURL: {"f_prefixes1", "f_prefixes2"}},
+	}
+	reader := &mockInventoryReader{
+		openFiles: make(map[string]bool),
+		sizes:     map[string]int64{"a/row1": 10, "b/c/row3": 30, "b/row4": 5, "row5": 15},
+	}
+	inv, err := s3.GenerateInventory(context.Background(), logging.Default(), manifestURL, s3api, reader, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats, err := inv.(*s3.Inventory).Stats(context.Background())
+	if err != nil {
+		t.Fatalf("failed to compute inventory stats: %v", err)
+	}
+	expected := s3.InventoryStats{
+		ObjectCount:       5,
+		TotalSize:         60,
+		MinSize:           5,
+		MaxSize:           30,
+		AverageSize:       15,
+		DeleteMarkerCount: 1,
+		PrefixCounts:      map[string]int64{"a": 2, "b": 2, "": 1},
+	}
+	if diff := deep.Equal(stats, expected); diff != nil {
+		t.Fatalf("unexpected inventory stats: %s", diff)
+	}
+	if len(reader.openFiles) != 0 {
+		t.Fatalf("expected all inventory files to be closed, got open files %v", reader.openFiles)
+	}
+}
+
+func TestInventoryPlan(t *testing.T) {
+	manifestURL := "s3://example-bucket/manifest1.json"
+	s3api := &mockS3Client{
+		FilesByManifestURL: map[string][]string{manifestURL: {"f1", "f2"}},
+		DeclaredSizes:      map[string]int64{"f1": 100, "f2": 50},
+	}
+	reader := &mockInventoryReader{openFiles: make(map[string]bool)}
+	inv, err := s3.GenerateInventory(context.Background(), logging.Default(), manifestURL, s3api, reader, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plan, err := inv.(*s3.Inventory).Plan(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := inventorys3.ImportPlan{
+		Files: []inventorys3.PlannedFile{
+			{Key: "f1", EstimatedRows: -1, Size: 100},
+			{Key: "f2", EstimatedRows: -1, Size: 50},
+		},
+		EstimatedBytes: 150,
+	}
+	if diff := deep.Equal(plan, expected); diff != nil {
+		t.Fatalf("unexpected plan: %s", diff)
+	}
+	if len(reader.openFiles) != 0 {
+		t.Fatalf("expected no inventory files to be opened, got %v", reader.openFiles)
+	}
+}
+
+func TestInventoryAbsoluteFileURIs(t *testing.T) {
+	manifestURL := "s3://example-bucket/manifest1.json"
+	s3api := &mockS3Client{
+		FilesByManifestURL: map[string][]string{manifestURL: {"f1", "s3://other-bucket/data/f2"}},
+		FileSizes:          map[string]int64{"f1": 10, "data/f2": 20},
+	}
+	reader := &mockInventoryReader{openFiles: make(map[string]bool)}
+	inv, err := s3.GenerateInventory(context.Background(), logging.Default(), manifestURL, s3api, reader, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s3inv := inv.(*s3.Inventory)
+	totalSize, err := s3inv.TotalSize(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if totalSize != 30 {
+		t.Fatalf("unexpected total size. expected=%d, got=%d", 30, totalSize)
+	}
+	s3api.MissingFiles = map[string]bool{"data/f2": true}
+	err = s3inv.Validate(context.Background())
+	if !errors.Is(err, s3.ErrInventoryFileInaccessible) || !strings.Contains(err.Error(), "s3://other-bucket/data/f2") {
+		t.Fatalf("expected error %v naming s3://other-bucket/data/f2, got %v", s3.ErrInventoryFileInaccessible, err)
+	}
+}
+
+func TestInventoryStaleObjects(t *testing.T) {
+	manifestURL := "s3://example-bucket/manifest1.json"
+	s3api := &mockS3Client{
+		FilesByManifestURL: map[string][]string{manifestURL: {"f1", "f2"}},
+		ETags: map[string]string{
+			"f1row2": "etag-f1row2",
+			"f1row3": "changed",
+			"f2row1": "etag-f2row1",
+		},
+		MissingFiles: map[string]bool{"f2row2": true},
+	}
+	reader := &mockInventoryReader{openFiles: make(map[string]bool)}
+	inv, err := s3.GenerateInventory(context.Background(), logging.Default(), manifestURL, s3api, reader, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s3inv := inv.(*s3.Inventory)
+	stale, err := s3inv.StaleObjects(context.Background(), 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var staleKeys []string
+	for _, obj := range stale {
+		staleKeys = append(staleKeys, obj.Key)
+	}
+	if diff := deep.Equal(staleKeys, []string{"f1row3", "f2row2"}); diff != nil {
+		t.Fatalf("unexpected stale objects: %s", diff)
+	}
+	// delete markers are not sampled
+	if s3api.HeadObjectCalls != 4 {
+		t.Fatalf("unexpected number of objects checked. expected=%d, got=%d", 4, s3api.HeadObjectCalls)
+	}
+	if len(reader.openFiles) != 0 {
+		t.Fatalf("some files stayed open: %v", reader.openFiles)
+	}
+
+	s3api.HeadObjectCalls = 0
+	if _, err = s3inv.StaleObjects(context.Background(), 2); err != nil {
+		t.Fatal(err)
+	}
+	if s3api.HeadObjectCalls != 2 {
+		t.Fatalf("unexpected number of objects checked. expected=%d, got=%d", 2, s3api.HeadObjectCalls)
+	}
+
+	forbidden := awserr.NewRequestFailure(awserr.New("AccessDenied", "access denied", nil), http.StatusForbidden, "")
+	s3api.HeadObjectErr = forbidden
+	if _, err = s3inv.StaleObjects(context.Background(), 100); !errors.Is(err, forbidden) {
+		t.Fatalf("expected error %v, got %v", forbidden, err)
+	}
+}
+
+func TestInventoryValidate(t *testing.T) {
+	manifestURL := "s3://example-bucket/manifest1.json"
+	s3api := &mockS3Client{
+		FilesByManifestURL: map[string][]string{manifestURL: {"f1", "f2", "f3"}},
+	}
+	reader := &mockInventoryReader{openFiles: make(map[string]bool)}
+	inv, err := s3.GenerateInventory(context.Background(), logging.Default(), manifestURL, s3api, reader, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s3inv := inv.(*s3.Inventory)
+	if err = s3inv.Validate(context.Background()); err != nil {
+		t.Fatalf("unexpected error validating inventory: %v", err)
+	}
+	s3api.MissingFiles = map[string]bool{"f2": true}
+	err = s3inv.Validate(context.Background())
+	if !errors.Is(err, s3.ErrInventoryFileInaccessible) {
+		t.Fatalf("expected error %v, got %v", s3.ErrInventoryFileInaccessible, err)
+	}
+	if !strings.Contains(err.Error(), "s3://example-bucket/f2") ||
+		strings.Contains(err.Error(), "/f1") || strings.Contains(err.Error(), "/f3") {
+		t.Fatalf("expected error to name only the missing file, got %v", err)
+	}
+	s3api.MissingFiles = nil
+	noSuchBucket := awserr.NewRequestFailure(awserr.New(s3sdk.ErrCodeNoSuchBucket, "no such bucket", nil), http.StatusNotFound, "")
+	s3api.HeadBucketErrs = map[string]error{"example-bucket": noSuchBucket}
+	err = s3inv.Validate(context.Background())
+	if !errors.Is(err, inventorys3.ErrInventoryBucketUnavailable) || !errors.Is(err, noSuchBucket) {
+		t.Fatalf("expected error %v wrapping %v, got %v", inventorys3.ErrInventoryBucketUnavailable, noSuchBucket, err)
+	}
+	s3api.HeadBucketErrs = map[string]error{"example-bucket": awserr.New("RequestError", "send request failed", nil)}
+	if err = s3inv.Validate(context.Background()); err == nil || errors.Is(err, inventorys3.ErrInventoryBucketUnavailable) {
+		t.Fatalf("expected a transient error not to be reported as %v, got %v", inventorys3.ErrInventoryBucketUnavailable, err)
+	}
+	s3api.HeadBucketErrs = nil
+	s3inv.Manifest.Format = "unknown"
+	if err = s3inv.Validate(context.Background()); !errors.Is(err, inventorys3.ErrUnsupportedInventoryFormat) {
+		t.Fatalf("expected error %v, got %v", inventorys3.ErrUnsupportedInventoryFormat, err)
+	}
+}
+
+func TestDiffManifests(t *testing.T) {
+	oldManifestURL := "s3://example-bucket/manifest1.json"
+	newManifestURL := "s3://example-bucket/manifest2.json"
+	unsortedManifestURL := "s3://example-bucket/manifest3.json"
+	s3api := &mockS3Client{
+		FilesByManifestURL: map[string][]string{
+			oldManifestURL:      {"f_diff_old1", "f_diff_old2"},
+			newManifestURL:      {"f_diff_new1", "f_diff_new2"},
+			unsortedManifestURL: {"f_diff_desc"},
+		},
+	}
+	oldReader := &mockInventoryReader{openFiles: make(map[string]bool), sizes: map[string]int64{"d_row3": 3, "d_row5": 5, "d_row7": 7}}
+	newReader := &mockInventoryReader{openFiles: make(map[string]bool), sizes: map[string]int64{"d_row3": 3, "d_row5": 50}}
+	oldInv, err := s3.GenerateInventory(context.Background(), logging.Default(), oldManifestURL, s3api, oldReader, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newInv, err := s3.GenerateInventory(context.Background(), logging.Default(), newManifestURL, s3api, newReader, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	added, removed, changed, err := collectDiff(s3.DiffManifests(context.Background(), oldInv.(*s3.Inventory), newInv.(*s3.Inventory)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := deep.Equal(added, []string{"d_row4", "d_row6", "d_row8"}); diff != nil {
+		t.Errorf("unexpected added objects: %s", diff)
+	}
+	if diff := deep.Equal(removed, []string{"d_row1"}); diff != nil {
+		t.Errorf("unexpected removed objects: %s", diff)
+	}
+	if diff := deep.Equal(changed, []string{"d_row5", "d_row7"}); diff != nil {
+		t.Errorf("unexpected changed objects: %s", diff)
+	}
+	if len(oldReader.openFiles) != 0 || len(newReader.openFiles) != 0 {
+		t.Fatalf("expected all files to be closed, got %v and %v", oldReader.openFiles, newReader.openFiles)
+	}
+
+	unsortedInv, err := s3.GenerateInventory(context.Background(), logging.Default(), unsortedManifestURL, s3api, newReader, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, _, err = collectDiff(s3.DiffManifests(context.Background(), oldInv.(*s3.Inventory), unsortedInv.(*s3.Inventory)))
+	if !errors.Is(err, s3.ErrInventoryNotSorted) {
+		t.Fatalf("expected error %v, got %v", s3.ErrInventoryNotSorted, err)
+	}
+}
+
+// collectDiff returns the keys sent on each of the diff channels, consuming them concurrently.
+func collectDiff(added, removed, changed <-chan inventorys3.InventoryObject, errs <-chan error) ([]string, []string, []string, error) {
+	var addedKeys, removedKeys, changedKeys []string
+	for added != nil || removed != nil || changed != nil {
+		select {
+		case obj, ok := <-added:
+			if !ok {
+				added = nil
+				continue
+			}
+			addedKeys = append(addedKeys, obj.Key)
+		case obj, ok := <-removed:
+			if !ok {
+				removed = nil
+				continue
+			}
+			removedKeys = append(removedKeys, obj.Key)
+		case obj, ok := <-changed:
+			if !ok {
+				changed = nil
+				continue
+			}
+			changedKeys = append(changedKeys, obj.Key)
+		}
+	}
+	return addedKeys, removedKeys, changedKeys, <-errs
+}
+
+func TestInventoryIsVersioned(t *testing.T) {
+	manifest, err := ioutil.ReadFile("testdata/manifest.json")
+	if err != nil {
+		t.Fatalf("failed to read manifest: %v", err)
+	}
+	const manifestURL = "s3://example-inventory-destination-bucket/inventory/manifest.json"
+	testdata := []struct {
+		name     string
+		schema   string
+		expected bool
+	}{
+		{name: "versioned", schema: "Bucket, Key, VersionId, IsLatest, IsDeleteMarker, Size", expected: true},
+		{name: "current", schema: "Bucket, Key, Size, LastModifiedDate, ETag"},
+	}
+	for _, test := range testdata {
+		t.Run(test.name, func(t *testing.T) {
+			data := regexp.MustCompile(`"fileSchema": "[^"]*"`).ReplaceAll(manifest, []byte(`"fileSchema": "`+test.schema+`"`))
+			svc := &objectsS3Client{objects: map[string][]byte{"/inventory/manifest.json": data}}
+			inv, err := s3.NewAdapter(svc).GenerateInventory(context.Background(), logging.Default(), manifestURL, false)
+			if err != nil {
+				t.Fatalf("failed to generate inventory: %v", err)
+			}
+			if versioned := inv.(*s3.Inventory).IsVersioned(); versioned != test.expected {
+				t.Fatalf("unexpected versioned. expected=%t, got=%t", test.expected, versioned)
+			}
+		})
+	}
+}
+
+func TestGenerateInventoryFromPrefix(t *testing.T) {
+	manifest, err := ioutil.ReadFile("testdata/manifest.json")
+	if err != nil {
+		t.Fatalf("failed to read manifest: %v", err)
+	}
+	shard := func(creationTimestamp string, fileKeys ...string) []byte {
+		var fields map[string]interface{}
+		if err := json.Unmarshal(manifest, &fields); err != nil {
+			t.Fatalf("failed to decode manifest: %v", err)
+		}
+		files := make([]map[string]string, 0, len(fileKeys))
+		for _, key := range fileKeys {
+			files = append(files, map[string]string{"key": key})
+		}
+		fields["files"] = files
+		fields["creationTimestamp"] = creationTimestamp
+		data, err := json.Marshal(fields)
+		if err != nil {
+			t.Fatalf("failed to encode manifest: %v", err)
+		}
+		return data
+	}
+	const bucket = "example-inventory-destination-bucket"
+	const prefix = "inventory/2020-01-01T00-00Z/"
+	svc := &objectsS3Client{objects: map[string][]byte{
+		"/inventory/2020-01-01T00-00Z/shard1/manifest.json": shard("1577836800000", "f1", "f2"),
+		"/inventory/2020-01-01T00-00Z/shard2/manifest.json": shard("1577836800000", "f3"),
+		"/inventory/2020-01-02T00-00Z/shard1/manifest.json": shard("1577923200000", "f4"),
+	}}
+	adapter := s3.NewAdapter(svc)
+	inv, err := adapter.GenerateInventoryFromPrefix(context.Background(), logging.Default(), bucket, prefix, false)
+	if err != nil {
+		t.Fatalf("failed to read inventory from prefix: %v", err)
+	}
+	s3Inv := inv.(*s3.Inventory)
+	if diff := deep.Equal(s3Inv.FileKeys(), []string{"f1", "f2", "f3"}); diff != nil {
+		t.Fatalf("unexpected merged files: %s", diff)
+	}
+	if s3Inv.ManifestFileCount() != 3 || s3Inv.Format() != inventorys3.CSVFormatName {
+		t.Fatalf("unexpected merged manifest: files=%d, format=%s", s3Inv.ManifestFileCount(), s3Inv.Format())
+	}
+
+	// sorting reads the inventory files listed by the manifests, which are missing
+	_, err = adapter.GenerateInventoryFromPrefix(context.Background(), logging.Default(), bucket, prefix, true)
+	if err == nil || !strings.Contains(err.Error(), "failed to sort inventory files") {
+		t.Fatalf("expected sorting the missing inventory files to fail, got %v", err)
+	}
+
+	// manifests are verified against their checksums
+	verifying := s3.NewAdapter(svc, s3.WithVerifyManifestChecksum(true))
+	if _, err = verifying.GenerateInventoryFromPrefix(context.Background(), logging.Default(), bucket, prefix, false); err == nil {
+		t.Fatal("expected manifests without checksums to fail verification")
+	}
+	for _, shardDir := range []string{"shard1", "shard2"} {
+		sum := md5.Sum(svc.objects["/"+prefix+shardDir+"/manifest.json"])
+		svc.objects["/"+prefix+shardDir+"/manifest.checksum"] = []byte(hex.EncodeToString(sum[:]))
+	}
+	if _, err = verifying.GenerateInventoryFromPrefix(context.Background(), logging.Default(), bucket, prefix, false); err != nil {
+		t.Fatalf("failed to read verified inventory from prefix: %v", err)
+	}
+
+	_, err = adapter.GenerateInventoryFromPrefix(context.Background(), logging.Default(), bucket, "inventory/", false)
+	if !errors.Is(err, s3.ErrMultipleInventoryDeliveries) {
+		t.Fatalf("expected error %v, got %v", s3.ErrMultipleInventoryDeliveries, err)
+	}
+	svc.objects["/inventory/2020-01-01T00-00Z/shard3/manifest.json"] = bytes.Replace(shard("1577836800000", "f5"), []byte(`"fileFormat":"CSV"`), []byte(`"fileFormat":"ORC"`), 1)
+	_, err = adapter.GenerateInventoryFromPrefix(context.Background(), logging.Default(), bucket, prefix, false)
+	if !errors.Is(err, s3.ErrInconsistentManifests) {
+		t.Fatalf("expected error %v, got %v", s3.ErrInconsistentManifests, err)
+	}
+	_, err = adapter.GenerateInventoryFromPrefix(context.Background(), logging.Default(), bucket, "inventory/2020-01-03T00-00Z/", false)
+	if !errors.Is(err, s3.ErrNoManifests) {
+		t.Fatalf("expected error %v, got %v", s3.ErrNoManifests, err)
+	}
+}
+
+func TestInventoryCount(t *testing.T) {
+	manifestURL := "s3://example-bucket/manifest1.json"
+	s3api := &mockS3Client{
+		FilesByManifestURL: map[string][]string{manifestURL: {"f1", "f2", "f4"}},
+	}
+	reader := &mockInventoryReader{openFiles: make(map[string]bool)}
+	inv, err := s3.GenerateInventory(context.Background(), logging.Default(), manifestURL, s3api, reader, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	count, err := inv.(*s3.Inventory).Count(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 13 {
+		t.Fatalf("unexpected count. expected=%d, got=%d", 13, count)
+	}
+	if reader.readCalls != 0 {
+		t.Fatalf("expected parquet files to be counted from their metadata, got %d reads", reader.readCalls)
+	}
+	if len(reader.openFiles) != 0 {
+		t.Fatalf("some files stayed open: %v", reader.openFiles)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err = inv.(*s3.Inventory).Count(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected error %v, got %v", context.Canceled, err)
+	}
+}
+
+// newFakeS3 returns a client of a fake S3 server holding the given buckets, closed once the test is done.
+func newFakeS3(t *testing.T, buckets ...string) s3iface.S3API {
+	t.Helper()
+	ts := httptest.NewServer(gofakes3.New(s3mem.New()).Server())
+	t.Cleanup(ts.Close)
+	sess, err := session.NewSession(&aws.Config{
+		Credentials:      credentials.NewStaticCredentials("YOUR-ACCESSKEYID", "YOUR-SECRETACCESSKEY", ""),
+		Endpoint:         aws.String(ts.URL),
+		Region:           aws.String("eu-central-1"),
+		DisableSSL:       aws.Bool(true),
+		S3ForcePathStyle: aws.Bool(true),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc := s3sdk.New(sess)
+	for _, bucket := range buckets {
+		if _, err := svc.CreateBucket(&s3sdk.CreateBucketInput{Bucket: aws.String(bucket)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return svc
+}
+
+func TestIteratorPooledBuffers(t *testing.T) {
+	const inventoryBucket = "inventory-bucket"
+	svc := newFakeS3(t, inventoryBucket)
+	files := map[string][]string{
+		"data/f1.csv": {"a/row1", "a/row2", "a/row3"},
+		"data/f2.csv": {"b/row1", "b/row2"},
+	}
+	var expected []string
+	for _, fileKey := range []string{"data/f1.csv", "data/f2.csv"} {
+		var body strings.Builder
+		for _, key := range files[fileKey] {
+			fmt.Fprintf(&body, "\"source-bucket\",\"%s\",\"%d\",\"2021-01-02T03:04:05.678Z\"\n", key, len(key))
+			expected = append(expected, key)
+		}
+		_, err := svc.PutObject(&s3sdk.PutObjectInput{
+			Bucket: aws.String(inventoryBucket),
+			Key:    aws.String(fileKey),
+			Body:   strings.NewReader(body.String()),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	manifest := `{"sourceBucket": "source-bucket", "destinationBucket": "arn:aws:s3:::inventory-bucket",
+		"creationTimestamp": "1609556645000", "fileFormat": "CSV", "fileSchema": "Bucket, Key, Size, LastModifiedDate",
+		"files": [{"key": "data/f1.csv"}, {"key": "data/f2.csv"}]}`
+	_, err := svc.PutObject(&s3sdk.PutObjectInput{
+		Bucket: aws.String(inventoryBucket),
+		Key:    aws.String("manifest.json"),
+		Body:   strings.NewReader(manifest),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	reader := inventorys3.NewReader(ctx, svc, logging.Default(), inventorys3.WithPooledBuffers(true))
+	inv, err := s3.GenerateInventory(ctx, logging.Default(), "s3://inventory-bucket/manifest.json", svc, reader, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	it := inv.Iterator()
+	var keys []string
+	for it.Next() {
+		obj := it.Get()
+		if obj.Size != int64(len(obj.Key)) {
+			t.Errorf("unexpected size of %s. expected=%d, got=%d", obj.Key, len(obj.Key), obj.Size)
+		}
+		keys = append(keys, obj.Key)
+	}
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if diff := deep.Equal(keys, expected); diff != nil {
+		t.Fatalf("unexpected keys read with pooled buffers: %s", diff)
+	}
+}
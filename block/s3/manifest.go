@@ -0,0 +1,170 @@
+package s3
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/md5" //nolint:gosec
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"path"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+const (
+	manifestFilesField = "files"
+	gzipEncoding       = "gzip"
+	gzipSuffix         = ".gz"
+	manifestChecksum   = "manifest.checksum"
+	manifestFileName   = "manifest.json"
+)
+
+var (
+	ErrMalformedManifest        = errors.New("malformed inventory manifest")
+	ErrManifestChecksumMismatch = errors.New("inventory manifest checksum mismatch")
+)
+
+// ParseManifest reads the inventory manifest.json found at the given location.
+// Manifests compressed with gzip, detected by their content encoding or by a .gz suffix, are decompressed while read.
+func ParseManifest(ctx context.Context, svc s3iface.S3API, bucket string, key string) (*Manifest, error) {
+	return parseManifest(ctx, svc, bucket, key, false)
+}
+
+// parseManifest reads the manifest found at the given location. If verifyChecksum is set, the MD5 of the stored manifest
+// is compared with the one written by S3 to the manifest.checksum next to it.
+func parseManifest(ctx context.Context, svc s3iface.S3API, bucket string, key string, verifyChecksum bool) (*Manifest, error) {
+	var expectedChecksum string
+	if verifyChecksum {
+		var err error
+		expectedChecksum, err = readManifestChecksum(ctx, svc, bucket, path.Join(path.Dir(key), manifestChecksum))
+		if err != nil {
+			return nil, err
+		}
+	}
+	output, err := svc.GetObjectWithContext(ctx, &s3.GetObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)})
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = output.Body.Close()
+	}()
+	var raw io.Reader = output.Body
+	var h hash.Hash
+	if verifyChecksum {
+		h = md5.New() //nolint:gosec
+		raw = io.TeeReader(output.Body, h)
+	}
+	body := raw
+	if strings.HasSuffix(key, gzipSuffix) || aws.StringValue(output.ContentEncoding) == gzipEncoding {
+		gzipReader, err := gzip.NewReader(raw)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrMalformedManifest, err)
+		}
+		defer func() {
+			_ = gzipReader.Close()
+		}()
+		body = gzipReader
+	}
+	m, err := decodeManifest(body)
+	if err != nil || !verifyChecksum {
+		return m, err
+	}
+	// the decoder may stop before the end of the stored object
+	if _, err := io.Copy(ioutil.Discard, raw); err != nil {
+		return nil, err
+	}
+	if checksum := hex.EncodeToString(h.Sum(nil)); checksum != expectedChecksum {
+		return nil, fmt.Errorf("%w: expected %s, got %s", ErrManifestChecksumMismatch, expectedChecksum, checksum)
+	}
+	return m, nil
+}
+
+// readManifestChecksum returns the hex encoded MD5 stored in the manifest.checksum at the given location.
+func readManifestChecksum(ctx context.Context, svc s3iface.S3API, bucket string, key string) (string, error) {
+	output, err := svc.GetObjectWithContext(ctx, &s3.GetObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)})
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", manifestChecksum, err)
+	}
+	defer func() {
+		_ = output.Body.Close()
+	}()
+	data, err := ioutil.ReadAll(output.Body)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", manifestChecksum, err)
+	}
+	return strings.ToLower(strings.TrimSpace(string(data))), nil
+}
+
+// decodeManifest decodes a manifest from r, streaming through the list of files so that the raw
+// document is never held in memory as a whole.
+func decodeManifest(r io.Reader) (*Manifest, error) {
+	dec := json.NewDecoder(r)
+	if err := expectDelim(dec, '{'); err != nil {
+		return nil, err
+	}
+	fields := make(map[string]json.RawMessage)
+	var files []inventoryFile
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrMalformedManifest, err)
+		}
+		name, ok := t.(string)
+		if !ok {
+			return nil, fmt.Errorf("%w: unexpected token %v", ErrMalformedManifest, t)
+		}
+		if name != manifestFilesField {
+			var value json.RawMessage
+			if err := dec.Decode(&value); err != nil {
+				return nil, fmt.Errorf("%w: field %s: %s", ErrMalformedManifest, name, err)
+			}
+			fields[name] = value
+			continue
+		}
+		if err := expectDelim(dec, '['); err != nil {
+			return nil, err
+		}
+		for dec.More() {
+			var f inventoryFile
+			if err := dec.Decode(&f); err != nil {
+				return nil, fmt.Errorf("%w: files: %s", ErrMalformedManifest, err)
+			}
+			files = append(files, f)
+		}
+		if err := expectDelim(dec, ']'); err != nil {
+			return nil, err
+		}
+	}
+	if err := expectDelim(dec, '}'); err != nil {
+		return nil, err
+	}
+	header, err := json.Marshal(fields)
+	if err != nil {
+		return nil, err
+	}
+	var m Manifest
+	if err := json.Unmarshal(header, &m); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrMalformedManifest, err)
+	}
+	m.Files = files
+	return &m, nil
+}
+
+func expectDelim(dec *json.Decoder, delim json.Delim) error {
+	t, err := dec.Token()
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrMalformedManifest, err)
+	}
+	if d, ok := t.(json.Delim); !ok || d != delim {
+		return fmt.Errorf("%w: expected %s, got %v", ErrMalformedManifest, delim, t)
+	}
+	return nil
+}
@@ -5,6 +5,7 @@ go 1.15
 require (
 	cloud.google.com/go v0.63.0 // indirect
 	cloud.google.com/go/storage v1.10.0
+	github.com/Azure/azure-storage-blob-go v0.10.0
 	github.com/Masterminds/squirrel v1.4.0
 	github.com/apache/thrift v0.13.0
 	github.com/aws/aws-sdk-go v1.34.0
@@ -42,8 +43,9 @@ require (
 	github.com/jessevdk/go-flags v1.4.0
 	github.com/jmoiron/sqlx v1.2.1-0.20190826204134-d7d95172beb5
 	github.com/johannesboyne/gofakes3 v0.0.0-20200716060623-6b2b4cb092cc
-	github.com/klauspost/compress v1.10.10 // indirect
+	github.com/klauspost/compress v1.10.10
 	github.com/lib/pq v1.8.0 // indirect
+	github.com/linkedin/goavro/v2 v2.9.7
 	github.com/lunixbochs/vtclean v1.0.0 // indirect
 	github.com/mailru/easyjson v0.7.2 // indirect
 	github.com/manifoldco/promptui v0.7.0
@@ -78,6 +80,7 @@ require (
 	golang.org/x/exp v0.0.0-20200513190911-00229845015e // indirect
 	golang.org/x/net v0.0.0-20200813134508-3edf25e44fcc
 	golang.org/x/oauth2 v0.0.0-20200107190931-bf48bf16ab8d
+	golang.org/x/sync v0.0.0-20200625203802-6e8e738ad208
 	golang.org/x/sys v0.0.0-20200817155316-9781c653f443 // indirect
 	golang.org/x/tools v0.0.0-20200818005847-188abfa75333 // indirect
 	gonum.org/v1/netlib v0.0.0-20200603212716-16abd5ac5bc7 // indirect
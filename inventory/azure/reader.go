@@ -0,0 +1,66 @@
+package azure
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/Azure/azure-storage-blob-go/azblob"
+	inventorys3 "github.com/treeverse/lakefs/inventory/s3"
+	"github.com/treeverse/lakefs/logging"
+)
+
+// InventoryColumnNames maps inventory columns to the fields of Azure Blob Storage inventories holding them.
+var InventoryColumnNames = inventorys3.ColumnNames{
+	"key":                "Name",
+	"size":               "Content-Length",
+	"last_modified_date": "Last-Modified",
+	"e_tag":              "Etag",
+	"is_latest":          "IsCurrentVersion",
+	"is_delete_marker":   "Deleted",
+	"version_id":         "VersionId",
+}
+
+// Reader reads inventory files stored in Azure Blob Storage.
+// Files are downloaded to local temporary files, and parsed by the same readers used for S3 inventories.
+// Azure inventories list the blobs of a single container and have no bucket column, so the container they describe
+// is set as the bucket of the listed objects.
+// Parquet and ORC inventories are supported, with the columns named as in InventoryColumnNames.
+type Reader struct {
+	ctx             context.Context
+	serviceURL      azblob.ServiceURL
+	sourceContainer string
+	logger          logging.Logger
+}
+
+// NewReader returns a reader of inventory files using serviceURL. A nil ctx is replaced with context.Background().
+func NewReader(ctx context.Context, serviceURL azblob.ServiceURL, sourceContainer string, logger logging.Logger) *Reader {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return &Reader{ctx: ctx, serviceURL: serviceURL, sourceContainer: sourceContainer, logger: logger}
+}
+
+// GetFileReader returns a reader for the inventory file stored in the given container and key.
+func (o *Reader) GetFileReader(format string, schema string, container string, key string) (inventorys3.FileReader, error) {
+	if format != inventorys3.ParquetFormatName && format != inventorys3.OrcFormatName {
+		return nil, fmt.Errorf("%w: azure inventories are read in parquet or orc format only, got %s", inventorys3.ErrUnsupportedInventoryFormat, format)
+	}
+	localFilename, err := o.download(format, container, key)
+	if err != nil {
+		return nil, err
+	}
+	return inventorys3.NewLocalFileReader(o.ctx, format, schema, localFilename, true,
+		inventorys3.WithColumnNames(InventoryColumnNames), inventorys3.WithBucket(o.sourceContainer))
+}
+
+func (o *Reader) GetMetadataReader(format string, schema string, container string, key string) (inventorys3.MetadataReader, error) {
+	return o.GetFileReader(format, schema, container, key)
+}
+
+func (o *Reader) download(format string, container string, key string) (string, error) {
+	return inventorys3.DownloadLocalFile(o.logger, format, container, key, func(f *os.File) error {
+		blobURL := o.serviceURL.NewContainerURL(container).NewBlobURL(key)
+		return azblob.DownloadBlobToFile(o.ctx, blobURL, 0, azblob.CountToEnd, f, azblob.DownloadFromBlobOptions{})
+	})
+}
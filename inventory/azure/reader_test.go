@@ -0,0 +1,137 @@
+package azure_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-openapi/swag"
+	"github.com/go-test/deep"
+	"github.com/scritchley/orc"
+	"github.com/treeverse/lakefs/inventory/azure"
+	inventorys3 "github.com/treeverse/lakefs/inventory/s3"
+	"github.com/treeverse/lakefs/inventory/testutil"
+	"github.com/treeverse/lakefs/logging"
+	"github.com/xitongsys/parquet-go-source/writerfile"
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+type azureParquetRow struct {
+	Name             string  `parquet:"name=Name, type=UTF8"`
+	ContentLength    *int64  `parquet:"name=Content-Length, type=INT_64"`
+	LastModified     *int64  `parquet:"name=Last-Modified, type=TIMESTAMP_MILLIS"`
+	Etag             *string `parquet:"name=Etag, type=UTF8"`
+	IsCurrentVersion *bool   `parquet:"name=IsCurrentVersion, type=BOOLEAN"`
+	Deleted          *bool   `parquet:"name=Deleted, type=BOOLEAN"`
+	VersionID        *string `parquet:"name=VersionId, type=UTF8"`
+}
+
+var lastModified = time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func azureParquet(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	pw, err := writer.NewParquetWriter(writerfile.NewWriterFile(&buf), new(azureParquetRow), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	millis := lastModified.UnixNano() / int64(time.Millisecond)
+	rows := []azureParquetRow{
+		{Name: "k1", ContentLength: swag.Int64(1), LastModified: &millis, Etag: swag.String("e1"), IsCurrentVersion: swag.Bool(true), Deleted: swag.Bool(false), VersionID: swag.String("v1")},
+		{Name: "k2", ContentLength: swag.Int64(2), LastModified: &millis, Etag: swag.String("e2"), IsCurrentVersion: swag.Bool(false), Deleted: swag.Bool(true), VersionID: swag.String("v2")},
+	}
+	for _, row := range rows {
+		if err = pw.Write(row); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = pw.WriteStop(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func azureOrc(t *testing.T) []byte {
+	t.Helper()
+	stringField := orc.SetCategory(orc.CategoryString)
+	booleanField := orc.SetCategory(orc.CategoryBoolean)
+	typeDescription, err := orc.NewTypeDescription(orc.SetCategory(orc.CategoryStruct),
+		orc.AddField("Name", stringField),
+		orc.AddField("Content-Length", orc.SetCategory(orc.CategoryLong)),
+		orc.AddField("Last-Modified", orc.SetCategory(orc.CategoryTimestamp)),
+		orc.AddField("Etag", stringField),
+		orc.AddField("IsCurrentVersion", booleanField),
+		orc.AddField("Deleted", booleanField),
+		orc.AddField("VersionId", stringField))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	w, err := orc.NewWriter(&buf, orc.SetSchema(typeDescription))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows := [][]interface{}{
+		{"k1", int64(1), lastModified, "e1", true, false, "v1"},
+		{"k2", int64(2), lastModified, "e2", false, true, "v2"},
+	}
+	for _, row := range rows {
+		if err = w.Write(row...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestReaderColumnNames(t *testing.T) {
+	serviceURL := testutil.NewFakeAzureServiceURL(t, map[string][]byte{
+		"/inventory/data.parquet": azureParquet(t),
+		"/inventory/data.orc":     azureOrc(t),
+	})
+	reader := azure.NewReader(context.Background(), serviceURL, "source-container", logging.Default())
+	millis := lastModified.UnixNano() / int64(time.Millisecond)
+	expected := []inventorys3.InventoryObject{
+		{Bucket: "source-container", Key: "k1", Size: swag.Int64(1), LastModifiedMillis: &millis, Checksum: swag.String("e1"),
+			IsLatest: swag.Bool(true), IsDeleteMarker: swag.Bool(false), VersionID: swag.String("v1")},
+		{Bucket: "source-container", Key: "k2", Size: swag.Int64(2), LastModifiedMillis: &millis, Checksum: swag.String("e2"),
+			IsLatest: swag.Bool(false), IsDeleteMarker: swag.Bool(true), VersionID: swag.String("v2")},
+	}
+	testdata := []struct {
+		format string
+		key    string
+	}{
+		{format: inventorys3.ParquetFormatName, key: "data.parquet"},
+		{format: inventorys3.OrcFormatName, key: "data.orc"},
+	}
+	for _, test := range testdata {
+		t.Run(test.format, func(t *testing.T) {
+			fileReader, err := reader.GetFileReader(test.format, "", "inventory", test.key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() {
+				_ = fileReader.Close()
+			}()
+			res := make([]inventorys3.InventoryObject, len(expected))
+			if err = fileReader.Read(&res); err != nil {
+				t.Fatal(err)
+			}
+			if diff := deep.Equal(res, expected); diff != nil {
+				t.Fatalf("unexpected objects: %s", diff)
+			}
+		})
+	}
+
+	_, err := reader.GetFileReader(inventorys3.CSVFormatName, "", "inventory", "data.csv")
+	if !errors.Is(err, inventorys3.ErrUnsupportedInventoryFormat) {
+		t.Fatalf("expected error %v, got %v", inventorys3.ErrUnsupportedInventoryFormat, err)
+	}
+	if _, err = reader.GetFileReader(inventorys3.OrcFormatName, "", "inventory", "missing.orc"); err == nil {
+		t.Fatal("expected reading a missing blob to fail")
+	}
+}
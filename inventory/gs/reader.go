@@ -0,0 +1,95 @@
+package gs
+
+import (
+	"context"
+	"io"
+	"os"
+
+	"cloud.google.com/go/storage"
+	inventorys3 "github.com/treeverse/lakefs/inventory/s3"
+	"github.com/treeverse/lakefs/logging"
+)
+
+// Reader reads inventory files stored in Google Cloud Storage.
+// Files are downloaded to local temporary files, and parsed by the same readers used for S3 inventories.
+type Reader struct {
+	ctx    context.Context
+	client *storage.Client
+	logger logging.Logger
+}
+
+// NewReader returns a reader of inventory files using client. A nil ctx is replaced with context.Background().
+func NewReader(ctx context.Context, client *storage.Client, logger logging.Logger) *Reader {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return &Reader{ctx: ctx, client: client, logger: logger}
+}
+
+func (o *Reader) GetFileReader(format string, schema string, bucket string, key string) (inventorys3.FileReader, error) {
+	localFilename, err := o.download(format, bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	return inventorys3.NewLocalFileReader(o.ctx, format, schema, localFilename, true)
+}
+
+// GetMetadataReader returns a reader for the metadata of the inventory file stored in the given bucket and key. Only the
+// end of ORC and Parquet files, which holds their metadata, is downloaded. Files of other formats carry no metadata,
+// and are downloaded in full.
+func (o *Reader) GetMetadataReader(format string, schema string, bucket string, key string) (inventorys3.MetadataReader, error) {
+	if format != inventorys3.OrcFormatName && format != inventorys3.ParquetFormatName {
+		return o.GetFileReader(format, schema, bucket, key)
+	}
+	localFilename, err := o.downloadTail(format, bucket, key, inventorys3.MetadataTailSize)
+	if err != nil {
+		return nil, err
+	}
+	tailLength, err := inventorys3.MetadataTailLength(format, localFilename)
+	if err != nil || tailLength > inventorys3.MetadataTailSize {
+		o.remove(localFilename)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if tailLength > inventorys3.MetadataTailSize {
+		// the metadata did not fit in the downloaded end of the file
+		localFilename, err = o.downloadTail(format, bucket, key, tailLength)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return inventorys3.NewLocalFileReader(o.ctx, format, schema, localFilename, true)
+}
+
+func (o *Reader) download(format string, bucket string, key string) (string, error) {
+	return inventorys3.DownloadLocalFile(o.logger, format, bucket, key, func(f *os.File) error {
+		return o.downloadTo(f, bucket, key, 0)
+	})
+}
+
+// downloadTail downloads the last length bytes of the given file, or all of it if it is shorter.
+func (o *Reader) downloadTail(format string, bucket string, key string, length int64) (string, error) {
+	return inventorys3.DownloadLocalFile(o.logger, format, bucket, key, func(f *os.File) error {
+		return o.downloadTo(f, bucket, key, -length)
+	})
+}
+
+func (o *Reader) remove(localFilename string) {
+	if err := os.Remove(localFilename); err != nil {
+		o.logger.WithField("local_file", localFilename).WithError(err).Error("failed to remove inventory file")
+	}
+}
+
+// downloadTo writes the given file to w from offset to its end, or its last -offset bytes if offset is negative.
+func (o *Reader) downloadTo(w io.Writer, bucket string, key string, offset int64) error {
+	r, err := o.client.Bucket(bucket).Object(key).NewRangeReader(o.ctx, offset, -1)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = r.Close()
+	}()
+	_, err = io.Copy(w, r)
+	return err
+}
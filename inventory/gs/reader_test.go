@@ -0,0 +1,209 @@
+package gs_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/storage"
+	"github.com/go-test/deep"
+	"github.com/scritchley/orc"
+	"github.com/treeverse/lakefs/inventory/gs"
+	inventorys3 "github.com/treeverse/lakefs/inventory/s3"
+	"github.com/treeverse/lakefs/inventory/testutil"
+	"github.com/treeverse/lakefs/logging"
+	"github.com/xitongsys/parquet-go-source/writerfile"
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+func gzipped(t *testing.T, rows string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write([]byte(rows)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestReader(t *testing.T) {
+	client := testutil.NewFakeGCSClient(t, map[string][]byte{
+		"/inventory-bucket/inventory/data.csv.gz": gzipped(t, "\"b\",\"k1\",\"10\"\n\"b\",\"k2\",\"20\"\n"),
+	})
+	reader := gs.NewReader(context.Background(), client, logging.Default())
+	fileReader, err := reader.GetFileReader(inventorys3.CSVFormatName, "Bucket, Key, Size", "inventory-bucket", "inventory/data.csv.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fileReader.GetNumRows() != 2 {
+		t.Fatalf("unexpected number of rows. expected=2, got=%d", fileReader.GetNumRows())
+	}
+	res := make([]inventorys3.InventoryObject, 2)
+	if err = fileReader.Read(&res); err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for _, obj := range res {
+		keys = append(keys, obj.Key)
+	}
+	if diff := deep.Equal(keys, []string{"k1", "k2"}); diff != nil {
+		t.Fatalf("unexpected keys read: %s", diff)
+	}
+	if err = fileReader.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = reader.GetFileReader(inventorys3.CSVFormatName, "Bucket, Key, Size", "inventory-bucket", "inventory/missing.csv.gz")
+	if !errors.Is(err, storage.ErrObjectNotExist) {
+		t.Fatalf("expected error %v, got %v", storage.ErrObjectNotExist, err)
+	}
+}
+
+type parquetRow struct {
+	Bucket string `parquet:"name=bucket, type=UTF8"`
+	Key    string `parquet:"name=key, type=UTF8"`
+	Size   int64  `parquet:"name=size, type=INT_64"`
+}
+
+// objectKeys returns numRows sorted keys. Every key at a multiple of paddedEvery, and the one before it, is padded with
+// padding bytes, so that files with a part starting at each multiple hold statistics of that many bytes in their
+// metadata.
+func objectKeys(numRows int, paddedEvery int, padding int) []string {
+	keys := make([]string, numRows)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("k%05d", i)
+		if paddedEvery > 0 && (i%paddedEvery == 0 || (i+1)%paddedEvery == 0 || i == numRows-1) {
+			keys[i] += strings.Repeat("x", padding)
+		}
+	}
+	return keys
+}
+
+func generateParquet(t *testing.T, keys []string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	pw, err := writer.NewParquetWriter(writerfile.NewWriterFile(&buf), new(parquetRow), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pw.RowGroupSize = 64 * 1024
+	for i, key := range keys {
+		if err = pw.Write(parquetRow{Bucket: "b", Key: key, Size: int64(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = pw.WriteStop(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func generateOrc(t *testing.T, keys []string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	schema, err := orc.ParseSchema("struct<bucket:string,key:string,size:int>")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := orc.NewWriter(&buf, orc.SetSchema(schema), orc.SetStripeTargetSize(64*1024))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, key := range keys {
+		if err = w.Write("b", key, int64(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// tailLength returns the length of the metadata at the end of the given inventory file.
+func tailLength(t *testing.T, format string, data []byte) int64 {
+	t.Helper()
+	f, err := ioutil.TempFile("", "inventory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.Remove(f.Name())
+	}()
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	length, err := inventorys3.MetadataTailLength(format, f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return length
+}
+
+func TestMetadataReader(t *testing.T) {
+	// the ORC writer starts a new stripe at most every 10000 rows
+	orcLargeTailKeys := objectKeys(40000, 10000, 5000)
+	parquetLargeTailKeys := objectKeys(2000, 1, 1000)
+	testdata := []struct {
+		name      string
+		format    string
+		keys      []string
+		largeTail bool // whether the metadata does not fit in the end of the file downloaded first
+		small     bool // whether the whole file fits in the end of the file downloaded first
+	}{
+		{name: "parquet", format: inventorys3.ParquetFormatName, keys: objectKeys(20000, 0, 0)},
+		{name: "parquet_large_tail", format: inventorys3.ParquetFormatName, keys: parquetLargeTailKeys, largeTail: true},
+		{name: "parquet_small", format: inventorys3.ParquetFormatName, keys: objectKeys(10, 0, 0), small: true},
+		{name: "orc", format: inventorys3.OrcFormatName, keys: objectKeys(20000, 0, 0)},
+		{name: "orc_large_tail", format: inventorys3.OrcFormatName, keys: orcLargeTailKeys, largeTail: true},
+	}
+	for _, test := range testdata {
+		t.Run(test.name, func(t *testing.T) {
+			var data []byte
+			if test.format == inventorys3.OrcFormatName {
+				data = generateOrc(t, test.keys)
+			} else {
+				data = generateParquet(t, test.keys)
+			}
+			if largeTail := tailLength(t, test.format, data) > inventorys3.MetadataTailSize; largeTail != test.largeTail {
+				t.Fatalf("expected metadata larger than %d bytes: %t, got %t", inventorys3.MetadataTailSize, test.largeTail, largeTail)
+			}
+			if small := int64(len(data)) <= inventorys3.MetadataTailSize; small != test.small {
+				t.Fatalf("expected a file of at most %d bytes: %t, got %d bytes", inventorys3.MetadataTailSize, test.small, len(data))
+			}
+			const path = "/inventory-bucket/inventory/data"
+			client, served := testutil.NewFakeGCSClientCountingBytes(t, map[string][]byte{path: data})
+			reader := gs.NewReader(context.Background(), client, logging.Default())
+			mr, err := reader.GetMetadataReader(test.format, "", "inventory-bucket", "inventory/data")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() {
+				_ = mr.Close()
+			}()
+			if mr.GetNumRows() != int64(len(test.keys)) {
+				t.Fatalf("unexpected number of rows. expected=%d, got=%d", len(test.keys), mr.GetNumRows())
+			}
+			firstKey, lastKey := test.keys[0], test.keys[len(test.keys)-1]
+			if mr.FirstObjectKey() != firstKey || mr.LastObjectKey() != lastKey {
+				t.Fatalf("unexpected key range. expected=%.10s-%.10s, got=%.10s-%.10s", firstKey, lastKey, mr.FirstObjectKey(), mr.LastObjectKey())
+			}
+			if !test.small && served.Get(path) >= int64(len(data)) {
+				t.Fatalf("expected only the metadata of the file to be read, read %d bytes of %d", served.Get(path), len(data))
+			}
+		})
+	}
+}
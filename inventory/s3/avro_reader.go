@@ -0,0 +1,261 @@
+package s3
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"time"
+
+	"github.com/go-openapi/swag"
+	"github.com/linkedin/goavro/v2"
+)
+
+var ErrMalformedAvroRecord = errors.New("malformed avro inventory record")
+
+type AvroInventoryFileReader struct {
+	ctx      context.Context
+	file     *os.File
+	reader   *goavro.OCFReader
+	numRows  int64
+	firstKey string
+	lastKey  string
+	filter   objectFilter
+	// bytesRead is shared by the OCF readers of the file, which is read again on every rewind
+	bytesRead  *byteCounter
+	buffers    objectBuffers
+	metrics    *fileReadMetrics
+	removePath string
+	tempDisk   *diskReservation
+}
+
+// NewAvroInventoryFileReader returns a reader for the given Avro object container file, after validating its schema.
+// Blocks compressed with any codec supported by goavro, such as deflate and snappy, are decompressed while read.
+// Since Avro files carry no statistics, the file is scanned once upfront to count its rows and find its first and last keys.
+func NewAvroInventoryFileReader(ctx context.Context, f *os.File) (*AvroInventoryFileReader, error) {
+	r := &AvroInventoryFileReader{
+		ctx:       ctx,
+		file:      f,
+		bytesRead: &byteCounter{},
+	}
+	if err := r.rewind(); err != nil {
+		return nil, err
+	}
+	if err := validateAvroSchema(r.reader.Codec().Schema()); err != nil {
+		return nil, err
+	}
+	if err := r.scanMetadata(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// validateAvroSchema checks that the record schema of an Avro file contains the required inventory columns.
+func validateAvroSchema(schema string) error {
+	var record struct {
+		Fields []struct {
+			Name string `json:"name"`
+		} `json:"fields"`
+	}
+	if err := json.Unmarshal([]byte(schema), &record); err != nil {
+		return fmt.Errorf("%w: %s", ErrMalformedAvroRecord, err)
+	}
+	found := make(map[string]bool)
+	for _, field := range record.Fields {
+		found[field.Name] = true
+	}
+	for _, column := range inventoryColumns {
+		if column.required && !found[column.name] {
+			return fmt.Errorf("%w: %q", ErrMissingInventoryColumn, column.name)
+		}
+	}
+	return nil
+}
+
+func (r *AvroInventoryFileReader) rewind() error {
+	if _, err := r.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	var err error
+	r.reader, err = goavro.NewOCFReader(&countingReader{r: r.file, counter: r.bytesRead})
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrMalformedAvroRecord, err)
+	}
+	return nil
+}
+
+func (r *AvroInventoryFileReader) scanMetadata() error {
+	for r.reader.Scan() {
+		obj, err := r.next()
+		if err != nil {
+			return err
+		}
+		if r.numRows == 0 || obj.Key < r.firstKey {
+			r.firstKey = obj.Key
+		}
+		if obj.Key > r.lastKey {
+			r.lastKey = obj.Key
+		}
+		r.numRows++
+	}
+	if err := r.reader.Err(); err != nil {
+		return fmt.Errorf("%w: %s", ErrMalformedAvroRecord, err)
+	}
+	return r.rewind()
+}
+
+func (r *AvroInventoryFileReader) next() (InventoryObject, error) {
+	datum, err := r.reader.Read()
+	if err != nil {
+		return InventoryObject{}, fmt.Errorf("%w: %s", ErrMalformedAvroRecord, err)
+	}
+	record, ok := datum.(map[string]interface{})
+	if !ok {
+		return InventoryObject{}, fmt.Errorf("%w: expected record, got %T", ErrMalformedAvroRecord, datum)
+	}
+	return inventoryObjectFromAvroRecord(record)
+}
+
+// avroValue returns the value of the given field in the record, unwrapping it if it is a union.
+// It returns nil if the field is missing or null.
+func avroValue(record map[string]interface{}, field string) interface{} {
+	v := record[field]
+	if union, ok := v.(map[string]interface{}); ok {
+		for _, unionValue := range union {
+			return unionValue
+		}
+		return nil
+	}
+	return v
+}
+
+func inventoryObjectFromAvroRecord(record map[string]interface{}) (InventoryObject, error) {
+	var res InventoryObject
+	var ok bool
+	if res.Bucket, ok = avroValue(record, "bucket").(string); !ok {
+		return res, fmt.Errorf("%w: %q", ErrIncompatibleInventoryColumn, "bucket")
+	}
+	if res.Key, ok = avroValue(record, "key").(string); !ok {
+		return res, fmt.Errorf("%w: %q", ErrIncompatibleInventoryColumn, "key")
+	}
+	if v := avroValue(record, "size"); v != nil {
+		size, err := objectSize(v)
+		if err != nil {
+			return res, &rowError{kind: ErrIncompatibleInventoryColumn, err: fmt.Errorf("key %s: %w", res.Key, err)}
+		}
+		res.Size = swag.Int64(size)
+	}
+	switch v := avroValue(record, "last_modified_date").(type) {
+	case nil:
+	case time.Time:
+		res.LastModifiedMillis = swag.Int64(v.UnixNano() / int64(time.Millisecond))
+	case int64:
+		res.LastModifiedMillis = swag.Int64(v)
+	default:
+		return res, fmt.Errorf("%w: column %q has type %T", ErrIncompatibleInventoryColumn, "last_modified_date", v)
+	}
+	switch v := avroValue(record, "e_tag").(type) {
+	case nil:
+	case string:
+		res.Checksum = swag.String(v)
+	default:
+		return res, fmt.Errorf("%w: column %q has type %T", ErrIncompatibleInventoryColumn, "e_tag", v)
+	}
+	switch v := avroValue(record, "is_latest").(type) {
+	case nil:
+	case bool:
+		res.IsLatest = swag.Bool(v)
+	default:
+		return res, fmt.Errorf("%w: column %q has type %T", ErrIncompatibleInventoryColumn, "is_latest", v)
+	}
+	switch v := avroValue(record, "version_id").(type) {
+	case nil:
+	case string:
+		res.VersionID = swag.String(v)
+	default:
+		return res, fmt.Errorf("%w: column %q has type %T", ErrIncompatibleInventoryColumn, "version_id", v)
+	}
+	switch v := avroValue(record, "is_delete_marker").(type) {
+	case nil:
+	case bool:
+		res.IsDeleteMarker = swag.Bool(v)
+	default:
+		return res, fmt.Errorf("%w: column %q has type %T", ErrIncompatibleInventoryColumn, "is_delete_marker", v)
+	}
+	switch v := avroValue(record, "storage_class").(type) {
+	case nil:
+	case string:
+		res.StorageClass = v
+	default:
+		return res, fmt.Errorf("%w: column %q has type %T", ErrIncompatibleInventoryColumn, "storage_class", v)
+	}
+	switch v := avroValue(record, "is_multipart_uploaded").(type) {
+	case nil:
+	case bool:
+		res.IsMultipartUploaded = v
+	default:
+		return res, fmt.Errorf("%w: column %q has type %T", ErrIncompatibleInventoryColumn, "is_multipart_uploaded", v)
+	}
+	return res, nil
+}
+
+func (r *AvroInventoryFileReader) Read(dstInterface interface{}) (err error) {
+	start := time.Now()
+	defer func() {
+		r.metrics.observeRead(start, dstInterface, err)
+	}()
+	num := reflect.ValueOf(dstInterface).Elem().Len()
+	res := r.buffers.get(num)
+	for len(res) < num && r.reader.Scan() {
+		select {
+		case <-r.ctx.Done():
+			return r.ctx.Err()
+		default:
+		}
+		obj, err := r.next()
+		if err != nil {
+			return err
+		}
+		if r.filter.match(&obj) {
+			res = append(res, obj)
+		}
+	}
+	if err := r.reader.Err(); err != nil {
+		return fmt.Errorf("%w: %s", ErrMalformedAvroRecord, err)
+	}
+	reflect.ValueOf(dstInterface).Elem().Set(reflect.ValueOf(res))
+	if len(res) == 0 && num > 0 {
+		return ErrNoMoreRows
+	}
+	return nil
+}
+
+func (r *AvroInventoryFileReader) Rewind() error {
+	return r.rewind()
+}
+
+// BytesRead returns the number of bytes read from the file so far, including while scanning it when opened.
+func (r *AvroInventoryFileReader) BytesRead() int64 {
+	return r.bytesRead.load()
+}
+
+func (r *AvroInventoryFileReader) GetNumRows() int64 {
+	return r.numRows
+}
+
+func (r *AvroInventoryFileReader) FirstObjectKey() string {
+	return r.firstKey
+}
+
+func (r *AvroInventoryFileReader) LastObjectKey() string {
+	return r.lastKey
+}
+
+func (r *AvroInventoryFileReader) Close() error {
+	r.buffers.release()
+	defer r.tempDisk.release()
+	return removeClosedFile(r.removePath, r.file.Close())
+}
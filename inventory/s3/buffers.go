@@ -0,0 +1,47 @@
+package s3
+
+import "sync"
+
+// objectSlicePool holds the slices of objects released by file readers reading WithPooledBuffers.
+var objectSlicePool = sync.Pool{
+	New: func() interface{} {
+		return new([]InventoryObject)
+	},
+}
+
+// objectBuffers allocates the slices of objects returned by the Read calls of a file reader. Pooled slices are taken
+// from objectSlicePool, and the slice returned by the previous Read is released to it, so that only the slice returned
+// by the last Read may be used.
+type objectBuffers struct {
+	pooled bool
+	last   *[]InventoryObject // the slice returned by the last Read, if pooled
+}
+
+// get returns an empty slice with room for n objects, releasing the slice returned by the previous call.
+func (b *objectBuffers) get(n int) []InventoryObject {
+	if !b.pooled {
+		return make([]InventoryObject, 0, n)
+	}
+	b.release()
+	buf := objectSlicePool.Get().(*[]InventoryObject)
+	if cap(*buf) < n {
+		*buf = make([]InventoryObject, 0, n)
+	}
+	b.last = buf
+	return (*buf)[:0]
+}
+
+// release returns the slice returned by the last call to get to the pool, after clearing the objects it holds so that
+// they are neither retained nor seen by its next user.
+func (b *objectBuffers) release() {
+	if b.last == nil {
+		return
+	}
+	buf := (*b.last)[:cap(*b.last)]
+	for i := range buf {
+		buf[i] = InventoryObject{}
+	}
+	*b.last = buf[:0]
+	objectSlicePool.Put(b.last)
+	b.last = nil
+}
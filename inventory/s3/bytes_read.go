@@ -0,0 +1,83 @@
+package s3
+
+import (
+	"io"
+	"sync/atomic"
+
+	"github.com/treeverse/lakefs/logging"
+	"github.com/xitongsys/parquet-go/source"
+)
+
+// minBytesReadRatio is the part of a file expected to be read by a reader which read all its rows and columns.
+// Reading less hints at a truncated file or read.
+const minBytesReadRatio = 0.5
+
+// byteCounter counts the bytes read from a file, possibly through several handles read at once.
+type byteCounter struct {
+	n int64
+}
+
+func (c *byteCounter) add(n int) {
+	atomic.AddInt64(&c.n, int64(n))
+}
+
+func (c *byteCounter) load() int64 {
+	return atomic.LoadInt64(&c.n)
+}
+
+// countingOrcSource counts the bytes read from an ORC file by the orc library.
+type countingOrcSource struct {
+	orcSource
+	counter *byteCounter
+}
+
+func (s *countingOrcSource) ReadAt(p []byte, off int64) (int, error) {
+	n, err := s.orcSource.ReadAt(p, off)
+	s.counter.add(n)
+	return n, err
+}
+
+// countingParquetFile counts the bytes read from a parquet file, including through the handles opened from it for
+// reading columns.
+type countingParquetFile struct {
+	source.ParquetFile
+	counter *byteCounter
+}
+
+func (f *countingParquetFile) Read(p []byte) (int, error) {
+	n, err := f.ParquetFile.Read(p)
+	f.counter.add(n)
+	return n, err
+}
+
+func (f *countingParquetFile) Open(name string) (source.ParquetFile, error) {
+	pf, err := f.ParquetFile.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &countingParquetFile{ParquetFile: pf, counter: f.counter}, nil
+}
+
+// countingReader counts the bytes read from a file read sequentially.
+type countingReader struct {
+	r       io.Reader
+	counter *byteCounter
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.counter.add(n)
+	return n, err
+}
+
+// warnOnPartialRead logs a warning if fewer bytes than expected were read from a file of the given size, whose rows
+// and columns were all read.
+func warnOnPartialRead(logger logging.Logger, bytesRead int64, size int64) {
+	if float64(bytesRead) >= float64(size)*minBytesReadRatio {
+		return
+	}
+	logger.WithFields(logging.Fields{
+		"bytes_read": bytesRead,
+		"file_size":  size,
+	}).Warn("read fewer bytes than expected from inventory file, it may be truncated")
+}
@@ -0,0 +1,92 @@
+package s3
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/treeverse/lakefs/logging"
+)
+
+// WithCacheDir sets a directory in which downloaded ORC inventory files are kept across readers and runs.
+// Cached files are keyed by their bucket, key and ETag, so a file is downloaded again only if its object changed.
+// Cached files are never removed by the reader.
+func WithCacheDir(dir string) ReaderOption {
+	return func(o *Reader) {
+		o.cacheDir = dir
+	}
+}
+
+// cachedFilename returns the path of the local copy of the given version of an object in the cache dir.
+func (o *Reader) cachedFilename(bucket string, key string, etag string) string {
+	h := sha256.Sum256([]byte(bucket + "/" + key + "/" + etag))
+	return filepath.Join(o.cacheDir, hex.EncodeToString(h[:])+"-"+path.Base(key))
+}
+
+// openCachedOrc opens the local copy of the given ORC file in the cache dir, downloading it if needed.
+func (o *Reader) openCachedOrc(bucket string, key string) (*OrcFile, error) {
+	filename, err := o.getCached(o.ctx, bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	return &OrcFile{f}, nil
+}
+
+// getCached returns the path of the local copy of the given object in the cache dir, downloading it unless the
+// cache holds a copy of its current version.
+func (o *Reader) getCached(ctx context.Context, bucket string, key string) (string, error) {
+	headObject, err := o.svc.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return "", downloadError(err)
+	}
+	filename := o.cachedFilename(bucket, key, aws.StringValue(headObject.ETag))
+	if _, err := os.Stat(filename); err == nil {
+		o.logger.WithFields(logging.Fields{
+			"bucket":     bucket,
+			"key":        key,
+			"format":     OrcFormatName,
+			"local_file": filename,
+		}).Debug("using cached copy of inventory file")
+		return filename, nil
+	}
+	// download to a temp file in the cache dir and move it in place once complete, so that other readers never
+	// see a partial copy
+	f, err := ioutil.TempFile(o.cacheDir, path.Base(key))
+	if err != nil {
+		return "", fmt.Errorf("%w: %s: %s", ErrInvalidCacheDir, o.cacheDir, err)
+	}
+	err = o.download(ctx, OrcFormatName, f, bucket, key, 0)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), filename)
+	}
+	if err != nil {
+		_ = os.Remove(f.Name())
+		return "", err
+	}
+	return filename, nil
+}
+
+// removeCached removes a local copy in the cache dir which cannot be read, so that the next attempt downloads the
+// file again.
+func (o *Reader) removeCached(filename string) {
+	if err := os.Remove(filename); err != nil && !os.IsNotExist(err) {
+		o.logger.WithField("local_file", filename).WithError(err).Error("failed to remove cached inventory file")
+	}
+}
@@ -0,0 +1,134 @@
+package s3
+
+import (
+	"crypto/md5" //nolint:gosec // S3 inventory manifests declare MD5 checksums
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+// FileChecksum is the size and MD5 checksum of an inventory file, as declared by the manifest listing it.
+// Zero values are not verified.
+type FileChecksum struct {
+	Size int64
+	MD5  string
+}
+
+// WithFileChecksums sets the declared checksums of inventory files, by their key. Downloaded files are verified
+// against them: the size of every download, and the MD5 checksum of files downloaded as a whole.
+// Files with no declared size are verified against the size reported by S3 when downloading them.
+func WithFileChecksums(checksums map[string]FileChecksum) ReaderOption {
+	return func(o *Reader) {
+		o.checksums = checksums
+	}
+}
+
+// AddFileChecksums sets the declared checksums of the inventory files stored in bucket, by their key, the way
+// WithFileChecksums does for the files of every bucket. It lets a single reader serve several manifests: it is called
+// once for every manifest, also while files of other manifests are read, and the checksums set for one bucket never
+// apply to files of another.
+func (o *Reader) AddFileChecksums(bucket string, checksums map[string]FileChecksum) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.bucketChecksums == nil {
+		o.bucketChecksums = make(map[string]FileChecksum, len(checksums))
+	}
+	for key, checksum := range checksums {
+		o.bucketChecksums[fileCacheKey(FileLocation(bucket, key))] = checksum
+	}
+}
+
+// fileChecksum returns the declared checksum of the given inventory file, preferring the one set for its bucket.
+func (o *Reader) fileChecksum(bucket string, key string) FileChecksum {
+	o.mu.RLock()
+	checksum, ok := o.bucketChecksums[fileCacheKey(bucket, key)]
+	o.mu.RUnlock()
+	if ok {
+		return checksum
+	}
+	return o.checksums[key]
+}
+
+// verifyDownload checks that n bytes downloaded from the given byte of an object, into the local file f, are the
+// whole of it. objectSize is the size of the object reported by S3, or -1 if unknown.
+func (o *Reader) verifyDownload(f *os.File, bucket string, key string, fromByte int64, n int64, objectSize int64) error {
+	checksum := o.fileChecksum(bucket, key)
+	size := checksum.Size
+	if size == 0 {
+		size = objectSize
+	}
+	if fromByte < 0 {
+		fromByte = 0
+	}
+	if size >= 0 && n != size-fromByte {
+		return fmt.Errorf("%w: %s: expected %d bytes, downloaded %d", ErrInventoryChecksumMismatch, key, size-fromByte, n)
+	}
+	if checksum.MD5 == "" || fromByte > 0 {
+		return nil
+	}
+	h := md5.New() //nolint:gosec
+	if _, err := io.Copy(h, io.NewSectionReader(f, 0, n)); err != nil {
+		return err
+	}
+	if sum := hex.EncodeToString(h.Sum(nil)); !strings.EqualFold(sum, checksum.MD5) {
+		return fmt.Errorf("%w: %s: expected md5 %s, got %s", ErrInventoryChecksumMismatch, key, checksum.MD5, sum)
+	}
+	return nil
+}
+
+// objectSizeClient records the size of the object downloaded through it, as reported by S3 in the responses.
+// The downloader reads objects in ranged parts, so the size is taken from their content range when available.
+type objectSizeClient struct {
+	s3iface.S3API
+	mu   sync.Mutex
+	size int64
+}
+
+func newObjectSizeClient(svc s3iface.S3API) *objectSizeClient {
+	return &objectSizeClient{S3API: svc, size: -1}
+}
+
+func (c *objectSizeClient) GetObjectWithContext(ctx aws.Context, input *s3.GetObjectInput, opts ...request.Option) (*s3.GetObjectOutput, error) {
+	output, err := c.S3API.GetObjectWithContext(ctx, input, opts...)
+	if err != nil {
+		return output, err
+	}
+	size, ok := contentRangeSize(aws.StringValue(output.ContentRange))
+	if !ok && input.Range == nil && output.ContentLength != nil {
+		size, ok = *output.ContentLength, true
+	}
+	if ok {
+		c.mu.Lock()
+		c.size = size
+		c.mu.Unlock()
+	}
+	return output, nil
+}
+
+func (c *objectSizeClient) objectSize() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.size
+}
+
+// contentRangeSize returns the complete length of the object from a content range such as "bytes 0-99/1000".
+func contentRangeSize(contentRange string) (int64, bool) {
+	i := strings.LastIndexByte(contentRange, '/')
+	if i < 0 {
+		return 0, false
+	}
+	size, err := strconv.ParseInt(contentRange[i+1:], 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return size, true
+}
@@ -0,0 +1,63 @@
+package s3
+
+import (
+	"context"
+	"fmt"
+)
+
+// Count returns the number of objects in the given inventory files. Unless the reader filters the objects read, the
+// number of rows of the metadata of the files is returned: ORC and Parquet files declare it, so their rows are not read
+// at all, and CSV and Avro files are decoded once to find it. Filtered files are read with only the key column and the
+// columns needed by the filters, and their objects counted.
+func (o *Reader) Count(ctx context.Context, format string, schema string, bucket string, fileKeys []string) (int64, error) {
+	var total int64
+	for _, fileKey := range fileKeys {
+		if err := ctx.Err(); err != nil {
+			return 0, err
+		}
+		var n int64
+		var err error
+		if o.filter.isEmpty() {
+			n, err = o.countFromMetadata(format, schema, bucket, fileKey)
+		} else {
+			n, err = o.countObjects(ctx, format, schema, bucket, fileKey)
+		}
+		if err != nil {
+			return 0, fmt.Errorf("failed to count objects of %s: %w", fileKey, err)
+		}
+		total += n
+	}
+	return total, nil
+}
+
+func (o *Reader) countFromMetadata(format string, schema string, bucket string, fileKey string) (int64, error) {
+	mr, err := o.GetMetadataReader(format, schema, bucket, fileKey)
+	if err != nil {
+		return 0, err
+	}
+	n := mr.GetNumRows()
+	return n, mr.Close()
+}
+
+func (o *Reader) countObjects(ctx context.Context, format string, schema string, bucket string, fileKey string) (int64, error) {
+	fileReader, err := o.getProjectedFileReader(format, schema, bucket, fileKey, []string{"key"})
+	if err != nil {
+		return 0, err
+	}
+	var n int64
+	for {
+		if err := ctx.Err(); err != nil {
+			_ = fileReader.Close()
+			return 0, err
+		}
+		batch := make([]InventoryObject, iteratorBatchSize)
+		if err := readBatch(fileReader, &batch); err != nil {
+			_ = fileReader.Close()
+			return 0, err
+		}
+		if len(batch) == 0 {
+			return n, fileReader.Close()
+		}
+		n += int64(len(batch))
+	}
+}
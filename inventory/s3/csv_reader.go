@@ -0,0 +1,326 @@
+package s3
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/go-openapi/swag"
+	"github.com/hashicorp/go-multierror"
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	csvBucketColumn         = "Bucket"
+	csvKeyColumn            = "Key"
+	csvSizeColumn           = "Size"
+	csvLastModifiedColumn   = "LastModifiedDate"
+	csvETagColumn           = "ETag"
+	csvIsLatestColumn       = "IsLatest"
+	csvVersionIDColumn      = "VersionId"
+	csvIsDeleteMarkerColumn = "IsDeleteMarker"
+	csvStorageClassColumn   = "StorageClass"
+	csvIsMultipartColumn    = "IsMultipartUploaded"
+)
+
+var ErrMalformedCSVRow = errors.New("malformed csv inventory row")
+
+var (
+	// zstdMagic starts every Zstandard frame
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	// gzipMagic starts every gzip member
+	gzipMagic = []byte{0x1f, 0x8b}
+)
+
+// csvCompression is the compression of a CSV inventory file.
+type csvCompression int
+
+const (
+	csvUncompressed csvCompression = iota
+	csvGzip
+	csvZstd
+)
+
+type CSVInventoryFileReader struct {
+	ctx        context.Context
+	file       *os.File
+	gzipReader *gzip.Reader
+	zstdReader *zstd.Decoder
+	reader     *csv.Reader
+	columns    map[string]int // for each column, its index in a row
+	numColumns int
+	numRows    int64
+	firstKey   string
+	lastKey    string
+	filter     objectFilter
+	bytesRead  *byteCounter
+	buffers    objectBuffers
+	metrics    *fileReadMetrics
+	removePath string
+	tempDisk   *diskReservation
+}
+
+// parseCSVSchema parses the fileSchema of a CSV inventory, which is a comma separated list of column names,
+// and returns the index of each column in a row.
+func parseCSVSchema(schema string) (map[string]int, error) {
+	names, err := parseFileSchema(schema)
+	if err != nil {
+		return nil, err
+	}
+	columns := make(map[string]int, len(names))
+	for i, name := range names {
+		columns[name] = i
+	}
+	for _, required := range []string{csvBucketColumn, csvKeyColumn} {
+		if _, ok := columns[required]; !ok {
+			return nil, fmt.Errorf("%w: %s", ErrMissingInventoryColumn, required)
+		}
+	}
+	return columns, nil
+}
+
+// NewCSVInventoryFileReader returns a reader for the given CSV file, compressed with gzip or with Zstandard, or not
+// compressed. The compression is detected from the magic number the file starts with.
+// Since CSV files carry no metadata, the file is scanned once upfront to count its rows and find its first and last keys.
+func NewCSVInventoryFileReader(ctx context.Context, f *os.File, columns map[string]int) (*CSVInventoryFileReader, error) {
+	r := &CSVInventoryFileReader{
+		ctx:        ctx,
+		file:       f,
+		columns:    columns,
+		numColumns: len(columns),
+		bytesRead:  &byteCounter{},
+	}
+	if err := r.scanMetadata(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *CSVInventoryFileReader) rewind() error {
+	if _, err := r.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	compression, err := r.compression()
+	if err != nil {
+		return err
+	}
+	var body io.Reader
+	file := &countingReader{r: r.file, counter: r.bytesRead}
+	switch {
+	case compression == csvUncompressed:
+		body = file
+	case compression == csvZstd && r.zstdReader == nil:
+		r.zstdReader, err = zstd.NewReader(file, zstd.WithDecoderConcurrency(1))
+		body = r.zstdReader
+	case compression == csvZstd:
+		err = r.zstdReader.Reset(file)
+		body = r.zstdReader
+	case r.gzipReader == nil:
+		r.gzipReader, err = gzip.NewReader(file)
+		body = r.gzipReader
+	default:
+		err = r.gzipReader.Reset(file)
+		body = r.gzipReader
+	}
+	if err != nil {
+		return err
+	}
+	r.reader = csv.NewReader(body)
+	r.reader.FieldsPerRecord = r.numColumns
+	return nil
+}
+
+// compression returns the compression of the file, detected from its magic number, leaving it at its beginning.
+// Files starting with no known magic number are not compressed.
+func (r *CSVInventoryFileReader) compression() (csvCompression, error) {
+	magic := make([]byte, len(zstdMagic))
+	n, err := io.ReadFull(r.file, magic)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return csvUncompressed, err
+	}
+	if _, err := r.file.Seek(0, io.SeekStart); err != nil {
+		return csvUncompressed, err
+	}
+	switch {
+	case bytes.Equal(magic[:n], zstdMagic):
+		return csvZstd, nil
+	case bytes.HasPrefix(magic[:n], gzipMagic):
+		return csvGzip, nil
+	default:
+		return csvUncompressed, nil
+	}
+}
+
+func (r *CSVInventoryFileReader) scanMetadata() error {
+	if err := r.rewind(); err != nil {
+		return err
+	}
+	for {
+		record, err := r.reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("%w: %s", ErrMalformedCSVRow, err)
+		}
+		key, err := url.QueryUnescape(record[r.columns[csvKeyColumn]])
+		if err != nil {
+			return fmt.Errorf("%w: bad key %s", ErrMalformedCSVRow, record[r.columns[csvKeyColumn]])
+		}
+		if r.numRows == 0 || key < r.firstKey {
+			r.firstKey = key
+		}
+		if key > r.lastKey {
+			r.lastKey = key
+		}
+		r.numRows++
+	}
+	return r.rewind()
+}
+
+func (r *CSVInventoryFileReader) inventoryObjectFromRecord(record []string) (InventoryObject, error) {
+	var res InventoryObject
+	var err error
+	res.Bucket = record[r.columns[csvBucketColumn]]
+	res.RawKey = record[r.columns[csvKeyColumn]]
+	res.Key, err = url.QueryUnescape(res.RawKey)
+	if err != nil {
+		return res, fmt.Errorf("%w: bad key %s", ErrMalformedCSVRow, res.RawKey)
+	}
+	if v := r.value(record, csvSizeColumn); v != "" {
+		size, err := objectSize(v)
+		if err != nil {
+			return res, &rowError{kind: ErrMalformedCSVRow, err: fmt.Errorf("key %s: %w", res.Key, err)}
+		}
+		res.Size = swag.Int64(size)
+	}
+	if v := r.value(record, csvLastModifiedColumn); v != "" {
+		lastModified, err := time.Parse(time.RFC3339Nano, v)
+		if err != nil {
+			return res, fmt.Errorf("%w: bad last modified date %s for key %s", ErrMalformedCSVRow, v, res.Key)
+		}
+		res.LastModifiedMillis = swag.Int64(lastModified.UnixNano() / int64(time.Millisecond))
+	}
+	if v := r.value(record, csvETagColumn); v != "" {
+		res.Checksum = swag.String(v)
+	}
+	if v := r.value(record, csvVersionIDColumn); v != "" {
+		res.VersionID = swag.String(v)
+	}
+	if v := r.value(record, csvIsLatestColumn); v != "" {
+		isLatest, err := strconv.ParseBool(v)
+		if err != nil {
+			return res, fmt.Errorf("%w: bad is_latest value %s for key %s", ErrMalformedCSVRow, v, res.Key)
+		}
+		res.IsLatest = swag.Bool(isLatest)
+	}
+	if v := r.value(record, csvIsDeleteMarkerColumn); v != "" {
+		isDeleteMarker, err := strconv.ParseBool(v)
+		if err != nil {
+			return res, fmt.Errorf("%w: bad is_delete_marker value %s for key %s", ErrMalformedCSVRow, v, res.Key)
+		}
+		res.IsDeleteMarker = swag.Bool(isDeleteMarker)
+	}
+	res.StorageClass = r.value(record, csvStorageClassColumn)
+	if v := r.value(record, csvIsMultipartColumn); v != "" {
+		isMultipart, err := strconv.ParseBool(v)
+		if err != nil {
+			return res, fmt.Errorf("%w: bad is_multipart_uploaded value %s for key %s", ErrMalformedCSVRow, v, res.Key)
+		}
+		res.IsMultipartUploaded = isMultipart
+	}
+	return res, nil
+}
+
+// value returns the value of the given column in the record, or an empty string if the column is not in the schema.
+func (r *CSVInventoryFileReader) value(record []string, column string) string {
+	idx, ok := r.columns[column]
+	if !ok {
+		return ""
+	}
+	return record[idx]
+}
+
+func (r *CSVInventoryFileReader) Read(dstInterface interface{}) (err error) {
+	start := time.Now()
+	defer func() {
+		r.metrics.observeRead(start, dstInterface, err)
+	}()
+	num := reflect.ValueOf(dstInterface).Elem().Len()
+	res := r.buffers.get(num)
+	for len(res) < num {
+		select {
+		case <-r.ctx.Done():
+			return r.ctx.Err()
+		default:
+		}
+		record, err := r.reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("%w: %s", ErrMalformedCSVRow, err)
+		}
+		obj, err := r.inventoryObjectFromRecord(record)
+		if err != nil {
+			return err
+		}
+		if r.filter.match(&obj) {
+			res = append(res, obj)
+		}
+	}
+	reflect.ValueOf(dstInterface).Elem().Set(reflect.ValueOf(res))
+	if len(res) == 0 && num > 0 {
+		return ErrNoMoreRows
+	}
+	return nil
+}
+
+func (r *CSVInventoryFileReader) Rewind() error {
+	return r.rewind()
+}
+
+// BytesRead returns the number of bytes read from the file so far, before decompressing them, including while scanning
+// it when opened.
+func (r *CSVInventoryFileReader) BytesRead() int64 {
+	return r.bytesRead.load()
+}
+
+func (r *CSVInventoryFileReader) GetNumRows() int64 {
+	return r.numRows
+}
+
+func (r *CSVInventoryFileReader) FirstObjectKey() string {
+	return r.firstKey
+}
+
+func (r *CSVInventoryFileReader) LastObjectKey() string {
+	return r.lastKey
+}
+
+func (r *CSVInventoryFileReader) Close() error {
+	r.buffers.release()
+	defer r.tempDisk.release()
+	var combinedErr error
+	if r.gzipReader != nil {
+		if err := r.gzipReader.Close(); err != nil {
+			combinedErr = multierror.Append(combinedErr, err)
+		}
+	}
+	if r.zstdReader != nil {
+		r.zstdReader.Close()
+	}
+	if err := r.file.Close(); err != nil {
+		combinedErr = multierror.Append(combinedErr, err)
+	}
+	return removeClosedFile(r.removePath, combinedErr)
+}
@@ -0,0 +1,156 @@
+package s3
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+const (
+	// errCodeAccessDenied is the code of S3 errors for requests the credentials are not allowed to make.
+	errCodeAccessDenied = "AccessDenied"
+	// kmsErrorCodePrefix starts the codes of the KMS errors S3 reports when it cannot decrypt an object.
+	kmsErrorCodePrefix = "KMS."
+)
+
+var (
+	// ErrInventoryDownload is matched by errors caused by a failure to fetch an inventory file from S3.
+	// Such failures may be transient, so the operation may be retried.
+	ErrInventoryDownload = errors.New("failed to download inventory file")
+	// ErrInventoryParse is matched by errors caused by an inventory file which cannot be read, such as a corrupt file
+	// or one with an invalid schema. Retrying will not help.
+	ErrInventoryParse = errors.New("failed to parse inventory file")
+	// ErrManifestFileNotFound is matched by errors caused by an inventory file listed in a manifest which does not
+	// exist in S3. It is not a download failure, as retrying will not help.
+	ErrManifestFileNotFound = errors.New("inventory file not found")
+	// ErrInventoryChecksumMismatch is matched by errors caused by a downloaded inventory file whose size or checksum
+	// differs from the expected ones, such as a truncated download. It is also matched by ErrInventoryDownload.
+	ErrInventoryChecksumMismatch = errors.New("inventory file checksum mismatch")
+	// ErrDownloadTimeout is matched by errors caused by a download of an inventory file which did not complete within
+	// the per-file timeout of the reader. It is also matched by ErrInventoryDownload.
+	ErrDownloadTimeout = errors.New("inventory file download timed out")
+	// ErrInventoryBucketUnavailable is matched by errors caused by a bucket holding inventory files which does not
+	// exist or which cannot be accessed with the credentials of the reader. Retrying will not help.
+	ErrInventoryBucketUnavailable = errors.New("inventory bucket does not exist or is inaccessible")
+	// ErrInventoryKMSAccessDenied is matched by errors caused by an inventory file encrypted with SSE-KMS, with or
+	// without an S3 Bucket Key, whose KMS key the credentials of the reader are not allowed to decrypt with. S3 decrypts
+	// such files transparently, so reading them needs no configuration of the reader, but the credentials must be
+	// granted kms:Decrypt on the key. Retrying will not help.
+	ErrInventoryKMSAccessDenied = errors.New("access denied to the KMS key of inventory file")
+)
+
+// inventoryError classifies an error as one of ErrInventoryDownload, ErrInventoryParse, ErrManifestFileNotFound,
+// ErrInventoryBucketUnavailable or ErrInventoryKMSAccessDenied, while keeping the original error accessible through errors.Is and errors.As.
+type inventoryError struct {
+	kind error
+	err  error
+}
+
+func (e *inventoryError) Error() string {
+	return fmt.Sprintf("%s: %s", e.kind, e.err)
+}
+
+func (e *inventoryError) Unwrap() error {
+	return e.err
+}
+
+func (e *inventoryError) Is(target error) bool {
+	return target == e.kind
+}
+
+func isClassified(err error) bool {
+	return errors.Is(err, ErrInventoryDownload) || errors.Is(err, ErrInventoryParse) || errors.Is(err, ErrManifestFileNotFound) ||
+		errors.Is(err, ErrInventoryBucketUnavailable) || errors.Is(err, ErrInventoryKMSAccessDenied)
+}
+
+// downloadError classifies an error from fetching a file from S3, reporting missing files as ErrManifestFileNotFound,
+// files of a missing bucket as ErrInventoryBucketUnavailable, and files which cannot be decrypted with their KMS key
+// as ErrInventoryKMSAccessDenied.
+func downloadError(err error) error {
+	if err == nil || isClassified(err) {
+		return err
+	}
+	if isKMSAccessDeniedError(err) {
+		return &inventoryError{kind: ErrInventoryKMSAccessDenied, err: err}
+	}
+	var awsErr awserr.Error
+	if errors.As(err, &awsErr) && awsErr.Code() == s3.ErrCodeNoSuchBucket {
+		return &inventoryError{kind: ErrInventoryBucketUnavailable, err: err}
+	}
+	if IsNotFoundError(err) {
+		return &inventoryError{kind: ErrManifestFileNotFound, err: err}
+	}
+	return &inventoryError{kind: ErrInventoryDownload, err: err}
+}
+
+func parseError(err error) error {
+	if err == nil || isClassified(err) {
+		return err
+	}
+	return &inventoryError{kind: ErrInventoryParse, err: err}
+}
+
+// parquetError classifies an error from opening a parquet file. The footer is read from S3 while the file is opened,
+// so S3 errors are reported as download failures and all others as parse failures.
+func parquetError(err error) error {
+	var awsErr awserr.Error
+	if errors.As(err, &awsErr) {
+		return downloadError(err)
+	}
+	return parseError(err)
+}
+
+// IsNotFoundError returns true if err is an S3 error for a missing object. GetObject reports it with a NoSuchKey
+// code, while HeadObject only has the status code to report it with.
+func IsNotFoundError(err error) bool {
+	var reqErr awserr.RequestFailure
+	if errors.As(err, &reqErr) && reqErr.StatusCode() == http.StatusNotFound {
+		return true
+	}
+	var awsErr awserr.Error
+	return errors.As(err, &awsErr) && awsErr.Code() == s3.ErrCodeNoSuchKey
+}
+
+// isKMSAccessDeniedError returns true if err is an S3 error for an object which cannot be decrypted with its KMS key.
+// S3 reports it either with the code of the KMS error, or as AccessDenied with a message naming KMS.
+func isKMSAccessDeniedError(err error) bool {
+	var awsErr awserr.Error
+	if !errors.As(err, &awsErr) {
+		return false
+	}
+	if strings.HasPrefix(awsErr.Code(), kmsErrorCodePrefix) {
+		return true
+	}
+	return awsErr.Code() == errCodeAccessDenied && strings.Contains(strings.ToLower(awsErr.Message()), "kms")
+}
+
+// CheckBucket verifies that the given bucket holding inventory files exists and can be accessed with svc. A missing or
+// forbidden bucket fails with ErrInventoryBucketUnavailable, wrapping the S3 error. Other errors are returned as is.
+func CheckBucket(ctx context.Context, svc s3iface.S3API, bucket string) error {
+	_, err := svc.HeadBucketWithContext(ctx, &s3.HeadBucketInput{Bucket: aws.String(bucket)})
+	if err == nil {
+		return nil
+	}
+	if isBucketUnavailableError(err) {
+		return &inventoryError{kind: ErrInventoryBucketUnavailable, err: fmt.Errorf("s3://%s: %w", bucket, err)}
+	}
+	return err
+}
+
+// isBucketUnavailableError returns true if err is an S3 error for a missing bucket, or for a bucket which cannot be
+// accessed. HeadBucket only has the status code to report them with.
+func isBucketUnavailableError(err error) bool {
+	var reqErr awserr.RequestFailure
+	if errors.As(err, &reqErr) && (reqErr.StatusCode() == http.StatusNotFound || reqErr.StatusCode() == http.StatusForbidden) {
+		return true
+	}
+	var awsErr awserr.Error
+	return errors.As(err, &awsErr) && (awsErr.Code() == s3.ErrCodeNoSuchBucket || awsErr.Code() == errCodeAccessDenied)
+}
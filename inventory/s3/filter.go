@@ -0,0 +1,148 @@
+package s3
+
+import (
+	"strings"
+	"time"
+	"unicode"
+)
+
+// objectFilter selects the objects returned when reading inventory files.
+type objectFilter struct {
+	keyPrefix         string
+	skipDeleteMarkers bool
+	latestOnly        bool
+	skipMissingSize   bool
+	modifiedAfter     time.Time
+	modifiedBefore    time.Time
+	keyFilter         KeyFilter
+	onRejectedKey     func(key string)
+}
+
+// KeyFilter returns the key under which an object read from an inventory file is returned, possibly transforming it,
+// and false if the object should be rejected instead.
+type KeyFilter func(key string) (string, bool)
+
+// RejectControlCharacters is a KeyFilter rejecting keys which contain control characters, such as null bytes or
+// newlines, which break the handling of keys as paths.
+func RejectControlCharacters(key string) (string, bool) {
+	return key, strings.IndexFunc(key, unicode.IsControl) < 0
+}
+
+// match returns true if obj should be returned by readers.
+func (f *objectFilter) match(obj *InventoryObject) bool {
+	if !strings.HasPrefix(obj.Key, f.keyPrefix) {
+		return false
+	}
+	if f.skipDeleteMarkers && obj.IsDeleteMarker != nil && *obj.IsDeleteMarker {
+		return false
+	}
+	if f.latestOnly && obj.IsLatest != nil && !*obj.IsLatest {
+		return false
+	}
+	if f.skipMissingSize && obj.Size == nil {
+		return false
+	}
+	if f.hasModifiedRange() {
+		if obj.LastModifiedMillis == nil {
+			return false
+		}
+		lastModified := millisToTime(*obj.LastModifiedMillis)
+		if !f.modifiedAfter.IsZero() && !lastModified.After(f.modifiedAfter) {
+			return false
+		}
+		if !f.modifiedBefore.IsZero() && !lastModified.Before(f.modifiedBefore) {
+			return false
+		}
+	}
+	if f.keyFilter != nil {
+		key, ok := f.keyFilter(obj.Key)
+		if !ok {
+			if f.onRejectedKey != nil {
+				f.onRejectedKey(obj.Key)
+			}
+			return false
+		}
+		obj.Key = key
+	}
+	return true
+}
+
+// isEmpty returns true if the filter selects all objects, leaving them unchanged.
+func (f *objectFilter) isEmpty() bool {
+	return f.keyPrefix == "" && !f.skipDeleteMarkers && !f.latestOnly && !f.skipMissingSize && !f.hasModifiedRange() &&
+		f.keyFilter == nil
+}
+
+func (f *objectFilter) hasModifiedRange() bool {
+	return !f.modifiedAfter.IsZero() || !f.modifiedBefore.IsZero()
+}
+
+// SupportsPushdown returns true if files of the given inventory format carry statistics by which readers skip the parts
+// of files which cannot contain objects matching their key prefix or modified range, without reading them.
+func SupportsPushdown(format string) bool {
+	return format == OrcFormatName || format == ParquetFormatName
+}
+
+// pushdown returns true if parts of files of the given format are skipped according to their statistics.
+func (f *objectFilter) pushdown(format string) bool {
+	if !SupportsPushdown(format) {
+		return false
+	}
+	if f.keyPrefix != "" {
+		return true
+	}
+	// ORC writers disagree on the unit of timestamp statistics
+	return format == ParquetFormatName && f.hasModifiedRange()
+}
+
+// modifiedRangeMayMatch returns false if no last modified time in the range [minMillis, maxMillis] is in the range
+// selected by the filter.
+func (f *objectFilter) modifiedRangeMayMatch(minMillis int64, maxMillis int64) bool {
+	if !f.modifiedAfter.IsZero() && !millisToTime(maxMillis).After(f.modifiedAfter) {
+		return false
+	}
+	if !f.modifiedBefore.IsZero() && !millisToTime(minMillis).Before(f.modifiedBefore) {
+		return false
+	}
+	return true
+}
+
+func millisToTime(millis int64) time.Time {
+	return time.Unix(0, millis*int64(time.Millisecond))
+}
+
+// columns returns the inventory columns which must be read to apply the filter.
+func (f *objectFilter) columns() []string {
+	res := []string{"key"}
+	if f.skipDeleteMarkers {
+		res = append(res, "is_delete_marker")
+	}
+	if f.latestOnly {
+		res = append(res, "is_latest")
+	}
+	if f.skipMissingSize {
+		res = append(res, "size")
+	}
+	if f.hasModifiedRange() {
+		res = append(res, "last_modified_date")
+	}
+	return res
+}
+
+// keyRangeMayHavePrefix returns false if no key in the range [minKey, maxKey] can start with prefix.
+func keyRangeMayHavePrefix(minKey string, maxKey string, prefix string) bool {
+	if maxKey < prefix {
+		return false
+	}
+	return minKey < prefix || strings.HasPrefix(minKey, prefix)
+}
+
+// appendMatching appends to res the objects which match filter.
+func appendMatching(res []InventoryObject, objects []InventoryObject, filter *objectFilter) []InventoryObject {
+	for i := range objects {
+		if filter.match(&objects[i]) {
+			res = append(res, objects[i])
+		}
+	}
+	return res
+}
@@ -0,0 +1,635 @@
+package s3
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/hashicorp/go-multierror"
+)
+
+const iteratorBatchSize = 1000
+
+// ErrSeekWithReadAhead is returned when seeking an iterator which reads objects ahead.
+var ErrSeekWithReadAhead = errors.New("cannot seek an inventory iterator reading ahead")
+
+// InventoryIterator yields, one at a time, the objects of a list of inventory files read in order.
+// Each file is opened only once the objects of the previous one are exhausted, and is read in batches.
+type InventoryIterator struct {
+	reader   IReader
+	format   string
+	schema   string
+	bucket   string
+	keys     []string
+	keyIndex int
+	current  FileReader
+	batch    []InventoryObject // the slice passed to the reads of the files, allocated once
+	buffer   []InventoryObject
+	bufIndex int
+	val      InventoryObject
+	err      error
+	numRows  map[string]int64 // the number of rows of each file, once known
+	consumed int64            // objects of the current file returned by Next, or of all files if sorted
+	sorted   bool
+	cursors  *fileCursorHeap // the files merged by key, once opened
+	// duplicates holds the keys found in adjacent objects, if detected
+	duplicates      []string
+	checkDuplicates bool
+	started         bool // whether val holds an object already returned
+	readAhead       int  // the number of objects read ahead in the background, or 0 to read them on demand
+	ahead           *iteratorReadAhead
+}
+
+// iteratorReadAhead reads objects with an inner iterator in the background, while the consumer processes the
+// previous ones.
+type iteratorReadAhead struct {
+	inner    *InventoryIterator
+	mu       sync.Mutex // guards inner
+	objects  chan InventoryObject
+	done     chan struct{} // closed to stop the background reads
+	stopOnce sync.Once
+	finished chan struct{} // closed once the background reads stopped and the inner iterator is closed
+	err      error         // the error of the background reads, set before objects is closed
+	closeErr error         // the error closing the inner iterator, set before finished is closed
+}
+
+// IteratorOption configures an InventoryIterator.
+type IteratorOption func(*InventoryIterator)
+
+// WithSorted sets whether the iterator yields the objects of all files in key order. Sorted iteration merges the files,
+// each of which must be sorted by key, and so keeps all of them open at once. By default, files are read one by one,
+// in the given order.
+func WithSorted(sorted bool) IteratorOption {
+	return func(it *InventoryIterator) {
+		it.sorted = sorted
+	}
+}
+
+// WithDetectDuplicates sets whether the iterator collects the keys of objects which have the same key as the object
+// yielded right before them, to be returned by Duplicates. Duplicates are fully detected only if the objects are yielded
+// in key order, as with WithSorted.
+func WithDetectDuplicates(detect bool) IteratorOption {
+	return func(it *InventoryIterator) {
+		it.checkDuplicates = detect
+	}
+}
+
+// WithReadAhead sets the number of objects read in the background while the consumer processes the objects already
+// yielded, so that decoding the files and consuming their objects overlap. By default, objects are read on demand.
+// Values smaller than 1 are ignored.
+func WithReadAhead(objects int) IteratorOption {
+	return func(it *InventoryIterator) {
+		if objects >= 1 {
+			it.readAhead = objects
+		}
+	}
+}
+
+// NewInventoryIterator returns an iterator over the objects of the given inventory files, in the given order.
+// The iterator must be closed once done with, to release the currently open files.
+func NewInventoryIterator(reader IReader, format string, schema string, bucket string, keys []string, opts ...IteratorOption) *InventoryIterator {
+	it := &InventoryIterator{
+		reader:  reader,
+		format:  format,
+		schema:  schema,
+		bucket:  bucket,
+		keys:    keys,
+		numRows: make(map[string]int64),
+	}
+	for _, opt := range opts {
+		opt(it)
+	}
+	return it
+}
+
+func (it *InventoryIterator) Next() bool {
+	prevKey := it.val.Key
+	if !it.next() {
+		return false
+	}
+	if it.checkDuplicates && it.started && it.val.Key == prevKey {
+		if n := len(it.duplicates); n == 0 || it.duplicates[n-1] != prevKey {
+			it.duplicates = append(it.duplicates, prevKey)
+		}
+	}
+	it.started = true
+	return true
+}
+
+// Duplicates returns the keys found more than once in a row so far, each once, if detected with WithDetectDuplicates.
+func (it *InventoryIterator) Duplicates() []string {
+	return it.duplicates
+}
+
+func (it *InventoryIterator) next() bool {
+	if it.err != nil {
+		return false
+	}
+	if it.readAhead > 0 {
+		return it.nextReadAhead()
+	}
+	if it.sorted {
+		return it.nextSorted()
+	}
+	for it.bufIndex >= len(it.buffer) {
+		if !it.fillBuffer() {
+			return false
+		}
+	}
+	it.val = it.buffer[it.bufIndex]
+	it.bufIndex++
+	it.consumed++
+	return true
+}
+
+// fillBuffer reads the next batch of objects, moving on to the next file if the current one is exhausted.
+// It returns false once all files are read, or on error.
+func (it *InventoryIterator) fillBuffer() bool {
+	if it.current == nil {
+		if it.keyIndex >= len(it.keys) {
+			return false
+		}
+		key := it.keys[it.keyIndex]
+		it.current, it.err = it.reader.GetFileReader(it.format, it.schema, it.bucket, key)
+		if it.err != nil {
+			it.current = nil
+			return false
+		}
+		if _, ok := it.numRows[key]; !ok {
+			it.numRows[key] = it.current.GetNumRows()
+		}
+		it.keyIndex++
+		it.consumed = 0
+	}
+	if it.batch == nil {
+		it.batch = make([]InventoryObject, iteratorBatchSize)
+	}
+	it.buffer = it.batch[:iteratorBatchSize]
+	it.bufIndex = 0
+	if it.err = readBatch(it.current, &it.buffer); it.err != nil {
+		return false
+	}
+	if len(it.buffer) == 0 {
+		// current file is exhausted
+		err := it.current.Close()
+		it.current = nil
+		if err != nil {
+			it.err = err
+			return false
+		}
+	}
+	return true
+}
+
+// nextReadAhead moves to the next object read in the background, starting the background reads on the first call.
+func (it *InventoryIterator) nextReadAhead() bool {
+	if it.ahead == nil {
+		it.ahead = it.startReadAhead()
+	}
+	obj, ok := <-it.ahead.objects
+	if !ok {
+		it.err = it.ahead.err
+		return false
+	}
+	it.val = obj
+	return true
+}
+
+func (it *InventoryIterator) startReadAhead() *iteratorReadAhead {
+	inner := &InventoryIterator{
+		reader:  it.reader,
+		format:  it.format,
+		schema:  it.schema,
+		bucket:  it.bucket,
+		keys:    it.keys,
+		numRows: make(map[string]int64),
+		sorted:  it.sorted,
+	}
+	ahead := &iteratorReadAhead{
+		inner:    inner,
+		objects:  make(chan InventoryObject, it.readAhead),
+		done:     make(chan struct{}),
+		finished: make(chan struct{}),
+	}
+	go func() {
+		defer close(ahead.finished)
+		defer func() {
+			ahead.mu.Lock()
+			ahead.closeErr = inner.Close()
+			ahead.mu.Unlock()
+		}()
+		defer close(ahead.objects)
+		for {
+			ahead.mu.Lock()
+			ok := inner.next()
+			obj, err := inner.val, inner.err
+			ahead.mu.Unlock()
+			if !ok {
+				ahead.err = err
+				return
+			}
+			select {
+			case ahead.objects <- obj:
+			case <-ahead.done:
+				return
+			}
+		}
+	}()
+	return ahead
+}
+
+// stop stops the background reads, and returns the error closing the inner iterator once they are stopped.
+func (ahead *iteratorReadAhead) stop() error {
+	ahead.stopOnce.Do(func() {
+		close(ahead.done)
+		// drain the objects already read, so that the background reads are not blocked
+		for range ahead.objects {
+		}
+	})
+	<-ahead.finished
+	return ahead.closeErr
+}
+
+// SeekGE advances the iterator, so that the next call to Next moves to the first object with a key greater than or
+// equal to the given key. It requires sorted input: each file must be sorted by key and, unless iterating WithSorted,
+// the files must be given in key order. Keys of objects are compared to the last key of ORC and Parquet files, according
+// to their stripe or row group statistics, so that files which end before key are skipped without being read. The
+// iterator never moves backwards: seeking to a key smaller than that of the next object has no effect.
+func (it *InventoryIterator) SeekGE(key string) error {
+	if it.err != nil {
+		return it.err
+	}
+	if it.readAhead > 0 {
+		return ErrSeekWithReadAhead
+	}
+	if it.sorted {
+		it.err = it.seekSorted(key)
+		return it.err
+	}
+	for {
+		for ; it.bufIndex < len(it.buffer); it.bufIndex++ {
+			if it.buffer[it.bufIndex].Key >= key {
+				return nil
+			}
+			it.consumed++
+		}
+		if it.current != nil && it.endsBefore(it.current, key) {
+			err := it.current.Close()
+			it.current = nil
+			if err != nil {
+				it.err = err
+				return err
+			}
+		}
+		if it.current == nil {
+			for it.keyIndex < len(it.keys) && it.fileEndsBefore(it.keys[it.keyIndex], key) {
+				it.keyIndex++
+			}
+		}
+		if !it.fillBuffer() {
+			return it.err
+		}
+	}
+}
+
+// seekSorted drops the objects with keys smaller than key from all files merged by a sorted iteration. Files which end
+// before key are not opened.
+func (it *InventoryIterator) seekSorted(key string) error {
+	if it.cursors == nil {
+		skip := make(map[int]bool)
+		for i, fileKey := range it.keys {
+			skip[i] = it.fileEndsBefore(fileKey, key)
+		}
+		if err := it.openCursors(skip); err != nil {
+			return err
+		}
+	}
+	cursors := (*it.cursors)[:0]
+	var err error
+	for _, c := range *it.cursors {
+		ok := true
+		for ok && err == nil && c.head().Key < key {
+			c.next++
+			it.consumed++
+			ok, err = c.fill()
+		}
+		if ok && err == nil {
+			cursors = append(cursors, c)
+			continue
+		}
+		if closeErr := c.reader.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	*it.cursors = cursors
+	heap.Init(it.cursors)
+	return err
+}
+
+// fileEndsBefore returns true if the statistics of the given ORC or Parquet file show that all of its keys are smaller
+// than key. Such files are counted as empty from then on.
+func (it *InventoryIterator) fileEndsBefore(fileKey string, key string) bool {
+	if !it.hasKeyStatistics() {
+		return false
+	}
+	mr, err := it.reader.GetMetadataReader(it.format, it.schema, it.bucket, fileKey)
+	if err != nil {
+		// the file is read, and the error reported, by the iteration
+		return false
+	}
+	defer func() { _ = mr.Close() }()
+	if !it.endsBefore(mr, key) {
+		return false
+	}
+	it.numRows[fileKey] = 0
+	return true
+}
+
+// hasKeyStatistics returns true if the iterated files hold statistics of their keys, which are read without reading
+// the objects.
+func (it *InventoryIterator) hasKeyStatistics() bool {
+	return it.format == OrcFormatName || it.format == ParquetFormatName
+}
+
+// endsBefore returns true if the last key of the file, according to its statistics, is smaller than key.
+func (it *InventoryIterator) endsBefore(mr MetadataReader, key string) bool {
+	if !it.hasKeyStatistics() || mr.GetNumRows() == 0 {
+		return false
+	}
+	lastKey := mr.LastObjectKey()
+	return lastKey != "" && lastKey < key
+}
+
+// Remaining estimates the number of rows left to iterate over, from the row counts of the inventory files. The row
+// counts of files not yet opened are read on the first call, with metadata readers, and files whose row count cannot
+// be read are counted as empty. Rows excluded by the filters of the reader are counted until their file is exhausted,
+// so the estimate is approximate, but it never increases as the iteration proceeds.
+func (it *InventoryIterator) Remaining() int64 {
+	if it.ahead != nil {
+		return it.ahead.remaining()
+	}
+	var remaining int64
+	if it.sorted {
+		for _, key := range it.keys {
+			remaining += it.fileNumRows(key)
+		}
+		if remaining -= it.consumed; remaining < 0 {
+			return 0
+		}
+		return remaining
+	}
+	if it.current != nil {
+		if rows := it.numRows[it.keys[it.keyIndex-1]] - it.consumed; rows > 0 {
+			remaining += rows
+		}
+	}
+	for _, key := range it.keys[it.keyIndex:] {
+		remaining += it.fileNumRows(key)
+	}
+	return remaining
+}
+
+// remaining returns the number of rows left to iterate over by the inner iterator. The row counts of the files it did
+// not open yet are read without holding the lock, so that the background reads go on meanwhile.
+func (ahead *iteratorReadAhead) remaining() int64 {
+	ahead.mu.Lock()
+	unknown := ahead.inner.unknownNumRows()
+	ahead.mu.Unlock()
+	numRows := make(map[string]int64, len(unknown))
+	for _, key := range unknown {
+		numRows[key] = ahead.inner.readNumRows(key)
+	}
+	ahead.mu.Lock()
+	defer ahead.mu.Unlock()
+	for key, n := range numRows {
+		// files opened meanwhile keep the row count of their reader
+		if _, ok := ahead.inner.numRows[key]; !ok {
+			ahead.inner.numRows[key] = n
+		}
+	}
+	// objects read ahead were counted as consumed by the inner iterator
+	return ahead.inner.Remaining() + int64(len(ahead.objects))
+}
+
+// unknownNumRows returns the files counted by Remaining whose row count is not known yet.
+func (it *InventoryIterator) unknownNumRows() []string {
+	keys := it.keys[it.keyIndex:]
+	if it.sorted {
+		keys = it.keys
+	}
+	var res []string
+	for _, key := range keys {
+		if _, ok := it.numRows[key]; !ok {
+			res = append(res, key)
+		}
+	}
+	return res
+}
+
+// fileNumRows returns the number of rows of the given inventory file, reading it with a metadata reader if unknown.
+func (it *InventoryIterator) fileNumRows(key string) int64 {
+	if n, ok := it.numRows[key]; ok {
+		return n
+	}
+	n := it.readNumRows(key)
+	it.numRows[key] = n
+	return n
+}
+
+// readNumRows reads the number of rows of the given inventory file with a metadata reader, or returns 0 if it cannot
+// be read. It reads none of the state of the iteration, so it may be called while the iterator is used.
+func (it *InventoryIterator) readNumRows(key string) int64 {
+	mr, err := it.reader.GetMetadataReader(it.format, it.schema, it.bucket, key)
+	if err != nil {
+		return 0
+	}
+	defer func() {
+		_ = mr.Close()
+	}()
+	return mr.GetNumRows()
+}
+
+// Stream sends the remaining objects of the iterator to the returned channel, buffering up to bufSize of them, so that
+// the files are read as fast as the consumer ranging over the channel receives their objects. The channel is closed
+// once all objects are sent, the iteration fails or ctx is done, after the iterator is closed. The returned function
+// waits for the channel to be closed, and returns the error which ended the iteration, if any. The iterator must not
+// be used otherwise once streamed: consumers which stop ranging over the channel early must cancel ctx instead.
+func (it *InventoryIterator) Stream(ctx context.Context, bufSize int) (<-chan InventoryObject, func() error) {
+	if bufSize < 0 {
+		bufSize = 0
+	}
+	objects := make(chan InventoryObject, bufSize)
+	finished := make(chan struct{})
+	var err error
+	go func() {
+		defer close(finished)
+		defer close(objects)
+		err = it.stream(ctx, objects)
+		if closeErr := it.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+	return objects, func() error {
+		<-finished
+		return err
+	}
+}
+
+func (it *InventoryIterator) stream(ctx context.Context, objects chan<- InventoryObject) error {
+	for it.Next() {
+		select {
+		case objects <- it.Get():
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return it.Err()
+}
+
+func (it *InventoryIterator) Get() InventoryObject {
+	return it.val
+}
+
+func (it *InventoryIterator) Err() error {
+	return it.err
+}
+
+// Close closes the currently open inventory files, if any. Background reads started WithReadAhead are stopped first.
+func (it *InventoryIterator) Close() error {
+	if it.ahead != nil {
+		return it.ahead.stop()
+	}
+	var err error
+	if it.current != nil {
+		err = it.current.Close()
+		it.current = nil
+	}
+	if it.cursors == nil {
+		return err
+	}
+	var result *multierror.Error
+	if err != nil {
+		result = multierror.Append(result, err)
+	}
+	for _, c := range *it.cursors {
+		if closeErr := c.reader.Close(); closeErr != nil {
+			result = multierror.Append(result, closeErr)
+		}
+	}
+	*it.cursors = nil
+	return result.ErrorOrNil()
+}
+
+// fileCursor is the position of a sorted iteration in one of the merged files.
+type fileCursor struct {
+	index  int // of the file in the iterated keys, breaking ties between equal object keys
+	reader FileReader
+	batch  []InventoryObject // the slice passed to the reads of the file, allocated once
+	buffer []InventoryObject
+	next   int
+}
+
+func (c *fileCursor) head() *InventoryObject {
+	return &c.buffer[c.next]
+}
+
+// fill reads the next batch of objects if the current one is consumed. It returns false once the file is exhausted.
+func (c *fileCursor) fill() (bool, error) {
+	if c.next < len(c.buffer) {
+		return true, nil
+	}
+	if c.batch == nil {
+		c.batch = make([]InventoryObject, iteratorBatchSize)
+	}
+	c.buffer = c.batch[:iteratorBatchSize]
+	c.next = 0
+	if err := readBatch(c.reader, &c.buffer); err != nil {
+		return false, err
+	}
+	return len(c.buffer) > 0, nil
+}
+
+// fileCursorHeap orders the merged files by the key of their next object.
+type fileCursorHeap []*fileCursor
+
+func (h fileCursorHeap) Len() int { return len(h) }
+
+func (h fileCursorHeap) Less(i, j int) bool {
+	if a, b := h[i].head().Key, h[j].head().Key; a != b {
+		return a < b
+	}
+	return h[i].index < h[j].index
+}
+
+func (h fileCursorHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *fileCursorHeap) Push(x interface{}) { *h = append(*h, x.(*fileCursor)) }
+
+func (h *fileCursorHeap) Pop() interface{} {
+	old := *h
+	c := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return c
+}
+
+// openCursors opens all files except those in skip, and positions a cursor on the first object of each file which is
+// not empty.
+func (it *InventoryIterator) openCursors(skip map[int]bool) error {
+	it.cursors = &fileCursorHeap{}
+	for i, key := range it.keys {
+		if skip[i] {
+			continue
+		}
+		reader, err := it.reader.GetFileReader(it.format, it.schema, it.bucket, key)
+		if err != nil {
+			return err
+		}
+		it.numRows[key] = reader.GetNumRows()
+		c := &fileCursor{index: i, reader: reader}
+		ok, err := c.fill()
+		if !ok {
+			if closeErr := reader.Close(); err == nil {
+				err = closeErr
+			}
+		}
+		if err != nil {
+			return err
+		}
+		if ok {
+			*it.cursors = append(*it.cursors, c)
+		}
+	}
+	heap.Init(it.cursors)
+	return nil
+}
+
+// nextSorted moves to the object with the smallest key among the next objects of all files.
+func (it *InventoryIterator) nextSorted() bool {
+	if it.cursors == nil {
+		if it.err = it.openCursors(nil); it.err != nil {
+			return false
+		}
+	}
+	if it.cursors.Len() == 0 {
+		return false
+	}
+	c := (*it.cursors)[0]
+	it.val = *c.head()
+	it.consumed++
+	c.next++
+	ok, err := c.fill()
+	if !ok {
+		heap.Pop(it.cursors)
+		if closeErr := c.reader.Close(); err == nil {
+			err = closeErr
+		}
+	} else {
+		heap.Fix(it.cursors, 0)
+	}
+	if err != nil {
+		it.err = err
+		return false
+	}
+	return true
+}
@@ -0,0 +1,47 @@
+package s3
+
+import "context"
+
+// Keys streams the keys of the objects in the given inventory files, in order, reading only the key column of ORC and
+// Parquet files and the columns needed by the reader's filters. The keys channel is closed once all files are read or
+// on failure, in which case the error is sent on the error channel before it is closed.
+func (o *Reader) Keys(ctx context.Context, format string, schema string, bucket string, fileKeys []string) (<-chan string, <-chan error) {
+	keys := make(chan string, iteratorBatchSize)
+	errs := make(chan error, 1)
+	go func() {
+		defer close(errs)
+		defer close(keys)
+		for _, fileKey := range fileKeys {
+			if err := o.sendKeys(ctx, keys, format, schema, bucket, fileKey); err != nil {
+				errs <- err
+				return
+			}
+		}
+	}()
+	return keys, errs
+}
+
+func (o *Reader) sendKeys(ctx context.Context, keys chan<- string, format string, schema string, bucket string, fileKey string) error {
+	fileReader, err := o.getProjectedFileReader(format, schema, bucket, fileKey, []string{"key"})
+	if err != nil {
+		return err
+	}
+	for {
+		batch := make([]InventoryObject, iteratorBatchSize)
+		if err := readBatch(fileReader, &batch); err != nil {
+			_ = fileReader.Close()
+			return err
+		}
+		if len(batch) == 0 {
+			return fileReader.Close()
+		}
+		for i := range batch {
+			select {
+			case keys <- batch[i].Key:
+			case <-ctx.Done():
+				_ = fileReader.Close()
+				return ctx.Err()
+			}
+		}
+	}
+}
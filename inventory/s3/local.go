@@ -0,0 +1,250 @@
+package s3
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/treeverse/lakefs/logging"
+	"github.com/xitongsys/parquet-go-source/local"
+)
+
+// LocalReader reads inventory files stored on the local filesystem, next to their manifest.
+// The keys of the files are resolved as paths relative to the directory of the manifest, and the bucket is ignored.
+type LocalReader struct {
+	ctx    context.Context
+	dir    string
+	logger logging.Logger
+}
+
+// NewLocalReader returns a reader for the inventory files listed in the manifest found at manifestPath.
+// Files are opened in place, with none of them downloaded or removed. A nil ctx is replaced with context.Background().
+func NewLocalReader(ctx context.Context, manifestPath string, logger logging.Logger) *LocalReader {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return &LocalReader{ctx: ctx, dir: filepath.Dir(manifestPath), logger: logger}
+}
+
+func (o *LocalReader) GetFileReader(format string, schema string, _ string, key string) (FileReader, error) {
+	path := filepath.Join(o.dir, filepath.FromSlash(key))
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil, &inventoryError{kind: ErrManifestFileNotFound, err: err}
+	}
+	o.logger.WithFields(logging.Fields{
+		"key":        key,
+		"format":     format,
+		"local_file": path,
+	}).Debug("opening local inventory file")
+	fileReader, err := NewLocalFileReader(o.ctx, format, schema, path, false)
+	if err != nil {
+		return nil, parseError(fmt.Errorf("%s: %w", path, err))
+	}
+	return fileReader, nil
+}
+
+func (o *LocalReader) GetMetadataReader(format string, schema string, bucket string, key string) (MetadataReader, error) {
+	return o.GetFileReader(format, schema, bucket, key)
+}
+
+// NewLocalFileReader returns a reader for an inventory file of the given format, stored in the local file at path.
+// It lets inventories downloaded from other object stores share the parsing done for S3 inventories.
+// If removeOnClose is set, the file is removed once the returned reader is closed.
+// Files produced by other tools than S3 may be read by passing options describing their layout.
+func NewLocalFileReader(ctx context.Context, format string, schema string, path string, removeOnClose bool, opts ...LocalFileOption) (FileReader, error) {
+	var layout fileLayout
+	for _, opt := range opts {
+		opt(&layout)
+	}
+	var fileReader FileReader
+	var err error
+	switch format {
+	case OrcFormatName:
+		fileReader, err = newLocalOrcReader(ctx, path, layout)
+	case ParquetFormatName:
+		fileReader, err = newLocalParquetReader(path, layout)
+	case CSVFormatName:
+		fileReader, err = newLocalCSVReader(ctx, schema, path)
+	case AvroFormatName, ApacheAvroFormatName:
+		fileReader, err = newLocalAvroReader(ctx, path)
+	default:
+		err = ErrUnsupportedInventoryFormat
+	}
+	if err != nil {
+		if removeOnClose {
+			_ = os.Remove(path)
+		}
+		return nil, err
+	}
+	if removeOnClose {
+		setRemoveOnClose(fileReader, path)
+	}
+	return fileReader, nil
+}
+
+// DownloadLocalFile downloads the inventory file at bucket and key into a new local temporary file by calling download,
+// and returns the name of the file. Readers of inventories stored in other object stores use it to download their files
+// before reading them with NewLocalFileReader. The file is removed if the download fails.
+func DownloadLocalFile(logger logging.Logger, format string, bucket string, key string, download func(f *os.File) error) (string, error) {
+	f, err := ioutil.TempFile("", filepath.Base(key))
+	if err != nil {
+		return "", err
+	}
+	logger = logger.WithFields(logging.Fields{
+		"bucket":     bucket,
+		"key":        key,
+		"format":     format,
+		"local_file": f.Name(),
+	})
+	logger.Debug("start downloading inventory file")
+	err = download(f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		if removeErr := os.Remove(f.Name()); removeErr != nil {
+			logger.WithError(removeErr).Error("failed to remove inventory file after failed download")
+		}
+		return "", err
+	}
+	logger.Debug("finished downloading inventory file")
+	return f.Name(), nil
+}
+
+// MetadataTailSize is the number of bytes at the end of ORC and Parquet files which readers of inventories stored in
+// other object stores download first to read their metadata, enough to hold the metadata of most files.
+const MetadataTailSize = orcInitialReadSize
+
+// MetadataTailLength returns the number of bytes at the end of the ORC or Parquet file at path which hold its metadata.
+// The local file may hold only the end of the inventory file, as downloaded to read its metadata: if it is shorter than
+// the returned length, the metadata is only partly downloaded, and the returned length must be downloaded again.
+// Files of other formats carry no metadata at their end, and fail with ErrUnsupportedInventoryFormat.
+func MetadataTailLength(format string, path string) (int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	switch format {
+	case OrcFormatName:
+		tailLength, err := getTailLength(f)
+		if err != nil {
+			return 0, fmt.Errorf("%w: %s", ErrMalformedOrcFile, err)
+		}
+		return int64(tailLength), nil
+	case ParquetFormatName:
+		return parquetTailLength(f)
+	default:
+		return 0, fmt.Errorf("%w: %s files have no metadata to read apart", ErrUnsupportedInventoryFormat, format)
+	}
+}
+
+// parquetTailLength returns the length of the footer of the parquet file f, with the footer length and magic number
+// following it.
+func parquetTailLength(f *os.File) (int64, error) {
+	stat, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	const footerLengthSize = 4
+	suffixLength := int64(footerLengthSize + len(parquetMagic))
+	if stat.Size() < suffixLength {
+		return 0, fmt.Errorf("%w: file of %d bytes", ErrMalformedParquetFile, stat.Size())
+	}
+	suffix := make([]byte, suffixLength)
+	if _, err := f.ReadAt(suffix, stat.Size()-suffixLength); err != nil {
+		return 0, err
+	}
+	if string(suffix[footerLengthSize:]) != parquetMagic {
+		return 0, fmt.Errorf("%w: missing magic number", ErrMalformedParquetFile)
+	}
+	return int64(binary.LittleEndian.Uint32(suffix[:footerLengthSize])) + suffixLength, nil
+}
+
+func newLocalOrcReader(ctx context.Context, path string, layout fileLayout) (FileReader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	orcReader, err := newLayoutOrcInventoryFileReader(ctx, &OrcFile{f}, nil, layout)
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return orcReader, nil
+}
+
+func newLocalParquetReader(path string, layout fileLayout) (FileReader, error) {
+	pf, err := local.NewLocalFileReader(path)
+	if err != nil {
+		return nil, err
+	}
+	parquetReader, err := newParquetInventoryFileReader(pf, objectFilter{}, nil, DefaultParquetConcurrency, layout)
+	if err != nil {
+		_ = pf.Close()
+		return nil, err
+	}
+	return parquetReader, nil
+}
+
+func newLocalCSVReader(ctx context.Context, schema string, path string) (FileReader, error) {
+	columns, err := parseCSVSchema(schema)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	csvReader, err := NewCSVInventoryFileReader(ctx, f, columns)
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return csvReader, nil
+}
+
+// setRemoveOnClose makes fileReader remove the local file at path once it is closed. The removal is done by the
+// concrete reader itself, so that its optional methods remain available to the callers.
+func setRemoveOnClose(fileReader FileReader, path string) {
+	switch r := fileReader.(type) {
+	case *OrcInventoryFileReader:
+		r.removePath = path
+	case *ParquetInventoryFileReader:
+		r.removePath = path
+	case *CSVInventoryFileReader:
+		r.removePath = path
+	case *AvroInventoryFileReader:
+		r.removePath = path
+	}
+}
+
+// removeClosedFile removes the file at path, if set, once its reader is closed with closeErr, and returns both errors.
+func removeClosedFile(path string, closeErr error) error {
+	if path == "" {
+		return closeErr
+	}
+	if err := os.Remove(path); err != nil {
+		return multierror.Append(closeErr, err)
+	}
+	return closeErr
+}
+
+func newLocalAvroReader(ctx context.Context, path string) (FileReader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	avroReader, err := NewAvroInventoryFileReader(ctx, f)
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return avroReader, nil
+}
@@ -2,22 +2,56 @@ package s3
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"reflect"
 	"time"
 
 	"github.com/go-openapi/swag"
 	"github.com/hashicorp/go-multierror"
 	"github.com/scritchley/orc"
+	"github.com/treeverse/lakefs/logging"
+)
+
+var (
+	ErrSkipBeyondLastRow = errors.New("cannot skip beyond the last row of the inventory file")
+	ErrMalformedOrcFile  = errors.New("malformed orc inventory file")
+	ErrCorruptOrcStripe  = errors.New("corrupt stripe in orc inventory file")
+	ErrMalformedOrcRow   = errors.New("malformed row in orc inventory file")
 )
 
 type OrcInventoryFileReader struct {
-	reader    *orc.Reader
-	cursor    *orc.Cursor
-	ctx       context.Context
-	orcSelect *OrcSelect
-	orcFile   *OrcFile
+	mgr            *Reader
+	cacheKey       string // set if the file was prefetched
+	reader         *orc.Reader
+	cursor         *orc.Cursor
+	ctx            context.Context
+	orcSelect      *OrcSelect
+	orcFile        orcSource
+	filter         objectFilter
+	nextStripe     int
+	stripeRowsLeft int64 // rows of the current stripe which were not yet read
+	nextStripeRow  int64 // index in the file of the first row of nextStripe, or -1 until counted after skipped stripes
+	skippedStripes int
+	pending        *InventoryObject // an object read but left out of the last batch by ReadBytes
+	skipCorrupt    bool             // skip corrupt stripes instead of failing the read
+	stripeLogEvery int              // the number of stripes between logs of a stripe start, or 0 for the default
+	onBadRow       BadRowHandler
+	key            string
+	manifestFile   FileChecksum // the entry of the file in its manifest, zero if the reader has none
+	bucket         string       // set for files with no bucket column
+	logger         logging.Logger
+	bytesRead      *byteCounter
+	rowsScanned    int64 // rows iterated since the file was opened or rewound, regardless of filters
+	buffers        objectBuffers
+	metrics        *fileReadMetrics
+	removePath     string
+	tempDisk       *diskReservation // room held by the local copy of the file, released on Close
 }
 
+// inventoryObjectOverhead is the estimated size in bytes of an inventory object, excluding its key.
+const inventoryObjectOverhead = 128
+
 type OrcField struct {
 	IndexInFile   int
 	IndexInSelect int
@@ -29,91 +63,460 @@ type OrcSelect struct {
 	IndexInFile   map[string]int // for each field, its index in the original file
 }
 
-func getOrcSelect(typeDescription *orc.TypeDescription) *OrcSelect {
-	relevantFields := []string{"bucket", "key", "size", "last_modified_date", "e_tag", "is_delete_marker", "is_latest"}
+// getOrcSelect returns the inventory columns to select from a file with the given schema.
+// If columns is not nil, only the columns it contains are selected. Columns are looked up in the file according to
+// layout, while the indexes of the result are keyed by inventory column.
+func getOrcSelect(typeDescription *orc.TypeDescription, columns map[string]bool, layout fileLayout) *OrcSelect {
 	res := &OrcSelect{
 		SelectFields:  nil,
 		IndexInFile:   make(map[string]int),
 		IndexInSelect: make(map[string]int),
 	}
 	for i, field := range typeDescription.Columns() {
-		res.IndexInFile[field] = i
+		res.IndexInFile[layout.inventoryColumnName(field)] = i
 	}
 	j := 0
-	for _, field := range relevantFields {
-		if _, ok := res.IndexInFile[field]; ok {
-			res.SelectFields = append(res.SelectFields, field)
-			res.IndexInSelect[field] = j
+	for _, column := range inventoryColumns {
+		if columns != nil && !columns[column.name] {
+			continue
+		}
+		if _, ok := res.IndexInFile[column.name]; ok {
+			res.SelectFields = append(res.SelectFields, layout.fileColumnName(column.name))
+			res.IndexInSelect[column.name] = j
 			j++
 		}
 	}
 	return res
 }
 
-func (r *OrcInventoryFileReader) inventoryObjectFromRow(rowData []interface{}) InventoryObject {
-	var size *int64
-	if sizeIdx, ok := r.orcSelect.IndexInSelect["size"]; ok && rowData[sizeIdx] != nil {
-		size = swag.Int64(rowData[sizeIdx].(int64))
+// inventoryObjectFromRow returns the object of the given row, failing with ErrMalformedOrcRow if its key is null or
+// if a value has an unexpected type.
+func (r *OrcInventoryFileReader) inventoryObjectFromRow(rowData []interface{}) (InventoryObject, error) {
+	var res InventoryObject
+	var ok bool
+	keyValue := rowData[r.orcSelect.IndexInSelect["key"]]
+	if res.Key, ok = keyValue.(string); !ok {
+		return res, malformedOrcRowError("key", keyValue)
+	}
+	if bucketIdx, found := r.orcSelect.IndexInSelect["bucket"]; found {
+		if res.Bucket, ok = rowData[bucketIdx].(string); !ok {
+			return res, malformedOrcRowError("bucket", rowData[bucketIdx])
+		}
+	} else {
+		res.Bucket = r.bucket
+	}
+	if sizeIdx, found := r.orcSelect.IndexInSelect["size"]; found && rowData[sizeIdx] != nil {
+		size, err := objectSize(rowData[sizeIdx])
+		if err != nil {
+			return res, &rowError{kind: ErrMalformedOrcRow, err: fmt.Errorf("key %s: %w", res.Key, err)}
+		}
+		res.Size = swag.Int64(size)
+	}
+	if lastModifiedIdx, found := r.orcSelect.IndexInSelect["last_modified_date"]; found && rowData[lastModifiedIdx] != nil {
+		lastModified, ok := rowData[lastModifiedIdx].(time.Time)
+		if !ok {
+			return res, malformedOrcRowError("last_modified_date", rowData[lastModifiedIdx])
+		}
+		res.LastModifiedMillis = swag.Int64(lastModified.UnixNano() / int64(time.Millisecond))
+	}
+	if eTagIdx, found := r.orcSelect.IndexInSelect["e_tag"]; found && rowData[eTagIdx] != nil {
+		eTag, ok := rowData[eTagIdx].(string)
+		if !ok {
+			return res, malformedOrcRowError("e_tag", rowData[eTagIdx])
+		}
+		res.Checksum = swag.String(eTag)
+	}
+	if isLatestIdx, found := r.orcSelect.IndexInSelect["is_latest"]; found && rowData[isLatestIdx] != nil {
+		isLatest, ok := rowData[isLatestIdx].(bool)
+		if !ok {
+			return res, malformedOrcRowError("is_latest", rowData[isLatestIdx])
+		}
+		res.IsLatest = swag.Bool(isLatest)
 	}
-	var lastModifiedMillis *int64
-	if lastModifiedIdx, ok := r.orcSelect.IndexInSelect["last_modified_date"]; ok && rowData[lastModifiedIdx] != nil {
-		lastModifiedMillis = swag.Int64(rowData[lastModifiedIdx].(time.Time).UnixNano() / int64(time.Millisecond))
+	if versionIDIdx, found := r.orcSelect.IndexInSelect["version_id"]; found && rowData[versionIDIdx] != nil {
+		versionID, ok := rowData[versionIDIdx].(string)
+		if !ok {
+			return res, malformedOrcRowError("version_id", rowData[versionIDIdx])
+		}
+		res.VersionID = swag.String(versionID)
 	}
-	var eTag *string
-	if eTagIdx, ok := r.orcSelect.IndexInSelect["e_tag"]; ok && rowData[eTagIdx] != nil {
-		eTag = swag.String(rowData[eTagIdx].(string))
+	if isDeleteMarkerIdx, found := r.orcSelect.IndexInSelect["is_delete_marker"]; found && rowData[isDeleteMarkerIdx] != nil {
+		isDeleteMarker, ok := rowData[isDeleteMarkerIdx].(bool)
+		if !ok {
+			return res, malformedOrcRowError("is_delete_marker", rowData[isDeleteMarkerIdx])
+		}
+		res.IsDeleteMarker = swag.Bool(isDeleteMarker)
 	}
-	var isLatest *bool
-	if isLatestIdx, ok := r.orcSelect.IndexInSelect["is_latest"]; ok && rowData[isLatestIdx] != nil {
-		isLatest = swag.Bool(rowData[isLatestIdx].(bool))
+	if storageClassIdx, found := r.orcSelect.IndexInSelect["storage_class"]; found && rowData[storageClassIdx] != nil {
+		if res.StorageClass, ok = rowData[storageClassIdx].(string); !ok {
+			return res, malformedOrcRowError("storage_class", rowData[storageClassIdx])
+		}
 	}
-	var isDeleteMarker *bool
-	if isDeleteMarkerIdx, ok := r.orcSelect.IndexInSelect["is_delete_marker"]; ok && rowData[isDeleteMarkerIdx] != nil {
-		isDeleteMarker = swag.Bool(rowData[isDeleteMarkerIdx].(bool))
+	if isMultipartIdx, found := r.orcSelect.IndexInSelect["is_multipart_uploaded"]; found && rowData[isMultipartIdx] != nil {
+		if res.IsMultipartUploaded, ok = rowData[isMultipartIdx].(bool); !ok {
+			return res, malformedOrcRowError("is_multipart_uploaded", rowData[isMultipartIdx])
+		}
 	}
-	return InventoryObject{
-		Bucket:             rowData[r.orcSelect.IndexInSelect["bucket"]].(string),
-		Key:                rowData[r.orcSelect.IndexInSelect["key"]].(string),
-		Size:               size,
-		LastModifiedMillis: lastModifiedMillis,
-		Checksum:           eTag,
-		IsLatest:           isLatest,
-		IsDeleteMarker:     isDeleteMarker,
+	return res, nil
+}
+
+func malformedOrcRowError(column string, value interface{}) error {
+	if value == nil {
+		return fmt.Errorf("%w: column %q is null", ErrMalformedOrcRow, column)
 	}
+	return fmt.Errorf("%w: column %q has type %T", ErrMalformedOrcRow, column, value)
 }
 
-func (r *OrcInventoryFileReader) Read(dstInterface interface{}) error {
+func (r *OrcInventoryFileReader) Read(dstInterface interface{}) (err error) {
+	start := time.Now()
+	defer func() {
+		r.metrics.observeRead(start, dstInterface, err)
+	}()
 	num := reflect.ValueOf(dstInterface).Elem().Len()
-	res := make([]InventoryObject, 0, num)
-	for {
+	res, err := r.read(num, 0)
+	if err != nil {
+		return err
+	}
+	reflect.ValueOf(dstInterface).Elem().Set(reflect.ValueOf(res))
+	if len(res) == 0 && num > 0 {
+		return ErrNoMoreRows
+	}
+	return nil
+}
+
+// ReadBytes is like Read, but also stops the batch before the estimated size of its objects exceeds maxBytes.
+// The size of an object is estimated from the length of its key plus a fixed overhead. A batch always contains at
+// least one object, unless the file is exhausted, so that reading proceeds even if a single object exceeds maxBytes.
+// As with Read, an exhausted file fails with ErrNoMoreRows.
+func (r *OrcInventoryFileReader) ReadBytes(dst *[]InventoryObject, maxBytes int) (err error) {
+	start := time.Now()
+	defer func() {
+		r.metrics.observeRead(start, dst, err)
+	}()
+	num := len(*dst)
+	res, err := r.read(num, maxBytes)
+	if err != nil {
+		return err
+	}
+	*dst = res
+	if len(res) == 0 && num > 0 {
+		return ErrNoMoreRows
+	}
+	return nil
+}
+
+func estimatedObjectSize(obj *InventoryObject) int {
+	return len(obj.Key) + inventoryObjectOverhead
+}
+
+// read returns up to num objects, whose total estimated size is at most maxBytes if it is positive.
+func (r *OrcInventoryFileReader) read(num int, maxBytes int) ([]InventoryObject, error) {
+	res := r.buffers.get(num)
+	size := 0
+	for len(res) < num {
 		select {
 		case <-r.ctx.Done():
-			return r.ctx.Err()
+			return nil, r.ctx.Err()
 		default:
 		}
-		if !r.cursor.Next() {
-			if !r.cursor.Stripes() {
-				break
+		var obj InventoryObject
+		if r.pending != nil {
+			obj, r.pending = *r.pending, nil
+		} else {
+			var ok bool
+			var err error
+			obj, ok, err = r.nextObject()
+			if err == nil && !ok {
+				var selected bool
+				selected, err = r.selectNextStripe()
+				if err == nil && !selected {
+					break
+				}
+			}
+			if errors.Is(err, ErrMalformedOrcRow) {
+				if r.onBadRow == nil || !r.onBadRow(r.key, r.rowIndex(), err) {
+					return nil, err
+				}
+				continue
+			}
+			if err != nil {
+				if !r.skipCorrupt || !errors.Is(err, ErrCorruptOrcStripe) {
+					return nil, err
+				}
+				r.log().WithError(err).Error("skipping corrupt stripe of orc inventory file")
+				// the cursor cannot be trusted after failing, reading continues with a fresh one from the next stripe
+				r.cursor = r.reader.Select(r.orcSelect.SelectFields...)
+				r.stripeRowsLeft = 0
+				continue
 			}
-			if !r.cursor.Next() {
-				break
+			if !ok || !r.filter.match(&obj) {
+				continue
 			}
 		}
-		res = append(res, r.inventoryObjectFromRow(r.cursor.Row()))
-		if len(res) == num {
+		objSize := estimatedObjectSize(&obj)
+		if maxBytes > 0 && len(res) > 0 && size+objSize > maxBytes {
+			r.pending = &obj
 			break
 		}
+		size += objSize
+		res = append(res, obj)
 	}
+	return res, nil
+}
 
-	reflect.ValueOf(dstInterface).Elem().Set(reflect.ValueOf(res))
+// nextObject returns the next object in the current stripe, or false if the stripe has no rows left.
+func (r *OrcInventoryFileReader) nextObject() (obj InventoryObject, ok bool, err error) {
+	ok, err = r.nextRow()
+	if err != nil || !ok {
+		return obj, ok, err
+	}
+	defer recoverCorruptStripe(r.nextStripe-1, &err)
+	obj, err = r.inventoryObjectFromRow(r.cursor.Row())
+	return obj, true, err
+}
+
+// nextRow moves the cursor to the next row in the current stripe, returning false if the stripe has no rows left.
+// A stripe which cannot be decoded, or which ends before its declared number of rows, fails with ErrCorruptOrcStripe.
+func (r *OrcInventoryFileReader) nextRow() (ok bool, err error) {
+	stripe := r.nextStripe - 1
+	defer recoverCorruptStripe(stripe, &err)
+	if r.cursor.Next() {
+		r.stripeRowsLeft--
+		r.rowsScanned++
+		return true, nil
+	}
+	if err := r.cursor.Err(); err != nil {
+		return false, fmt.Errorf("%w: stripe %d: %s", ErrCorruptOrcStripe, stripe, err)
+	}
+	if r.stripeRowsLeft > 0 {
+		return false, fmt.Errorf("%w: stripe %d: %d rows missing", ErrCorruptOrcStripe, stripe, r.stripeRowsLeft)
+	}
+	return false, nil
+}
+
+// rowIndex returns the index in the file of the last row read, or -1 if it cannot be determined. It is kept as stripes
+// are selected, and the rows of the previous stripes are only counted once stripes were skipped unread by the filters.
+func (r *OrcInventoryFileReader) rowIndex() int64 {
+	if r.nextStripeRow < 0 {
+		var index int64
+		for i := 0; i < r.nextStripe; i++ {
+			stripeRows, err := r.stripeNumRows(i)
+			if err != nil {
+				return -1
+			}
+			index += stripeRows
+		}
+		r.nextStripeRow = index
+	}
+	return r.nextStripeRow - r.stripeRowsLeft - 1
+}
+
+// addStripeRows moves the index of the first row of the next stripe past a stripe of the given number of rows.
+func (r *OrcInventoryFileReader) addStripeRows(stripeRows int64) {
+	if r.nextStripeRow >= 0 {
+		r.nextStripeRow += stripeRows
+	}
+}
+
+// recoverCorruptStripe converts a panic of the orc library, which panics on some corrupt data, into
+// ErrCorruptOrcStripe for the given stripe.
+func recoverCorruptStripe(stripe int, err *error) {
+	if p := recover(); p != nil {
+		*err = fmt.Errorf("%w: stripe %d: %v", ErrCorruptOrcStripe, stripe, p)
+	}
+}
+
+func (r *OrcInventoryFileReader) log() logging.Logger {
+	if r.logger == nil {
+		return logging.Default()
+	}
+	return r.logger
+}
+
+// selectNextStripe moves the cursor to the next stripe which may contain keys starting with the key prefix.
+// It returns false if no such stripe is left.
+func (r *OrcInventoryFileReader) selectNextStripe() (bool, error) {
+	numStripes, err := r.reader.NumStripes()
+	if err != nil {
+		return false, err
+	}
+	for ; r.nextStripe < numStripes; r.nextStripe++ {
+		if !r.filter.pushdown(OrcFormatName) || r.stripeMayHavePrefix(r.nextStripe) {
+			break
+		}
+		r.skippedStripes++
+		// the rows of the stripe are not counted, so as not to read it
+		r.nextStripeRow = -1
+	}
+	if r.nextStripe >= numStripes {
+		return false, nil
+	}
+	r.logStripeStart(r.nextStripe, numStripes)
+	if err = r.selectStripe(r.nextStripe); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// logStripeStart logs the start of reading a stripe, sampled to once every stripeLogEvery stripes, so that files with
+// many stripes do not flood the log. The first and last stripes are always logged. For files with a size declared by
+// their manifest, the bytes read out of it are logged as well.
+func (r *OrcInventoryFileReader) logStripeStart(stripe int, numStripes int) {
+	every := r.stripeLogEvery
+	if every == 0 {
+		every = DefaultStripeLogInterval
+	}
+	if stripe != 0 && stripe != numStripes-1 && stripe%every != 0 {
+		return
+	}
+	r.log().WithFields(logging.Fields{"stripe": stripe, "num_stripes": numStripes}).Debug("start new stripe")
+	if r.manifestFile.Size > 0 {
+		r.log().WithFields(logging.Fields{"bytes_read": r.BytesRead(), "declared_size": r.manifestFile.Size}).Debug("orc file read progress")
+	}
+}
+
+// selectStripe moves the cursor to the beginning of the given stripe. Reading continues from the stripe after it even
+// if it cannot be selected.
+func (r *OrcInventoryFileReader) selectStripe(stripe int) (err error) {
+	r.nextStripe = stripe + 1
+	r.stripeRowsLeft = 0
+	defer func() {
+		if err != nil {
+			// the rows of a stripe which cannot be selected are unknown
+			r.nextStripeRow = -1
+		}
+	}()
+	defer recoverCorruptStripe(stripe, &err)
+	// a new cursor is used for each stripe, since selecting a stripe doesn't reset the position of an existing one
+	cursor := r.reader.Select(r.orcSelect.SelectFields...)
+	if err := cursor.SelectStripe(stripe); err != nil {
+		return err
+	}
+	r.cursor = cursor
+	r.stripeRowsLeft = int64(cursor.Stripe.GetNumberOfRows())
+	r.addStripeRows(r.stripeRowsLeft)
+	return nil
+}
+
+func (r *OrcInventoryFileReader) Rewind() error {
+	// the cursor has no stripe selected until the next read
+	r.cursor = r.reader.Select(r.orcSelect.SelectFields...)
+	r.nextStripe = 0
+	r.stripeRowsLeft = 0
+	r.nextStripeRow = 0
+	r.pending = nil
+	r.rowsScanned = 0
+	return nil
+}
+
+// stripeNumRows returns the number of rows in the given stripe, without reading its data.
+func (r *OrcInventoryFileReader) stripeNumRows(stripe int) (_ int64, err error) {
+	defer recoverCorruptStripe(stripe, &err)
+	cursor := r.reader.Select()
+	if err := cursor.SelectStripe(stripe); err != nil {
+		return 0, err
+	}
+	return int64(cursor.Stripe.GetNumberOfRows()), nil
+}
+
+// SkipRows advances the reader past the next num rows of the file, regardless of the filters applied by Read.
+// Whole stripes are skipped according to their row counts, so only the rows of the last stripe are iterated.
+// If fewer than num rows are left, the reader is moved to the end of the file and ErrSkipBeyondLastRow is returned.
+// It stops with the error of the context of the reader once the context is done.
+func (r *OrcInventoryFileReader) SkipRows(num int64) error {
+	if r.pending != nil && num > 0 {
+		r.pending = nil
+		num--
+	}
+	numStripes, err := r.reader.NumStripes()
+	if err != nil {
+		return err
+	}
+	for num > r.stripeRowsLeft {
+		if err := r.ctx.Err(); err != nil {
+			return err
+		}
+		num -= r.stripeRowsLeft
+		r.stripeRowsLeft = 0
+		if r.nextStripe >= numStripes {
+			return fmt.Errorf("%w: %d rows left to skip", ErrSkipBeyondLastRow, num)
+		}
+		stripeRows, err := r.stripeNumRows(r.nextStripe)
+		if err != nil {
+			return err
+		}
+		if stripeRows <= num {
+			num -= stripeRows
+			r.nextStripe++
+			r.addStripeRows(stripeRows)
+			continue
+		}
+		if err = r.selectStripe(r.nextStripe); err != nil {
+			return err
+		}
+	}
+	for ; num > 0; num-- {
+		if err := r.ctx.Err(); err != nil {
+			return err
+		}
+		ok, err := r.nextRow()
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return nil
+		}
+	}
 	return nil
 }
 
+func (r *OrcInventoryFileReader) stripeMayHavePrefix(stripe int) bool {
+	minKey, maxKey, ok := r.stripeKeyRange(stripe)
+	if !ok {
+		return true
+	}
+	return keyRangeMayHavePrefix(minKey, maxKey, r.filter.keyPrefix)
+}
+
+// stripeKeyRange returns the minimal and maximal keys of the given stripe, according to the statistics of the file. It
+// returns false if the file has no such stripe, or no statistics of its key column.
+func (r *OrcInventoryFileReader) stripeKeyRange(stripe int) (string, string, bool) {
+	metadata := r.reader.Metadata()
+	if metadata == nil || stripe < 0 || stripe >= len(metadata.GetStripeStats()) {
+		return "", "", false
+	}
+	colStats := metadata.GetStripeStats()[stripe].GetColStats()
+	keyIdx := r.orcSelect.IndexInFile["key"] + 1
+	if keyIdx >= len(colStats) {
+		return "", "", false
+	}
+	stats := colStats[keyIdx].GetStringStatistics()
+	if stats == nil || stats.Minimum == nil || stats.Maximum == nil {
+		return "", "", false
+	}
+	return stats.GetMinimum(), stats.GetMaximum(), true
+}
+
 func (r *OrcInventoryFileReader) GetNumRows() int64 {
 	return int64(r.reader.NumRows())
 }
 
+// BytesRead returns the number of bytes read from the file so far, by the orc library.
+func (r *OrcInventoryFileReader) BytesRead() int64 {
+	return r.bytesRead.load()
+}
+
+// readInFull returns true if all rows of the file were iterated, with all of its columns selected.
+func (r *OrcInventoryFileReader) readInFull() bool {
+	return r.rowsScanned > 0 && r.rowsScanned >= r.GetNumRows() && len(r.orcSelect.SelectFields) == len(r.orcSelect.IndexInFile)
+}
+
 func (r *OrcInventoryFileReader) Close() error {
+	if r.readInFull() {
+		warnOnPartialRead(r.log(), r.BytesRead(), r.orcFile.Size())
+	}
+	r.buffers.release()
+	defer r.tempDisk.release()
 	var combinedErr error
 	if err := r.cursor.Close(); err != nil {
 		combinedErr = multierror.Append(combinedErr, err)
@@ -124,13 +527,31 @@ func (r *OrcInventoryFileReader) Close() error {
 	if err := r.orcFile.Close(); err != nil {
 		combinedErr = multierror.Append(combinedErr, err)
 	}
-	return combinedErr
+	if r.cacheKey != "" {
+		r.mgr.release(r.cacheKey)
+	}
+	return removeClosedFile(r.removePath, combinedErr)
 }
 
+// FirstObjectKey returns the minimal key of the first stripe, which is the first key of the file as files are sorted by
+// key. It returns an empty string if the file has no stripes, or no statistics of its key column.
 func (r *OrcInventoryFileReader) FirstObjectKey() string {
-	return *r.reader.Metadata().StripeStats[0].GetColStats()[r.orcSelect.IndexInFile["key"]+1].StringStatistics.Minimum
+	minKey, _, _ := r.stripeKeyRange(0)
+	return minKey
 }
 
+// LastObjectKey returns the maximal key of the last stripe, which is the last key of the file as files are sorted by key.
+// Trailing stripes with no statistics of the key column, such as empty stripes, are skipped. It returns an empty string
+// if no stripe has statistics of the key column.
 func (r *OrcInventoryFileReader) LastObjectKey() string {
-	return *r.reader.Metadata().StripeStats[0].GetColStats()[r.orcSelect.IndexInFile["key"]+1].StringStatistics.Maximum
+	metadata := r.reader.Metadata()
+	if metadata == nil {
+		return ""
+	}
+	for stripe := len(metadata.GetStripeStats()) - 1; stripe >= 0; stripe-- {
+		if _, maxKey, ok := r.stripeKeyRange(stripe); ok {
+			return maxKey
+		}
+	}
+	return ""
 }
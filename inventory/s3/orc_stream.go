@@ -0,0 +1,91 @@
+package s3
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/scritchley/orc"
+)
+
+// orcSource is the data of an ORC file, as read by the orc library.
+type orcSource interface {
+	orc.SizedReaderAt
+	io.Closer
+}
+
+// WithStreamOrc sets whether ORC files are read in place from S3, with a ranged request for every read of the orc
+// library, instead of being downloaded whole before being opened. Streaming trades many small requests for not
+// storing the files locally, so it pays off when only small parts of large files are read, e.g. their footers.
+// Prefetched and cached files are still read locally.
+func WithStreamOrc(stream bool) ReaderOption {
+	return func(o *Reader) {
+		o.streamOrc = stream
+	}
+}
+
+// s3OrcSource reads an ORC file from S3 with ranged requests.
+type s3OrcSource struct {
+	ctx    context.Context
+	svc    s3iface.S3API
+	bucket string
+	key    string
+	size   int64
+}
+
+func (o *Reader) openS3OrcSource(bucket string, key string) (*s3OrcSource, error) {
+	headObject, err := o.svc.HeadObjectWithContext(o.ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, downloadError(err)
+	}
+	return &s3OrcSource{
+		ctx:    o.ctx,
+		svc:    o.svc,
+		bucket: bucket,
+		key:    key,
+		size:   aws.Int64Value(headObject.ContentLength),
+	}, nil
+}
+
+func (s *s3OrcSource) ReadAt(p []byte, off int64) (int, error) {
+	if off >= s.size {
+		return 0, io.EOF
+	}
+	end := off + int64(len(p))
+	if end > s.size {
+		end = s.size
+	}
+	output, err := s.svc.GetObjectWithContext(s.ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.key),
+		Range:  aws.String(fmt.Sprintf("bytes=%d-%d", off, end-1)),
+	})
+	if err != nil {
+		return 0, downloadError(err)
+	}
+	defer func() {
+		_ = output.Body.Close()
+	}()
+	n, err := io.ReadFull(output.Body, p[:end-off])
+	if err != nil {
+		return n, downloadError(err)
+	}
+	if end-off < int64(len(p)) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (s *s3OrcSource) Size() int64 {
+	return s.size
+}
+
+func (s *s3OrcSource) Close() error {
+	return nil
+}
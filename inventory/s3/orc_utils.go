@@ -2,17 +2,22 @@ package s3
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
-	"io/ioutil"
+	"math/rand"
+	"net/http"
 	"os"
 	"path"
+	"syscall"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3iface"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
-	"github.com/go-openapi/swag"
 	gproto "github.com/golang/protobuf/proto" //nolint:staticcheck // orc lib uses old proto
 	"github.com/scritchley/orc/proto"
 	"github.com/treeverse/lakefs/logging"
@@ -21,6 +26,8 @@ import (
 const (
 	maxPostScriptSize  = 256 // from the ORC specification: https://orc.apache.org/specification/ORCv1/
 	orcInitialReadSize = 16000
+	maxDownloadBackoff = 30 * time.Second
+	slowDownErrorCode  = "SlowDown"
 )
 
 // getTailLength reads the ORC postscript from the given file, returning the full tail length.
@@ -54,70 +61,254 @@ func getTailLength(f *os.File) (int, error) {
 	return footerLength + metadataLength + psLen + 1, nil
 }
 
-func downloadRange(ctx context.Context, svc s3iface.S3API, logger logging.Logger, bucket string, key string, fromByte int64) (*os.File, error) {
-	f, err := ioutil.TempFile("", path.Base(key))
+// tempFile creates a new local file in the reader's temp dir for downloading the given key.
+func (o *Reader) tempFile(key string) (*os.File, error) {
+	f, err := o.tempFiles(o.tempDir, path.Base(key))
+	if err != nil && o.tempDir != "" {
+		return nil, fmt.Errorf("%w: %s: %s", ErrInvalidTempDir, o.tempDir, err)
+	}
+	return f, err
+}
+
+// downloadRange downloads the object from the given byte to its end into a new temp file, which is removed once closed.
+// The returned reservation holds room in the temp disk budget of the reader for the file, until released.
+func (o *Reader) downloadRange(ctx context.Context, format string, bucket string, key string, fromByte int64) (*os.File, *diskReservation, error) {
+	reservation, err := o.reserveTempDisk(ctx, bucket, key, fromByte)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+	f, err := o.tempFile(key)
+	if err != nil {
+		reservation.release()
+		return nil, nil, err
 	}
 	defer func() {
 		if err := os.Remove(f.Name()); err != nil {
-			logger.Errorf("failed to remove orc file after download. file=%s, err=%w", f.Name(), err)
+			o.logger.WithField("local_file", f.Name()).WithError(err).Error("failed to remove inventory file after download")
 		}
 	}()
-	downloader := s3manager.NewDownloaderWithClient(svc)
+	err = o.download(ctx, format, f, bucket, key, fromByte)
+	if err != nil {
+		_ = f.Close()
+		reservation.release()
+		return nil, nil, err
+	}
+	return f, reservation, nil
+}
+
+// download writes the object from the given byte to its end into the local file f, and verifies its checksum.
+// Transient failures are retried according to the reader's retry policy.
+func (o *Reader) download(ctx context.Context, format string, f *os.File, bucket string, key string, fromByte int64) error {
+	start := time.Now()
+	sizes := newObjectSizeClient(o.svc)
+	downloader := o.newDownloader(sizes)
 	var rng *string
 	if fromByte > 0 {
 		rng = aws.String(fmt.Sprintf("bytes=%d-", fromByte))
 	}
-	logger.Debugf("start downloading %s[%s] to local file %s", key, swag.StringValue(rng), f.Name())
-	_, err = downloader.DownloadWithContext(ctx, f, &s3.GetObjectInput{
-		Bucket: aws.String(bucket),
-		Key:    aws.String(key),
-		Range:  rng,
+	logger := o.logger.WithFields(logging.Fields{
+		"bucket":     bucket,
+		"key":        key,
+		"format":     format,
+		"local_file": f.Name(),
+		"range":      aws.StringValue(rng),
 	})
-	if err != nil {
-		return nil, err
+	for attempt := 0; ; attempt++ {
+		logger.Debug("start downloading inventory file")
+		var w io.WriterAt = f
+		progress := o.progressWriter(f, bucket, key, fromByte, sizes)
+		if progress != nil {
+			w = progress
+		}
+		if err := o.acquireDownloadSlot(ctx); err != nil {
+			return downloadError(err)
+		}
+		attemptCtx, cancel := ctx, context.CancelFunc(func() {})
+		if o.fileTimeout > 0 {
+			attemptCtx, cancel = context.WithTimeout(ctx, o.fileTimeout)
+		}
+		n, err := downloader.DownloadWithContext(attemptCtx, w, &s3.GetObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(key),
+			Range:  rng,
+		})
+		timedOut := err != nil && ctx.Err() == nil && errors.Is(attemptCtx.Err(), context.DeadlineExceeded)
+		cancel()
+		o.releaseDownloadSlot()
+		if timedOut {
+			err = fmt.Errorf("%w after %s: %s", ErrDownloadTimeout, o.fileTimeout, err)
+		}
+		if err == nil {
+			if err = o.verifyDownload(f, bucket, key, fromByte, n, sizes.objectSize()); err != nil {
+				return downloadError(err)
+			}
+			if progress != nil {
+				progress.done(n)
+			}
+			o.metrics.reportDownload(format, start, n)
+			break
+		}
+		if attempt >= o.downloadRetries || !(timedOut || isRetryableDownloadError(err)) {
+			return downloadError(err)
+		}
+		delay := downloadRetryDelay(o.downloadBackoff, attempt)
+		logger.WithFields(logging.Fields{
+			"attempt": attempt + 1,
+			"delay":   delay,
+		}).WithError(err).Debug("download failed, retrying")
+		select {
+		case <-ctx.Done():
+			return downloadError(ctx.Err())
+		case <-time.After(delay):
+		}
+		if err := f.Truncate(0); err != nil {
+			return err
+		}
 	}
-	logger.Debugf("finished downloading %s to local file %s", key, f.Name())
-	return f, nil
+	logger.Debug("finished downloading inventory file")
+	return nil
+}
+
+// newDownloader returns a downloader using svc, with the part size and concurrency of the reader.
+func (o *Reader) newDownloader(svc s3iface.S3API) *s3manager.Downloader {
+	return s3manager.NewDownloaderWithClient(svc, func(d *s3manager.Downloader) {
+		if o.partSize > 0 {
+			d.PartSize = o.partSize
+		}
+		if o.partConcurrency > 0 {
+			d.Concurrency = o.partConcurrency
+		}
+	})
+}
+
+// acquireDownloadSlot waits until another download may start according to the reader's concurrency limit.
+// Slots are not held while waiting to retry.
+func (o *Reader) acquireDownloadSlot(ctx context.Context) error {
+	if o.downloadSlots == nil {
+		return nil
+	}
+	select {
+	case o.downloadSlots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (o *Reader) releaseDownloadSlot() {
+	if o.downloadSlots != nil {
+		<-o.downloadSlots
+	}
+}
+
+// isRetryableDownloadError returns true if err is a transient S3 failure, such as throttling or an unavailable service.
+func isRetryableDownloadError(err error) bool {
+	var reqErr awserr.RequestFailure
+	if errors.As(err, &reqErr) {
+		if reqErr.Code() == request.CanceledErrorCode {
+			return false
+		}
+		switch reqErr.StatusCode() {
+		case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway,
+			http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		}
+	}
+	var awsErr awserr.Error
+	if !errors.As(err, &awsErr) {
+		return false
+	}
+	return awsErr.Code() == slowDownErrorCode || request.IsErrorThrottle(awsErr) || request.IsErrorRetryable(awsErr)
+}
+
+// openOrcReader opens the local copy of an ORC file, retrying transient I/O errors.
+func (o *Reader) openOrcReader(orcFile orcSource, columns map[string]bool, logger logging.Logger) (*OrcInventoryFileReader, error) {
+	for attempt := 0; ; attempt++ {
+		orcReader, err := o.openOrc(o.ctx, orcFile, columns)
+		if err == nil || attempt >= o.orcOpenRetries || !isTransientIOError(err) {
+			return orcReader, err
+		}
+		delay := downloadRetryDelay(o.orcOpenBackoff, attempt)
+		logger.WithFields(logging.Fields{
+			"attempt": attempt + 1,
+			"delay":   delay,
+		}).WithError(err).Debug("failed to open orc file, retrying")
+		select {
+		case <-o.ctx.Done():
+			return nil, o.ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+// isTransientIOError returns true if err is a local I/O failure which may succeed when retried, rather than a sign
+// of a corrupt file.
+func isTransientIOError(err error) bool {
+	for _, errno := range []syscall.Errno{syscall.EIO, syscall.EAGAIN, syscall.EINTR, syscall.ESTALE, syscall.ETIMEDOUT} {
+		if errors.Is(err, errno) {
+			return true
+		}
+	}
+	return false
+}
+
+// downloadRetryDelay returns the delay before the given retry attempt: exponential in the attempt, with full jitter
+// on its upper half.
+func downloadRetryDelay(backoff time.Duration, attempt int) time.Duration {
+	delay := backoff << attempt
+	if delay <= 0 || delay > maxDownloadBackoff {
+		delay = maxDownloadBackoff
+	}
+	half := delay >> 1
+	return half + time.Duration(rand.Int63n(int64(half)+1)) //nolint:gosec
 }
 
 // DownloadOrc downloads a file from s3 and returns a ReaderSeeker to it.
 // If tailOnly is set to true, download only the tail (metadata+footer) by trying the last `orcInitialReadSize` bytes of the file.
 // Then, check the last byte to see if the whole tail was downloaded. If not, download again with the actual tail length.
 func DownloadOrc(ctx context.Context, svc s3iface.S3API, logger logging.Logger, bucket string, key string, tailOnly bool) (*OrcFile, error) {
+	f, _, err := NewReader(ctx, svc, logger).downloadOrc(bucket, key, tailOnly)
+	return f, err
+}
+
+// downloadOrc downloads the given ORC file, or only its tail, as DownloadOrc does. The returned reservation holds room
+// in the temp disk budget of the reader for the local file, until released.
+func (o *Reader) downloadOrc(bucket string, key string, tailOnly bool) (*OrcFile, *diskReservation, error) {
 	var size int64
 	if tailOnly {
-		headObject, err := svc.HeadObject(&s3.HeadObjectInput{
+		headObject, err := o.svc.HeadObject(&s3.HeadObjectInput{
 			Bucket: aws.String(bucket),
 			Key:    aws.String(key),
 		})
 		if err != nil {
-			return nil, err
+			return nil, nil, downloadError(err)
 		}
 		size = *headObject.ContentLength
 	}
-	f, err := downloadRange(ctx, svc, logger, bucket, key, size-orcInitialReadSize)
+	f, reservation, err := o.downloadRange(o.ctx, OrcFormatName, bucket, key, size-orcInitialReadSize)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	if tailOnly {
 		tailLength, err := getTailLength(f)
 		if err != nil {
-			return nil, err
+			_ = f.Close()
+			reservation.release()
+			return nil, nil, parseError(err)
 		}
 		if tailLength > orcInitialReadSize {
 			// tail didn't fit in initially downloaded file
 			if err = f.Close(); err != nil {
-				logger.Errorf("failed to close orc file. file=%s, err=%w", f.Name(), err)
+				o.logger.WithField("local_file", f.Name()).WithError(err).Error("failed to close orc file")
 			}
-			f, err = downloadRange(ctx, svc, logger, bucket, key, size-int64(tailLength))
+			reservation.release()
+			f, reservation, err = o.downloadRange(o.ctx, OrcFormatName, bucket, key, size-int64(tailLength))
 			if err != nil {
-				return nil, err
+				return nil, nil, err
 			}
 		}
 	}
-	return &OrcFile{f}, nil
+	return &OrcFile{f}, reservation, nil
 }
 
 type OrcFile struct {
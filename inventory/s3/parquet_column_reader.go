@@ -0,0 +1,210 @@
+package s3
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/reader"
+	"github.com/xitongsys/parquet-go/schema"
+	"github.com/xitongsys/parquet-go/source"
+)
+
+// ParquetColumnReader reads the projected columns of a parquet inventory file with the column reader of parquet-go,
+// which decodes every column on its own rather than assembling rows into a struct. Columns out of the projection are
+// never read, and their fields are left zero in the objects read.
+type ParquetColumnReader struct {
+	pr            *reader.ParquetReader
+	columns       []parquetProjectedColumn
+	remainingRows int64
+	bytesRead     *byteCounter
+	rowGroupStats []RowGroupStatistics
+}
+
+// parquetProjectedColumn is an inventory column read by a ParquetColumnReader, and its path in the parquet-go schema.
+type parquetProjectedColumn struct {
+	name string
+	path string
+}
+
+// NewParquetColumnReader returns a reader of the given projection of inventory columns of a parquet file, after
+// validating its schema. The key column is read if it is not in the projection, as with WithProjection. If the
+// projection is empty, it returns a reader of all columns, as returned by NewParquetInventoryFileReader.
+func NewParquetColumnReader(pf source.ParquetFile, projection ...string) (FileReader, error) {
+	if len(projection) == 0 {
+		return NewParquetInventoryFileReader(pf)
+	}
+	columns, err := projectionColumns(projection, []string{"key"})
+	if err != nil {
+		return nil, err
+	}
+	bytesRead := &byteCounter{}
+	pf = &countingParquetFile{ParquetFile: pf, counter: bytesRead}
+	footer, err := readParquetFooter(pf)
+	if err != nil {
+		_ = pf.Close()
+		return nil, fmt.Errorf("failed to read parquet footer: %w", err)
+	}
+	if _, _, err = getParquetSchema(footer, columns, fileLayout{}); err != nil {
+		_ = pf.Close()
+		return nil, err
+	}
+	rowGroupStats := parquetFooterStatistics(footer, fileLayout{})
+	// as done by reader.NewParquetColumnReader, without reading the footer again
+	schemaHandler := schema.NewSchemaHandlerFromSchemaList(footer.GetSchema())
+	projected := parquetProjectedColumns(footer, schemaHandler, columns)
+	pr := &reader.ParquetReader{
+		NP:            1,
+		PFile:         pf,
+		Footer:        footer,
+		SchemaHandler: schemaHandler,
+		ColumnBuffers: make(map[string]*reader.ColumnBufferType),
+	}
+	pr.RenameSchema()
+	return &ParquetColumnReader{
+		pr:            pr,
+		columns:       projected,
+		remainingRows: footer.GetNumRows(),
+		bytesRead:     bytesRead,
+		rowGroupStats: rowGroupStats,
+	}, nil
+}
+
+// parquetProjectedColumns returns the columns of footer holding the given inventory columns, in their file order. It
+// must be called before the schema of footer is renamed by the reader.
+func parquetProjectedColumns(footer *parquet.FileMetaData, schemaHandler *schema.SchemaHandler, columns map[string]bool) []parquetProjectedColumn {
+	var res []parquetProjectedColumn
+	for i, element := range footer.GetSchema() {
+		if element.GetNumChildren() == 0 && columns[element.GetName()] {
+			res = append(res, parquetProjectedColumn{name: element.GetName(), path: schemaHandler.IndexMap[int32(i)]})
+		}
+	}
+	return res
+}
+
+func (p *ParquetColumnReader) Read(dstInterface interface{}) error {
+	requested := int64(reflect.ValueOf(dstInterface).Elem().Len())
+	num := requested
+	if num > p.remainingRows {
+		num = p.remainingRows
+	}
+	res := make([]InventoryObject, num)
+	for _, column := range p.columns {
+		if num == 0 {
+			break
+		}
+		values, _, _, err := p.pr.ReadColumnByPath(column.path, num)
+		if err != nil {
+			return err
+		}
+		if int64(len(values)) != num {
+			return fmt.Errorf("column %q has %d values, expected %d", column.name, len(values), num)
+		}
+		for i, value := range values {
+			if err = setParquetColumnValue(&res[i], column.name, value); err != nil {
+				return err
+			}
+		}
+	}
+	p.remainingRows -= num
+	reflect.ValueOf(dstInterface).Elem().Set(reflect.ValueOf(res))
+	if num == 0 && requested > 0 {
+		return ErrNoMoreRows
+	}
+	return nil
+}
+
+// setParquetColumnValue sets the field of obj holding the given inventory column to a value read from it. Null values
+// leave the field unset.
+func setParquetColumnValue(obj *InventoryObject, column string, value interface{}) error {
+	if value == nil {
+		return nil
+	}
+	var ok bool
+	switch column {
+	case "bucket":
+		obj.Bucket, ok = value.(string)
+	case "key":
+		obj.Key, ok = value.(string)
+	case "size":
+		size, err := objectSize(value)
+		if err != nil {
+			return err
+		}
+		obj.Size, ok = &size, true
+	case "last_modified_date":
+		var millis int64
+		if millis, ok = value.(int64); ok {
+			obj.LastModifiedMillis = &millis
+		}
+	case "e_tag":
+		var checksum string
+		if checksum, ok = value.(string); ok {
+			obj.Checksum = &checksum
+		}
+	case "is_delete_marker":
+		var isDeleteMarker bool
+		if isDeleteMarker, ok = value.(bool); ok {
+			obj.IsDeleteMarker = &isDeleteMarker
+		}
+	case "is_latest":
+		var isLatest bool
+		if isLatest, ok = value.(bool); ok {
+			obj.IsLatest = &isLatest
+		}
+	case "version_id":
+		var versionID string
+		if versionID, ok = value.(string); ok {
+			obj.VersionID = &versionID
+		}
+	case "storage_class":
+		obj.StorageClass, ok = value.(string)
+	case "is_multipart_uploaded":
+		obj.IsMultipartUploaded, ok = value.(bool)
+	default:
+		return fmt.Errorf("%w: %q", ErrUnknownInventoryColumn, column)
+	}
+	if !ok {
+		return fmt.Errorf("%w: column %q has type %T", ErrIncompatibleInventoryColumn, column, value)
+	}
+	return nil
+}
+
+func (p *ParquetColumnReader) Rewind() error {
+	p.pr.ReadStop()
+	p.pr.ColumnBuffers = make(map[string]*reader.ColumnBufferType)
+	p.remainingRows = p.pr.Footer.GetNumRows()
+	return nil
+}
+
+func (p *ParquetColumnReader) GetNumRows() int64 {
+	return p.pr.Footer.GetNumRows()
+}
+
+// BytesRead returns the number of bytes read from the file so far, including its footer.
+func (p *ParquetColumnReader) BytesRead() int64 {
+	return p.bytesRead.load()
+}
+
+// FirstObjectKey returns the minimal key of the first row group, according to its statistics, or an empty string if
+// the statistics are missing.
+func (p *ParquetColumnReader) FirstObjectKey() string {
+	if len(p.rowGroupStats) == 0 {
+		return ""
+	}
+	return p.rowGroupStats[0].MinKey
+}
+
+// LastObjectKey returns the maximal key of the last row group, according to its statistics, or an empty string if the
+// statistics are missing.
+func (p *ParquetColumnReader) LastObjectKey() string {
+	if len(p.rowGroupStats) == 0 {
+		return ""
+	}
+	return p.rowGroupStats[len(p.rowGroupStats)-1].MaxKey
+}
+
+func (p *ParquetColumnReader) Close() error {
+	p.pr.ReadStop()
+	return p.pr.PFile.Close()
+}
@@ -1,20 +1,469 @@
 package s3
 
-import "github.com/xitongsys/parquet-go/reader"
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/treeverse/lakefs/logging"
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/reader"
+	"github.com/xitongsys/parquet-go/source"
+)
+
+const parquetMagic = "PAR1"
+
+var ErrMalformedParquetFile = errors.New("malformed parquet inventory file")
 
 type ParquetInventoryFileReader struct {
 	reader.ParquetReader
+	filter           objectFilter
+	bucket           string // set for files with no bucket column
+	rawSize          bool   // set for files with a size column of another type than INT64, decoded by readRows
+	remainingRows    int64
+	filteredRows     int64 // rows of the row groups left by the filter, read by Read unlike GetNumRows
+	skippedRowGroups int
+	// rowGroupConcurrency is the number of row groups read in parallel, or 0 to read them serially
+	rowGroupConcurrency int
+	rowGroups           *parquetRowGroupPipeline
+	rowByRow            bool              // set once ReadOne reads rows from the column buffers instead of the pipeline
+	pending             []InventoryObject // rows of the current row group not returned yet
+	logger              logging.Logger
+	bytesRead           *byteCounter
+	size                int64
+	allColumns          bool  // whether all columns of the file are decoded
+	rowsScanned         int64 // rows decoded since the file was opened or rewound, regardless of filters
+	rowGroupStats       []RowGroupStatistics
+	buffers             objectBuffers
+	metrics             *fileReadMetrics
+	removePath          string
+}
+
+// parquetRowGroupPipeline reads the row groups of a parquet file in parallel, delivering them in their file order.
+type parquetRowGroupPipeline struct {
+	results chan chan parquetRowGroupResult // one channel per row group, in file order
+	done    chan struct{}
+	wg      sync.WaitGroup
+}
+
+type parquetRowGroupResult struct {
+	rows []InventoryObject
+	err  error
+}
+
+const parquetInt64Size = 8
+
+// parquetRawSizeRow is a row of a parquet file with a size column of another type than INT64. The size is decoded into
+// the field of its type, and converted by objectSize.
+type parquetRawSizeRow struct {
+	InventoryObject
+	SizeInt32  *int32
+	SizeFloat  *float32
+	SizeDouble *float64
+	SizeString *string
+}
+
+func (r *parquetRawSizeRow) rawSize() interface{} {
+	switch {
+	case r.SizeInt32 != nil:
+		return *r.SizeInt32
+	case r.SizeFloat != nil:
+		return *r.SizeFloat
+	case r.SizeDouble != nil:
+		return *r.SizeDouble
+	case r.SizeString != nil:
+		return *r.SizeString
+	default:
+		return nil
+	}
+}
+
+// RowGroupStatistics is the range of the keys and of the last modified times of the objects of a parquet row group,
+// according to the statistics of its columns in the file footer.
+type RowGroupStatistics struct {
+	NumRows int64
+	// MinKey and MaxKey are empty if the statistics of the key column are missing.
+	MinKey string
+	MaxKey string
+	// MinLastModifiedMillis and MaxLastModifiedMillis are nil if the statistics of the last modified column are missing.
+	MinLastModifiedMillis *int64
+	MaxLastModifiedMillis *int64
+}
+
+// filterParquetRowGroups removes from the footer the row groups which cannot contain objects matching filter,
+// according to the statistics of their key and last modified columns, looked up in the file according to layout.
+// It returns the number of row groups removed, and the number of rows of the row groups left. The number of rows of
+// the footer is left as is, so that the file is still counted in full.
+func filterParquetRowGroups(footer *parquet.FileMetaData, filter *objectFilter, layout fileLayout) (int, int64) {
+	rowGroups := footer.RowGroups[:0]
+	var numRows int64
+	for _, rowGroup := range footer.RowGroups {
+		stats := parquetRowGroupStatistics(rowGroup, layout.fileColumnName("key"), layout.fileColumnName("last_modified_date"))
+		if !parquetRowGroupMayMatch(stats, filter) {
+			continue
+		}
+		rowGroups = append(rowGroups, rowGroup)
+		numRows += rowGroup.GetNumRows()
+	}
+	skipped := len(footer.RowGroups) - len(rowGroups)
+	footer.RowGroups = rowGroups
+	return skipped, numRows
+}
+
+// parquetDataSize returns the compressed size of all column chunks of the file described by footer.
+func parquetDataSize(footer *parquet.FileMetaData) int64 {
+	var size int64
+	for _, rowGroup := range footer.RowGroups {
+		for _, column := range rowGroup.GetColumns() {
+			size += column.GetMetaData().GetTotalCompressedSize()
+		}
+	}
+	return size
+}
+
+func parquetRowGroupMayMatch(stats RowGroupStatistics, filter *objectFilter) bool {
+	if filter.keyPrefix != "" && stats.MaxKey != "" &&
+		!keyRangeMayHavePrefix(stats.MinKey, stats.MaxKey, filter.keyPrefix) {
+		return false
+	}
+	if filter.hasModifiedRange() && stats.MinLastModifiedMillis != nil &&
+		!filter.modifiedRangeMayMatch(*stats.MinLastModifiedMillis, *stats.MaxLastModifiedMillis) {
+		return false
+	}
+	return true
+}
+
+// parquetFooterStatistics returns the statistics of the row groups of footer, in their file order, looking the key and
+// last modified columns up according to layout.
+func parquetFooterStatistics(footer *parquet.FileMetaData, layout fileLayout) []RowGroupStatistics {
+	res := make([]RowGroupStatistics, 0, len(footer.RowGroups))
+	for _, rowGroup := range footer.RowGroups {
+		res = append(res, parquetRowGroupStatistics(rowGroup, layout.fileColumnName("key"), layout.fileColumnName("last_modified_date")))
+	}
+	return res
+}
+
+// parquetRowGroupStatistics returns the statistics of the row group for the given key and last modified columns.
+func parquetRowGroupStatistics(rowGroup *parquet.RowGroup, keyColumn string, lastModifiedColumn string) RowGroupStatistics {
+	stats := RowGroupStatistics{NumRows: rowGroup.GetNumRows()}
+	if minKey, maxKey, ok := parquetColumnStatistics(rowGroup, keyColumn); ok {
+		stats.MinKey, stats.MaxKey = string(minKey), string(maxKey)
+	}
+	minValue, maxValue, ok := parquetColumnStatistics(rowGroup, lastModifiedColumn)
+	if ok && len(minValue) == parquetInt64Size && len(maxValue) == parquetInt64Size {
+		minMillis := int64(binary.LittleEndian.Uint64(minValue))
+		maxMillis := int64(binary.LittleEndian.Uint64(maxValue))
+		stats.MinLastModifiedMillis, stats.MaxLastModifiedMillis = &minMillis, &maxMillis
+	}
+	return stats
+}
+
+// parquetColumnStatistics returns the minimal and maximal values of the given column in the row group, in their plain
+// encoding, if the statistics are available.
+func parquetColumnStatistics(rowGroup *parquet.RowGroup, name string) ([]byte, []byte, bool) {
+	for _, column := range rowGroup.GetColumns() {
+		path := column.GetMetaData().GetPathInSchema()
+		if len(path) != 1 || !strings.EqualFold(path[0], name) {
+			continue
+		}
+		stats := column.GetMetaData().GetStatistics()
+		minValue, maxValue := stats.GetMinValue(), stats.GetMaxValue()
+		if minValue == nil || maxValue == nil {
+			minValue, maxValue = stats.GetMin(), stats.GetMax()
+		}
+		if minValue == nil || maxValue == nil {
+			return nil, nil, false
+		}
+		return minValue, maxValue, true
+	}
+	return nil, nil, false
+}
+
+func (p *ParquetInventoryFileReader) Read(dstInterface interface{}) (err error) {
+	start := time.Now()
+	defer func() {
+		p.metrics.observeRead(start, dstInterface, err)
+	}()
+	if p.rowGroupConcurrency > 0 && !p.rowByRow {
+		return p.readConcurrently(dstInterface)
+	}
+	num := reflect.ValueOf(dstInterface).Elem().Len()
+	res := p.buffers.get(num)
+	for len(res) < num && p.remainingRows > 0 {
+		batchSize := int64(num - len(res))
+		if batchSize > p.remainingRows {
+			batchSize = p.remainingRows
+		}
+		batch, err := p.readRows(&p.ParquetReader, batchSize)
+		if err != nil {
+			return err
+		}
+		p.remainingRows -= batchSize
+		p.rowsScanned += batchSize
+		if p.bucket != "" {
+			for i := range batch {
+				batch[i].Bucket = p.bucket
+			}
+		}
+		res = appendMatching(res, batch, &p.filter)
+	}
+	reflect.ValueOf(dstInterface).Elem().Set(reflect.ValueOf(res))
+	if len(res) == 0 && num > 0 {
+		return ErrNoMoreRows
+	}
+	return nil
+}
+
+// ReadOne reads the next row of the file matching the filters of the reader. Rows are decoded one at a time from the
+// column buffers, holding no more than the current page of every column in memory, even if row groups are read
+// concurrently: the row group pipeline is not started, and the following calls to Read read serially too until the
+// reader is rewound. If Read already started the pipeline, rows are taken from it, holding up to the row groups it
+// reads in parallel in memory.
+func (p *ParquetInventoryFileReader) ReadOne() (InventoryObject, bool, error) {
+	if p.rowGroups == nil {
+		p.rowByRow = true
+	}
+	row := make([]InventoryObject, 1)
+	err := p.Read(&row)
+	if errors.Is(err, ErrNoMoreRows) {
+		return InventoryObject{}, false, nil
+	}
+	if err != nil {
+		return InventoryObject{}, false, err
+	}
+	if len(row) == 0 {
+		return InventoryObject{}, false, nil
+	}
+	return row[0], true, nil
+}
+
+// readConcurrently reads the rows of the row groups read in parallel by the pipeline, in their file order.
+func (p *ParquetInventoryFileReader) readConcurrently(dstInterface interface{}) error {
+	if p.rowGroups == nil {
+		p.rowGroups = p.startRowGroupPipeline()
+	}
+	num := reflect.ValueOf(dstInterface).Elem().Len()
+	res := p.buffers.get(num)
+	for len(res) < num {
+		if len(p.pending) == 0 {
+			resultCh, ok := <-p.rowGroups.results
+			if !ok {
+				break
+			}
+			result := <-resultCh
+			if result.err != nil {
+				return result.err
+			}
+			p.pending = result.rows
+			p.rowsScanned += int64(len(result.rows))
+			continue
+		}
+		n := num - len(res)
+		if n > len(p.pending) {
+			n = len(p.pending)
+		}
+		res = appendMatching(res, p.pending[:n], &p.filter)
+		p.pending = p.pending[n:]
+	}
+	reflect.ValueOf(dstInterface).Elem().Set(reflect.ValueOf(res))
+	if len(res) == 0 && num > 0 {
+		return ErrNoMoreRows
+	}
+	return nil
+}
+
+func (p *ParquetInventoryFileReader) startRowGroupPipeline() *parquetRowGroupPipeline {
+	pipeline := &parquetRowGroupPipeline{
+		// the row group being consumed is not buffered
+		results: make(chan chan parquetRowGroupResult, p.rowGroupConcurrency-1),
+		done:    make(chan struct{}),
+	}
+	pipeline.wg.Add(1)
+	go func() {
+		defer pipeline.wg.Done()
+		defer close(pipeline.results)
+		for _, rowGroup := range p.Footer.RowGroups {
+			resultCh := make(chan parquetRowGroupResult, 1)
+			// blocks once rowGroupConcurrency row groups are being read
+			select {
+			case pipeline.results <- resultCh:
+			case <-pipeline.done:
+				return
+			}
+			pipeline.wg.Add(1)
+			go func(rowGroup *parquet.RowGroup) {
+				defer pipeline.wg.Done()
+				rows, err := p.readRowGroup(rowGroup)
+				resultCh <- parquetRowGroupResult{rows: rows, err: err}
+			}(rowGroup)
+		}
+	}()
+	return pipeline
+}
+
+func (pipeline *parquetRowGroupPipeline) stop() {
+	close(pipeline.done)
+	// drain the row groups already dispatched, so that the dispatcher is not blocked
+	for range pipeline.results {
+	}
+	pipeline.wg.Wait()
+}
+
+// readRowGroup reads all the rows of a single row group, with a file handle and column buffers of its own, so that
+// row groups read in parallel never share a handle.
+func (p *ParquetInventoryFileReader) readRowGroup(rowGroup *parquet.RowGroup) ([]InventoryObject, error) {
+	pf, err := p.PFile.Open("")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open parquet row group: %w", err)
+	}
+	defer func() {
+		_ = pf.Close()
+	}()
+	footer := *p.Footer
+	footer.RowGroups = []*parquet.RowGroup{rowGroup}
+	footer.NumRows = rowGroup.GetNumRows()
+	columnBuffers, err := p.newColumnBuffers(pf, &footer)
+	if err != nil {
+		return nil, err
+	}
+	pr := &reader.ParquetReader{
+		NP:            1,
+		PFile:         pf,
+		Footer:        &footer,
+		SchemaHandler: p.SchemaHandler,
+		ColumnBuffers: columnBuffers,
+	}
+	defer pr.ReadStop()
+	rows, err := p.readRows(pr, rowGroup.GetNumRows())
+	if err != nil {
+		return nil, err
+	}
+	if p.bucket != "" {
+		for i := range rows {
+			rows[i].Bucket = p.bucket
+		}
+	}
+	return rows, nil
+}
+
+// readRows reads the next num rows of the file with pr. Sizes stored in a column of another type than INT64 are
+// decoded into parquetRawSizeRow first, and fail with ErrIndexMalformed if they are not numeric.
+func (p *ParquetInventoryFileReader) readRows(pr *reader.ParquetReader, num int64) ([]InventoryObject, error) {
+	rows := make([]InventoryObject, num)
+	if !p.rawSize {
+		if err := pr.Read(&rows); err != nil {
+			return nil, err
+		}
+		return rows, nil
+	}
+	rawRows := make([]parquetRawSizeRow, num)
+	if err := pr.Read(&rawRows); err != nil {
+		return nil, err
+	}
+	for i := range rawRows {
+		rows[i] = rawRows[i].InventoryObject
+		value := rawRows[i].rawSize()
+		if value == nil {
+			continue
+		}
+		size, err := objectSize(value)
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %w", rows[i].Key, err)
+		}
+		rows[i].Size = &size
+	}
+	return rows, nil
+}
+
+// newColumnBuffers creates the column buffers for reading the row groups of footer from pf, the way
+// SetSchemaHandlerFromJSON does, without renaming the footer schema again.
+func (p *ParquetInventoryFileReader) newColumnBuffers(pf source.ParquetFile, footer *parquet.FileMetaData) (map[string]*reader.ColumnBufferType, error) {
+	columnBuffers := make(map[string]*reader.ColumnBufferType)
+	for i, element := range p.SchemaHandler.SchemaElements {
+		if element.GetNumChildren() != 0 {
+			continue
+		}
+		pathStr := p.SchemaHandler.IndexMap[int32(i)]
+		columnBuffer, err := reader.NewColumnBuffer(pf, footer, p.SchemaHandler, pathStr)
+		if err != nil {
+			return nil, err
+		}
+		columnBuffers[pathStr] = columnBuffer
+	}
+	return columnBuffers, nil
+}
+
+func (p *ParquetInventoryFileReader) stopRowGroupPipeline() {
+	if p.rowGroups != nil {
+		p.rowGroups.stop()
+		p.rowGroups = nil
+	}
+	p.pending = nil
+}
+
+func (p *ParquetInventoryFileReader) Rewind() error {
+	p.stopRowGroupPipeline()
+	p.ReadStop()
+	columnBuffers, err := p.newColumnBuffers(p.PFile, p.Footer)
+	if err != nil {
+		return err
+	}
+	p.ColumnBuffers = columnBuffers
+	p.remainingRows = p.filteredRows
+	p.rowsScanned = 0
+	p.rowByRow = false
+	return nil
+}
+
+// BytesRead returns the number of bytes read from the file so far, including its footer.
+func (p *ParquetInventoryFileReader) BytesRead() int64 {
+	return p.bytesRead.load()
+}
+
+// readInFull returns true if all rows of the file were decoded, with all of its columns.
+func (p *ParquetInventoryFileReader) readInFull() bool {
+	return p.allColumns && p.skippedRowGroups == 0 && p.rowsScanned > 0 && p.rowsScanned >= p.GetNumRows()
 }
 
 func (p *ParquetInventoryFileReader) Close() error {
+	if p.readInFull() {
+		logger := p.logger
+		if logger == nil {
+			logger = logging.Default()
+		}
+		warnOnPartialRead(logger, p.BytesRead(), p.size)
+	}
+	p.stopRowGroupPipeline()
 	p.ReadStop()
-	return p.PFile.Close()
+	p.buffers.release()
+	return removeClosedFile(p.removePath, p.PFile.Close())
+}
+
+// RowGroupStatistics returns the statistics of all the row groups of the file, in their file order, including the row
+// groups skipped by the filters of the reader, which are only skipped when reading.
+func (p *ParquetInventoryFileReader) RowGroupStatistics() []RowGroupStatistics {
+	return append([]RowGroupStatistics(nil), p.rowGroupStats...)
 }
 
+// FirstObjectKey returns the minimal key of the first row group, according to its statistics. Files are sorted by key,
+// so it is the first key of the file, whether or not the row group is skipped by the filters of the reader. It returns
+// an empty string if the statistics are missing.
 func (p *ParquetInventoryFileReader) FirstObjectKey() string {
-	return string(p.Footer.RowGroups[0].Columns[0].GetMetaData().GetStatistics().GetMinValue())
+	if len(p.rowGroupStats) == 0 {
+		return ""
+	}
+	return p.rowGroupStats[0].MinKey
 }
 
+// LastObjectKey returns the maximal key of the last row group, according to its statistics, or an empty string if the
+// statistics are missing.
 func (p *ParquetInventoryFileReader) LastObjectKey() string {
-	return string(p.Footer.RowGroups[0].Columns[0].GetMetaData().GetStatistics().GetMaxValue())
+	if len(p.rowGroupStats) == 0 {
+		return ""
+	}
+	return p.rowGroupStats[len(p.rowGroupStats)-1].MaxKey
 }
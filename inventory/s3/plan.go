@@ -0,0 +1,134 @@
+package s3
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	s3parquet "github.com/xitongsys/parquet-go-source/s3"
+)
+
+// ImportPlan describes the inventory files a reader would read, and how much of them, without reading their objects.
+type ImportPlan struct {
+	Files []PlannedFile
+	// EstimatedRows is the sum of the estimated rows of the files with statistics
+	EstimatedRows int64
+	// EstimatedBytes is the sum of the sizes of the files
+	EstimatedBytes int64
+}
+
+// PlannedFile is an inventory file which may contain objects matching the filters of the reader.
+type PlannedFile struct {
+	Key string
+	// EstimatedRows is the number of rows in the parts of the file which may contain matching objects, according to
+	// its statistics, or -1 for formats with no statistics
+	EstimatedRows int64
+	Size          int64
+}
+
+// Plan returns the inventory files among fileKeys which may contain objects matching the filters of the reader,
+// according to the statistics of ORC and Parquet files. Only the sizes of the files and their footers are read, with
+// HEAD and ranged requests. Files of formats with no statistics, such as CSV, are always planned.
+func (o *Reader) Plan(ctx context.Context, format string, bucket string, fileKeys []string) (ImportPlan, error) {
+	var plan ImportPlan
+	for _, key := range fileKeys {
+		if err := ctx.Err(); err != nil {
+			return ImportPlan{}, err
+		}
+		fileBucket, fileKey := FileLocation(bucket, key)
+		rows, err := o.estimateRows(format, fileBucket, fileKey)
+		if err != nil {
+			return ImportPlan{}, fmt.Errorf("failed to plan s3://%s/%s: %w", fileBucket, fileKey, err)
+		}
+		if rows == 0 {
+			continue
+		}
+		size, err := o.fileSize(ctx, fileBucket, fileKey)
+		if err != nil {
+			return ImportPlan{}, fmt.Errorf("failed to plan s3://%s/%s: %w", fileBucket, fileKey, err)
+		}
+		plan.Files = append(plan.Files, PlannedFile{Key: key, EstimatedRows: rows, Size: size})
+		if rows > 0 {
+			plan.EstimatedRows += rows
+		}
+		plan.EstimatedBytes += size
+	}
+	return plan, nil
+}
+
+// estimateRows returns the number of rows of the given file which may match the filters of the reader, according to
+// its statistics, or -1 if its format has none.
+func (o *Reader) estimateRows(format string, bucket string, key string) (int64, error) {
+	switch format {
+	case OrcFormatName:
+		return o.estimateOrcRows(bucket, key)
+	case ParquetFormatName:
+		return o.estimateParquetRows(bucket, key)
+	case CSVFormatName, AvroFormatName, ApacheAvroFormatName:
+		return -1, nil
+	default:
+		return 0, ErrUnsupportedInventoryFormat
+	}
+}
+
+func (o *Reader) estimateOrcRows(bucket string, key string) (int64, error) {
+	fileReader, err := o.getOrcReader(bucket, key, true, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		_ = fileReader.Close()
+	}()
+	r := fileReader.(*OrcInventoryFileReader)
+	stripeStats := r.reader.Metadata().GetStripeStats()
+	if !r.filter.pushdown(OrcFormatName) || len(stripeStats) == 0 {
+		return r.GetNumRows(), nil
+	}
+	var rows int64
+	for stripe, stats := range stripeStats {
+		colStats := stats.GetColStats()
+		if len(colStats) == 0 {
+			// unknown number of rows in the stripe
+			return r.GetNumRows(), nil
+		}
+		if r.stripeMayHavePrefix(stripe) {
+			rows += int64(colStats[0].GetNumberOfValues())
+		}
+	}
+	return rows, nil
+}
+
+func (o *Reader) estimateParquetRows(bucket string, key string) (int64, error) {
+	pf, err := s3parquet.NewS3FileReaderWithClient(o.ctx, o.svc, bucket, key)
+	if err != nil {
+		return 0, downloadError(fmt.Errorf("failed to create parquet file reader: %w", err))
+	}
+	defer func() {
+		_ = pf.Close()
+	}()
+	footer, err := readParquetFooter(pf)
+	if err != nil {
+		return 0, parquetError(fmt.Errorf("failed to read parquet footer: %w", err))
+	}
+	if o.filter.pushdown(ParquetFormatName) {
+		_, rows := filterParquetRowGroups(footer, &o.filter, fileLayout{})
+		return rows, nil
+	}
+	return footer.GetNumRows(), nil
+}
+
+// fileSize returns the size of the given inventory file, as declared by its manifest or else as reported by S3.
+func (o *Reader) fileSize(ctx context.Context, bucket string, key string) (int64, error) {
+	if size := o.fileChecksum(bucket, key).Size; size > 0 {
+		return size, nil
+	}
+	headObject, err := o.svc.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return 0, downloadError(err)
+	}
+	return aws.Int64Value(headObject.ContentLength), nil
+}
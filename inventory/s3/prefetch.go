@@ -0,0 +1,274 @@
+package s3
+
+import (
+	"context"
+	"os"
+	"sync"
+
+	"github.com/hashicorp/go-multierror"
+	"golang.org/x/sync/errgroup"
+)
+
+// downloadedFile is an inventory file which was downloaded ahead of time to a local file.
+type downloadedFile struct {
+	localFilename string
+	ready         bool
+	refs          int  // number of open readers which remove the file once the last of them is closed
+	cached        bool // set if the file is in the cache dir, in which case it is never removed
+	tempDisk      *diskReservation
+}
+
+func fileCacheKey(bucket string, key string) string {
+	return bucket + "/" + key
+}
+
+// PrefetchAll downloads the given ORC inventory files to local files, using up to concurrency parallel downloads.
+// Subsequent calls to GetFileReader for these files read the local files instead of downloading them again.
+// A prefetched file is removed once the last reader for it is closed.
+// If any download fails, the files downloaded by this call are removed and the first error is returned.
+func (o *Reader) PrefetchAll(ctx context.Context, bucket string, keys []string, concurrency int) error {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	g, ctx := errgroup.WithContext(ctx)
+	keysCh := make(chan string)
+	g.Go(func() error {
+		defer close(keysCh)
+		for _, key := range keys {
+			select {
+			case keysCh <- key:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		return nil
+	})
+	// the files added by this call, which are the only ones removed if it fails
+	var addedMu sync.Mutex
+	added := make(map[string]*downloadedFile)
+	for i := 0; i < concurrency; i++ {
+		g.Go(func() error {
+			for key := range keysCh {
+				cacheKey, file, err := o.prefetch(ctx, bucket, key)
+				if file != nil {
+					addedMu.Lock()
+					added[cacheKey] = file
+					addedMu.Unlock()
+				}
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+	err := g.Wait()
+	if err != nil {
+		for cacheKey, file := range added {
+			o.cleanFile(cacheKey, file)
+		}
+	}
+	return err
+}
+
+// prefetch downloads the given file, unless it was already prefetched. It returns the cache key of the file, and the
+// prefetched file if it was added by this call.
+func (o *Reader) prefetch(ctx context.Context, bucket string, key string) (string, *downloadedFile, error) {
+	bucket, key = FileLocation(bucket, key)
+	cacheKey := fileCacheKey(bucket, key)
+	o.mu.Lock()
+	if _, ok := o.orcFilesByKey[cacheKey]; ok {
+		o.mu.Unlock()
+		return cacheKey, nil, nil
+	}
+	file := &downloadedFile{}
+	o.orcFilesByKey[cacheKey] = file
+	o.mu.Unlock()
+	o.cleanOnDone()
+	return cacheKey, file, o.downloadPrefetched(ctx, bucket, key, cacheKey, file)
+}
+
+// downloadPrefetched downloads the given file to the local copy of the prefetched file.
+func (o *Reader) downloadPrefetched(ctx context.Context, bucket string, key string, cacheKey string, file *downloadedFile) error {
+	if o.cacheDir != "" {
+		filename, err := o.getCached(ctx, bucket, key)
+		if err != nil {
+			return err
+		}
+		o.mu.Lock()
+		file.localFilename = filename
+		file.cached = true
+		file.ready = true
+		o.mu.Unlock()
+		return nil
+	}
+	reservation, err := o.reserveTempDisk(ctx, bucket, key, 0)
+	if err != nil {
+		return err
+	}
+	f, err := o.tempFile(key)
+	if err != nil {
+		reservation.release()
+		return err
+	}
+	o.mu.Lock()
+	file.localFilename = f.Name()
+	file.tempDisk = reservation
+	o.mu.Unlock()
+	err = o.download(ctx, OrcFormatName, f, bucket, key, 0)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	o.mu.Lock()
+	file.ready = err == nil
+	// the reader context may have been done during the download, after its files were removed
+	removed := o.orcFilesByKey[cacheKey] != file
+	o.mu.Unlock()
+	if removed {
+		o.removeLocalFile(file)
+	}
+	return err
+}
+
+// cleanOnDone removes all prefetched files once the context of the reader is done, so that files which were never
+// read, or whose readers were never closed, are not left behind. Readers closed later find nothing to remove.
+func (o *Reader) cleanOnDone() {
+	done := o.ctx.Done()
+	if done == nil {
+		return
+	}
+	o.cleanOnce.Do(func() {
+		go func() {
+			select {
+			case <-done:
+				o.cleanAll()
+			case <-o.closed:
+			}
+		}()
+	})
+}
+
+// cleanAll removes the local copies of all prefetched files, regardless of the readers which acquired them.
+func (o *Reader) cleanAll() {
+	files := o.takeAll()
+	for i := range files {
+		o.removeLocalFile(&files[i])
+	}
+}
+
+// Close removes the local copies of all prefetched files, regardless of the readers which acquired them, and returns
+// the combined errors of failed removals. Open file readers keep reading their files, and closing them removes
+// nothing. The reader may still be used after Close, to read files downloaded again.
+func (o *Reader) Close() error {
+	o.closeOnce.Do(func() {
+		close(o.closed)
+	})
+	var err error
+	files := o.takeAll()
+	for i := range files {
+		if removeErr := files[i].remove(); removeErr != nil {
+			err = multierror.Append(err, removeErr)
+		}
+	}
+	return err
+}
+
+// takeAll forgets all prefetched files, returning copies of them.
+func (o *Reader) takeAll() []downloadedFile {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	files := make([]downloadedFile, 0, len(o.orcFilesByKey))
+	for _, file := range o.orcFilesByKey {
+		files = append(files, *file)
+	}
+	o.orcFilesByKey = make(map[string]*downloadedFile)
+	return files
+}
+
+// getPrefetched opens the local copy of the given file, if it was prefetched.
+// If acquire is set, the local copy is kept until a matching call to release.
+func (o *Reader) getPrefetched(bucket string, key string, acquire bool) (*os.File, bool, error) {
+	if acquire {
+		o.mu.Lock()
+		defer o.mu.Unlock()
+	} else {
+		o.mu.RLock()
+		defer o.mu.RUnlock()
+	}
+	file, ok := o.orcFilesByKey[fileCacheKey(bucket, key)]
+	if !ok || !file.ready {
+		return nil, false, nil
+	}
+	f, err := os.Open(file.localFilename)
+	if err != nil {
+		return nil, false, err
+	}
+	if acquire {
+		file.refs++
+	}
+	return f, true, nil
+}
+
+// release removes the local copy of a prefetched file once no reader acquired it anymore.
+func (o *Reader) release(cacheKey string) {
+	o.mu.Lock()
+	file, ok := o.orcFilesByKey[cacheKey]
+	if !ok {
+		o.mu.Unlock()
+		return
+	}
+	file.refs--
+	if file.refs > 0 {
+		o.mu.Unlock()
+		return
+	}
+	delete(o.orcFilesByKey, cacheKey)
+	o.mu.Unlock()
+	o.removeLocalFile(file)
+}
+
+// cleanFile removes the local copy of the given prefetched file, regardless of the readers which acquired it, unless
+// it was already removed and the file prefetched again.
+func (o *Reader) cleanFile(cacheKey string, file *downloadedFile) {
+	o.mu.Lock()
+	current, ok := o.orcFilesByKey[cacheKey]
+	ok = ok && current == file
+	if ok {
+		delete(o.orcFilesByKey, cacheKey)
+	}
+	o.mu.Unlock()
+	if ok {
+		o.removeLocalFile(file)
+	}
+}
+
+// clean removes the local copy of a prefetched file, regardless of the readers which acquired it.
+func (o *Reader) clean(cacheKey string) {
+	o.mu.Lock()
+	file, ok := o.orcFilesByKey[cacheKey]
+	delete(o.orcFilesByKey, cacheKey)
+	o.mu.Unlock()
+	if ok {
+		o.removeLocalFile(file)
+	}
+}
+
+func (o *Reader) removeLocalFile(file *downloadedFile) {
+	if err := file.remove(); err != nil {
+		o.logger.WithField("local_file", file.localFilename).WithError(err).Error("failed to remove prefetched inventory file")
+	}
+}
+
+// remove removes the local copy of the file, unless it is kept in the cache dir, freeing its room in the temp disk
+// budget of the reader.
+func (file *downloadedFile) remove() error {
+	if file.localFilename == "" || file.cached {
+		return nil
+	}
+	err := os.Remove(file.localFilename)
+	file.tempDisk.release()
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
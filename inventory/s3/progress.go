@@ -0,0 +1,81 @@
+package s3
+
+import (
+	"io"
+	"sync"
+)
+
+// downloadProgressInterval is the number of bytes downloaded between calls to the download progress callback.
+const downloadProgressInterval = 4 << 20
+
+// DownloadProgress is called with the number of bytes downloaded so far of an inventory file, out of totalBytes.
+// totalBytes is the size declared by the manifest if known, or else the size reported by S3, or -1 if not known yet.
+type DownloadProgress func(key string, bytesDownloaded int64, totalBytes int64)
+
+// WithDownloadProgress sets a callback reporting the progress of inventory file downloads. It is called every few MB
+// downloaded and once when the download completes. Calls for the same file are serialized, with increasing byte counts,
+// except that the count starts over when a failed download is retried.
+func WithDownloadProgress(progress DownloadProgress) ReaderOption {
+	return func(o *Reader) {
+		o.progress = progress
+	}
+}
+
+// progressWriter reports the bytes written to the wrapped writer by the downloader, which writes parts concurrently.
+type progressWriter struct {
+	io.WriterAt
+	key      string
+	total    func() int64
+	interval int64
+	progress DownloadProgress
+	mu       sync.Mutex
+	written  int64
+	reported int64
+}
+
+func (w *progressWriter) WriteAt(p []byte, off int64) (int, error) {
+	n, err := w.WriterAt.WriteAt(p, off)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.written += int64(n)
+	if w.written-w.reported >= w.interval {
+		w.reported = w.written
+		w.progress(w.key, w.written, w.total())
+	}
+	return n, err
+}
+
+// done reports the completion of a download of n bytes.
+func (w *progressWriter) done(n int64) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.reported = n
+	w.progress(w.key, n, w.total())
+}
+
+// progressWriter wraps w to report the progress of downloading the given object from the given byte, if the reader has a
+// progress callback. Otherwise, it returns nil.
+func (o *Reader) progressWriter(w io.WriterAt, bucket string, key string, fromByte int64, sizes *objectSizeClient) *progressWriter {
+	if o.progress == nil {
+		return nil
+	}
+	if fromByte < 0 {
+		fromByte = 0
+	}
+	return &progressWriter{
+		WriterAt: w,
+		key:      key,
+		total: func() int64 {
+			size := o.fileChecksum(bucket, key).Size
+			if size == 0 {
+				size = sizes.objectSize()
+			}
+			if size < 0 {
+				return size
+			}
+			return size - fromByte
+		},
+		interval: o.progressInterval,
+		progress: o.progress,
+	}
+}
@@ -2,48 +2,297 @@ package s3
 
 import (
 	"context"
+	"encoding/binary"
 	"errors"
 	"fmt"
+	"hash/fnv"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/scritchley/orc"
 	"github.com/treeverse/lakefs/logging"
 	s3parquet "github.com/xitongsys/parquet-go-source/s3"
 	"github.com/xitongsys/parquet-go/reader"
+	"github.com/xitongsys/parquet-go/source"
 )
 
 const (
 	OrcFormatName     = "ORC"
 	ParquetFormatName = "Parquet"
+	CSVFormatName     = "CSV"
+	// AvroFormatName and ApacheAvroFormatName are both used by tools producing Avro inventories
+	AvroFormatName       = "Avro"
+	ApacheAvroFormatName = "Apache Avro"
+
+	DefaultDownloadRetries    = 3
+	DefaultDownloadBackoff    = 200 * time.Millisecond
+	DefaultParquetConcurrency = 4
+	DefaultStripeLogInterval  = 100
+	DefaultOrcOpenRetries     = 2
+	DefaultOrcOpenBackoff     = 50 * time.Millisecond
 )
 
 var (
-	ErrUnsupportedInventoryFormat = errors.New("unsupported inventory type. supported types: parquet, orc")
+	ErrUnsupportedInventoryFormat = errors.New("unsupported inventory type. supported types: parquet, orc, csv, avro")
+	ErrInvalidTempDir             = errors.New("temp dir for inventory files does not exist or is not writable")
+	ErrInvalidCacheDir            = errors.New("cache dir for inventory files does not exist or is not writable")
+	ErrMissingInventoryFormat     = errors.New("inventory manifest has no fileFormat. make sure the inventory was generated by a configuration with an output format")
+	// ErrNoMoreRows is returned by Read once the file is exhausted. It matches io.EOF.
+	ErrNoMoreRows = fmt.Errorf("%w: no more rows in inventory file", io.EOF)
+	// ErrRowsNotCounted is returned by EstimatedTotalRows along with the rows of the files it counted, naming the files
+	// whose rows it could not count.
+	ErrRowsNotCounted = errors.New("rows of inventory files not counted")
 )
 
+var formatNames = []string{OrcFormatName, ParquetFormatName, CSVFormatName, AvroFormatName, ApacheAvroFormatName}
+
+// NormalizeFormat returns the format name as used by the readers for the given manifest fileFormat, matched
+// case-insensitively and ignoring surrounding whitespace. Unknown formats are returned trimmed.
+func NormalizeFormat(format string) string {
+	format = strings.TrimSpace(format)
+	for _, name := range formatNames {
+		if strings.EqualFold(format, name) {
+			return name
+		}
+	}
+	return format
+}
+
+const s3URIPrefix = "s3://"
+
+// FileLocation returns the bucket and key of an inventory file listed with the given key by a manifest whose files are
+// stored in bucket. Files are usually listed by their key in that bucket, but some manifests list them by absolute
+// s3://bucket/key URIs, which are read from the bucket they name.
+func FileLocation(bucket string, key string) (string, string) {
+	if !strings.HasPrefix(key, s3URIPrefix) {
+		return bucket, key
+	}
+	location := strings.TrimPrefix(key, s3URIPrefix)
+	i := strings.IndexByte(location, '/')
+	if i <= 0 {
+		return bucket, key
+	}
+	return location[:i], location[i+1:]
+}
+
+// IReader opens inventory files of a given format.
+// schema is the fileSchema declared in the inventory manifest. It is required for CSV files, which carry no
+// schema of their own, and ignored for self-describing formats.
 type IReader interface {
-	GetFileReader(format string, bucket string, key string) (FileReader, error)
-	GetMetadataReader(format string, bucket string, key string) (MetadataReader, error)
+	GetFileReader(format string, schema string, bucket string, key string) (FileReader, error)
+	GetMetadataReader(format string, schema string, bucket string, key string) (MetadataReader, error)
 }
 
 type InventoryObject struct {
-	Bucket             string  `parquet:"name=bucket, type=UTF8"`
-	Key                string  `parquet:"name=key, type=UTF8"`
-	IsLatest           *bool   `parquet:"name=is_latest, type=BOOLEAN"`
-	IsDeleteMarker     *bool   `parquet:"name=is_delete_marker, type=BOOLEAN"`
-	Size               *int64  `parquet:"name=size, type=INT_64"`
-	LastModifiedMillis *int64  `parquet:"name=last_modified_date, type=TIMESTAMP_MILLIS"`
-	Checksum           *string `parquet:"name=e_tag, type=UTF8"`
+	Bucket             string  `parquet:"name=bucket, type=UTF8" json:"bucket"`
+	Key                string  `parquet:"name=key, type=UTF8" json:"key"`
+	IsLatest           *bool   `parquet:"name=is_latest, type=BOOLEAN" json:"is_latest,omitempty"`
+	IsDeleteMarker     *bool   `parquet:"name=is_delete_marker, type=BOOLEAN" json:"is_delete_marker,omitempty"`
+	Size               *int64  `parquet:"name=size, type=INT_64" json:"size,omitempty"`
+	LastModifiedMillis *int64  `parquet:"name=last_modified_date, type=TIMESTAMP_MILLIS" json:"last_modified_date,omitempty"`
+	Checksum           *string `parquet:"name=e_tag, type=UTF8" json:"e_tag,omitempty"`
+	// VersionID is set by inventories of versioned buckets, which list a row for each version of an object.
+	VersionID *string `parquet:"name=version_id, type=UTF8" json:"version_id,omitempty"`
+	// RawKey is the key as stored in inventory files which URL-encode keys, such as CSV files. Key is always decoded,
+	// so it is the same for all formats. RawKey is empty for formats storing keys as is.
+	RawKey string `json:"raw_key,omitempty"`
+	// StorageClass and IsMultipartUploaded are read from inventories which include their columns, and are left zero
+	// otherwise.
+	StorageClass        string `parquet:"name=storage_class, type=UTF8, repetitiontype=OPTIONAL" json:"storage_class,omitempty"`
+	IsMultipartUploaded bool   `parquet:"name=is_multipart_uploaded, type=BOOLEAN, repetitiontype=OPTIONAL" json:"is_multipart_uploaded,omitempty"`
 }
 
 func (o *InventoryObject) GetPhysicalAddress() string {
 	return "s3://" + o.Bucket + "/" + o.Key
 }
 
+// Equal returns true if o and other have the same key, size, last modified time and ETag. A missing size, time or ETag
+// is only equal to a missing one. The bucket, version and flags of the objects are not compared.
+func (o InventoryObject) Equal(other InventoryObject) bool {
+	return o.Key == other.Key &&
+		equalInt64Ptr(o.Size, other.Size) &&
+		equalInt64Ptr(o.LastModifiedMillis, other.LastModifiedMillis) &&
+		((o.Checksum == nil && other.Checksum == nil) || (o.Checksum != nil && other.Checksum != nil && *o.Checksum == *other.Checksum))
+}
+
+func equalInt64Ptr(a, b *int64) bool {
+	return (a == nil && b == nil) || (a != nil && b != nil && *a == *b)
+}
+
+// Hash returns an FNV-1a hash of the fields compared by Equal, so that equal objects have the same hash. It is stable
+// across processes and versions.
+func (o InventoryObject) Hash() uint64 {
+	h := fnv.New64a()
+	var buf [binary.MaxVarintLen64]byte
+	writeString := func(s string) {
+		n := binary.PutUvarint(buf[:], uint64(len(s)))
+		_, _ = h.Write(buf[:n])
+		_, _ = h.Write([]byte(s))
+	}
+	writeInt64Ptr := func(v *int64) {
+		if v == nil {
+			_, _ = h.Write([]byte{0})
+			return
+		}
+		_, _ = h.Write([]byte{1})
+		n := binary.PutVarint(buf[:], *v)
+		_, _ = h.Write(buf[:n])
+	}
+	writeString(o.Key)
+	writeInt64Ptr(o.Size)
+	writeInt64Ptr(o.LastModifiedMillis)
+	if o.Checksum == nil {
+		_, _ = h.Write([]byte{0})
+	} else {
+		_, _ = h.Write([]byte{1})
+		writeString(*o.Checksum)
+	}
+	return h.Sum64()
+}
+
+// Reader reads inventory files from S3. It is safe for concurrent use by multiple goroutines, which may open, prefetch
+// and close files of the same or of distinct keys. The file readers it returns are not: each of them is read by a single
+// goroutine at a time.
 type Reader struct {
-	ctx    context.Context
-	svc    s3iface.S3API
-	logger logging.Logger
+	ctx                context.Context
+	svc                s3iface.S3API
+	logger             logging.Logger
+	downloadRetries    int
+	downloadBackoff    time.Duration
+	fileTimeout        time.Duration // limits each download attempt of a file, if set
+	parquetConcurrency int
+	partSize           int64 // the part size of downloads, or 0 for the downloader's default
+	partConcurrency    int   // the number of parts of a download fetched in parallel, or 0 for the downloader's default
+	parallelRowGroups  bool
+	skipCorruptStripes bool
+	stripeLogInterval  int
+	onBadRow           BadRowHandler
+	streamOrc          bool
+	tempDir            string
+	tempFiles          TempFileFactory
+	cacheDir           string
+	filter             objectFilter
+	metrics            *readerMetrics
+	projection         []string
+	mu                 sync.RWMutex // guards orcFilesByKey and bucketChecksums
+	orcFilesByKey      map[string]*downloadedFile
+	cleanOnce          sync.Once // starts removing prefetched files once ctx is done
+	closeOnce          sync.Once
+	closed             chan struct{}
+	checksums          map[string]FileChecksum
+	bucketChecksums    map[string]FileChecksum // by bucket and key, guarded by mu
+	progress           DownloadProgress
+	progressInterval   int64
+	downloadSlots      chan struct{} // limits the concurrent downloads, if set
+	regions            *bucketRegionClient
+	orcOpenRetries     int
+	orcOpenBackoff     time.Duration
+	openOrc            orcOpener // opens local copies of ORC files, replaced by tests
+	pooledBuffers      bool
+	tempDisk           *tempDiskBudget // limits the bytes of downloaded files, if set
+}
+
+// orcOpener opens an ORC file for reading the given columns, or all of them if nil.
+type orcOpener func(ctx context.Context, orcFile orcSource, columns map[string]bool) (*OrcInventoryFileReader, error)
+
+type ReaderOption func(*Reader)
+
+// TempFileFactory creates a new local file for downloading an inventory file, the way ioutil.TempFile does with the
+// given dir and pattern. Downloaded files are read with random access, so they are always files.
+type TempFileFactory func(dir string, pattern string) (*os.File, error)
+
+// WithDownloadRetries sets the number of times a failed download of an inventory file is retried.
+// Only errors which S3 reports as transient, such as throttling, are retried.
+func WithDownloadRetries(retries int) ReaderOption {
+	return func(o *Reader) {
+		o.downloadRetries = retries
+	}
+}
+
+// WithPooledBuffers sets whether the slices of objects returned by Read are taken from a pool shared by all file
+// readers, rather than allocated by every call, to reduce the garbage collected while reading many files. A pooled
+// slice is owned by the file reader and is returned to the pool by its next Read or by Close, after which it holds
+// other objects: callers must copy the objects they retain before reading further.
+func WithPooledBuffers(pooled bool) ReaderOption {
+	return func(o *Reader) {
+		o.pooledBuffers = pooled
+	}
+}
+
+// WithOrcOpenRetries sets the number of times opening a downloaded ORC file is retried when it fails with a transient
+// I/O error, as returned by some networked filesystems right after the file is written. Malformed files are not
+// retried. Negative values are ignored.
+func WithOrcOpenRetries(retries int) ReaderOption {
+	return func(o *Reader) {
+		if retries >= 0 {
+			o.orcOpenRetries = retries
+		}
+	}
+}
+
+// WithPerFileDownloadTimeout limits the time taken by each attempt to download an inventory file, so that a stuck
+// download fails with ErrDownloadTimeout, and is retried, without waiting for the context of the reader. Time spent
+// waiting for a download slot is not counted. A zero timeout, the default, sets no limit.
+func WithPerFileDownloadTimeout(timeout time.Duration) ReaderOption {
+	return func(o *Reader) {
+		if timeout >= 0 {
+			o.fileTimeout = timeout
+		}
+	}
+}
+
+// WithDownloadBackoff sets the base delay before retrying a failed download.
+// The delay doubles with each attempt, and a random jitter is applied to it.
+func WithDownloadBackoff(backoff time.Duration) ReaderOption {
+	return func(o *Reader) {
+		o.downloadBackoff = backoff
+	}
+}
+
+// WithDownloadPartSize sets the size of the parts in which inventory files are downloaded, in bytes.
+// Values lower than s3manager.DefaultDownloadPartSize (5MB) are ignored, keeping it as the part size.
+func WithDownloadPartSize(partSize int64) ReaderOption {
+	return func(o *Reader) {
+		if partSize >= s3manager.DefaultDownloadPartSize {
+			o.partSize = partSize
+		}
+	}
+}
+
+// WithDownloadConcurrency sets the number of parts of an inventory file downloaded in parallel.
+// Values lower than 1 are ignored, keeping the default of s3manager.DefaultDownloadConcurrency.
+func WithDownloadConcurrency(concurrency int) ReaderOption {
+	return func(o *Reader) {
+		if concurrency >= 1 {
+			o.partConcurrency = concurrency
+		}
+	}
+}
+
+// WithParquetConcurrency sets the number of goroutines decoding the columns of a Parquet file in parallel.
+// Values lower than 1 are ignored, keeping the default of DefaultParquetConcurrency.
+func WithParquetConcurrency(concurrency int) ReaderOption {
+	return func(o *Reader) {
+		if concurrency >= 1 {
+			o.parquetConcurrency = concurrency
+		}
+	}
+}
+
+// WithConcurrentParquetRowGroups sets whether the row groups of a Parquet file are read in parallel, up to the
+// parquet concurrency of the reader at a time, rather than one after the other. Rows are still returned in their
+// order in the file, at the cost of holding up to that many row groups in memory.
+func WithConcurrentParquetRowGroups(concurrent bool) ReaderOption {
+	return func(o *Reader) {
+		o.parallelRowGroups = concurrent
+	}
 }
 
 type MetadataReader interface {
@@ -55,61 +304,496 @@ type MetadataReader interface {
 
 type FileReader interface {
 	MetadataReader
+	// Read reads the next objects of the file into the slice pointed to by dstInterface, up to its length, and sets it
+	// to the objects read. A final batch may be shorter. Once the file is exhausted, Read sets it to an empty slice and
+	// returns ErrNoMoreRows.
 	Read(dstInterface interface{}) error
+	// BytesRead returns the number of bytes of the file read so far. Rewinding does not reset it.
+	BytesRead() int64
+	// Rewind moves the reader back to the first row of the file. Local copies of the file are reused rather than downloaded again.
+	Rewind() error
+}
+
+// readBatch reads the next batch of objects of the file into dst, which is left empty once the file is exhausted.
+// Readers which report exhaustion with an empty batch rather than with ErrNoMoreRows are also accepted.
+func readBatch(fileReader FileReader, dst *[]InventoryObject) error {
+	err := fileReader.Read(dst)
+	if errors.Is(err, ErrNoMoreRows) {
+		*dst = (*dst)[:0]
+		return nil
+	}
+	return err
+}
+
+// RowReader is implemented by file readers which can read a single row at a time, keeping the memory used constant
+// regardless of the batch size, at the cost of throughput.
+type RowReader interface {
+	// ReadOne reads the next object of the file. It returns false once the file is exhausted.
+	ReadOne() (InventoryObject, bool, error)
+}
+
+// WithMaxConcurrentDownloads limits the number of inventory files downloaded at once by the reader, across all the
+// files it reads. Downloads beyond the limit wait for a running one to end, or for their context to be cancelled.
+// By default, downloads are not limited. Parquet files are read from S3 in place, so they are not limited either.
+func WithMaxConcurrentDownloads(n int) ReaderOption {
+	return func(o *Reader) {
+		if n > 0 {
+			o.downloadSlots = make(chan struct{}, n)
+		}
+	}
+}
+
+// WithTempDir sets the directory in which inventory files are downloaded.
+// By default, the OS temp dir is used.
+func WithTempDir(dir string) ReaderOption {
+	return func(o *Reader) {
+		o.tempDir = dir
+	}
+}
+
+// WithTempFileFactory sets the function creating the local files to which inventory files are downloaded, e.g. to
+// create them on a memory backed filesystem. By default, ioutil.TempFile is used.
+// Files are created with the dir set by WithTempDir, and are removed by the reader when no longer needed.
+func WithTempFileFactory(factory TempFileFactory) ReaderOption {
+	return func(o *Reader) {
+		o.tempFiles = factory
+	}
+}
+
+// WithKeyPrefix limits the objects read from inventory files to those whose key starts with prefix.
+// Parts of files which cannot contain such keys according to their statistics are skipped without being read.
+func WithKeyPrefix(prefix string) ReaderOption {
+	return func(o *Reader) {
+		o.filter.keyPrefix = prefix
+	}
+}
+
+// WithSkipDeleteMarkers excludes delete markers from the objects read from inventory files of versioned buckets.
+// Files are still counted in full by GetNumRows.
+func WithSkipDeleteMarkers(skip bool) ReaderOption {
+	return func(o *Reader) {
+		o.filter.skipDeleteMarkers = skip
+	}
+}
+
+// WithLatestOnly excludes the noncurrent versions of objects, those with is_latest false, from the objects read from
+// inventory files of versioned buckets, so that only the current state of the bucket is read. Current delete markers
+// are kept, unless excluded by WithSkipDeleteMarkers. Objects with no is_latest value are current.
+// Files are still counted in full by GetNumRows.
+func WithLatestOnly(latest bool) ReaderOption {
+	return func(o *Reader) {
+		o.filter.latestOnly = latest
+	}
+}
+
+// WithSkipMissingSize excludes objects with no size, such as delete markers, from the objects read from inventory files.
+// Files are still counted in full by GetNumRows.
+func WithSkipMissingSize(skip bool) ReaderOption {
+	return func(o *Reader) {
+		o.filter.skipMissingSize = skip
+	}
+}
+
+// WithModifiedAfter limits the objects read from inventory files to those last modified after t.
+// Objects with no last modified time are excluded.
+// Parquet row groups which cannot contain objects in the range, according to their statistics, are skipped without
+// being read. ORC statistics are not used, since ORC writers disagree on the unit of timestamp statistics.
+func WithModifiedAfter(t time.Time) ReaderOption {
+	return func(o *Reader) {
+		o.filter.modifiedAfter = t
+	}
+}
+
+// WithModifiedBefore limits the objects read from inventory files to those last modified before t.
+// As with WithModifiedAfter, objects with no last modified time are excluded.
+func WithModifiedBefore(t time.Time) ReaderOption {
+	return func(o *Reader) {
+		o.filter.modifiedBefore = t
+	}
+}
+
+// WithKeyFilter applies filter to the key of every object read from inventory files, after the other filters of the
+// reader: objects are returned under the key it returns, or excluded if it rejects them. Key prefixes are matched
+// against the keys stored in the files. Files are still counted in full by GetNumRows.
+func WithKeyFilter(filter KeyFilter) ReaderOption {
+	return func(o *Reader) {
+		o.filter.keyFilter = filter
+	}
+}
+
+// WithOnRejectedKey sets a handler called with the key of every object rejected by the filter set WithKeyFilter, so
+// that rejected objects can be reported or counted. It is called by the goroutines reading the files, possibly
+// concurrently when several files are read at once.
+func WithOnRejectedKey(handler func(key string)) ReaderOption {
+	return func(o *Reader) {
+		o.filter.onRejectedKey = handler
+	}
 }
 
-func NewReader(ctx context.Context, svc s3iface.S3API, logger logging.Logger) IReader {
-	return &Reader{ctx: ctx, svc: svc, logger: logger}
+// WithSkipCorruptOrcStripes sets whether ORC stripes which cannot be decoded are logged and skipped, instead of failing
+// the read with ErrCorruptOrcStripe. The objects of a skipped stripe are missing from the objects read, and SkipRows
+// fails on corrupt stripes regardless.
+func WithSkipCorruptOrcStripes(skip bool) ReaderOption {
+	return func(o *Reader) {
+		o.skipCorruptStripes = skip
+	}
 }
 
-func (o *Reader) GetFileReader(format string, bucket string, key string) (FileReader, error) {
+// WithStripeLogInterval sets the number of stripes of an ORC file read between two logs of the start of a stripe,
+// instead of DefaultStripeLogInterval. The first and last stripes of a file are always logged, and an interval of 1
+// logs every stripe. Intervals smaller than 1 are ignored.
+func WithStripeLogInterval(stripes int) ReaderOption {
+	return func(o *Reader) {
+		if stripes >= 1 {
+			o.stripeLogInterval = stripes
+		}
+	}
+}
+
+// BadRowHandler is called with the key of an inventory file, the index of a malformed row in it and the error reading
+// it. Returning true skips the row and continues reading, and returning false fails the read with err.
+type BadRowHandler func(fileKey string, rowIndex int64, err error) bool
+
+// WithOnBadRow sets a handler for rows of ORC inventory files which cannot be converted to objects, such as rows with a
+// null key, failing with ErrMalformedOrcRow. By default, such rows fail the read.
+func WithOnBadRow(handler BadRowHandler) ReaderOption {
+	return func(o *Reader) {
+		o.onBadRow = handler
+	}
+}
+
+// WithMetricsRegisterer instruments inventory downloads and reads with Prometheus metrics registered to reg. Reads are
+// labeled by a hash of the file key, so that the parse throughput of each file can be followed.
+// By default, no metrics are collected. Parquet files are read from S3 in place, so they are never counted as downloads.
+// Readers built with the same reg share their metrics.
+func WithMetricsRegisterer(reg prometheus.Registerer) ReaderOption {
+	return func(o *Reader) {
+		o.metrics = newReaderMetrics(reg)
+	}
+}
+
+// WithProjection limits the columns read from ORC and Parquet inventory files to the given ones, and the key, which is
+// always read. The fields of the other columns are left zero-valued in the objects read.
+func WithProjection(columns ...string) ReaderOption {
+	return func(o *Reader) {
+		o.projection = columns
+	}
+}
+
+// NewReader returns a reader of inventory files using svc. Inventory files in buckets of another region than svc's
+// are read with a client for their region, resolved when S3 first redirects a request for them.
+// All requests, including the downloads of inventory files and the ranged reads of Parquet files, are sent with svc or
+// with the regional clients derived from its config, so that its HTTP client, such as one routed through a proxy,
+// handles all the traffic of the reader.
+// ctx applies to all reads of the reader. A nil ctx is replaced with context.Background().
+func NewReader(ctx context.Context, svc s3iface.S3API, logger logging.Logger, opts ...ReaderOption) *Reader {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	regions := newBucketRegionClient(svc)
+	o := &Reader{
+		ctx:                ctx,
+		svc:                regions,
+		regions:            regions,
+		logger:             logger,
+		downloadRetries:    DefaultDownloadRetries,
+		downloadBackoff:    DefaultDownloadBackoff,
+		parquetConcurrency: DefaultParquetConcurrency,
+		stripeLogInterval:  DefaultStripeLogInterval,
+		tempFiles:          ioutil.TempFile,
+		progressInterval:   downloadProgressInterval,
+		orcFilesByKey:      make(map[string]*downloadedFile),
+		closed:             make(chan struct{}),
+		orcOpenRetries:     DefaultOrcOpenRetries,
+		orcOpenBackoff:     DefaultOrcOpenBackoff,
+		openOrc:            newOrcInventoryFileReader,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+func (o *Reader) GetFileReader(format string, schema string, bucket string, key string) (FileReader, error) {
+	return o.getProjectedFileReader(format, schema, bucket, key, o.projection)
+}
+
+func (o *Reader) getProjectedFileReader(format string, schema string, bucket string, key string, projection []string) (FileReader, error) {
+	bucket, key = FileLocation(bucket, key)
+	fileReader, err := o.getFileReader(format, schema, bucket, key, projection)
+	if err != nil {
+		return nil, err
+	}
+	setReadMetrics(fileReader, o.metrics.forFile(format, key))
+	return fileReader, nil
+}
+
+func (o *Reader) getFileReader(format string, schema string, bucket string, key string, projection []string) (FileReader, error) {
+	columns, err := projectionColumns(projection, o.filter.columns())
+	if err != nil {
+		return nil, err
+	}
 	switch format {
 	case OrcFormatName:
-		return o.getOrcReader(bucket, key, false)
+		return o.getOrcReader(bucket, key, false, columns)
 	case ParquetFormatName:
-		return o.getParquetReader(bucket, key)
+		return o.getParquetReader(bucket, key, columns)
+	case CSVFormatName:
+		return o.getCSVReader(schema, bucket, key)
+	case AvroFormatName, ApacheAvroFormatName:
+		return o.getAvroReader(bucket, key)
 	default:
 		return nil, ErrUnsupportedInventoryFormat
 	}
 }
 
-func (o *Reader) GetMetadataReader(format string, bucket string, key string) (MetadataReader, error) {
+func (o *Reader) GetMetadataReader(format string, schema string, bucket string, key string) (MetadataReader, error) {
+	bucket, key = FileLocation(bucket, key)
 	switch format {
 	case OrcFormatName:
-		return o.getOrcReader(bucket, key, true)
+		return o.getOrcReader(bucket, key, true, nil)
 	default:
-		return o.GetFileReader(format, bucket, key)
+		return o.getFileReader(format, schema, bucket, key, o.projection)
 	}
 }
 
-func (o *Reader) getParquetReader(bucket string, key string) (FileReader, error) {
+func (o *Reader) getParquetReader(bucket string, key string, columns map[string]bool) (FileReader, error) {
 	pf, err := s3parquet.NewS3FileReaderWithClient(o.ctx, o.svc, bucket, key)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create parquet file reader: %w", err)
+		return nil, downloadError(fmt.Errorf("failed to create parquet file reader: %w", err))
+	}
+	parquetReader, err := newParquetInventoryFileReader(pf, o.filter, columns, o.parquetConcurrency, fileLayout{})
+	if err != nil {
+		return nil, parquetError(err)
+	}
+	if o.parallelRowGroups {
+		parquetReader.rowGroupConcurrency = o.parquetConcurrency
+	}
+	parquetReader.logger = o.logger.WithFields(logging.Fields{"bucket": bucket, "key": key})
+	parquetReader.buffers.pooled = o.pooledBuffers
+	return parquetReader, nil
+}
+
+// NewParquetInventoryFileReader returns a reader for the given parquet file, after validating its schema.
+func NewParquetInventoryFileReader(pf source.ParquetFile) (*ParquetInventoryFileReader, error) {
+	return newParquetInventoryFileReader(pf, objectFilter{}, nil, DefaultParquetConcurrency, fileLayout{})
+}
+
+// newParquetInventoryFileReader returns a reader for the given parquet file, reading only the objects matching filter.
+// Row groups which cannot contain such objects, according to their statistics, are never read.
+// If columns is not nil, only the columns it contains are decoded. Up to concurrency columns are decoded in parallel.
+// Columns are looked up in the file according to layout.
+func newParquetInventoryFileReader(pf source.ParquetFile, filter objectFilter, columns map[string]bool, concurrency int, layout fileLayout) (*ParquetInventoryFileReader, error) {
+	bytesRead := &byteCounter{}
+	pf = &countingParquetFile{ParquetFile: pf, counter: bytesRead}
+	footer, err := readParquetFooter(pf)
+	if err != nil {
+		_ = pf.Close()
+		return nil, fmt.Errorf("failed to read parquet footer: %w", err)
 	}
-	var rawObject InventoryObject
-	pr, err := reader.NewParquetReader(pf, &rawObject, 4)
+	schema, rawSize, err := getParquetSchema(footer, columns, layout)
 	if err != nil {
+		_ = pf.Close()
+		return nil, err
+	}
+	size := parquetDataSize(footer)
+	// statistics describe the whole file, and must be read before setting the schema handler renames the columns of
+	// the footer after the fields they are read into
+	rowGroupStats := parquetFooterStatistics(footer, layout)
+	skippedRowGroups := 0
+	filteredRows := footer.GetNumRows()
+	if filter.pushdown(ParquetFormatName) {
+		// only the row groups left in the footer are read
+		skippedRowGroups, filteredRows = filterParquetRowGroups(footer, &filter, layout)
+	}
+	pr := &reader.ParquetReader{
+		NP:            int64(concurrency),
+		PFile:         pf,
+		Footer:        footer,
+		ColumnBuffers: make(map[string]*reader.ColumnBufferType),
+	}
+	if err = pr.SetSchemaHandlerFromJSON(schema); err != nil {
 		return nil, fmt.Errorf("failed to create parquet reader: %w", err)
 	}
-	return &ParquetInventoryFileReader{ParquetReader: *pr}, nil
+	return &ParquetInventoryFileReader{
+		ParquetReader:    *pr,
+		filter:           filter,
+		bucket:           layout.bucket,
+		rawSize:          rawSize,
+		remainingRows:    filteredRows,
+		filteredRows:     filteredRows,
+		skippedRowGroups: skippedRowGroups,
+		bytesRead:        bytesRead,
+		size:             size,
+		allColumns:       parquetLeafCount(footer.GetSchema()) == parquetLeafCount(pr.SchemaHandler.SchemaElements),
+		rowGroupStats:    rowGroupStats,
+	}, nil
 }
 
-func (o *Reader) getOrcReader(bucket string, key string, tailOnly bool) (FileReader, error) {
-	orcFile, err := DownloadOrc(o.ctx, o.svc, o.logger, bucket, key, tailOnly)
+func (o *Reader) getOrcReader(bucket string, key string, tailOnly bool, columns map[string]bool) (FileReader, error) {
+	var orcFile orcSource
+	var cacheKey string
+	var cachedFilename string
+	var reservation *diskReservation
+	// readers of the full file hold the local copy, which is removed once the last of them is closed
+	f, prefetched, err := o.getPrefetched(bucket, key, !tailOnly)
 	if err != nil {
 		return nil, err
 	}
-	orcReader, err := orc.NewReader(orcFile)
+	switch {
+	case prefetched:
+		orcFile = &OrcFile{f}
+		if !tailOnly {
+			cacheKey = fileCacheKey(bucket, key)
+		}
+	case o.cacheDir != "" && !tailOnly:
+		cachedFile, err := o.openCachedOrc(bucket, key)
+		if err != nil {
+			return nil, err
+		}
+		orcFile = cachedFile
+		cachedFilename = cachedFile.Name()
+	case o.streamOrc:
+		orcFile, err = o.openS3OrcSource(bucket, key)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		orcFile, reservation, err = o.downloadOrc(bucket, key, tailOnly)
+		if err != nil {
+			return nil, err
+		}
+	}
+	orcReader, err := o.openOrcReader(orcFile, columns, o.logger.WithFields(logging.Fields{"bucket": bucket, "key": key}))
 	if err != nil {
+		_ = orcFile.Close()
+		reservation.release()
+		if prefetched {
+			// the local copy cannot be read, remove it so that the next attempt downloads the file again
+			o.clean(fileCacheKey(bucket, key))
+		}
+		if cachedFilename != "" {
+			o.removeCached(cachedFilename)
+		}
+		return nil, parseError(err)
+	}
+	orcReader.mgr = o
+	orcReader.cacheKey = cacheKey
+	orcReader.filter = o.filter
+	orcReader.buffers.pooled = o.pooledBuffers
+	orcReader.tempDisk = reservation
+	orcReader.skipCorrupt = o.skipCorruptStripes
+	orcReader.stripeLogEvery = o.stripeLogInterval
+	orcReader.onBadRow = o.onBadRow
+	orcReader.key = key
+	orcReader.manifestFile = o.fileChecksum(bucket, key)
+	orcReader.logger = o.logger.WithFields(logging.Fields{"bucket": bucket, "key": key})
+	return orcReader, nil
+}
+
+// NewOrcInventoryFileReader returns a reader for the given ORC file, after validating its schema.
+func NewOrcInventoryFileReader(ctx context.Context, orcFile *OrcFile) (*OrcInventoryFileReader, error) {
+	return newOrcInventoryFileReader(ctx, orcFile, nil)
+}
+
+// newOrcInventoryFileReader returns a reader for the given ORC file, selecting only the given columns if not nil.
+func newOrcInventoryFileReader(ctx context.Context, orcFile orcSource, columns map[string]bool) (*OrcInventoryFileReader, error) {
+	return newLayoutOrcInventoryFileReader(ctx, orcFile, columns, fileLayout{})
+}
+
+// newLayoutOrcInventoryFileReader is newOrcInventoryFileReader for a file with the given layout.
+func newLayoutOrcInventoryFileReader(ctx context.Context, orcFile orcSource, columns map[string]bool, layout fileLayout) (*OrcInventoryFileReader, error) {
+	bytesRead := &byteCounter{}
+	orcFile = &countingOrcSource{orcSource: orcFile, counter: bytesRead}
+	orcReader, err := newOrcReader(orcFile)
+	if err != nil {
+		return nil, err
+	}
+	if err = validateOrcSchema(orcReader.Schema(), layout); err != nil {
 		return nil, err
 	}
-	orcSelect := getOrcSelect(orcReader.Schema())
+	orcSelect := getOrcSelect(orcReader.Schema(), columns, layout)
 	return &OrcInventoryFileReader{
-		ctx:       o.ctx,
+		ctx:       ctx,
 		reader:    orcReader,
 		orcFile:   orcFile,
 		orcSelect: orcSelect,
 		cursor:    orcReader.Select(orcSelect.SelectFields...),
+		bucket:    layout.bucket,
+		bytesRead: bytesRead,
 	}, nil
 }
+
+// newOrcReader opens the ORC file with the orc library, which panics on some malformed files, such as files
+// shorter than their declared postscript.
+func newOrcReader(orcFile orcSource) (r *orc.Reader, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			r, err = nil, fmt.Errorf("%w: %v", ErrMalformedOrcFile, p)
+		}
+	}()
+	return orc.NewReader(orcFile)
+}
+
+func (o *Reader) getCSVReader(schema string, bucket string, key string) (FileReader, error) {
+	columns, err := parseCSVSchema(schema)
+	if err != nil {
+		return nil, parseError(err)
+	}
+	f, reservation, err := o.downloadRange(o.ctx, CSVFormatName, bucket, key, 0)
+	if err != nil {
+		return nil, err
+	}
+	csvReader, err := NewCSVInventoryFileReader(o.ctx, f, columns)
+	if err != nil {
+		_ = f.Close()
+		reservation.release()
+		return nil, parseError(err)
+	}
+	csvReader.tempDisk = reservation
+	csvReader.filter = o.filter
+	csvReader.buffers.pooled = o.pooledBuffers
+	return csvReader, nil
+}
+
+// EstimatedTotalRows returns the total number of rows in the given inventory files, read from their metadata without
+// reading the rows themselves. It is meant for reporting progress before an inventory is read.
+// CSV files carry no metadata, so their rows are not counted: the total is returned with an error matching
+// ErrRowsNotCounted, naming the files.
+func (o *Reader) EstimatedTotalRows(format string, schema string, bucket string, keys []string) (int64, error) {
+	if format == CSVFormatName {
+		if len(keys) == 0 {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("%w: %s files carry no row count: %s", ErrRowsNotCounted, format, strings.Join(keys, ", "))
+	}
+	var total int64
+	for _, key := range keys {
+		mr, err := o.GetMetadataReader(format, schema, bucket, key)
+		if err != nil {
+			return 0, fmt.Errorf("failed to read metadata of %s: %w", key, err)
+		}
+		total += mr.GetNumRows()
+		if err = mr.Close(); err != nil {
+			return 0, err
+		}
+	}
+	return total, nil
+}
+
+func (o *Reader) getAvroReader(bucket string, key string) (FileReader, error) {
+	f, reservation, err := o.downloadRange(o.ctx, AvroFormatName, bucket, key, 0)
+	if err != nil {
+		return nil, err
+	}
+	avroReader, err := NewAvroInventoryFileReader(o.ctx, f)
+	if err != nil {
+		_ = f.Close()
+		reservation.release()
+		return nil, parseError(err)
+	}
+	avroReader.tempDisk = reservation
+	avroReader.filter = o.filter
+	avroReader.buffers.pooled = o.pooledBuffers
+	return avroReader, nil
+}
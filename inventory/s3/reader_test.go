@@ -1,55 +1,85 @@
 package s3
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"math"
+	"math/big"
+	"math/rand"
+	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"testing"
 	"time"
+	"unicode"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3iface"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 	"github.com/cznic/mathutil"
 	"github.com/go-openapi/swag"
+	"github.com/go-test/deep"
 	"github.com/johannesboyne/gofakes3"
 	"github.com/johannesboyne/gofakes3/backend/s3mem"
+	"github.com/klauspost/compress/zstd"
+	"github.com/linkedin/goavro/v2"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/scritchley/orc"
 	"github.com/treeverse/lakefs/logging"
+	"github.com/xitongsys/parquet-go-source/local"
+	"github.com/xitongsys/parquet-go-source/writerfile"
+	"github.com/xitongsys/parquet-go/source"
+	"github.com/xitongsys/parquet-go/writer"
+	"golang.org/x/sync/errgroup"
 )
 
 const inventoryBucketName = "inventory-bucket"
 
-func generateOrc(t *testing.T, objs <-chan *InventoryObject) string {
+func generateOrc(tb testing.TB, objs <-chan *InventoryObject) string {
 	f, err := ioutil.TempFile("", "orctest")
 	if err != nil {
-		t.Fatal(err)
+		tb.Fatal(err)
 	}
 	defer func() {
 		_ = f.Close()
 	}()
 	schema, err := orc.ParseSchema("struct<bucket:string,key:string,size:int,last_modified_date:timestamp,e_tag:string>")
 	if err != nil {
-		t.Fatal(err)
+		tb.Fatal(err)
 	}
 	w, err := orc.NewWriter(f, orc.SetSchema(schema), orc.SetStripeTargetSize(100))
 	if err != nil {
-		t.Fatal(err)
+		tb.Fatal(err)
 	}
 	for o := range objs {
 		err = w.Write(o.Bucket, o.Key, *o.Size, time.Unix(*o.LastModifiedMillis/1000, 0), *o.Checksum)
 		if err != nil {
-			t.Fatal(err)
+			tb.Fatal(err)
 		}
 	}
 	err = w.Close()
 	if err != nil {
-		t.Fatal(err)
+		tb.Fatal(err)
 	}
 	return f.Name()
 }
@@ -73,6 +103,21 @@ func getS3Fake(t *testing.T) (s3iface.S3API, *httptest.Server) {
 	return s3.New(newSession), ts
 }
 
+// newTestInventoryBucket returns a client of a fake S3 server holding an empty inventory bucket. The server is closed
+// once the test and its subtests are done.
+func newTestInventoryBucket(t *testing.T) s3iface.S3API {
+	t.Helper()
+	svc, testServer := getS3Fake(t)
+	t.Cleanup(testServer.Close)
+	_, err := svc.CreateBucket(&s3.CreateBucketInput{
+		Bucket: aws.String(inventoryBucketName),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return svc
+}
+
 func objs(num int, lastModified []time.Time) <-chan *InventoryObject {
 	out := make(chan *InventoryObject)
 	go func() {
@@ -111,14 +156,7 @@ func uploadFile(t *testing.T, s3 s3iface.S3API, inventoryBucket string, inventor
 }
 
 func TestInventoryReader(t *testing.T) {
-	svc, testServer := getS3Fake(t)
-	defer testServer.Close()
-	_, err := svc.CreateBucket(&s3.CreateBucketInput{
-		Bucket: aws.String(inventoryBucketName),
-	})
-	if err != nil {
-		t.Fatal(err)
-	}
+	svc := newTestInventoryBucket(t)
 	testdata := []struct {
 		ObjectNum           int
 		ExpectedReadObjects int
@@ -150,7 +188,7 @@ func TestInventoryReader(t *testing.T) {
 		lastModified := []time.Time{now, now.Add(-1 * time.Hour), now.Add(-2 * time.Hour), now.Add(-3 * time.Hour)}
 		uploadFile(t, svc, inventoryBucketName, "myFile.orc", objs(test.ObjectNum, lastModified))
 		reader := NewReader(context.Background(), svc, logging.Default())
-		fileReader, err := reader.GetFileReader("ORC", inventoryBucketName, "myFile.orc")
+		fileReader, err := reader.GetFileReader("ORC", "", inventoryBucketName, "myFile.orc")
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -198,3 +236,6135 @@ func TestInventoryReader(t *testing.T) {
 		}
 	}
 }
+
+func uploadCSV(t *testing.T, svc s3iface.S3API, inventoryFilename string, rows []string) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	for _, row := range rows {
+		_, err := w.Write([]byte(row + "\n"))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	uploadBytes(t, svc, inventoryFilename, buf.Bytes())
+}
+
+func uploadBytes(t *testing.T, svc s3iface.S3API, inventoryFilename string, data []byte) {
+	uploader := s3manager.NewUploaderWithClient(svc)
+	_, err := uploader.Upload(&s3manager.UploadInput{
+		Bucket: aws.String(inventoryBucketName),
+		Key:    aws.String(inventoryFilename),
+		Body:   bytes.NewReader(data),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCSVInventoryReader(t *testing.T) {
+	svc := newTestInventoryBucket(t)
+	const schema = "Bucket, Key, Size, LastModifiedDate, ETag"
+	uploadCSV(t, svc, "good.csv.gz", []string{
+		`"my-bucket","f1","100","2020-06-27T00:00:00.000Z","abc"`,
+		`"my-bucket","f2+with+spaces","200","2020-06-27T01:00:00.000Z","def"`,
+		`"my-bucket","f3%2Bplus","300","2020-06-27T02:00:00.000Z","ghi"`,
+	})
+	uploadCSV(t, svc, "bad.csv.gz", []string{
+		`"my-bucket","f1","not-a-number","2020-06-27T00:00:00.000Z","abc"`,
+	})
+	reader := NewReader(context.Background(), svc, logging.Default())
+
+	fileReader, err := reader.GetFileReader(CSVFormatName, schema, inventoryBucketName, "good.csv.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fileReader.GetNumRows() != 3 {
+		t.Fatalf("unexpected result from GetNumRows. expected=3, got=%d", fileReader.GetNumRows())
+	}
+	if fileReader.FirstObjectKey() != "f1" || fileReader.LastObjectKey() != "f3+plus" {
+		t.Fatalf("unexpected first/last keys. got=%s, %s", fileReader.FirstObjectKey(), fileReader.LastObjectKey())
+	}
+	res := make([]InventoryObject, 10)
+	if err = fileReader.Read(&res); err != nil {
+		t.Fatal(err)
+	}
+	expectedKeys := []string{"f1", "f2 with spaces", "f3+plus"}
+	expectedRawKeys := []string{"f1", "f2+with+spaces", "f3%2Bplus"}
+	if len(res) != len(expectedKeys) {
+		t.Fatalf("read unexpected number of rows. expected=%d, got=%d", len(expectedKeys), len(res))
+	}
+	for i, obj := range res {
+		if obj.Key != expectedKeys[i] {
+			t.Fatalf("unexpected key at index %d. expected=%s, got=%s", i, expectedKeys[i], obj.Key)
+		}
+		if obj.RawKey != expectedRawKeys[i] {
+			t.Fatalf("unexpected raw key at index %d. expected=%s, got=%s", i, expectedRawKeys[i], obj.RawKey)
+		}
+		if *obj.Size != int64(100*(i+1)) {
+			t.Fatalf("unexpected size at index %d. expected=%d, got=%d", i, 100*(i+1), *obj.Size)
+		}
+	}
+	expectedLastModified := time.Date(2020, 6, 27, 1, 0, 0, 0, time.UTC).Unix() * 1000
+	if *res[1].LastModifiedMillis != expectedLastModified {
+		t.Fatalf("unexpected last modified. expected=%d, got=%d", expectedLastModified, *res[1].LastModifiedMillis)
+	}
+	if err = fileReader.Close(); err != nil {
+		t.Fatalf("failed to close file reader: %v", err)
+	}
+
+	fileReader, err = reader.GetFileReader(CSVFormatName, schema, inventoryBucketName, "bad.csv.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res = make([]InventoryObject, 10)
+	if err = fileReader.Read(&res); !errors.Is(err, ErrMalformedCSVRow) || !errors.Is(err, ErrIndexMalformed) {
+		t.Fatalf("expected errors %v and %v, got %v", ErrMalformedCSVRow, ErrIndexMalformed, err)
+	}
+	_ = fileReader.Close()
+
+	_, err = reader.GetFileReader(CSVFormatName, "Size, LastModifiedDate", inventoryBucketName, "good.csv.gz")
+	if !errors.Is(err, ErrMissingInventoryColumn) {
+		t.Fatalf("expected error %v, got %v", ErrMissingInventoryColumn, err)
+	}
+}
+
+func uploadOrcWithSchema(t *testing.T, svc s3iface.S3API, inventoryFilename string, schema string, rows ...[]interface{}) {
+	var buf bytes.Buffer
+	typeDescription, err := orc.ParseSchema(schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := orc.NewWriter(&buf, orc.SetSchema(typeDescription))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, row := range rows {
+		if err = w.Write(row...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	uploadBytes(t, svc, inventoryFilename, buf.Bytes())
+}
+
+type parquetKeyOnlyRow struct {
+	Bucket string `parquet:"name=bucket, type=UTF8"`
+	Key    string `parquet:"name=key, type=UTF8"`
+}
+
+type parquetStringSizeRow struct {
+	Bucket string  `parquet:"name=bucket, type=UTF8"`
+	Key    string  `parquet:"name=key, type=UTF8"`
+	Size   *string `parquet:"name=size, type=UTF8"`
+}
+
+type parquetDoubleSizeRow struct {
+	Bucket string   `parquet:"name=bucket, type=UTF8"`
+	Key    string   `parquet:"name=key, type=UTF8"`
+	Size   *float64 `parquet:"name=size, type=DOUBLE"`
+}
+
+type parquetBooleanSizeRow struct {
+	Bucket string `parquet:"name=bucket, type=UTF8"`
+	Key    string `parquet:"name=key, type=UTF8"`
+	Size   *bool  `parquet:"name=size, type=BOOLEAN"`
+}
+
+type parquetInt32SizeRow struct {
+	Bucket string `parquet:"name=bucket, type=UTF8"`
+	Key    string `parquet:"name=key, type=UTF8"`
+	Size   *int32 `parquet:"name=size, type=INT32"`
+}
+
+type parquetFloatSizeRow struct {
+	Bucket string   `parquet:"name=bucket, type=UTF8"`
+	Key    string   `parquet:"name=key, type=UTF8"`
+	Size   *float32 `parquet:"name=size, type=FLOAT"`
+}
+
+func uploadParquet(t *testing.T, svc s3iface.S3API, inventoryFilename string, obj interface{}, rows ...interface{}) {
+	var buf bytes.Buffer
+	pw, err := writer.NewParquetWriter(writerfile.NewWriterFile(&buf), obj, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, row := range rows {
+		if err = pw.Write(row); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = pw.WriteStop(); err != nil {
+		t.Fatal(err)
+	}
+	uploadBytes(t, svc, inventoryFilename, buf.Bytes())
+}
+
+const avroInventorySchema = `{
+	"type": "record",
+	"name": "inventory",
+	"fields": [
+		{"name": "bucket", "type": "string"},
+		{"name": "key", "type": "string"},
+		{"name": "size", "type": ["null", "long"], "default": null},
+		{"name": "last_modified_date", "type": ["null", {"type": "long", "logicalType": "timestamp-millis"}], "default": null},
+		{"name": "is_latest", "type": ["null", "boolean"], "default": null}
+	]
+}`
+
+func uploadAvro(t *testing.T, svc s3iface.S3API, inventoryFilename string, compression string, records ...map[string]interface{}) {
+	uploadAvroWithSchema(t, svc, inventoryFilename, avroInventorySchema, compression, records...)
+}
+
+func uploadAvroWithSchema(t *testing.T, svc s3iface.S3API, inventoryFilename string, schema string, compression string, records ...map[string]interface{}) {
+	var buf bytes.Buffer
+	w, err := goavro.NewOCFWriter(goavro.OCFConfig{W: &buf, Schema: schema, CompressionName: compression})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, record := range records {
+		if err = w.Append([]interface{}{record}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	uploadBytes(t, svc, inventoryFilename, buf.Bytes())
+}
+
+func TestCSVInventoryReaderZstd(t *testing.T) {
+	svc := newTestInventoryBucket(t)
+	var buf bytes.Buffer
+	w, err := zstd.NewWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 1000; i++ {
+		if _, err := fmt.Fprintf(w, "\"my-bucket\",\"f%04d\",\"100\",\"2020-06-27T00:00:00.000Z\",\"abc\"\n", i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	uploadBytes(t, svc, "good.csv.zst", buf.Bytes())
+	reader := NewReader(context.Background(), svc, logging.Default())
+	fileReader, err := reader.GetFileReader(CSVFormatName, "Bucket, Key, Size, LastModifiedDate, ETag", inventoryBucketName, "good.csv.zst")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = fileReader.Close()
+	}()
+	if fileReader.GetNumRows() != 1000 || fileReader.FirstObjectKey() != "f0000" || fileReader.LastObjectKey() != "f0999" {
+		t.Fatalf("unexpected metadata. rows=%d, first=%s, last=%s", fileReader.GetNumRows(), fileReader.FirstObjectKey(), fileReader.LastObjectKey())
+	}
+	for pass := 0; pass < 2; pass++ {
+		res := make([]InventoryObject, 2000)
+		if err = fileReader.Read(&res); err != nil {
+			t.Fatal(err)
+		}
+		if len(res) != 1000 || res[999].Key != "f0999" || *res[0].Size != 100 {
+			t.Fatalf("unexpected objects read on pass %d: %d objects", pass, len(res))
+		}
+		if err = fileReader.Rewind(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestCSVInventoryReaderUncompressed(t *testing.T) {
+	svc := newTestInventoryBucket(t)
+	var buf bytes.Buffer
+	for i := 0; i < 1000; i++ {
+		fmt.Fprintf(&buf, "\"my-bucket\",\"f%04d\",\"100\",\"2020-06-27T00:00:00.000Z\",\"abc\"\n", i)
+	}
+	uploadBytes(t, svc, "good.csv", buf.Bytes())
+	reader := NewReader(context.Background(), svc, logging.Default())
+	fileReader, err := reader.GetFileReader(CSVFormatName, "Bucket, Key, Size, LastModifiedDate, ETag", inventoryBucketName, "good.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = fileReader.Close()
+	}()
+	if fileReader.GetNumRows() != 1000 || fileReader.FirstObjectKey() != "f0000" || fileReader.LastObjectKey() != "f0999" {
+		t.Fatalf("unexpected metadata. rows=%d, first=%s, last=%s", fileReader.GetNumRows(), fileReader.FirstObjectKey(), fileReader.LastObjectKey())
+	}
+	for pass := 0; pass < 2; pass++ {
+		res := make([]InventoryObject, 2000)
+		if err = fileReader.Read(&res); err != nil {
+			t.Fatal(err)
+		}
+		if len(res) != 1000 || res[999].Key != "f0999" || *res[0].Size != 100 {
+			t.Fatalf("unexpected objects read on pass %d: %d objects", pass, len(res))
+		}
+		if err = fileReader.Rewind(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestKeyEncodingSameForCSVAndOrc(t *testing.T) {
+	keys := []string{"a b", "a+b", "a%2Bb", "dir/a b+c"}
+	lastModified := time.Date(2020, 6, 27, 0, 0, 0, 0, time.UTC)
+	in := make(chan *InventoryObject)
+	go func() {
+		defer close(in)
+		for _, key := range keys {
+			in <- &InventoryObject{
+				Bucket:             inventoryBucketName,
+				Key:                key,
+				Size:               swag.Int64(100),
+				LastModifiedMillis: swag.Int64(lastModified.Unix() * 1000),
+				Checksum:           swag.String("abc"),
+			}
+		}
+	}()
+	localOrcFile := generateOrc(t, in)
+	orcReader, err := NewLocalFileReader(context.Background(), OrcFormatName, "", localOrcFile, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = orcReader.Close()
+	}()
+	csvFile, err := ioutil.TempFile("", "csvtest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := gzip.NewWriter(csvFile)
+	for _, key := range keys {
+		row := fmt.Sprintf("%q,%q,\"100\",%q,\"abc\"\n", inventoryBucketName, url.QueryEscape(key), lastModified.Format(time.RFC3339Nano))
+		if _, err := w.Write([]byte(row)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	_ = csvFile.Close()
+	csvReader, err := NewLocalFileReader(context.Background(), CSVFormatName, "Bucket, Key, Size, LastModifiedDate, ETag", csvFile.Name(), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = csvReader.Close()
+	}()
+	orcRes := make([]InventoryObject, len(keys))
+	if err := orcReader.Read(&orcRes); err != nil {
+		t.Fatal(err)
+	}
+	csvRes := make([]InventoryObject, len(keys))
+	if err := csvReader.Read(&csvRes); err != nil {
+		t.Fatal(err)
+	}
+	if len(orcRes) != len(keys) || len(csvRes) != len(keys) {
+		t.Fatalf("unexpected number of rows. expected=%d, got orc=%d, csv=%d", len(keys), len(orcRes), len(csvRes))
+	}
+	for i, key := range keys {
+		if orcRes[i].Key != key || csvRes[i].Key != key {
+			t.Fatalf("unexpected key at index %d. expected=%s, got orc=%s, csv=%s", i, key, orcRes[i].Key, csvRes[i].Key)
+		}
+		if orcRes[i].RawKey != "" {
+			t.Fatalf("unexpected raw key for orc at index %d: %s", i, orcRes[i].RawKey)
+		}
+		if csvRes[i].RawKey != url.QueryEscape(key) {
+			t.Fatalf("unexpected raw key for csv at index %d. expected=%s, got=%s", i, url.QueryEscape(key), csvRes[i].RawKey)
+		}
+	}
+}
+
+func TestAvroInventoryReader(t *testing.T) {
+	svc := newTestInventoryBucket(t)
+	lastModified := time.Unix(1593216000, 0)
+	records := []map[string]interface{}{
+		{"bucket": "b", "key": "k2", "size": goavro.Union("long", int64(500)), "last_modified_date": goavro.Union("long.timestamp-millis", lastModified), "is_latest": goavro.Union("boolean", true)},
+		{"bucket": "b", "key": "k1", "size": nil, "last_modified_date": nil, "is_latest": nil},
+		{"bucket": "b", "key": "k3", "size": goavro.Union("long", int64(0)), "last_modified_date": nil, "is_latest": goavro.Union("boolean", false)},
+	}
+	expected := []InventoryObject{
+		{Bucket: "b", Key: "k2", Size: swag.Int64(500), LastModifiedMillis: swag.Int64(lastModified.Unix() * 1000), IsLatest: swag.Bool(true)},
+		{Bucket: "b", Key: "k1"},
+		{Bucket: "b", Key: "k3", Size: swag.Int64(0), IsLatest: swag.Bool(false)},
+	}
+	for _, compression := range []string{goavro.CompressionNullLabel, goavro.CompressionDeflateLabel, goavro.CompressionSnappyLabel} {
+		t.Run(compression, func(t *testing.T) {
+			key := "myFile." + compression + ".avro"
+			uploadAvro(t, svc, key, compression, records...)
+			reader := NewReader(context.Background(), svc, logging.Default())
+			fileReader, err := reader.GetFileReader(AvroFormatName, "", inventoryBucketName, key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() {
+				_ = fileReader.Close()
+			}()
+			if fileReader.GetNumRows() != int64(len(records)) {
+				t.Fatalf("unexpected number of rows. expected=%d, got=%d", len(records), fileReader.GetNumRows())
+			}
+			if fileReader.FirstObjectKey() != "k1" || fileReader.LastObjectKey() != "k3" {
+				t.Fatalf("unexpected key range. expected=[k1, k3], got=[%s, %s]", fileReader.FirstObjectKey(), fileReader.LastObjectKey())
+			}
+			res := make([]InventoryObject, len(records))
+			if err = fileReader.Read(&res); err != nil {
+				t.Fatal(err)
+			}
+			if diff := deep.Equal(res, expected); diff != nil {
+				t.Fatalf("unexpected objects read: %v", diff)
+			}
+		})
+	}
+	uploadBytes(t, svc, "notAvro.avro", []byte("not an avro file"))
+	reader := NewReader(context.Background(), svc, logging.Default())
+	if _, err := reader.GetFileReader(ApacheAvroFormatName, "", inventoryBucketName, "notAvro.avro"); !errors.Is(err, ErrMalformedAvroRecord) {
+		t.Fatalf("expected error %v, got %v", ErrMalformedAvroRecord, err)
+	}
+}
+
+func TestInventoryReaderSchemaValidation(t *testing.T) {
+	svc := newTestInventoryBucket(t)
+	uploadOrcWithSchema(t, svc, "no_key.orc", "struct<bucket:string,size:int>", []interface{}{"b", int64(1)})
+	uploadOrcWithSchema(t, svc, "string_size.orc", "struct<bucket:string,key:string,size:string>", []interface{}{"b", "k", "1"})
+	uploadOrcWithSchema(t, svc, "boolean_size.orc", "struct<bucket:string,key:string,size:boolean>", []interface{}{"b", "k", true})
+	uploadOrcWithSchema(t, svc, "key_only.orc", "struct<bucket:string,key:string>", []interface{}{"b", "k1"}, []interface{}{"b", "k2"})
+	uploadParquet(t, svc, "string_size.parquet", new(parquetStringSizeRow), parquetStringSizeRow{Bucket: "b", Key: "k", Size: swag.String("1")})
+	uploadParquet(t, svc, "double_size.parquet", new(parquetDoubleSizeRow), parquetDoubleSizeRow{Bucket: "b", Key: "k", Size: swag.Float64(1)})
+	uploadParquet(t, svc, "boolean_size.parquet", new(parquetBooleanSizeRow), parquetBooleanSizeRow{Bucket: "b", Key: "k", Size: swag.Bool(true)})
+	uploadParquet(t, svc, "key_only.parquet", new(parquetKeyOnlyRow), parquetKeyOnlyRow{Bucket: "b", Key: "k1"}, parquetKeyOnlyRow{Bucket: "b", Key: "k2"})
+	uploadParquet(t, svc, "full.parquet", new(InventoryObject), InventoryObject{Bucket: "b", Key: "k1", Size: swag.Int64(100), IsLatest: swag.Bool(true), Checksum: swag.String("abc")})
+
+	testdata := []struct {
+		Format       string
+		Key          string
+		ErrExpected  error
+		ExpectedKeys []string
+		ExpectedSize *int64
+	}{
+		{Format: OrcFormatName, Key: "no_key.orc", ErrExpected: ErrMissingInventoryColumn},
+		{Format: OrcFormatName, Key: "string_size.orc", ExpectedKeys: []string{"k"}, ExpectedSize: swag.Int64(1)},
+		{Format: OrcFormatName, Key: "boolean_size.orc", ErrExpected: ErrIncompatibleInventoryColumn},
+		{Format: OrcFormatName, Key: "key_only.orc", ExpectedKeys: []string{"k1", "k2"}},
+		{Format: ParquetFormatName, Key: "string_size.parquet", ExpectedKeys: []string{"k"}, ExpectedSize: swag.Int64(1)},
+		{Format: ParquetFormatName, Key: "double_size.parquet", ExpectedKeys: []string{"k"}, ExpectedSize: swag.Int64(1)},
+		{Format: ParquetFormatName, Key: "boolean_size.parquet", ErrExpected: ErrIncompatibleInventoryColumn},
+		{Format: ParquetFormatName, Key: "key_only.parquet", ExpectedKeys: []string{"k1", "k2"}},
+		{Format: ParquetFormatName, Key: "full.parquet", ExpectedKeys: []string{"k1"}, ExpectedSize: swag.Int64(100)},
+	}
+	reader := NewReader(context.Background(), svc, logging.Default())
+	for _, test := range testdata {
+		fileReader, err := reader.GetFileReader(test.Format, "", inventoryBucketName, test.Key)
+		if test.ErrExpected != nil {
+			if !errors.Is(err, test.ErrExpected) {
+				t.Fatalf("%s: expected error %v, got %v", test.Key, test.ErrExpected, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", test.Key, err)
+		}
+		res := make([]InventoryObject, len(test.ExpectedKeys))
+		if err = fileReader.Read(&res); err != nil {
+			t.Fatalf("%s: %v", test.Key, err)
+		}
+		for i, obj := range res {
+			if obj.Key != test.ExpectedKeys[i] {
+				t.Fatalf("%s: unexpected key at index %d. expected=%s, got=%s", test.Key, i, test.ExpectedKeys[i], obj.Key)
+			}
+			if test.ExpectedSize != nil {
+				if obj.Size == nil || *obj.Size != *test.ExpectedSize {
+					t.Fatalf("%s: unexpected size at index %d. expected=%d, got=%v", test.Key, i, *test.ExpectedSize, obj.Size)
+				}
+				continue
+			}
+			if obj.Size != nil || obj.LastModifiedMillis != nil || obj.Checksum != nil {
+				t.Fatalf("%s: expected absent columns to be nil, got %+v", test.Key, obj)
+			}
+		}
+		if err = fileReader.Close(); err != nil {
+			t.Fatalf("%s: failed to close file reader: %v", test.Key, err)
+		}
+	}
+}
+
+func TestOrcBadRow(t *testing.T) {
+	svc := newTestInventoryBucket(t)
+	uploadOrcWithSchema(t, svc, "bad_row.orc", "struct<bucket:string,key:string,size:bigint>",
+		[]interface{}{"b", "k1", int64(1)}, []interface{}{"b", nil, int64(2)}, []interface{}{"b", "k3", int64(3)})
+	type badRow struct {
+		fileKey  string
+		rowIndex int64
+	}
+	testdata := []struct {
+		name         string
+		handler      *bool // the result of the handler, or nil for no handler
+		expectedKeys []string
+	}{
+		{name: "no_handler"},
+		{name: "skip", handler: swag.Bool(true), expectedKeys: []string{"k1", "k3"}},
+		{name: "abort", handler: swag.Bool(false)},
+	}
+	for _, test := range testdata {
+		t.Run(test.name, func(t *testing.T) {
+			var badRows []badRow
+			var opts []ReaderOption
+			if test.handler != nil {
+				opts = append(opts, WithOnBadRow(func(fileKey string, rowIndex int64, err error) bool {
+					if !errors.Is(err, ErrMalformedOrcRow) {
+						t.Errorf("unexpected bad row error: %v", err)
+					}
+					badRows = append(badRows, badRow{fileKey: fileKey, rowIndex: rowIndex})
+					return *test.handler
+				}))
+			}
+			reader := NewReader(context.Background(), svc, logging.Default(), opts...)
+			fileReader, err := reader.GetFileReader(OrcFormatName, "", inventoryBucketName, "bad_row.orc")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() {
+				_ = fileReader.Close()
+			}()
+			res := make([]InventoryObject, 3)
+			err = fileReader.Read(&res)
+			if test.handler != nil {
+				if diff := deep.Equal(badRows, []badRow{{fileKey: "bad_row.orc", rowIndex: 1}}); diff != nil {
+					t.Fatalf("unexpected bad rows: %s", diff)
+				}
+			}
+			if test.expectedKeys == nil {
+				if !errors.Is(err, ErrMalformedOrcRow) {
+					t.Fatalf("expected error %v, got %v", ErrMalformedOrcRow, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			keys := make([]string, len(res))
+			for i, obj := range res {
+				keys[i] = obj.Key
+			}
+			if diff := deep.Equal(keys, test.expectedKeys); diff != nil {
+				t.Fatalf("unexpected keys: %s", diff)
+			}
+		})
+	}
+}
+
+type countingS3Client struct {
+	s3iface.S3API
+	getObjectCalls int32
+}
+
+func (c *countingS3Client) GetObjectWithContext(ctx aws.Context, input *s3.GetObjectInput, opts ...request.Option) (*s3.GetObjectOutput, error) {
+	atomic.AddInt32(&c.getObjectCalls, 1)
+	return c.S3API.GetObjectWithContext(ctx, input, opts...)
+}
+
+func TestPrefetchAll(t *testing.T) {
+	svc := newTestInventoryBucket(t)
+	lastModified := []time.Time{time.Now()}
+	keys := []string{"f1.orc", "f2.orc", "f3.orc"}
+	for _, key := range keys {
+		uploadFile(t, svc, inventoryBucketName, key, objs(10, lastModified))
+	}
+	counter := &countingS3Client{S3API: svc}
+	reader := NewReader(context.Background(), counter, logging.Default())
+	err := reader.PrefetchAll(context.Background(), inventoryBucketName, keys, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if counter.getObjectCalls != int32(len(keys)) {
+		t.Fatalf("unexpected number of downloads. expected=%d, got=%d", len(keys), counter.getObjectCalls)
+	}
+	localFiles := make([]string, 0, len(keys))
+	for _, f := range reader.orcFilesByKey {
+		if !f.ready {
+			t.Fatalf("prefetched file %s is not ready", f.localFilename)
+		}
+		localFiles = append(localFiles, f.localFilename)
+	}
+	for _, key := range keys {
+		fileReader, err := reader.GetFileReader(OrcFormatName, "", inventoryBucketName, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res := make([]InventoryObject, 10)
+		if err = fileReader.Read(&res); err != nil {
+			t.Fatal(err)
+		}
+		if len(res) != 10 {
+			t.Fatalf("read unexpected number of rows from %s. expected=10, got=%d", key, len(res))
+		}
+		if err = fileReader.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if counter.getObjectCalls != int32(len(keys)) {
+		t.Fatalf("prefetched files were downloaded again. expected=%d downloads, got=%d", len(keys), counter.getObjectCalls)
+	}
+	for _, localFile := range localFiles {
+		if _, err := os.Stat(localFile); !os.IsNotExist(err) {
+			t.Fatalf("expected prefetched file %s to be removed after close", localFile)
+		}
+	}
+
+	// a failed prefetch leaves no files behind
+	err = reader.PrefetchAll(context.Background(), inventoryBucketName, []string{"f1.orc", "no_such_file.orc"}, 2)
+	if err == nil {
+		t.Fatal("expected prefetch of a missing file to fail")
+	}
+	if len(reader.orcFilesByKey) != 0 {
+		t.Fatalf("expected no prefetched files after failure, got %d", len(reader.orcFilesByKey))
+	}
+}
+
+func TestPrefetchAllFailureKeepsEarlierFiles(t *testing.T) {
+	svc := newTestInventoryBucket(t)
+	const numRows = 2500
+	uploadFile(t, svc, inventoryBucketName, "f1.orc", objs(numRows, []time.Time{time.Now()}))
+	uploadFile(t, svc, inventoryBucketName, "f2.orc", objs(numRows, []time.Time{time.Now()}))
+	reader := NewReader(context.Background(), svc, logging.Default())
+	if err := reader.PrefetchAll(context.Background(), inventoryBucketName, []string{"f1.orc"}, 1); err != nil {
+		t.Fatal(err)
+	}
+	fileReader, err := reader.GetFileReader(OrcFormatName, "", inventoryBucketName, "f1.orc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	localFile := reader.orcFilesByKey[fileCacheKey(inventoryBucketName, "f1.orc")].localFilename
+
+	// the failed call removes the files it downloaded, not the one prefetched before it
+	err = reader.PrefetchAll(context.Background(), inventoryBucketName, []string{"f1.orc", "f2.orc", "no_such_file.orc"}, 1)
+	if err == nil {
+		t.Fatal("expected prefetch of a missing file to fail")
+	}
+	if _, ok := reader.orcFilesByKey[fileCacheKey(inventoryBucketName, "f2.orc")]; ok {
+		t.Fatal("expected the file downloaded by the failed prefetch to be removed")
+	}
+	if _, err = os.Stat(localFile); err != nil {
+		t.Fatalf("expected the file prefetched before the failure to be kept: %v", err)
+	}
+	res := make([]InventoryObject, numRows)
+	if err = fileReader.Read(&res); err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != numRows {
+		t.Fatalf("unexpected number of objects read. expected=%d, got=%d", numRows, len(res))
+	}
+	if err = fileReader.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(localFile); !os.IsNotExist(err) {
+		t.Fatalf("expected prefetched file %s to be removed after close", localFile)
+	}
+}
+
+func TestPrefetchCleanedOnCancel(t *testing.T) {
+	svc := newTestInventoryBucket(t)
+	keys := []string{"f1.orc", "f2.orc"}
+	for _, key := range keys {
+		uploadFile(t, svc, inventoryBucketName, key, objs(10, []time.Time{time.Now()}))
+	}
+	tempDir, err := ioutil.TempDir("", "prefetch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(tempDir)
+	}()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	reader := NewReader(ctx, svc, logging.Default(), WithTempDir(tempDir))
+	if err = reader.PrefetchAll(context.Background(), inventoryBucketName, keys, 2); err != nil {
+		t.Fatal(err)
+	}
+	// one file is opened and never closed before cancelling, the other one is never opened
+	fileReader, err := reader.GetFileReader(OrcFormatName, "", inventoryBucketName, keys[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, err := ioutil.ReadDir(tempDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != len(keys) {
+		t.Fatalf("expected %d prefetched files, got %d", len(keys), len(files))
+	}
+	cancel()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		files, err = ioutil.ReadDir(tempDir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(files) == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected prefetched files to be removed after cancel, got %d files", len(files))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err = fileReader.Close(); err != nil {
+		t.Fatalf("failed to close reader after its file was removed: %v", err)
+	}
+	reader.mu.Lock()
+	defer reader.mu.Unlock()
+	if len(reader.orcFilesByKey) != 0 {
+		t.Fatalf("expected no prefetched files after cancel, got %d", len(reader.orcFilesByKey))
+	}
+}
+
+func TestReaderClose(t *testing.T) {
+	svc := newTestInventoryBucket(t)
+	keys := []string{"f1.orc", "f2.orc", "f3.orc"}
+	for _, key := range keys {
+		uploadFile(t, svc, inventoryBucketName, key, objs(10, []time.Time{time.Now()}))
+	}
+	tempDir, err := ioutil.TempDir("", "prefetch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(tempDir)
+	}()
+	reader := NewReader(context.Background(), svc, logging.Default(), WithTempDir(tempDir))
+	if err = reader.PrefetchAll(context.Background(), inventoryBucketName, keys, 2); err != nil {
+		t.Fatal(err)
+	}
+	// file readers are opened for some of the files, and none of them is closed
+	var fileReaders []FileReader
+	for _, key := range keys[:2] {
+		fileReader, err := reader.GetFileReader(OrcFormatName, "", inventoryBucketName, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fileReaders = append(fileReaders, fileReader)
+	}
+	if err = reader.Close(); err != nil {
+		t.Fatal(err)
+	}
+	files, err := ioutil.ReadDir(tempDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 0 {
+		t.Fatalf("expected all prefetched files to be removed, got %d files", len(files))
+	}
+	if len(reader.orcFilesByKey) != 0 {
+		t.Fatalf("expected no prefetched files after close, got %d", len(reader.orcFilesByKey))
+	}
+	for _, fileReader := range fileReaders {
+		res := make([]InventoryObject, 10)
+		if err = fileReader.Read(&res); err != nil {
+			t.Fatalf("failed to read open file after closing the reader: %v", err)
+		}
+		if len(res) != 10 {
+			t.Fatalf("unexpected number of objects. expected=%d, got=%d", 10, len(res))
+		}
+		if err = fileReader.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = reader.Close(); err != nil {
+		t.Fatalf("failed to close reader twice: %v", err)
+	}
+}
+
+func TestPrefetchedFileSharedByReaders(t *testing.T) {
+	svc := newTestInventoryBucket(t)
+	uploadFile(t, svc, inventoryBucketName, "myFile.orc", objs(100, []time.Time{time.Now()}))
+	for _, closeFirst := range []int{0, 1} {
+		t.Run(fmt.Sprintf("close_first=%d", closeFirst), func(t *testing.T) {
+			reader := NewReader(context.Background(), svc, logging.Default())
+			err := reader.PrefetchAll(context.Background(), inventoryBucketName, []string{"myFile.orc"}, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			localFile := reader.orcFilesByKey[fileCacheKey(inventoryBucketName, "myFile.orc")].localFilename
+			fileReaders := make([]FileReader, 2)
+			var g errgroup.Group
+			for i := range fileReaders {
+				i := i
+				g.Go(func() error {
+					var err error
+					fileReaders[i], err = reader.GetFileReader(OrcFormatName, "", inventoryBucketName, "myFile.orc")
+					return err
+				})
+			}
+			if err = g.Wait(); err != nil {
+				t.Fatal(err)
+			}
+			if err = fileReaders[closeFirst].Close(); err != nil {
+				t.Fatal(err)
+			}
+			if _, err = os.Stat(localFile); err != nil {
+				t.Fatalf("expected local file %s to be kept while a reader is open: %v", localFile, err)
+			}
+			remaining := fileReaders[1-closeFirst]
+			res := make([]InventoryObject, 100)
+			if err = remaining.Read(&res); err != nil {
+				t.Fatal(err)
+			}
+			if len(res) != 100 {
+				t.Fatalf("unexpected number of objects read. expected=%d, got=%d", 100, len(res))
+			}
+			if err = remaining.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if _, err = os.Stat(localFile); !os.IsNotExist(err) {
+				t.Fatalf("expected local file %s to be removed after the last reader is closed", localFile)
+			}
+		})
+	}
+}
+
+func TestPrefetchedCorruptFile(t *testing.T) {
+	svc := newTestInventoryBucket(t)
+	uploadBytes(t, svc, "corrupt.orc", bytes.Repeat([]byte("not an orc file "), 100))
+	reader := NewReader(context.Background(), svc, logging.Default())
+	err := reader.PrefetchAll(context.Background(), inventoryBucketName, []string{"corrupt.orc"}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cacheKey := fileCacheKey(inventoryBucketName, "corrupt.orc")
+	localFile := reader.orcFilesByKey[cacheKey].localFilename
+	if _, err = reader.GetFileReader(OrcFormatName, "", inventoryBucketName, "corrupt.orc"); err == nil {
+		t.Fatal("expected error reading corrupt orc file")
+	}
+	if _, err = os.Stat(localFile); !os.IsNotExist(err) {
+		t.Fatalf("expected local file %s to be removed after failed read", localFile)
+	}
+	if file, ok := reader.orcFilesByKey[cacheKey]; ok && file.ready {
+		t.Fatal("expected corrupt file to no longer be marked as prefetched")
+	}
+}
+
+func TestOptionalColumnsSameForOrcAndParquet(t *testing.T) {
+	svc := newTestInventoryBucket(t)
+	lastModified := time.Date(2020, 6, 27, 0, 0, 0, 0, time.UTC)
+	expected := []InventoryObject{
+		{Bucket: "b", Key: "k1", Size: swag.Int64(1), LastModifiedMillis: swag.Int64(lastModified.Unix() * 1000), Checksum: swag.String("e1"), IsLatest: swag.Bool(true), IsDeleteMarker: swag.Bool(false)},
+		{Bucket: "b", Key: "k2", Size: swag.Int64(2), LastModifiedMillis: swag.Int64(lastModified.Unix() * 1000), Checksum: swag.String("e2"), IsLatest: swag.Bool(false), IsDeleteMarker: swag.Bool(true)},
+	}
+	orcRows := make([][]interface{}, 0, len(expected))
+	parquetRows := make([]interface{}, 0, len(expected))
+	for _, o := range expected {
+		orcRows = append(orcRows, []interface{}{o.Bucket, o.Key, *o.Size, lastModified, *o.Checksum, *o.IsLatest, *o.IsDeleteMarker})
+		parquetRows = append(parquetRows, o)
+	}
+	uploadOrcWithSchema(t, svc, "all.orc", "struct<bucket:string,key:string,size:bigint,last_modified_date:timestamp,e_tag:string,is_latest:boolean,is_delete_marker:boolean>", orcRows...)
+	uploadParquet(t, svc, "all.parquet", new(InventoryObject), parquetRows...)
+	reader := NewReader(context.Background(), svc, logging.Default())
+	for format, key := range map[string]string{OrcFormatName: "all.orc", ParquetFormatName: "all.parquet"} {
+		fileReader, err := reader.GetFileReader(format, "", inventoryBucketName, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res := make([]InventoryObject, len(expected))
+		if err = fileReader.Read(&res); err != nil {
+			t.Fatal(err)
+		}
+		if diff := deep.Equal(res, expected); diff != nil {
+			t.Fatalf("%s: unexpected result: %s", format, diff)
+		}
+		_ = fileReader.Close()
+	}
+}
+
+func TestSkipDeleteMarkers(t *testing.T) {
+	svc := newTestInventoryBucket(t)
+	objects := []InventoryObject{
+		{Bucket: "b", Key: "k1", Size: swag.Int64(1), Checksum: swag.String("e1"), IsDeleteMarker: swag.Bool(false)},
+		{Bucket: "b", Key: "k2", Checksum: swag.String("e2"), IsDeleteMarker: swag.Bool(true)},
+		{Bucket: "b", Key: "k3", Checksum: swag.String("e3"), IsDeleteMarker: swag.Bool(false)},
+		{Bucket: "b", Key: "k4", Size: swag.Int64(4), Checksum: swag.String("e4"), IsDeleteMarker: swag.Bool(true)},
+	}
+	orcRows := make([][]interface{}, 0, len(objects))
+	parquetRows := make([]interface{}, 0, len(objects))
+	csvRows := make([]string, 0, len(objects))
+	for _, o := range objects {
+		var size interface{}
+		csvSize := ""
+		if o.Size != nil {
+			size = *o.Size
+			csvSize = strconv.FormatInt(*o.Size, 10)
+		}
+		orcRows = append(orcRows, []interface{}{o.Bucket, o.Key, size, *o.Checksum, *o.IsDeleteMarker})
+		parquetRows = append(parquetRows, o)
+		csvRows = append(csvRows, fmt.Sprintf(`"%s","%s","%s","%s","%t"`, o.Bucket, o.Key, csvSize, *o.Checksum, *o.IsDeleteMarker))
+	}
+	uploadOrcWithSchema(t, svc, "delete_markers.orc", "struct<bucket:string,key:string,size:bigint,e_tag:string,is_delete_marker:boolean>", orcRows...)
+	uploadParquet(t, svc, "delete_markers.parquet", new(InventoryObject), parquetRows...)
+	uploadCSV(t, svc, "delete_markers.csv.gz", csvRows)
+	files := map[string]string{
+		OrcFormatName:     "delete_markers.orc",
+		ParquetFormatName: "delete_markers.parquet",
+		CSVFormatName:     "delete_markers.csv.gz",
+	}
+	testdata := []struct {
+		name         string
+		opts         []ReaderOption
+		expectedKeys []string
+	}{
+		{name: "no filter", expectedKeys: []string{"k1", "k2", "k3", "k4"}},
+		{name: "skip delete markers", opts: []ReaderOption{WithSkipDeleteMarkers(true)}, expectedKeys: []string{"k1", "k3"}},
+		{name: "skip missing size", opts: []ReaderOption{WithSkipMissingSize(true)}, expectedKeys: []string{"k1", "k4"}},
+		{name: "skip both", opts: []ReaderOption{WithSkipDeleteMarkers(true), WithSkipMissingSize(true)}, expectedKeys: []string{"k1"}},
+		{name: "skip with projection", opts: []ReaderOption{WithSkipDeleteMarkers(true), WithProjection("e_tag")}, expectedKeys: []string{"k1", "k3"}},
+	}
+	for _, test := range testdata {
+		for format, key := range files {
+			t.Run(test.name+"/"+format, func(t *testing.T) {
+				reader := NewReader(context.Background(), svc, logging.Default(), test.opts...)
+				fileReader, err := reader.GetFileReader(format, "Bucket, Key, Size, ETag, IsDeleteMarker", inventoryBucketName, key)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer func() {
+					_ = fileReader.Close()
+				}()
+				if format != CSVFormatName && fileReader.GetNumRows() != int64(len(objects)) {
+					t.Fatalf("unexpected number of rows. expected=%d, got=%d", len(objects), fileReader.GetNumRows())
+				}
+				res := make([]InventoryObject, len(objects))
+				if err = fileReader.Read(&res); err != nil {
+					t.Fatal(err)
+				}
+				keys := make([]string, 0, len(res))
+				for _, obj := range res {
+					keys = append(keys, obj.Key)
+				}
+				if diff := deep.Equal(keys, test.expectedKeys); diff != nil {
+					t.Fatalf("unexpected keys: %s", diff)
+				}
+			})
+		}
+	}
+}
+
+func TestLatestOnly(t *testing.T) {
+	svc := newTestInventoryBucket(t)
+	objects := []InventoryObject{
+		{Bucket: "b", Key: "k1", VersionID: swag.String("v3"), IsLatest: swag.Bool(true), IsDeleteMarker: swag.Bool(false)},
+		{Bucket: "b", Key: "k1", VersionID: swag.String("v2"), IsLatest: swag.Bool(false), IsDeleteMarker: swag.Bool(false)},
+		{Bucket: "b", Key: "k1", VersionID: swag.String("v1"), IsLatest: swag.Bool(false), IsDeleteMarker: swag.Bool(false)},
+		{Bucket: "b", Key: "k2", VersionID: swag.String("v5"), IsLatest: swag.Bool(true), IsDeleteMarker: swag.Bool(true)},
+		{Bucket: "b", Key: "k2", VersionID: swag.String("v4"), IsLatest: swag.Bool(false), IsDeleteMarker: swag.Bool(false)},
+		{Bucket: "b", Key: "k3", VersionID: swag.String("v7"), IsLatest: swag.Bool(false), IsDeleteMarker: swag.Bool(true)},
+		{Bucket: "b", Key: "k3", VersionID: swag.String("v6"), IsLatest: swag.Bool(true), IsDeleteMarker: swag.Bool(false)},
+	}
+	orcRows := make([][]interface{}, 0, len(objects))
+	parquetRows := make([]interface{}, 0, len(objects))
+	csvRows := make([]string, 0, len(objects))
+	for _, o := range objects {
+		orcRows = append(orcRows, []interface{}{o.Bucket, o.Key, *o.VersionID, *o.IsLatest, *o.IsDeleteMarker})
+		parquetRows = append(parquetRows, o)
+		csvRows = append(csvRows, fmt.Sprintf(`"%s","%s","%s","%t","%t"`, o.Bucket, o.Key, *o.VersionID, *o.IsLatest, *o.IsDeleteMarker))
+	}
+	uploadOrcWithSchema(t, svc, "versions.orc", "struct<bucket:string,key:string,version_id:string,is_latest:boolean,is_delete_marker:boolean>", orcRows...)
+	uploadParquet(t, svc, "versions.parquet", new(InventoryObject), parquetRows...)
+	uploadCSV(t, svc, "versions.csv.gz", csvRows)
+	files := map[string]string{
+		OrcFormatName:     "versions.orc",
+		ParquetFormatName: "versions.parquet",
+		CSVFormatName:     "versions.csv.gz",
+	}
+	testdata := []struct {
+		name             string
+		opts             []ReaderOption
+		expectedVersions []string
+	}{
+		{name: "all versions", expectedVersions: []string{"v3", "v2", "v1", "v5", "v4", "v7", "v6"}},
+		{name: "latest only", opts: []ReaderOption{WithLatestOnly(true)}, expectedVersions: []string{"v3", "v5", "v6"}},
+		{name: "latest without delete markers", opts: []ReaderOption{WithLatestOnly(true), WithSkipDeleteMarkers(true)}, expectedVersions: []string{"v3", "v6"}},
+		{name: "latest with projection", opts: []ReaderOption{WithLatestOnly(true), WithProjection("version_id")}, expectedVersions: []string{"v3", "v5", "v6"}},
+	}
+	for _, test := range testdata {
+		for format, key := range files {
+			t.Run(test.name+"/"+format, func(t *testing.T) {
+				reader := NewReader(context.Background(), svc, logging.Default(), test.opts...)
+				fileReader, err := reader.GetFileReader(format, "Bucket, Key, VersionId, IsLatest, IsDeleteMarker", inventoryBucketName, key)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer func() {
+					_ = fileReader.Close()
+				}()
+				res := make([]InventoryObject, len(objects))
+				if err = fileReader.Read(&res); err != nil {
+					t.Fatal(err)
+				}
+				versions := make([]string, 0, len(res))
+				for _, obj := range res {
+					versions = append(versions, swag.StringValue(obj.VersionID))
+				}
+				if diff := deep.Equal(versions, test.expectedVersions); diff != nil {
+					t.Fatalf("unexpected versions: %s", diff)
+				}
+			})
+		}
+	}
+}
+
+type parquetReorderedRow struct {
+	IsDeleteMarker     *bool   `parquet:"name=is_delete_marker, type=BOOLEAN"`
+	IsLatest           *bool   `parquet:"name=is_latest, type=BOOLEAN"`
+	Checksum           *string `parquet:"name=e_tag, type=UTF8"`
+	LastModifiedMillis *int64  `parquet:"name=last_modified_date, type=TIMESTAMP_MILLIS"`
+	Size               *int64  `parquet:"name=size, type=INT_64"`
+	Key                string  `parquet:"name=key, type=UTF8"`
+	Bucket             string  `parquet:"name=bucket, type=UTF8"`
+}
+
+func TestColumnOrder(t *testing.T) {
+	svc := newTestInventoryBucket(t)
+	lastModified := time.Date(2020, 6, 27, 0, 0, 0, 0, time.UTC)
+	expected := []InventoryObject{
+		{Bucket: "b", Key: "k1", Size: swag.Int64(1), LastModifiedMillis: swag.Int64(lastModified.Unix() * 1000), Checksum: swag.String("e1"), IsLatest: swag.Bool(true), IsDeleteMarker: swag.Bool(false)},
+		{Bucket: "b", Key: "k2", Size: swag.Int64(2), LastModifiedMillis: swag.Int64(lastModified.Unix() * 1000), Checksum: swag.String("e2"), IsLatest: swag.Bool(false), IsDeleteMarker: swag.Bool(true)},
+	}
+	var orcRows, reorderedOrcRows [][]interface{}
+	var parquetRows, reorderedParquetRows []interface{}
+	var csvRows, reorderedCSVRows []string
+	for _, o := range expected {
+		orcRows = append(orcRows, []interface{}{o.Bucket, o.Key, *o.Size, lastModified, *o.Checksum, *o.IsLatest, *o.IsDeleteMarker})
+		reorderedOrcRows = append(reorderedOrcRows, []interface{}{*o.IsDeleteMarker, *o.IsLatest, *o.Checksum, lastModified, *o.Size, o.Key, o.Bucket})
+		parquetRows = append(parquetRows, o)
+		reorderedParquetRows = append(reorderedParquetRows, parquetReorderedRow{
+			IsDeleteMarker: o.IsDeleteMarker, IsLatest: o.IsLatest, Checksum: o.Checksum, LastModifiedMillis: o.LastModifiedMillis,
+			Size: o.Size, Key: o.Key, Bucket: o.Bucket,
+		})
+		lastModifiedValue := lastModified.Format(time.RFC3339Nano)
+		csvRows = append(csvRows, fmt.Sprintf(`"%s","%s","%d","%s","%s","%t","%t"`, o.Bucket, o.Key, *o.Size, lastModifiedValue, *o.Checksum, *o.IsLatest, *o.IsDeleteMarker))
+		reorderedCSVRows = append(reorderedCSVRows, fmt.Sprintf(`"%t","%t","%s","%s","%d","%s","%s"`, *o.IsDeleteMarker, *o.IsLatest, *o.Checksum, lastModifiedValue, *o.Size, o.Key, o.Bucket))
+	}
+	uploadOrcWithSchema(t, svc, "ordered.orc", "struct<bucket:string,key:string,size:bigint,last_modified_date:timestamp,e_tag:string,is_latest:boolean,is_delete_marker:boolean>", orcRows...)
+	uploadOrcWithSchema(t, svc, "reordered.orc", "struct<is_delete_marker:boolean,is_latest:boolean,e_tag:string,last_modified_date:timestamp,size:bigint,key:string,bucket:string>", reorderedOrcRows...)
+	uploadParquet(t, svc, "ordered.parquet", new(InventoryObject), parquetRows...)
+	uploadParquet(t, svc, "reordered.parquet", new(parquetReorderedRow), reorderedParquetRows...)
+	uploadCSV(t, svc, "ordered.csv.gz", csvRows)
+	uploadCSV(t, svc, "reordered.csv.gz", reorderedCSVRows)
+	testdata := []struct {
+		format string
+		schema string
+		key    string
+	}{
+		{format: OrcFormatName, key: "ordered.orc"},
+		{format: OrcFormatName, key: "reordered.orc"},
+		{format: ParquetFormatName, key: "ordered.parquet"},
+		{format: ParquetFormatName, key: "reordered.parquet"},
+		{format: CSVFormatName, schema: "Bucket, Key, Size, LastModifiedDate, ETag, IsLatest, IsDeleteMarker", key: "ordered.csv.gz"},
+		{format: CSVFormatName, schema: "IsDeleteMarker, IsLatest, ETag, LastModifiedDate, Size, Key, Bucket", key: "reordered.csv.gz"},
+	}
+	reader := NewReader(context.Background(), svc, logging.Default())
+	for _, test := range testdata {
+		t.Run(test.key, func(t *testing.T) {
+			fileReader, err := reader.GetFileReader(test.format, test.schema, inventoryBucketName, test.key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() {
+				_ = fileReader.Close()
+			}()
+			res := make([]InventoryObject, len(expected))
+			if err = fileReader.Read(&res); err != nil {
+				t.Fatal(err)
+			}
+			// raw keys of CSV files are checked by TestCSVInventoryReader
+			for i := range res {
+				res[i].RawKey = ""
+			}
+			if diff := deep.Equal(res, expected); diff != nil {
+				t.Fatalf("unexpected result: %s", diff)
+			}
+		})
+	}
+}
+
+func TestRewind(t *testing.T) {
+	svc := newTestInventoryBucket(t)
+	const numRows = 12000
+	uploadFile(t, svc, inventoryBucketName, "myFile.orc", objs(numRows, []time.Time{time.Now()}))
+	uploadBytes(t, svc, "myFile.parquet", generateParquet(t, 5000, objs(numRows, []time.Time{time.Now()})))
+	csvRows := make([]string, 0, numRows)
+	avroRecords := make([]map[string]interface{}, 0, numRows)
+	for o := range objs(numRows, []time.Time{time.Now()}) {
+		csvRows = append(csvRows, fmt.Sprintf(`"%s","%s"`, o.Bucket, o.Key))
+		avroRecords = append(avroRecords, map[string]interface{}{"bucket": o.Bucket, "key": o.Key})
+	}
+	uploadCSV(t, svc, "myFile.csv.gz", csvRows)
+	uploadAvro(t, svc, "myFile.avro", goavro.CompressionNullLabel, avroRecords...)
+	testdata := []struct {
+		format string
+		key    string
+	}{
+		{format: OrcFormatName, key: "myFile.orc"},
+		{format: ParquetFormatName, key: "myFile.parquet"},
+		{format: CSVFormatName, key: "myFile.csv.gz"},
+		{format: AvroFormatName, key: "myFile.avro"},
+	}
+	for _, test := range testdata {
+		t.Run(test.format, func(t *testing.T) {
+			client := &countingS3Client{S3API: svc}
+			reader := NewReader(context.Background(), client, logging.Default())
+			fileReader, err := reader.GetFileReader(test.format, "Bucket, Key", inventoryBucketName, test.key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() {
+				_ = fileReader.Close()
+			}()
+			readKeys := func() []string {
+				var keys []string
+				for {
+					res := make([]InventoryObject, 1000)
+					err := fileReader.Read(&res)
+					if errors.Is(err, ErrNoMoreRows) {
+						return keys
+					}
+					if err != nil {
+						t.Fatal(err)
+					}
+					for _, obj := range res {
+						keys = append(keys, obj.Key)
+					}
+				}
+			}
+			first := readKeys()
+			if len(first) != numRows {
+				t.Fatalf("unexpected number of objects read. expected=%d, got=%d", numRows, len(first))
+			}
+			calls := atomic.LoadInt32(&client.getObjectCalls)
+			// rewind in the middle of a read as well as at its end
+			res := make([]InventoryObject, 10)
+			for i := 0; i < 2; i++ {
+				if err = fileReader.Rewind(); err != nil {
+					t.Fatal(err)
+				}
+				if diff := deep.Equal(readKeys(), first); diff != nil {
+					t.Fatalf("unexpected objects read after rewind: %s", diff)
+				}
+				if err = fileReader.Rewind(); err != nil {
+					t.Fatal(err)
+				}
+				if err = fileReader.Read(&res); err != nil {
+					t.Fatal(err)
+				}
+			}
+			// parquet files are read from S3 by ranges rather than downloaded
+			if test.format != ParquetFormatName && atomic.LoadInt32(&client.getObjectCalls) != calls {
+				t.Fatal("expected rewind not to download the file again")
+			}
+		})
+	}
+}
+
+func TestNilContext(t *testing.T) {
+	svc := newTestInventoryBucket(t)
+	uploadFile(t, svc, inventoryBucketName, "myFile.orc", objs(10, []time.Time{time.Now()}))
+	var ctx context.Context
+	reader := NewReader(ctx, svc, logging.Default())
+	for _, tailOnly := range []bool{false, true} {
+		var fileReader MetadataReader
+		var err error
+		if tailOnly {
+			fileReader, err = reader.GetMetadataReader(OrcFormatName, "", inventoryBucketName, "myFile.orc")
+		} else {
+			fileReader, err = reader.GetFileReader(OrcFormatName, "", inventoryBucketName, "myFile.orc")
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fileReader.GetNumRows() != 10 {
+			t.Fatalf("unexpected number of rows. expected=%d, got=%d", 10, fileReader.GetNumRows())
+		}
+		_ = fileReader.Close()
+	}
+}
+
+func TestOrcReadCancelled(t *testing.T) {
+	svc := newTestInventoryBucket(t)
+	uploadFile(t, svc, inventoryBucketName, "myFile.orc", objs(1000, []time.Time{time.Now()}))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	reader := NewReader(ctx, svc, logging.Default())
+	err := reader.PrefetchAll(ctx, inventoryBucketName, []string{"myFile.orc"}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	localFile := reader.orcFilesByKey[fileCacheKey(inventoryBucketName, "myFile.orc")].localFilename
+	fileReader, err := reader.GetFileReader(OrcFormatName, "", inventoryBucketName, "myFile.orc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := make([]InventoryObject, 100)
+	if err = fileReader.Read(&res); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	if err = fileReader.Read(&res); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected error %v, got %v", context.Canceled, err)
+	}
+	if err = fileReader.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(localFile); !os.IsNotExist(err) {
+		t.Fatalf("expected local file %s to be removed after close", localFile)
+	}
+}
+
+func openLocalOrc(tb testing.TB, localOrcFile string) *OrcInventoryFileReader {
+	f, err := os.Open(localOrcFile)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	r, err := NewOrcInventoryFileReader(context.Background(), &OrcFile{f})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return r
+}
+
+func TestOrcSkipRows(t *testing.T) {
+	// the ORC writer starts a new stripe at most every 10000 rows
+	localOrcFile := generateOrc(t, objs(35000, []time.Time{time.Now()}))
+	defer func() {
+		_ = os.Remove(localOrcFile)
+	}()
+	testdata := []struct {
+		name          string
+		readFirst     int
+		skips         []int64
+		expectedFirst int
+		expectedErr   error
+	}{
+		{name: "no skip", skips: []int64{0}, expectedFirst: 0},
+		{name: "within first stripe", skips: []int64{10}, expectedFirst: 10},
+		{name: "whole stripe", skips: []int64{10000}, expectedFirst: 10000},
+		{name: "several stripes", skips: []int64{25000}, expectedFirst: 25000},
+		{name: "consecutive skips", skips: []int64{5000, 12000, 3}, expectedFirst: 17003},
+		{name: "after read", readFirst: 100, skips: []int64{20000}, expectedFirst: 20100},
+		{name: "to the end", skips: []int64{35000}, expectedFirst: 35000},
+		{name: "beyond the end", skips: []int64{35001}, expectedErr: ErrSkipBeyondLastRow},
+	}
+	for _, test := range testdata {
+		t.Run(test.name, func(t *testing.T) {
+			r := openLocalOrc(t, localOrcFile)
+			defer func() {
+				_ = r.Close()
+			}()
+			if test.readFirst > 0 {
+				res := make([]InventoryObject, test.readFirst)
+				if err := r.Read(&res); err != nil {
+					t.Fatal(err)
+				}
+			}
+			var err error
+			for _, skip := range test.skips {
+				if err = r.SkipRows(skip); err != nil {
+					break
+				}
+			}
+			if test.expectedErr != nil {
+				if !errors.Is(err, test.expectedErr) {
+					t.Fatalf("expected error %v, got %v", test.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			res := make([]InventoryObject, 35000)
+			err = r.Read(&res)
+			if test.expectedFirst == 35000 {
+				// nothing is left to read after skipping to the end
+				if !errors.Is(err, ErrNoMoreRows) {
+					t.Fatalf("expected error %v, got %v", ErrNoMoreRows, err)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if len(res) != 35000-test.expectedFirst {
+				t.Fatalf("unexpected number of rows after skip. expected=%d, got=%d", 35000-test.expectedFirst, len(res))
+			}
+			if len(res) > 0 && res[0].Key != fmt.Sprintf("f%05d", test.expectedFirst) {
+				t.Fatalf("unexpected first key after skip. expected=%s, got=%s", fmt.Sprintf("f%05d", test.expectedFirst), res[0].Key)
+			}
+		})
+	}
+}
+
+// cancelAfterContext is a context reporting that it is cancelled once its Err method was called more than after times.
+type cancelAfterContext struct {
+	context.Context
+	after int
+	calls int
+}
+
+func (c *cancelAfterContext) Err() error {
+	c.calls++
+	if c.calls > c.after {
+		return context.Canceled
+	}
+	return nil
+}
+
+func TestOrcSkipRowsCancel(t *testing.T) {
+	localOrcFile := generateOrc(t, objs(35000, []time.Time{time.Now()}))
+	defer func() {
+		_ = os.Remove(localOrcFile)
+	}()
+	testdata := []struct {
+		name  string
+		skip  int64
+		after int
+	}{
+		{name: "while skipping stripes", skip: 25000, after: 1},
+		{name: "while skipping rows", skip: 5000, after: 100},
+	}
+	for _, test := range testdata {
+		t.Run(test.name, func(t *testing.T) {
+			f, err := os.Open(localOrcFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ctx := &cancelAfterContext{Context: context.Background(), after: test.after}
+			r, err := NewOrcInventoryFileReader(ctx, &OrcFile{f})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() {
+				_ = r.Close()
+			}()
+			ctx.calls = 0
+			if err = r.SkipRows(test.skip); !errors.Is(err, context.Canceled) {
+				t.Fatalf("expected error %v, got %v", context.Canceled, err)
+			}
+			if ctx.calls != test.after+1 {
+				t.Fatalf("expected skip to stop on the first check of the cancelled context, checked %d times", ctx.calls)
+			}
+		})
+	}
+}
+
+func BenchmarkOrcSkipRows(b *testing.B) {
+	const numRows = 200000
+	localOrcFile := generateOrc(b, objs(numRows, []time.Time{time.Now()}))
+	defer func() {
+		_ = os.Remove(localOrcFile)
+	}()
+	const offset = numRows - 10
+	b.Run("row by row", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			r := openLocalOrc(b, localOrcFile)
+			for skipped := 0; skipped < offset; skipped++ {
+				if !r.cursor.Next() {
+					if _, err := r.selectNextStripe(); err != nil {
+						b.Fatal(err)
+					}
+					skipped--
+				}
+			}
+			_ = r.Close()
+		}
+	})
+	b.Run("by stripe", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			r := openLocalOrc(b, localOrcFile)
+			if err := r.SkipRows(offset); err != nil {
+				b.Fatal(err)
+			}
+			_ = r.Close()
+		}
+	})
+}
+
+func TestOrcReadBytes(t *testing.T) {
+	const numRows = 3000
+	const maxBytes = 4096
+	keys := make([]string, numRows)
+	lastModified := time.Now().Unix() * 1000
+	in := make(chan *InventoryObject)
+	go func() {
+		defer close(in)
+		for i := range keys {
+			// every 100th key is long, and one is longer than the budget by itself
+			keys[i] = fmt.Sprintf("f%05d", i)
+			switch {
+			case i == 1500:
+				keys[i] += strings.Repeat("x", 2*maxBytes)
+			case i%100 == 0:
+				keys[i] += strings.Repeat("x", 1000)
+			}
+			in <- &InventoryObject{
+				Bucket:             inventoryBucketName,
+				Key:                keys[i],
+				Size:               swag.Int64(500),
+				LastModifiedMillis: swag.Int64(lastModified),
+				Checksum:           swag.String("abcdefg"),
+			}
+		}
+	}()
+	localOrcFile := generateOrc(t, in)
+	defer func() {
+		_ = os.Remove(localOrcFile)
+	}()
+	r := openLocalOrc(t, localOrcFile)
+	defer func() {
+		_ = r.Close()
+	}()
+	var read []string
+	for {
+		res := make([]InventoryObject, 1000)
+		err := r.ReadBytes(&res, maxBytes)
+		if errors.Is(err, ErrNoMoreRows) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		size := 0
+		for _, obj := range res {
+			size += len(obj.Key) + inventoryObjectOverhead
+			read = append(read, obj.Key)
+		}
+		if size > maxBytes && len(res) > 1 {
+			t.Fatalf("batch of %d objects exceeds the byte budget: %d > %d", len(res), size, maxBytes)
+		}
+	}
+	if diff := deep.Equal(read, keys); diff != nil {
+		t.Fatalf("unexpected keys read in batches: %v", diff)
+	}
+}
+
+// corruptOrcStripe returns the contents of the ORC file with the data of the given stripe overwritten by random bytes,
+// keeping its footer and other stripes intact.
+func corruptOrcStripe(t *testing.T, localOrcFile string, stripe int, seed int64) []byte {
+	r := openLocalOrc(t, localOrcFile)
+	cursor := r.reader.Select()
+	if err := cursor.SelectStripe(stripe); err != nil {
+		t.Fatal(err)
+	}
+	offset, length := int(cursor.Stripe.GetOffset()), int(cursor.Stripe.GetDataLength())
+	_ = r.Close()
+	data, err := ioutil.ReadFile(localOrcFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rnd := rand.New(rand.NewSource(seed))
+	for i := offset; i < offset+length; i++ {
+		if rnd.Intn(3) == 0 {
+			data[i] = byte(rnd.Intn(256))
+		}
+	}
+	return data
+}
+
+func TestOrcCorruptStripe(t *testing.T) {
+	svc := newTestInventoryBucket(t)
+	// the ORC writer starts a new stripe at most every 10000 rows
+	localOrcFile := generateOrc(t, objs(35000, []time.Time{time.Now()}))
+	defer func() {
+		_ = os.Remove(localOrcFile)
+	}()
+	// with these seeds, the orc library panics while decoding the stripe, and fails decoding it
+	uploadBytes(t, svc, "panic.orc", corruptOrcStripe(t, localOrcFile, 1, 0))
+	uploadBytes(t, svc, "decode_error.orc", corruptOrcStripe(t, localOrcFile, 1, 1))
+	for _, key := range []string{"panic.orc", "decode_error.orc"} {
+		t.Run(key, func(t *testing.T) {
+			fileReader, err := NewReader(context.Background(), svc, logging.Default()).GetFileReader(OrcFormatName, "", inventoryBucketName, key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res := make([]InventoryObject, 35000)
+			err = fileReader.Read(&res)
+			_ = fileReader.Close()
+			if !errors.Is(err, ErrCorruptOrcStripe) || !strings.Contains(err.Error(), "stripe 1") {
+				t.Fatalf("expected error %v for stripe 1, got %v", ErrCorruptOrcStripe, err)
+			}
+
+			orcReader := openOrcFromS3(t, svc, key, WithSkipCorruptOrcStripes(true))
+			if err = orcReader.SkipRows(15000); !errors.Is(err, ErrCorruptOrcStripe) {
+				t.Fatalf("expected error %v when skipping into the corrupt stripe, got %v", ErrCorruptOrcStripe, err)
+			}
+			_ = orcReader.Close()
+
+			orcReader = openOrcFromS3(t, svc, key, WithSkipCorruptOrcStripes(true))
+			defer func() {
+				_ = orcReader.Close()
+			}()
+			keys := make(map[string]bool)
+			for {
+				res := make([]InventoryObject, 1000)
+				err := orcReader.Read(&res)
+				if errors.Is(err, ErrNoMoreRows) {
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, obj := range res {
+					keys[obj.Key] = true
+				}
+			}
+			for i := 0; i < 35000; i++ {
+				if i >= 10000 && i < 20000 {
+					continue
+				}
+				if key := fmt.Sprintf("f%05d", i); !keys[key] {
+					t.Fatalf("expected key %s outside the corrupt stripe to be read", key)
+				}
+			}
+		})
+	}
+}
+
+func openOrcFromS3(t *testing.T, svc s3iface.S3API, key string, opts ...ReaderOption) *OrcInventoryFileReader {
+	fileReader, err := NewReader(context.Background(), svc, logging.Default(), opts...).GetFileReader(OrcFormatName, "", inventoryBucketName, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fileReader.(*OrcInventoryFileReader)
+}
+
+func TestParquetReaderClose(t *testing.T) {
+	svc := newTestInventoryBucket(t)
+	uploadParquet(t, svc, "myFile.parquet", new(InventoryObject), InventoryObject{Bucket: "b", Key: "k1"})
+	reader := NewReader(context.Background(), svc, logging.Default())
+	fileReader, err := reader.GetFileReader(ParquetFormatName, "", inventoryBucketName, "myFile.parquet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	parquetReader := fileReader.(*ParquetInventoryFileReader)
+	pf := &closeCountingParquetFile{ParquetFile: parquetReader.PFile}
+	parquetReader.PFile = pf
+	if err = fileReader.Close(); err != nil {
+		t.Fatalf("failed to close parquet reader: %v", err)
+	}
+	if pf.closed != 1 {
+		t.Fatalf("expected the parquet file to be closed once, closed %d times", pf.closed)
+	}
+}
+
+// closeCountingParquetFile counts the calls to the Close method of the parquet file it wraps.
+type closeCountingParquetFile struct {
+	source.ParquetFile
+	closed int
+}
+
+func (f *closeCountingParquetFile) Close() error {
+	f.closed++
+	return f.ParquetFile.Close()
+}
+
+func TestParquetConcurrency(t *testing.T) {
+	svc := newTestInventoryBucket(t)
+	uploadParquet(t, svc, "myFile.parquet", new(InventoryObject), InventoryObject{Bucket: "b", Key: "k1"})
+	testdata := []struct {
+		concurrency int
+		expected    int64
+	}{
+		{concurrency: 8, expected: 8},
+		{concurrency: 1, expected: 1},
+		{concurrency: 0, expected: DefaultParquetConcurrency},
+		{concurrency: -1, expected: DefaultParquetConcurrency},
+	}
+	for _, test := range testdata {
+		reader := NewReader(context.Background(), svc, logging.Default(), WithParquetConcurrency(test.concurrency))
+		fileReader, err := reader.GetFileReader(ParquetFormatName, "", inventoryBucketName, "myFile.parquet")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if np := fileReader.(*ParquetInventoryFileReader).NP; np != test.expected {
+			t.Fatalf("unexpected parquet concurrency for %d. expected=%d, got=%d", test.concurrency, test.expected, np)
+		}
+		_ = fileReader.Close()
+	}
+}
+
+func TestEstimatedTotalRows(t *testing.T) {
+	svc := newTestInventoryBucket(t)
+	uploadFile(t, svc, inventoryBucketName, "f1.orc", objs(10, []time.Time{time.Now()}))
+	uploadFile(t, svc, inventoryBucketName, "f2.orc", objs(25, []time.Time{time.Now()}))
+	uploadParquet(t, svc, "f1.parquet", new(InventoryObject),
+		InventoryObject{Bucket: "b", Key: "k1"}, InventoryObject{Bucket: "b", Key: "k2"}, InventoryObject{Bucket: "b", Key: "k3"})
+	reader := NewReader(context.Background(), svc, logging.Default())
+	testdata := []struct {
+		format   string
+		keys     []string
+		expected int64
+	}{
+		{format: OrcFormatName, keys: []string{"f1.orc", "f2.orc"}, expected: 35},
+		{format: ParquetFormatName, keys: []string{"f1.parquet"}, expected: 3},
+		{format: OrcFormatName, expected: 0},
+	}
+	for _, test := range testdata {
+		total, err := reader.EstimatedTotalRows(test.format, "", inventoryBucketName, test.keys)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if total != test.expected {
+			t.Fatalf("unexpected total rows for %v. expected=%d, got=%d", test.keys, test.expected, total)
+		}
+	}
+
+	// the rows of CSV files are reported as not counted, rather than as none
+	csvKeys := []string{"f1.csv.gz", "f2.csv.gz"}
+	total, err := reader.EstimatedTotalRows(CSVFormatName, "Bucket, Key", inventoryBucketName, csvKeys)
+	if !errors.Is(err, ErrRowsNotCounted) {
+		t.Fatalf("expected error %v, got %v", ErrRowsNotCounted, err)
+	}
+	for _, key := range csvKeys {
+		if !strings.Contains(err.Error(), key) {
+			t.Fatalf("expected error naming %s, got %v", key, err)
+		}
+	}
+	if total != 0 {
+		t.Fatalf("unexpected total rows of CSV files. expected=0, got=%d", total)
+	}
+	if total, err = reader.EstimatedTotalRows(CSVFormatName, "Bucket, Key", inventoryBucketName, nil); err != nil || total != 0 {
+		t.Fatalf("expected no rows and no error for no CSV files, got %d and %v", total, err)
+	}
+}
+
+func TestTempDir(t *testing.T) {
+	svc := newTestInventoryBucket(t)
+	uploadFile(t, svc, inventoryBucketName, "myFile.orc", objs(10, []time.Time{time.Now()}))
+	tempDir, err := ioutil.TempDir("", "inventory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(tempDir)
+	}()
+	reader := NewReader(context.Background(), svc, logging.Default(), WithTempDir(tempDir))
+	err = reader.PrefetchAll(context.Background(), inventoryBucketName, []string{"myFile.orc"}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cacheKey := fileCacheKey(inventoryBucketName, "myFile.orc")
+	localFile := reader.orcFilesByKey[cacheKey].localFilename
+	if filepath.Dir(localFile) != tempDir {
+		t.Fatalf("expected file %s to be created under %s", localFile, tempDir)
+	}
+	reader.clean(cacheKey)
+	if _, err = os.Stat(localFile); !os.IsNotExist(err) {
+		t.Fatalf("expected local file %s to be removed after clean", localFile)
+	}
+
+	reader = NewReader(context.Background(), svc, logging.Default(), WithTempDir(filepath.Join(tempDir, "missing")))
+	_, err = reader.GetFileReader(OrcFormatName, "", inventoryBucketName, "myFile.orc")
+	if !errors.Is(err, ErrInvalidTempDir) {
+		t.Fatalf("expected error %v, got %v", ErrInvalidTempDir, err)
+	}
+}
+
+func TestTempFileFactory(t *testing.T) {
+	svc := newTestInventoryBucket(t)
+	uploadFile(t, svc, inventoryBucketName, "myFile.orc", objs(10, []time.Time{time.Now()}))
+	uploadCSV(t, svc, "myFile.csv.gz", []string{`"my-bucket","f1","100","2020-06-27T00:00:00.000Z","abc"`})
+	filesDir, err := ioutil.TempDir("", "inventory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(filesDir)
+	}()
+	var mu sync.Mutex
+	var created []string
+	factory := func(dir string, pattern string) (*os.File, error) {
+		if dir != "" {
+			t.Errorf("unexpected temp dir %s passed to factory", dir)
+		}
+		f, err := ioutil.TempFile(filesDir, pattern)
+		if err == nil {
+			mu.Lock()
+			created = append(created, f.Name())
+			mu.Unlock()
+		}
+		return f, err
+	}
+	reader := NewReader(context.Background(), svc, logging.Default(), WithTempFileFactory(factory))
+	if err = reader.PrefetchAll(context.Background(), inventoryBucketName, []string{"myFile.orc"}, 1); err != nil {
+		t.Fatal(err)
+	}
+	cacheKey := fileCacheKey(inventoryBucketName, "myFile.orc")
+	if localFile := reader.orcFilesByKey[cacheKey].localFilename; len(created) != 1 || localFile != created[0] {
+		t.Fatalf("expected prefetched file to be created by factory, got %s (created: %v)", localFile, created)
+	}
+	reader.clean(cacheKey)
+	fileReader, err := reader.GetFileReader(CSVFormatName, "Bucket, Key, Size, LastModifiedDate, ETag", inventoryBucketName, "myFile.csv.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := make([]InventoryObject, 10)
+	if err = fileReader.Read(&res); err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 1 || res[0].Key != "f1" {
+		t.Fatalf("unexpected objects read: %v", res)
+	}
+	_ = fileReader.Close()
+	if len(created) != 2 {
+		t.Fatalf("expected 2 files to be created by factory, got %v", created)
+	}
+	for _, name := range created {
+		if _, err = os.Stat(name); !os.IsNotExist(err) {
+			t.Fatalf("expected local file %s to be removed", name)
+		}
+	}
+
+	errFactory := errors.New("no temp files")
+	reader = NewReader(context.Background(), svc, logging.Default(), WithTempFileFactory(func(string, string) (*os.File, error) {
+		return nil, errFactory
+	}))
+	if _, err = reader.GetFileReader(OrcFormatName, "", inventoryBucketName, "myFile.orc"); !errors.Is(err, errFactory) {
+		t.Fatalf("expected error %v, got %v", errFactory, err)
+	}
+}
+
+func TestLocalFileReader(t *testing.T) {
+	localOrcFile := generateOrc(t, objs(10, []time.Time{time.Now()}))
+	fileReader, err := NewLocalFileReader(context.Background(), OrcFormatName, "", localOrcFile, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fileReader.GetNumRows() != 10 {
+		t.Fatalf("unexpected result from GetNumRows. expected=10, got=%d", fileReader.GetNumRows())
+	}
+	res := make([]InventoryObject, 10)
+	if err = fileReader.Read(&res); err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 10 || res[9].Key != "f00009" {
+		t.Fatalf("unexpected rows read from local file: %d rows", len(res))
+	}
+	if err = fileReader.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(localOrcFile); !os.IsNotExist(err) {
+		t.Fatalf("expected local file %s to be removed after close", localOrcFile)
+	}
+
+	_, err = NewLocalFileReader(context.Background(), "JSON", "", localOrcFile, false)
+	if !errors.Is(err, ErrUnsupportedInventoryFormat) {
+		t.Fatalf("expected error %v, got %v", ErrUnsupportedInventoryFormat, err)
+	}
+}
+
+func TestLocalFileReaderRemoveOnCloseOptionalMethods(t *testing.T) {
+	localOrcFile := generateOrc(t, objs(10, []time.Time{time.Now()}))
+	orcReader, err := NewLocalFileReader(context.Background(), OrcFormatName, "", localOrcFile, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	skipper, ok := orcReader.(interface{ SkipRows(int64) error })
+	if !ok {
+		t.Fatalf("expected local ORC reader %T to skip rows", orcReader)
+	}
+	if err = skipper.SkipRows(5); err != nil {
+		t.Fatal(err)
+	}
+	res := make([]InventoryObject, 10)
+	if err = orcReader.Read(&res); err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 5 || res[0].Key != "f00005" {
+		t.Fatalf("unexpected rows read after skipping: %d rows", len(res))
+	}
+	if err = orcReader.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(localOrcFile); !os.IsNotExist(err) {
+		t.Fatalf("expected local file %s to be removed after close", localOrcFile)
+	}
+
+	f, err := ioutil.TempFile("", "parquettest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = f.Write(generateParquet(t, 4, objs(10, []time.Time{time.Now()}))); err != nil {
+		t.Fatal(err)
+	}
+	if err = f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	parquetReader, err := NewLocalFileReader(context.Background(), ParquetFormatName, "", f.Name(), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rowReader, ok := parquetReader.(RowReader)
+	if !ok {
+		t.Fatalf("expected local Parquet reader %T to implement RowReader", parquetReader)
+	}
+	if _, ok = parquetReader.(interface{ RowGroupStatistics() []RowGroupStatistics }); !ok {
+		t.Fatalf("expected local Parquet reader %T to return row group statistics", parquetReader)
+	}
+	obj, ok, err := rowReader.ReadOne()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok || obj.Key != "f00000" {
+		t.Fatalf("unexpected first object %+v, ok=%t", obj, ok)
+	}
+	if err = parquetReader.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(f.Name()); !os.IsNotExist(err) {
+		t.Fatalf("expected local file %s to be removed after close", f.Name())
+	}
+}
+
+type parquetRenamedRow struct {
+	Name          string  `parquet:"name=Name, type=UTF8"`
+	ContentLength *int64  `parquet:"name=Content-Length, type=INT_64"`
+	Etag          *string `parquet:"name=Etag, type=UTF8"`
+}
+
+func TestLocalReader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "local_inventory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	manifestPath := filepath.Join(dir, "manifest.json")
+	if err = ioutil.WriteFile(manifestPath, []byte(`{"fileFormat": "ORC", "files": [{"key": "data/f1.orc"}]}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Mkdir(filepath.Join(dir, "data"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	localOrcFile := generateOrc(t, objs(100, []time.Time{time.Now()}))
+	orcData, err := ioutil.ReadFile(localOrcFile)
+	_ = os.Remove(localOrcFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(filepath.Join(dir, "data", "f1.orc"), orcData, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	parquetData := generateParquet(t, 50, objs(100, []time.Time{time.Now()}))
+	if err = ioutil.WriteFile(filepath.Join(dir, "data", "f1.parquet"), parquetData, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	reader := NewLocalReader(context.Background(), manifestPath, logging.Default())
+	for _, test := range []struct {
+		format string
+		key    string
+	}{
+		{format: OrcFormatName, key: "data/f1.orc"},
+		{format: ParquetFormatName, key: "data/f1.parquet"},
+	} {
+		it := NewInventoryIterator(reader, test.format, "", inventoryBucketName, []string{test.key})
+		count := 0
+		for it.Next() {
+			if it.Get().Key != fmt.Sprintf("f%05d", count) {
+				t.Fatalf("unexpected key at index %d in %s. expected=%s, got=%s", count, test.key, fmt.Sprintf("f%05d", count), it.Get().Key)
+			}
+			count++
+		}
+		if it.Err() != nil {
+			t.Fatal(it.Err())
+		}
+		if count != 100 {
+			t.Fatalf("unexpected number of objects in %s. expected=%d, got=%d", test.key, 100, count)
+		}
+		if err = it.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = os.Stat(filepath.Join(dir, filepath.FromSlash(test.key))); err != nil {
+			t.Fatalf("expected local file to be kept after read: %s", err)
+		}
+	}
+	_, err = reader.GetFileReader(OrcFormatName, "", inventoryBucketName, "data/missing.orc")
+	if !errors.Is(err, ErrManifestFileNotFound) {
+		t.Fatalf("expected error %v, got %v", ErrManifestFileNotFound, err)
+	}
+	_, err = reader.GetFileReader(OrcFormatName, "", inventoryBucketName, "manifest.json")
+	if !errors.Is(err, ErrInventoryParse) {
+		t.Fatalf("expected error %v, got %v", ErrInventoryParse, err)
+	}
+}
+
+func TestLocalFileReaderColumnNames(t *testing.T) {
+	var buf bytes.Buffer
+	pw, err := writer.NewParquetWriter(writerfile.NewWriterFile(&buf), new(parquetRenamedRow), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows := []parquetRenamedRow{
+		{Name: "k1", ContentLength: swag.Int64(1), Etag: swag.String("e1")},
+		{Name: "k2", ContentLength: swag.Int64(2), Etag: swag.String("e2")},
+	}
+	for _, row := range rows {
+		if err = pw.Write(row); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = pw.WriteStop(); err != nil {
+		t.Fatal(err)
+	}
+	f, err := ioutil.TempFile("", "renamed.parquet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.Remove(f.Name())
+	}()
+	if _, err = f.Write(buf.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	_ = f.Close()
+
+	names := ColumnNames{"key": "Name", "size": "Content-Length", "e_tag": "Etag"}
+	_, err = NewLocalFileReader(context.Background(), ParquetFormatName, "", f.Name(), false, WithColumnNames(names))
+	if !errors.Is(err, ErrMissingInventoryColumn) {
+		t.Fatalf("expected error %v for file with no bucket column, got %v", ErrMissingInventoryColumn, err)
+	}
+	fileReader, err := NewLocalFileReader(context.Background(), ParquetFormatName, "", f.Name(), false,
+		WithColumnNames(names), WithBucket("container"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = fileReader.Close()
+	}()
+	res := make([]InventoryObject, len(rows))
+	if err = fileReader.Read(&res); err != nil {
+		t.Fatal(err)
+	}
+	expected := []InventoryObject{
+		{Bucket: "container", Key: "k1", Size: swag.Int64(1), Checksum: swag.String("e1")},
+		{Bucket: "container", Key: "k2", Size: swag.Int64(2), Checksum: swag.String("e2")},
+	}
+	if diff := deep.Equal(res, expected); diff != nil {
+		t.Fatalf("unexpected result: %s", diff)
+	}
+}
+
+func TestLocalFileReaderOrcColumnNames(t *testing.T) {
+	typeDescription, err := orc.NewTypeDescription(orc.SetCategory(orc.CategoryStruct),
+		orc.AddField("Name", orc.SetCategory(orc.CategoryString)),
+		orc.AddField("Content-Length", orc.SetCategory(orc.CategoryLong)),
+		orc.AddField("Etag", orc.SetCategory(orc.CategoryString)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := ioutil.TempFile("", "renamed.orc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.Remove(f.Name())
+	}()
+	w, err := orc.NewWriter(f, orc.SetSchema(typeDescription))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, row := range [][]interface{}{{"k1", int64(1), "e1"}, {"k2", int64(2), "e2"}} {
+		if err = w.Write(row...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	_ = f.Close()
+
+	names := ColumnNames{"key": "Name", "size": "Content-Length", "e_tag": "Etag"}
+	_, err = NewLocalFileReader(context.Background(), OrcFormatName, "", f.Name(), false, WithColumnNames(names))
+	if !errors.Is(err, ErrMissingInventoryColumn) {
+		t.Fatalf("expected error %v for file with no bucket column, got %v", ErrMissingInventoryColumn, err)
+	}
+	fileReader, err := NewLocalFileReader(context.Background(), OrcFormatName, "", f.Name(), false,
+		WithColumnNames(names), WithBucket("container"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = fileReader.Close()
+	}()
+	if fileReader.FirstObjectKey() != "k1" || fileReader.LastObjectKey() != "k2" {
+		t.Fatalf("unexpected key range: %s-%s", fileReader.FirstObjectKey(), fileReader.LastObjectKey())
+	}
+	res := make([]InventoryObject, 2)
+	if err = fileReader.Read(&res); err != nil {
+		t.Fatal(err)
+	}
+	expected := []InventoryObject{
+		{Bucket: "container", Key: "k1", Size: swag.Int64(1), Checksum: swag.String("e1")},
+		{Bucket: "container", Key: "k2", Size: swag.Int64(2), Checksum: swag.String("e2")},
+	}
+	if diff := deep.Equal(res, expected); diff != nil {
+		t.Fatalf("unexpected result: %s", diff)
+	}
+}
+
+// flakyS3Client fails the first getObjectFailures calls to GetObjectWithContext with the given error.
+type flakyS3Client struct {
+	countingS3Client
+	getObjectFailures int32
+	err               error
+}
+
+func (c *flakyS3Client) GetObjectWithContext(ctx aws.Context, input *s3.GetObjectInput, opts ...request.Option) (*s3.GetObjectOutput, error) {
+	if atomic.AddInt32(&c.getObjectCalls, 1) <= c.getObjectFailures {
+		return nil, c.err
+	}
+	return c.S3API.GetObjectWithContext(ctx, input, opts...)
+}
+
+func TestCacheDir(t *testing.T) {
+	svc := newTestInventoryBucket(t)
+	cacheDir, err := ioutil.TempDir("", "inventory_cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(cacheDir)
+	}()
+	lastModified := []time.Time{time.Now()}
+	uploadFile(t, svc, inventoryBucketName, "f1.orc", objs(10, lastModified))
+	uploadFile(t, svc, inventoryBucketName, "f2.orc", objs(20, lastModified))
+	counter := &countingS3Client{S3API: svc}
+	readRows := func(key string) int64 {
+		// a new reader for every read, as in separate runs
+		reader := NewReader(context.Background(), counter, logging.Default(), WithCacheDir(cacheDir))
+		fileReader, err := reader.GetFileReader(OrcFormatName, "", inventoryBucketName, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() {
+			if err := fileReader.Close(); err != nil {
+				t.Fatal(err)
+			}
+		}()
+		return fileReader.GetNumRows()
+	}
+	expectDownloads := func(expected int32) {
+		t.Helper()
+		if calls := atomic.LoadInt32(&counter.getObjectCalls); calls != expected {
+			t.Fatalf("unexpected number of downloads. expected=%d, got=%d", expected, calls)
+		}
+	}
+	if rows := readRows("f1.orc"); rows != 10 {
+		t.Fatalf("unexpected number of rows. expected=%d, got=%d", 10, rows)
+	}
+	expectDownloads(1)
+	if rows := readRows("f1.orc"); rows != 10 {
+		t.Fatalf("unexpected number of rows from cached file. expected=%d, got=%d", 10, rows)
+	}
+	expectDownloads(1)
+	readRows("f2.orc")
+	expectDownloads(2)
+
+	// a changed object is downloaded again
+	uploadFile(t, svc, inventoryBucketName, "f1.orc", objs(30, lastModified))
+	if rows := readRows("f1.orc"); rows != 30 {
+		t.Fatalf("unexpected number of rows from changed file. expected=%d, got=%d", 30, rows)
+	}
+	expectDownloads(3)
+
+	// prefetched files in the cache dir are kept once read
+	reader := NewReader(context.Background(), counter, logging.Default(), WithCacheDir(cacheDir))
+	if err = reader.PrefetchAll(context.Background(), inventoryBucketName, []string{"f1.orc", "f2.orc"}, 2); err != nil {
+		t.Fatal(err)
+	}
+	expectDownloads(3)
+	fileReader, err := reader.GetFileReader(OrcFormatName, "", inventoryBucketName, "f2.orc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = fileReader.Close(); err != nil {
+		t.Fatal(err)
+	}
+	cached, err := ioutil.ReadDir(cacheDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cached) != 3 {
+		t.Fatalf("unexpected number of cached files. expected=%d, got=%d", 3, len(cached))
+	}
+	readRows("f2.orc")
+	expectDownloads(3)
+
+	reader = NewReader(context.Background(), svc, logging.Default(), WithCacheDir(filepath.Join(cacheDir, "missing")))
+	_, err = reader.GetFileReader(OrcFormatName, "", inventoryBucketName, "f2.orc")
+	if !errors.Is(err, ErrInvalidCacheDir) {
+		t.Fatalf("expected error %v, got %v", ErrInvalidCacheDir, err)
+	}
+}
+
+// wrongRegionS3Client redirects all requests reading objects, as S3 does for buckets of another region.
+type wrongRegionS3Client struct {
+	s3iface.S3API
+	region                 string
+	getBucketLocationCalls int32
+}
+
+func (c *wrongRegionS3Client) GetObjectWithContext(aws.Context, *s3.GetObjectInput, ...request.Option) (*s3.GetObjectOutput, error) {
+	return nil, awserr.NewRequestFailure(awserr.New("PermanentRedirect", "permanent redirect", nil), http.StatusMovedPermanently, "")
+}
+
+func (c *wrongRegionS3Client) HeadObjectWithContext(aws.Context, *s3.HeadObjectInput, ...request.Option) (*s3.HeadObjectOutput, error) {
+	return nil, awserr.NewRequestFailure(awserr.New("MovedPermanently", "moved permanently", nil), http.StatusMovedPermanently, "")
+}
+
+func (c *wrongRegionS3Client) GetBucketLocationWithContext(aws.Context, *s3.GetBucketLocationInput, ...request.Option) (*s3.GetBucketLocationOutput, error) {
+	atomic.AddInt32(&c.getBucketLocationCalls, 1)
+	return &s3.GetBucketLocationOutput{LocationConstraint: aws.String(c.region)}, nil
+}
+
+func TestBucketRegionRedirect(t *testing.T) {
+	svc := newTestInventoryBucket(t)
+	uploadFile(t, svc, inventoryBucketName, "myFile.orc", objs(10, []time.Time{time.Now()}))
+	uploadBytes(t, svc, "myFile.parquet", generateParquet(t, 5000, objs(10, []time.Time{time.Now()})))
+	wrongRegion := &wrongRegionS3Client{S3API: svc, region: "eu-west-1"}
+	regional := &countingS3Client{S3API: svc}
+	var regions []string
+	reader := NewReader(context.Background(), wrongRegion, logging.Default(), WithDownloadRetries(0),
+		WithRegionalClientFactory(func(region string) (s3iface.S3API, error) {
+			regions = append(regions, region)
+			return regional, nil
+		}))
+	for _, test := range []struct {
+		format string
+		key    string
+	}{
+		{format: OrcFormatName, key: "myFile.orc"},
+		{format: ParquetFormatName, key: "myFile.parquet"},
+		{format: OrcFormatName, key: "myFile.orc"},
+	} {
+		fileReader, err := reader.GetFileReader(test.format, "", inventoryBucketName, test.key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fileReader.GetNumRows() != 10 {
+			t.Fatalf("unexpected number of rows in %s. expected=%d, got=%d", test.key, 10, fileReader.GetNumRows())
+		}
+		if err = fileReader.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if metadataReader, err := reader.GetMetadataReader(OrcFormatName, "", inventoryBucketName, "myFile.orc"); err != nil {
+		t.Fatal(err)
+	} else {
+		_ = metadataReader.Close()
+	}
+	if diff := deep.Equal(regions, []string{"eu-west-1"}); diff != nil {
+		t.Fatalf("unexpected regional clients created: %s", diff)
+	}
+	if calls := atomic.LoadInt32(&wrongRegion.getBucketLocationCalls); calls != 1 {
+		t.Fatalf("expected bucket region to be resolved once, got %d lookups", calls)
+	}
+	if atomic.LoadInt32(&regional.getObjectCalls) == 0 {
+		t.Fatal("expected objects to be read with the regional client")
+	}
+
+	// a bucket no client can be created for fails with the redirect
+	reader = NewReader(context.Background(), wrongRegion, logging.Default(), WithDownloadRetries(0))
+	_, err := reader.GetFileReader(OrcFormatName, "", inventoryBucketName, "myFile.orc")
+	if !isRegionMismatchError(err) || !errors.Is(err, ErrInventoryDownload) {
+		t.Fatalf("expected redirect download error, got %v", err)
+	}
+}
+
+type logEntry struct {
+	fields  logging.Fields
+	message string
+}
+
+// capturingLogger records the debug and error messages logged through it, with their fields.
+type capturingLogger struct {
+	logging.DummyLogger
+	fields  logging.Fields
+	mu      *sync.Mutex
+	entries *[]logEntry
+}
+
+func newCapturingLogger() *capturingLogger {
+	return &capturingLogger{fields: logging.Fields{}, mu: &sync.Mutex{}, entries: &[]logEntry{}}
+}
+
+func (l *capturingLogger) WithField(key string, value interface{}) logging.Logger {
+	return l.WithFields(logging.Fields{key: value})
+}
+
+func (l *capturingLogger) WithFields(fields logging.Fields) logging.Logger {
+	merged := make(logging.Fields, len(l.fields)+len(fields))
+	for k, v := range l.fields {
+		merged[k] = v
+	}
+	for k, v := range fields {
+		merged[k] = v
+	}
+	return &capturingLogger{fields: merged, mu: l.mu, entries: l.entries}
+}
+
+func (l *capturingLogger) WithError(err error) logging.Logger {
+	return l.WithField("error", err)
+}
+
+func (l *capturingLogger) log(message string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	*l.entries = append(*l.entries, logEntry{fields: l.fields, message: message})
+}
+
+func (l *capturingLogger) Debug(args ...interface{}) {
+	l.log(fmt.Sprint(args...))
+}
+
+func (l *capturingLogger) Debugf(format string, args ...interface{}) {
+	l.log(fmt.Sprintf(format, args...))
+}
+
+func (l *capturingLogger) Error(args ...interface{}) {
+	l.log(fmt.Sprint(args...))
+}
+
+func (l *capturingLogger) Errorf(format string, args ...interface{}) {
+	l.log(fmt.Sprintf(format, args...))
+}
+
+func TestDownloadLogFields(t *testing.T) {
+	svc := newTestInventoryBucket(t)
+	uploadFile(t, svc, inventoryBucketName, "myFile.orc", objs(10, []time.Time{time.Now()}))
+	logger := newCapturingLogger()
+	reader := NewReader(context.Background(), svc, logger)
+	fileReader, err := reader.GetFileReader(OrcFormatName, "", inventoryBucketName, "myFile.orc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = fileReader.Close()
+	messages := make(map[string]bool)
+	for _, entry := range *logger.entries {
+		messages[entry.message] = true
+		if entry.fields["key"] != "myFile.orc" {
+			t.Fatalf("expected key field on log entry %q, got fields %v", entry.message, entry.fields)
+		}
+		if entry.fields["bucket"] != inventoryBucketName || entry.fields["format"] != OrcFormatName || entry.fields["local_file"] == nil {
+			t.Fatalf("missing fields on log entry %q, got fields %v", entry.message, entry.fields)
+		}
+	}
+	for _, message := range []string{"start downloading inventory file", "finished downloading inventory file"} {
+		if !messages[message] {
+			t.Fatalf("expected log message %q, got %v", message, messages)
+		}
+	}
+}
+
+func TestOrcBadRowIndex(t *testing.T) {
+	svc := newTestInventoryBucket(t)
+	// stripes of 10000 rows, the second of which has keys of another prefix than the bad row in the third one
+	schema, err := orc.ParseSchema("struct<bucket:string,key:string>")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	w, err := orc.NewWriter(&buf, orc.SetSchema(schema))
+	if err != nil {
+		t.Fatal(err)
+	}
+	const numRows = 25000
+	for i := 0; i < numRows; i++ {
+		var key interface{} = fmt.Sprintf("%c%05d", "acb"[i/10000], i)
+		if i == 20005 {
+			key = nil
+		}
+		if err = w.Write("b", key); err != nil {
+			t.Fatal(err)
+		}
+		if i%10000 == 9999 {
+			if err = w.Flush(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	uploadBytes(t, svc, "bad_row_stripes.orc", buf.Bytes())
+	testdata := []struct {
+		name            string
+		opts            []ReaderOption
+		expectedSkipped int
+	}{
+		{name: "all_stripes"},
+		{name: "skipped_stripe", opts: []ReaderOption{WithKeyPrefix("b")}, expectedSkipped: 1},
+	}
+	for _, test := range testdata {
+		t.Run(test.name, func(t *testing.T) {
+			var badRows []int64
+			opts := append(test.opts, WithOnBadRow(func(fileKey string, rowIndex int64, err error) bool {
+				badRows = append(badRows, rowIndex)
+				return true
+			}))
+			reader := NewReader(context.Background(), svc, logging.Default(), opts...)
+			fileReader, err := reader.GetFileReader(OrcFormatName, "", inventoryBucketName, "bad_row_stripes.orc")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() { _ = fileReader.Close() }()
+			res := make([]InventoryObject, numRows)
+			if err = readBatch(fileReader, &res); err != nil {
+				t.Fatal(err)
+			}
+			r := fileReader.(*OrcInventoryFileReader)
+			if r.skippedStripes != test.expectedSkipped {
+				t.Fatalf("unexpected number of skipped stripes. expected=%d, got=%d", test.expectedSkipped, r.skippedStripes)
+			}
+			// the row offset is kept as stripes are selected, and counted again once stripes were skipped
+			if r.nextStripeRow != numRows {
+				t.Fatalf("unexpected row offset after the last stripe. expected=%d, got=%d", numRows, r.nextStripeRow)
+			}
+			if diff := deep.Equal(badRows, []int64{20005}); diff != nil {
+				t.Fatalf("unexpected bad rows: %s", diff)
+			}
+		})
+	}
+}
+
+func TestOrcObjectKeyRange(t *testing.T) {
+	svc := newTestInventoryBucket(t)
+	// the ORC writer starts a new stripe every 10000 rows, ending files of a multiple of 10000 rows with an empty stripe
+	uploadFile(t, svc, inventoryBucketName, "stripes.orc", objs(25000, []time.Time{time.Now()}))
+	uploadFile(t, svc, inventoryBucketName, "empty_stripe.orc", objs(30000, []time.Time{time.Now()}))
+	uploadOrcWithSchema(t, svc, "empty.orc", "struct<bucket:string,key:string>")
+	reader := NewReader(context.Background(), svc, logging.Default())
+	openMetadata := func(key string) *OrcInventoryFileReader {
+		mr, err := reader.GetMetadataReader(OrcFormatName, "", inventoryBucketName, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = mr.Close() })
+		return mr.(*OrcInventoryFileReader)
+	}
+
+	r := openMetadata("stripes.orc")
+	numStripes, err := r.reader.NumStripes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if numStripes != 3 {
+		t.Fatalf("unexpected number of stripes. expected=3, got=%d", numStripes)
+	}
+	// the last key is the maximal key of the last stripe
+	if _, lastStripeMax, _ := r.stripeKeyRange(numStripes - 1); lastStripeMax != "f24999" {
+		t.Fatalf("unexpected maximal key of the last stripe. expected=f24999, got=%s", lastStripeMax)
+	}
+	if first, last := r.FirstObjectKey(), r.LastObjectKey(); first != "f00000" || last != "f24999" {
+		t.Fatalf("unexpected key range. expected=f00000..f24999, got=%s..%s", first, last)
+	}
+
+	// trailing stripes with no key statistics are skipped
+	r = openMetadata("empty_stripe.orc")
+	if _, _, ok := r.stripeKeyRange(len(r.reader.Metadata().GetStripeStats()) - 1); ok {
+		t.Fatal("expected no key statistics for the empty last stripe")
+	}
+	if last := r.LastObjectKey(); last != "f29999" {
+		t.Fatalf("unexpected last key. expected=f29999, got=%s", last)
+	}
+
+	// files with no stripes have no key range
+	r = openMetadata("empty.orc")
+	if first, last := r.FirstObjectKey(), r.LastObjectKey(); first != "" || last != "" {
+		t.Fatalf("expected no key range for a file with no stripes, got %s..%s", first, last)
+	}
+}
+
+func TestOrcManifestFileEntry(t *testing.T) {
+	svc := newTestInventoryBucket(t)
+	uploadFile(t, svc, inventoryBucketName, "f1.orc", objs(1000, []time.Time{time.Now()}))
+	uploadFile(t, svc, inventoryBucketName, "f2.orc", objs(500, []time.Time{time.Now()}))
+	uploadFile(t, svc, inventoryBucketName, "f3.orc", objs(10, []time.Time{time.Now()}))
+	checksums := make(map[string]FileChecksum)
+	for _, key := range []string{"f1.orc", "f2.orc"} {
+		head, err := svc.HeadObject(&s3.HeadObjectInput{Bucket: aws.String(inventoryBucketName), Key: aws.String(key)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		checksums[key] = FileChecksum{Size: *head.ContentLength}
+	}
+	logger := newCapturingLogger()
+	reader := NewReader(context.Background(), svc, logger, WithFileChecksums(checksums))
+	for _, key := range []string{"f1.orc", "f2.orc", "f3.orc"} {
+		fileReader, err := reader.GetFileReader(OrcFormatName, "", inventoryBucketName, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if entry := fileReader.(*OrcInventoryFileReader).manifestFile; entry != checksums[key] {
+			t.Fatalf("unexpected manifest entry of %s. expected=%+v, got=%+v", key, checksums[key], entry)
+		}
+		res := make([]InventoryObject, 1000)
+		if err = fileReader.Read(&res); err != nil {
+			t.Fatal(err)
+		}
+		_ = fileReader.Close()
+	}
+	logged := make(map[string]bool)
+	for _, entry := range *logger.entries {
+		if entry.message != "orc file read progress" {
+			continue
+		}
+		key := entry.fields["key"].(string)
+		if entry.fields["declared_size"] != checksums[key].Size {
+			t.Fatalf("unexpected declared size logged for %s. expected=%d, got=%v", key, checksums[key].Size, entry.fields["declared_size"])
+		}
+		logged[key] = true
+	}
+	if diff := deep.Equal(logged, map[string]bool{"f1.orc": true, "f2.orc": true}); diff != nil {
+		t.Fatalf("unexpected files logging read progress: %s", diff)
+	}
+}
+
+func TestDownloadProgress(t *testing.T) {
+	svc := newTestInventoryBucket(t)
+	uploadFile(t, svc, inventoryBucketName, "myFile.orc", objs(35000, []time.Time{time.Now()}))
+	head, err := svc.HeadObject(&s3.HeadObjectInput{Bucket: aws.String(inventoryBucketName), Key: aws.String("myFile.orc")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	size := *head.ContentLength
+	testdata := []struct {
+		name      string
+		checksums map[string]FileChecksum
+	}{
+		{name: "size from s3"},
+		{name: "declared size", checksums: map[string]FileChecksum{"myFile.orc": {Size: size}}},
+	}
+	for _, test := range testdata {
+		t.Run(test.name, func(t *testing.T) {
+			var downloaded []int64
+			progress := func(key string, bytesDownloaded int64, totalBytes int64) {
+				if key != "myFile.orc" {
+					t.Errorf("unexpected key %s reported", key)
+				}
+				if totalBytes != size {
+					t.Errorf("unexpected total bytes. expected=%d, got=%d", size, totalBytes)
+				}
+				downloaded = append(downloaded, bytesDownloaded)
+			}
+			reader := NewReader(context.Background(), svc, logging.Default(), WithDownloadProgress(progress), WithFileChecksums(test.checksums))
+			reader.progressInterval = size / 10
+			fileReader, err := reader.GetFileReader(OrcFormatName, "", inventoryBucketName, "myFile.orc")
+			if err != nil {
+				t.Fatal(err)
+			}
+			_ = fileReader.Close()
+			if len(downloaded) < 3 {
+				t.Fatalf("expected progress to be reported several times, got %v", downloaded)
+			}
+			for i := 1; i < len(downloaded); i++ {
+				if downloaded[i] < downloaded[i-1] {
+					t.Fatalf("expected increasing byte counts, got %v", downloaded)
+				}
+			}
+			if downloaded[len(downloaded)-1] != size {
+				t.Fatalf("expected last progress to report %d bytes, got %v", size, downloaded)
+			}
+		})
+	}
+}
+
+func TestDownloadRetry(t *testing.T) {
+	svc := newTestInventoryBucket(t)
+	uploadFile(t, svc, inventoryBucketName, "myFile.orc", objs(100, []time.Time{time.Now()}))
+	unavailable := awserr.NewRequestFailure(awserr.New("ServiceUnavailable", "service unavailable", nil), http.StatusServiceUnavailable, "")
+	forbidden := awserr.NewRequestFailure(awserr.New("AccessDenied", "access denied", nil), http.StatusForbidden, "")
+	testdata := []struct {
+		name          string
+		failures      int32
+		err           error
+		retries       int
+		expectedCalls int32
+		expectedErr   bool
+	}{
+		{name: "succeed on third attempt", failures: 2, err: unavailable, retries: 3, expectedCalls: 3},
+		{name: "retries exhausted", failures: 2, err: unavailable, retries: 1, expectedCalls: 2, expectedErr: true},
+		{name: "not retryable", failures: 1, err: forbidden, retries: 3, expectedCalls: 1, expectedErr: true},
+	}
+	for _, test := range testdata {
+		t.Run(test.name, func(t *testing.T) {
+			client := &flakyS3Client{countingS3Client: countingS3Client{S3API: svc}, getObjectFailures: test.failures, err: test.err}
+			reader := NewReader(context.Background(), client, logging.Default(),
+				WithDownloadRetries(test.retries), WithDownloadBackoff(time.Millisecond))
+			fileReader, err := reader.GetFileReader(OrcFormatName, "", inventoryBucketName, "myFile.orc")
+			if client.getObjectCalls != test.expectedCalls {
+				t.Fatalf("unexpected number of download attempts. expected=%d, got=%d", test.expectedCalls, client.getObjectCalls)
+			}
+			if test.expectedErr {
+				if err == nil {
+					t.Fatal("expected error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() {
+				_ = fileReader.Close()
+			}()
+			if fileReader.GetNumRows() != 100 {
+				t.Fatalf("unexpected number of rows. expected=%d, got=%d", 100, fileReader.GetNumRows())
+			}
+		})
+	}
+}
+
+func TestDownloadRetryCancelled(t *testing.T) {
+	unavailable := awserr.NewRequestFailure(awserr.New("SlowDown", "slow down", nil), http.StatusServiceUnavailable, "")
+	client := &flakyS3Client{getObjectFailures: 1, err: unavailable}
+	ctx, cancel := context.WithCancel(context.Background())
+	reader := NewReader(ctx, client, logging.Default(), WithDownloadBackoff(time.Hour))
+	time.AfterFunc(10*time.Millisecond, cancel)
+	_, err := reader.GetFileReader(OrcFormatName, "", inventoryBucketName, "myFile.orc")
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected error %v, got %v", context.Canceled, err)
+	}
+}
+
+// generateParquet writes the given objects to a parquet file, starting a new row group every rowsPerGroup rows.
+func generateParquet(tb testing.TB, rowsPerGroup int, objs <-chan *InventoryObject) []byte {
+	var buf bytes.Buffer
+	pw, err := writer.NewParquetWriter(writerfile.NewWriterFile(&buf), new(InventoryObject), 1)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	i := 0
+	for o := range objs {
+		if err = pw.Write(*o); err != nil {
+			tb.Fatal(err)
+		}
+		i++
+		if i%rowsPerGroup == 0 {
+			if err = pw.Flush(true); err != nil {
+				tb.Fatal(err)
+			}
+		}
+	}
+	if err = pw.WriteStop(); err != nil {
+		tb.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestKeyPrefix(t *testing.T) {
+	svc := newTestInventoryBucket(t)
+	// the ORC writer starts a new stripe at most every 10000 rows
+	uploadFile(t, svc, inventoryBucketName, "myFile.orc", objs(30000, []time.Time{time.Now()}))
+	uploadBytes(t, svc, "myFile.parquet", generateParquet(t, 1000, objs(30000, []time.Time{time.Now()})))
+	testdata := []struct {
+		format string
+		key    string
+	}{
+		{format: OrcFormatName, key: "myFile.orc"},
+		{format: ParquetFormatName, key: "myFile.parquet"},
+	}
+	for _, test := range testdata {
+		t.Run(test.format, func(t *testing.T) {
+			reader := NewReader(context.Background(), svc, logging.Default(), WithKeyPrefix("f1"))
+			fileReader, err := reader.GetFileReader(test.format, "", inventoryBucketName, test.key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() {
+				_ = fileReader.Close()
+			}()
+			res := make([]InventoryObject, 30000)
+			if err = fileReader.Read(&res); err != nil {
+				t.Fatal(err)
+			}
+			if len(res) != 10000 {
+				t.Fatalf("unexpected number of objects read. expected=%d, got=%d", 10000, len(res))
+			}
+			for i, obj := range res {
+				if expected := fmt.Sprintf("f%05d", 10000+i); obj.Key != expected {
+					t.Fatalf("unexpected key at index %d. expected=%s, got=%s", i, expected, obj.Key)
+				}
+			}
+			// the file is still counted in full
+			if fileReader.GetNumRows() != 30000 {
+				t.Fatalf("unexpected number of rows. expected=%d, got=%d", 30000, fileReader.GetNumRows())
+			}
+			if r, ok := fileReader.(*ParquetInventoryFileReader); ok {
+				if err = r.Rewind(); err != nil {
+					t.Fatal(err)
+				}
+				res = make([]InventoryObject, 30000)
+				if err = r.Read(&res); err != nil {
+					t.Fatal(err)
+				}
+				if len(res) != 10000 {
+					t.Fatalf("unexpected number of objects read after rewind. expected=%d, got=%d", 10000, len(res))
+				}
+			}
+			var skipped int
+			switch r := fileReader.(type) {
+			case *OrcInventoryFileReader:
+				skipped = r.skippedStripes
+			case *ParquetInventoryFileReader:
+				skipped = r.skippedRowGroups
+			}
+			if skipped == 0 {
+				t.Fatal("expected parts of the file to be skipped")
+			}
+		})
+	}
+}
+
+func TestModifiedRange(t *testing.T) {
+	svc := newTestInventoryBucket(t)
+	base := time.Date(2020, 6, 27, 0, 0, 0, 0, time.UTC)
+	lastModified := make([]time.Time, 30)
+	for i := range lastModified {
+		lastModified[i] = base.Add(time.Duration(i) * time.Minute)
+	}
+	uploadFile(t, svc, inventoryBucketName, "myFile.orc", objs(len(lastModified), lastModified))
+	uploadBytes(t, svc, "myFile.parquet", generateParquet(t, 10, objs(len(lastModified), lastModified)))
+	testdata := []struct {
+		name                     string
+		opts                     []ReaderOption
+		expectedFirst            int
+		expectedLast             int
+		expectedSkippedRowGroups int
+	}{
+		{name: "after is exclusive", opts: []ReaderOption{WithModifiedAfter(base.Add(10 * time.Minute))}, expectedFirst: 11, expectedLast: 29, expectedSkippedRowGroups: 1},
+		{name: "before is exclusive", opts: []ReaderOption{WithModifiedBefore(base.Add(10 * time.Minute))}, expectedFirst: 0, expectedLast: 9, expectedSkippedRowGroups: 2},
+		{name: "between", opts: []ReaderOption{WithModifiedAfter(base.Add(5 * time.Minute)), WithModifiedBefore(base.Add(15 * time.Minute))}, expectedFirst: 6, expectedLast: 14, expectedSkippedRowGroups: 1},
+		{name: "skip groups", opts: []ReaderOption{WithModifiedAfter(base.Add(19 * time.Minute))}, expectedFirst: 20, expectedLast: 29, expectedSkippedRowGroups: 2},
+		{name: "sub-millisecond before", opts: []ReaderOption{WithModifiedBefore(base.Add(20*time.Minute + time.Microsecond))}, expectedFirst: 0, expectedLast: 20, expectedSkippedRowGroups: 0},
+	}
+	for _, test := range testdata {
+		for format, key := range map[string]string{OrcFormatName: "myFile.orc", ParquetFormatName: "myFile.parquet"} {
+			t.Run(test.name+"/"+format, func(t *testing.T) {
+				reader := NewReader(context.Background(), svc, logging.Default(), test.opts...)
+				fileReader, err := reader.GetFileReader(format, "", inventoryBucketName, key)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer func() {
+					_ = fileReader.Close()
+				}()
+				res := make([]InventoryObject, len(lastModified))
+				if err = fileReader.Read(&res); err != nil {
+					t.Fatal(err)
+				}
+				if len(res) != test.expectedLast-test.expectedFirst+1 {
+					t.Fatalf("unexpected number of objects read. expected=%d, got=%d", test.expectedLast-test.expectedFirst+1, len(res))
+				}
+				for i, obj := range res {
+					if expected := fmt.Sprintf("f%05d", test.expectedFirst+i); obj.Key != expected {
+						t.Fatalf("unexpected key at index %d. expected=%s, got=%s", i, expected, obj.Key)
+					}
+				}
+				if r, ok := fileReader.(*ParquetInventoryFileReader); ok && r.skippedRowGroups != test.expectedSkippedRowGroups {
+					t.Fatalf("unexpected number of skipped row groups. expected=%d, got=%d", test.expectedSkippedRowGroups, r.skippedRowGroups)
+				}
+			})
+		}
+	}
+}
+
+func BenchmarkParquetKeyPrefix(b *testing.B) {
+	data := generateParquet(b, 1000, objs(100000, []time.Time{time.Now()}))
+	f, err := ioutil.TempFile("", "benchmark.parquet")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer func() {
+		_ = os.Remove(f.Name())
+	}()
+	if _, err = f.Write(data); err != nil {
+		b.Fatal(err)
+	}
+	if err = f.Close(); err != nil {
+		b.Fatal(err)
+	}
+	for _, prefix := range []string{"", "f050"} {
+		b.Run("prefix="+prefix, func(b *testing.B) {
+			var skipped int
+			for i := 0; i < b.N; i++ {
+				pf, err := local.NewLocalFileReader(f.Name())
+				if err != nil {
+					b.Fatal(err)
+				}
+				r, err := newParquetInventoryFileReader(pf, objectFilter{keyPrefix: prefix}, nil, DefaultParquetConcurrency, fileLayout{})
+				if err != nil {
+					b.Fatal(err)
+				}
+				res := make([]InventoryObject, 100000)
+				if err = r.Read(&res); err != nil {
+					b.Fatal(err)
+				}
+				skipped = r.skippedRowGroups
+				_ = r.Close()
+			}
+			b.ReportMetric(float64(skipped), "skipped-row-groups")
+		})
+	}
+}
+
+var errCaptured = errors.New("request captured")
+
+// capturingS3Client records the input of GetObject and HeadObject requests, without sending them.
+type capturingS3Client struct {
+	s3iface.S3API
+	getObjectInput  *s3.GetObjectInput
+	headObjectInput *s3.HeadObjectInput
+}
+
+func (c *capturingS3Client) GetObjectWithContext(_ aws.Context, input *s3.GetObjectInput, _ ...request.Option) (*s3.GetObjectOutput, error) {
+	c.getObjectInput = input
+	return nil, errCaptured
+}
+
+func (c *capturingS3Client) HeadObjectWithContext(_ aws.Context, input *s3.HeadObjectInput, _ ...request.Option) (*s3.HeadObjectOutput, error) {
+	c.headObjectInput = input
+	return nil, errCaptured
+}
+
+func TestSSECustomerKey(t *testing.T) {
+	key := bytes.Repeat([]byte{'k'}, 32)
+	client := &capturingS3Client{}
+	reader := NewReader(context.Background(), client, logging.Default(), WithSSECustomerKey(key), WithDownloadRetries(0))
+	_, err := reader.GetFileReader(OrcFormatName, "", inventoryBucketName, "myFile.orc")
+	if !errors.Is(err, errCaptured) {
+		t.Fatalf("expected error %v, got %v", errCaptured, err)
+	}
+	input := client.getObjectInput
+	if input == nil {
+		t.Fatal("expected GetObject to be called")
+	}
+	if aws.StringValue(input.SSECustomerAlgorithm) != "AES256" {
+		t.Fatalf("unexpected SSE-C algorithm: %s", aws.StringValue(input.SSECustomerAlgorithm))
+	}
+	if aws.StringValue(input.SSECustomerKey) != string(key) {
+		t.Fatalf("unexpected SSE-C key: %s", aws.StringValue(input.SSECustomerKey))
+	}
+	if aws.StringValue(input.SSECustomerKeyMD5) == "" {
+		t.Fatal("expected SSE-C key MD5 to be set")
+	}
+
+	reader = NewReader(context.Background(), &capturingS3Client{}, logging.Default(), WithSSECustomerKey([]byte("short")))
+	_, err = reader.GetFileReader(OrcFormatName, "", inventoryBucketName, "myFile.orc")
+	if !errors.Is(err, ErrInvalidSSECustomerKey) {
+		t.Fatalf("expected error %v, got %v", ErrInvalidSSECustomerKey, err)
+	}
+}
+
+func TestNewReaderWithSSECustomerKey(t *testing.T) {
+	if _, err := NewReaderWithSSECustomerKey(context.Background(), &capturingS3Client{}, logging.Default(), []byte("short")); !errors.Is(err, ErrInvalidSSECustomerKey) {
+		t.Fatalf("expected error %v, got %v", ErrInvalidSSECustomerKey, err)
+	}
+	key := bytes.Repeat([]byte{'k'}, 32)
+	client := &capturingS3Client{}
+	reader, err := NewReaderWithSSECustomerKey(context.Background(), client, logging.Default(), key, WithDownloadRetries(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = reader.GetFileReader(OrcFormatName, "", inventoryBucketName, "myFile.orc")
+	if !errors.Is(err, errCaptured) {
+		t.Fatalf("expected error %v, got %v", errCaptured, err)
+	}
+	if client.getObjectInput == nil || aws.StringValue(client.getObjectInput.SSECustomerKey) != string(key) {
+		t.Fatal("expected GetObject to be called with the SSE-C key")
+	}
+}
+
+// encryptedS3Client answers GetObject and HeadObject requests for objects encrypted as given, with an empty body.
+type encryptedS3Client struct {
+	s3iface.S3API
+	encryption *string
+	kmsKeyID   *string
+	options    []request.Option
+}
+
+func (c *encryptedS3Client) GetObjectWithContext(_ aws.Context, _ *s3.GetObjectInput, opts ...request.Option) (*s3.GetObjectOutput, error) {
+	c.options = append(c.options, opts...)
+	return &s3.GetObjectOutput{
+		Body:                 ioutil.NopCloser(strings.NewReader("")),
+		ServerSideEncryption: c.encryption,
+		SSEKMSKeyId:          c.kmsKeyID,
+	}, nil
+}
+
+func (c *encryptedS3Client) HeadObjectWithContext(_ aws.Context, _ *s3.HeadObjectInput, opts ...request.Option) (*s3.HeadObjectOutput, error) {
+	c.options = append(c.options, opts...)
+	return &s3.HeadObjectOutput{ServerSideEncryption: c.encryption, SSEKMSKeyId: c.kmsKeyID}, nil
+}
+
+func TestSSEKMSKeyID(t *testing.T) {
+	const keyARN = "arn:aws:kms:us-east-1:123456789012:key/example-key"
+	testdata := []struct {
+		name        string
+		keyID       string
+		encryption  *string
+		kmsKeyID    *string
+		expectedErr error
+	}{
+		{name: "same_arn", keyID: keyARN, encryption: aws.String("aws:kms"), kmsKeyID: aws.String(keyARN)},
+		{name: "same_id", keyID: "example-key", encryption: aws.String("aws:kms"), kmsKeyID: aws.String(keyARN)},
+		{name: "other_key", keyID: "other-key", encryption: aws.String("aws:kms"), kmsKeyID: aws.String(keyARN), expectedErr: ErrSSEKMSKeyMismatch},
+		{name: "sse_s3", keyID: keyARN, encryption: aws.String("AES256"), expectedErr: ErrSSEKMSKeyMismatch},
+		{name: "unencrypted", keyID: keyARN, expectedErr: ErrSSEKMSKeyMismatch},
+	}
+	for _, test := range testdata {
+		t.Run(test.name, func(t *testing.T) {
+			svc := &encryptedS3Client{encryption: test.encryption, kmsKeyID: test.kmsKeyID}
+			client := newSSEKMSKeyClient(svc, test.keyID)
+			input := &s3.GetObjectInput{Bucket: aws.String(inventoryBucketName), Key: aws.String("myFile.orc")}
+			if _, err := client.GetObjectWithContext(context.Background(), input); !errors.Is(err, test.expectedErr) {
+				t.Fatalf("GetObject: expected error %v, got %v", test.expectedErr, err)
+			}
+			headInput := &s3.HeadObjectInput{Bucket: aws.String(inventoryBucketName), Key: aws.String("myFile.orc")}
+			if _, err := client.HeadObjectWithContext(context.Background(), headInput); !errors.Is(err, test.expectedErr) {
+				t.Fatalf("HeadObject: expected error %v, got %v", test.expectedErr, err)
+			}
+			// S3 rejects reads sending SSE-KMS headers, so none are sent
+			req := &request.Request{HTTPRequest: &http.Request{Header: http.Header{}}}
+			req.ApplyOptions(svc.options...)
+			if len(req.HTTPRequest.Header) != 0 {
+				t.Fatalf("unexpected headers sent reading an SSE-KMS object: %v", req.HTTPRequest.Header)
+			}
+		})
+	}
+
+	svc := &encryptedS3Client{encryption: aws.String("aws:kms"), kmsKeyID: aws.String(keyARN)}
+	reader := NewReader(context.Background(), svc, logging.Default(), WithSSEKMSKeyID("other-key"), WithDownloadRetries(0))
+	if _, err := reader.GetFileReader(OrcFormatName, "", inventoryBucketName, "myFile.orc"); !errors.Is(err, ErrSSEKMSKeyMismatch) {
+		t.Fatalf("expected error %v, got %v", ErrSSEKMSKeyMismatch, err)
+	}
+
+	reader = NewReader(context.Background(), &capturingS3Client{}, logging.Default(), WithSSEKMSKeyID(""))
+	_, err := reader.GetFileReader(OrcFormatName, "", inventoryBucketName, "myFile.orc")
+	if !errors.Is(err, ErrInvalidSSEKMSKeyID) {
+		t.Fatalf("expected error %v, got %v", ErrInvalidSSEKMSKeyID, err)
+	}
+	if _, err = NewReaderWithSSEKMSKeyID(context.Background(), &capturingS3Client{}, logging.Default(), ""); !errors.Is(err, ErrInvalidSSEKMSKeyID) {
+		t.Fatalf("expected error %v, got %v", ErrInvalidSSEKMSKeyID, err)
+	}
+}
+
+func TestRequesterPays(t *testing.T) {
+	client := &capturingS3Client{}
+	reader := NewReader(context.Background(), client, logging.Default(), WithRequesterPays(true), WithDownloadRetries(0))
+	_, err := reader.GetFileReader(OrcFormatName, "", inventoryBucketName, "myFile.orc")
+	if !errors.Is(err, errCaptured) {
+		t.Fatalf("expected error %v, got %v", errCaptured, err)
+	}
+	if client.getObjectInput == nil || aws.StringValue(client.getObjectInput.RequestPayer) != s3.RequestPayerRequester {
+		t.Fatalf("expected GetObject request payer to be %s", s3.RequestPayerRequester)
+	}
+	_, err = reader.GetFileReader(ParquetFormatName, "", inventoryBucketName, "myFile.parquet")
+	if !errors.Is(err, errCaptured) {
+		t.Fatalf("expected error %v, got %v", errCaptured, err)
+	}
+	if client.headObjectInput == nil || aws.StringValue(client.headObjectInput.RequestPayer) != s3.RequestPayerRequester {
+		t.Fatalf("expected HeadObject request payer to be %s", s3.RequestPayerRequester)
+	}
+
+	client = &capturingS3Client{}
+	reader = NewReader(context.Background(), client, logging.Default(), WithRequesterPays(false), WithDownloadRetries(0))
+	_, _ = reader.GetFileReader(OrcFormatName, "", inventoryBucketName, "myFile.orc")
+	if client.getObjectInput == nil || client.getObjectInput.RequestPayer != nil {
+		t.Fatal("expected GetObject request payer to be unset")
+	}
+}
+
+func TestReaderMetrics(t *testing.T) {
+	svc := newTestInventoryBucket(t)
+	uploadFile(t, svc, inventoryBucketName, "myFile.orc", objs(100, []time.Time{time.Now()}))
+	reg := prometheus.NewRegistry()
+	reader := NewReader(context.Background(), svc, logging.Default(), WithMetricsRegisterer(reg))
+	fileReader, err := reader.GetFileReader(OrcFormatName, "", inventoryBucketName, "myFile.orc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := make([]InventoryObject, 100)
+	if err = fileReader.Read(&res); err != nil {
+		t.Fatal(err)
+	}
+	if err = fileReader.Close(); err != nil {
+		t.Fatal(err)
+	}
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var downloads uint64
+	var rowsRead float64
+	for _, family := range families {
+		for _, m := range family.GetMetric() {
+			switch family.GetName() {
+			case "inventory_file_download_duration_seconds":
+				downloads += m.GetHistogram().GetSampleCount()
+			case "inventory_rows_read_total":
+				rowsRead += m.GetCounter().GetValue()
+			}
+		}
+	}
+	if downloads < 1 {
+		t.Fatal("expected download duration to be observed")
+	}
+	if rowsRead != 100 {
+		t.Fatalf("unexpected number of rows read. expected=%d, got=%f", 100, rowsRead)
+	}
+}
+
+// rowsReadMetric returns the number of rows read from inventory files, according to the metrics gathered by reg.
+func rowsReadMetric(t *testing.T, reg *prometheus.Registry) float64 {
+	t.Helper()
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rowsRead float64
+	for _, family := range families {
+		if family.GetName() != "inventory_rows_read_total" {
+			continue
+		}
+		for _, m := range family.GetMetric() {
+			rowsRead += m.GetCounter().GetValue()
+		}
+	}
+	return rowsRead
+}
+
+func TestReaderMetricsReadOne(t *testing.T) {
+	svc := newTestInventoryBucket(t)
+	uploadBytes(t, svc, "myFile.parquet", generateParquet(t, 5, objs(10, []time.Time{time.Now()})))
+	reg := prometheus.NewRegistry()
+	// readers built on the same registerer share their metrics
+	for i := 0; i < 2; i++ {
+		reader := NewReader(context.Background(), svc, logging.Default(), WithMetricsRegisterer(reg))
+		fileReader, err := reader.GetFileReader(ParquetFormatName, "", inventoryBucketName, "myFile.parquet")
+		if err != nil {
+			t.Fatal(err)
+		}
+		rowReader, ok := fileReader.(RowReader)
+		if !ok {
+			t.Fatalf("expected parquet file reader with metrics to implement RowReader, got %T", fileReader)
+		}
+		count := 0
+		for {
+			_, ok, err := rowReader.ReadOne()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !ok {
+				break
+			}
+			count++
+		}
+		if count != 10 {
+			t.Fatalf("unexpected number of objects read. expected=%d, got=%d", 10, count)
+		}
+		if err = fileReader.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if rowsRead := rowsReadMetric(t, reg); rowsRead != float64(10*(i+1)) {
+			t.Fatalf("unexpected number of rows read. expected=%d, got=%f", 10*(i+1), rowsRead)
+		}
+	}
+}
+
+func TestProjection(t *testing.T) {
+	svc := newTestInventoryBucket(t)
+	uploadFile(t, svc, inventoryBucketName, "myFile.orc", objs(10, []time.Time{time.Now()}))
+	uploadBytes(t, svc, "myFile.parquet", generateParquet(t, 5, objs(10, []time.Time{time.Now()})))
+	testdata := []struct {
+		format string
+		key    string
+	}{
+		{format: OrcFormatName, key: "myFile.orc"},
+		{format: ParquetFormatName, key: "myFile.parquet"},
+	}
+	for _, test := range testdata {
+		t.Run(test.format, func(t *testing.T) {
+			reader := NewReader(context.Background(), svc, logging.Default(), WithProjection("size"))
+			fileReader, err := reader.GetFileReader(test.format, "", inventoryBucketName, test.key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() {
+				_ = fileReader.Close()
+			}()
+			res := make([]InventoryObject, 10)
+			if err = fileReader.Read(&res); err != nil {
+				t.Fatal(err)
+			}
+			for i, obj := range res {
+				expected := InventoryObject{Key: fmt.Sprintf("f%05d", i), Size: swag.Int64(500)}
+				if diff := deep.Equal(obj, expected); diff != nil {
+					t.Fatalf("unexpected object at index %d: %v", i, diff)
+				}
+			}
+		})
+	}
+	reader := NewReader(context.Background(), svc, logging.Default(), WithProjection("no_such_column"))
+	if _, err := reader.GetFileReader(OrcFormatName, "", inventoryBucketName, "myFile.orc"); !errors.Is(err, ErrUnknownInventoryColumn) {
+		t.Fatalf("expected error %v, got %v", ErrUnknownInventoryColumn, err)
+	}
+}
+
+func BenchmarkParquetProjection(b *testing.B) {
+	data := generateParquet(b, 10000, objs(100000, []time.Time{time.Now()}))
+	f, err := ioutil.TempFile("", "benchmark.parquet")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer func() {
+		_ = os.Remove(f.Name())
+	}()
+	if _, err = f.Write(data); err != nil {
+		b.Fatal(err)
+	}
+	if err = f.Close(); err != nil {
+		b.Fatal(err)
+	}
+	for _, projection := range [][]string{nil, {"key"}} {
+		columns, err := projectionColumns(projection, []string{"key"})
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.Run(fmt.Sprintf("projection=%v", projection), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				pf, err := local.NewLocalFileReader(f.Name())
+				if err != nil {
+					b.Fatal(err)
+				}
+				r, err := newParquetInventoryFileReader(pf, objectFilter{}, columns, DefaultParquetConcurrency, fileLayout{})
+				if err != nil {
+					b.Fatal(err)
+				}
+				res := make([]InventoryObject, 100000)
+				if err = r.Read(&res); err != nil {
+					b.Fatal(err)
+				}
+				_ = r.Close()
+			}
+		})
+	}
+}
+
+// localParquetFile writes data to a local temporary file, removed once the test is done, and returns its name.
+func localParquetFile(tb testing.TB, data []byte) string {
+	f, err := ioutil.TempFile("", "columns.parquet")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() {
+		_ = os.Remove(f.Name())
+	})
+	if _, err = f.Write(data); err != nil {
+		tb.Fatal(err)
+	}
+	if err = f.Close(); err != nil {
+		tb.Fatal(err)
+	}
+	return f.Name()
+}
+
+func TestParquetColumnReader(t *testing.T) {
+	path := localParquetFile(t, generateParquet(t, 100, objs(250, []time.Time{time.Now()})))
+	testdata := []struct {
+		projection []string
+		expected   func(o *InventoryObject) InventoryObject
+	}{
+		{
+			projection: []string{"key"},
+			expected:   func(o *InventoryObject) InventoryObject { return InventoryObject{Key: o.Key} },
+		},
+		{
+			projection: []string{"size", "e_tag"},
+			expected: func(o *InventoryObject) InventoryObject {
+				return InventoryObject{Key: o.Key, Size: o.Size, Checksum: o.Checksum}
+			},
+		},
+	}
+	for _, test := range testdata {
+		t.Run(fmt.Sprintf("projection=%v", test.projection), func(t *testing.T) {
+			pf, err := local.NewLocalFileReader(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r, err := NewParquetColumnReader(pf, test.projection...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() {
+				_ = r.Close()
+			}()
+			if _, ok := r.(*ParquetColumnReader); !ok {
+				t.Fatalf("expected a column reader, got %T", r)
+			}
+			if r.GetNumRows() != 250 {
+				t.Fatalf("unexpected number of rows: %d", r.GetNumRows())
+			}
+			var expected []InventoryObject
+			for o := range objs(250, []time.Time{time.Now()}) {
+				expected = append(expected, test.expected(o))
+			}
+			for pass := 0; pass < 2; pass++ {
+				var res []InventoryObject
+				for {
+					batch := make([]InventoryObject, 60)
+					err = r.Read(&batch)
+					if errors.Is(err, ErrNoMoreRows) {
+						break
+					}
+					if err != nil {
+						t.Fatal(err)
+					}
+					res = append(res, batch...)
+				}
+				if diff := deep.Equal(res, expected); diff != nil {
+					t.Fatalf("unexpected objects: %s", diff)
+				}
+				if err = r.Rewind(); err != nil {
+					t.Fatal(err)
+				}
+			}
+		})
+	}
+
+	pf, err := local.NewLocalFileReader(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewParquetColumnReader(pf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := r.(*ParquetInventoryFileReader); !ok {
+		t.Fatalf("expected a struct reader with no projection, got %T", r)
+	}
+	_ = r.Close()
+	pf, err = local.NewLocalFileReader(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = NewParquetColumnReader(pf, "owner"); !errors.Is(err, ErrUnknownInventoryColumn) {
+		t.Fatalf("expected error %v, got %v", ErrUnknownInventoryColumn, err)
+	}
+}
+
+// wideParquetRow is an inventory row with many columns beside the key, for benchmarking projections.
+type wideParquetRow struct {
+	Bucket         string  `parquet:"name=bucket, type=UTF8"`
+	Key            string  `parquet:"name=key, type=UTF8"`
+	Size           *int64  `parquet:"name=size, type=INT_64"`
+	LastModified   *int64  `parquet:"name=last_modified_date, type=TIMESTAMP_MILLIS"`
+	Checksum       *string `parquet:"name=e_tag, type=UTF8"`
+	StorageClass   *string `parquet:"name=storage_class, type=UTF8"`
+	Extra1         *string `parquet:"name=extra_1, type=UTF8"`
+	Extra2         *string `parquet:"name=extra_2, type=UTF8"`
+	Extra3         *string `parquet:"name=extra_3, type=UTF8"`
+	Extra4         *string `parquet:"name=extra_4, type=UTF8"`
+	Extra5         *string `parquet:"name=extra_5, type=UTF8"`
+	Extra6         *string `parquet:"name=extra_6, type=UTF8"`
+	IsLatest       *bool   `parquet:"name=is_latest, type=BOOLEAN"`
+	IsDeleteMarker *bool   `parquet:"name=is_delete_marker, type=BOOLEAN"`
+}
+
+func BenchmarkParquetColumnReader(b *testing.B) {
+	const numRows = 100000
+	var buf bytes.Buffer
+	pw, err := writer.NewParquetWriter(writerfile.NewWriterFile(&buf), new(wideParquetRow), 1)
+	if err != nil {
+		b.Fatal(err)
+	}
+	for i := 0; i < numRows; i++ {
+		extra := swag.String(fmt.Sprintf("extra value of row %d", i))
+		row := wideParquetRow{
+			Bucket: inventoryBucketName, Key: fmt.Sprintf("f%06d", i), Size: swag.Int64(500), LastModified: swag.Int64(int64(i)),
+			Checksum: swag.String("abcdefg"), StorageClass: swag.String("STANDARD"), IsLatest: swag.Bool(true), IsDeleteMarker: swag.Bool(false),
+			Extra1: extra, Extra2: extra, Extra3: extra, Extra4: extra, Extra5: extra, Extra6: extra,
+		}
+		if err = pw.Write(row); err != nil {
+			b.Fatal(err)
+		}
+	}
+	if err = pw.WriteStop(); err != nil {
+		b.Fatal(err)
+	}
+	path := localParquetFile(b, buf.Bytes())
+	for _, projection := range [][]string{nil, {"key"}} {
+		b.Run(fmt.Sprintf("projection=%v", projection), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				pf, err := local.NewLocalFileReader(path)
+				if err != nil {
+					b.Fatal(err)
+				}
+				r, err := NewParquetColumnReader(pf, projection...)
+				if err != nil {
+					b.Fatal(err)
+				}
+				res := make([]InventoryObject, numRows)
+				if err = r.Read(&res); err != nil {
+					b.Fatal(err)
+				}
+				_ = r.Close()
+			}
+		})
+	}
+}
+
+func TestKeys(t *testing.T) {
+	svc := newTestInventoryBucket(t)
+	uploadFile(t, svc, inventoryBucketName, "f1.orc", objs(12000, []time.Time{time.Now()}))
+	uploadFile(t, svc, inventoryBucketName, "f2.orc", objs(500, []time.Time{time.Now()}))
+	uploadBytes(t, svc, "f1.parquet", generateParquet(t, 5, objs(3000, []time.Time{time.Now()})))
+	testdata := []struct {
+		format   string
+		fileKeys []string
+	}{
+		{format: OrcFormatName, fileKeys: []string{"f1.orc", "f2.orc"}},
+		{format: ParquetFormatName, fileKeys: []string{"f1.parquet"}},
+	}
+	reader := NewReader(context.Background(), svc, logging.Default())
+	for _, test := range testdata {
+		t.Run(test.format, func(t *testing.T) {
+			var totalRows int64
+			for _, fileKey := range test.fileKeys {
+				fileReader, err := reader.GetMetadataReader(test.format, "", inventoryBucketName, fileKey)
+				if err != nil {
+					t.Fatal(err)
+				}
+				totalRows += fileReader.GetNumRows()
+				_ = fileReader.Close()
+			}
+			keys, errs := reader.Keys(context.Background(), test.format, "", inventoryBucketName, test.fileKeys)
+			var count int64
+			for key := range keys {
+				if expected := fmt.Sprintf("f%05d", count); count < 12000 && key != expected {
+					t.Fatalf("unexpected key at index %d. expected=%s, got=%s", count, expected, key)
+				}
+				count++
+			}
+			if err := <-errs; err != nil {
+				t.Fatal(err)
+			}
+			if count != totalRows {
+				t.Fatalf("unexpected number of keys. expected=%d, got=%d", totalRows, count)
+			}
+		})
+	}
+
+	keys, errs := reader.Keys(context.Background(), OrcFormatName, "", inventoryBucketName, []string{"f2.orc", "missing.orc"})
+	var count int
+	for range keys {
+		count++
+	}
+	if err := <-errs; !errors.Is(err, ErrManifestFileNotFound) {
+		t.Fatalf("expected error %v, got %v", ErrManifestFileNotFound, err)
+	}
+	if count != 500 {
+		t.Fatalf("expected the keys of the file read before the failure, got %d keys", count)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	keys, errs = reader.Keys(ctx, OrcFormatName, "", inventoryBucketName, []string{"f1.orc"})
+	<-keys
+	cancel()
+	for range keys {
+	}
+	if err := <-errs; !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected error %v, got %v", context.Canceled, err)
+	}
+}
+
+func TestParquetConcurrentRowGroups(t *testing.T) {
+	svc := newTestInventoryBucket(t)
+	uploadBytes(t, svc, "myFile.parquet", generateParquet(t, 700, objs(10000, []time.Time{time.Now()})))
+	readAll := func(opts ...ReaderOption) []InventoryObject {
+		reader := NewReader(context.Background(), svc, logging.Default(), opts...)
+		fileReader, err := reader.GetFileReader(ParquetFormatName, "", inventoryBucketName, "myFile.parquet")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() {
+			_ = fileReader.Close()
+		}()
+		var all []InventoryObject
+		for pass := 0; pass < 2; pass++ {
+			all = nil
+			for {
+				// a batch size which is not a divisor of the row group size
+				res := make([]InventoryObject, 333)
+				err := fileReader.Read(&res)
+				if errors.Is(err, ErrNoMoreRows) {
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				all = append(all, res...)
+			}
+			if err = fileReader.Rewind(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return all
+	}
+	for _, concurrency := range []int{1, 2, 4, 16} {
+		t.Run(fmt.Sprintf("concurrency=%d", concurrency), func(t *testing.T) {
+			serial := readAll(WithParquetConcurrency(concurrency))
+			if len(serial) != 10000 {
+				t.Fatalf("unexpected number of objects. expected=%d, got=%d", 10000, len(serial))
+			}
+			concurrent := readAll(WithParquetConcurrency(concurrency), WithConcurrentParquetRowGroups(true))
+			if diff := deep.Equal(concurrent, serial); diff != nil {
+				t.Fatalf("concurrent read differs from serial read: %s", diff)
+			}
+			opts := []ReaderOption{WithParquetConcurrency(concurrency), WithKeyPrefix("f0")}
+			serial = readAll(opts...)
+			concurrent = readAll(append(opts, WithConcurrentParquetRowGroups(true))...)
+			if diff := deep.Equal(concurrent, serial); diff != nil {
+				t.Fatalf("concurrent read with prefix differs from serial read: %s", diff)
+			}
+		})
+	}
+
+	// closing before reading all row groups stops reading them
+	reader := NewReader(context.Background(), svc, logging.Default(), WithConcurrentParquetRowGroups(true))
+	fileReader, err := reader.GetFileReader(ParquetFormatName, "", inventoryBucketName, "myFile.parquet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := make([]InventoryObject, 10)
+	if err = fileReader.Read(&res); err != nil {
+		t.Fatal(err)
+	}
+	if err = fileReader.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// handleTrackingParquetFile records the handles opened from a parquet file, and the handles opened from them in turn.
+type handleTrackingParquetFile struct {
+	source.ParquetFile
+	mu     sync.Mutex
+	opened []*handleTrackingParquetFile
+}
+
+func (f *handleTrackingParquetFile) Open(name string) (source.ParquetFile, error) {
+	pf, err := f.ParquetFile.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	handle := &handleTrackingParquetFile{ParquetFile: pf}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.opened = append(f.opened, handle)
+	return handle, nil
+}
+
+// parents returns the number of handles opened from f which other handles were opened from.
+func (f *handleTrackingParquetFile) parents() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := 0
+	for _, handle := range f.opened {
+		handle.mu.Lock()
+		if len(handle.opened) > 0 {
+			n++
+		}
+		handle.mu.Unlock()
+	}
+	return n
+}
+
+func TestParquetConcurrentRowGroupsOwnHandles(t *testing.T) {
+	path := localParquetFile(t, generateParquet(t, 100, objs(1000, []time.Time{time.Now()})))
+	pf, err := local.NewLocalFileReader(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracking := &handleTrackingParquetFile{ParquetFile: pf}
+	r, err := newParquetInventoryFileReader(tracking, objectFilter{}, nil, DefaultParquetConcurrency, fileLayout{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.rowGroupConcurrency = DefaultParquetConcurrency
+	res := make([]InventoryObject, 1000)
+	if err = r.Read(&res); err != nil {
+		t.Fatal(err)
+	}
+	if err = r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 1000 {
+		t.Fatalf("unexpected number of objects. expected=1000, got=%d", len(res))
+	}
+	// the worker of every row group opens a handle, which the column buffers of the row group are opened from
+	if parents := tracking.parents(); parents != 10 {
+		t.Fatalf("unexpected number of row group handles. expected=10, got=%d", parents)
+	}
+}
+
+func BenchmarkParquetConcurrentRowGroups(b *testing.B) {
+	// 20 row groups of 10000 rows
+	path := localParquetFile(b, generateParquet(b, 10000, objs(200000, []time.Time{time.Now()})))
+	for _, rowGroupConcurrency := range []int{0, DefaultParquetConcurrency} {
+		b.Run(fmt.Sprintf("row_group_concurrency=%d", rowGroupConcurrency), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				pf, err := local.NewLocalFileReader(path)
+				if err != nil {
+					b.Fatal(err)
+				}
+				r, err := newParquetInventoryFileReader(pf, objectFilter{}, nil, DefaultParquetConcurrency, fileLayout{})
+				if err != nil {
+					b.Fatal(err)
+				}
+				r.rowGroupConcurrency = rowGroupConcurrency
+				for {
+					res := make([]InventoryObject, 10000)
+					err = r.Read(&res)
+					if errors.Is(err, ErrNoMoreRows) {
+						break
+					}
+					if err != nil {
+						b.Fatal(err)
+					}
+				}
+				_ = r.Close()
+			}
+		})
+	}
+}
+
+// truncatingS3Client returns the first half of the body of every object, while reporting its full length.
+type truncatingS3Client struct {
+	s3iface.S3API
+}
+
+func (c *truncatingS3Client) GetObjectWithContext(ctx aws.Context, input *s3.GetObjectInput, opts ...request.Option) (*s3.GetObjectOutput, error) {
+	output, err := c.S3API.GetObjectWithContext(ctx, input, opts...)
+	if err != nil {
+		return nil, err
+	}
+	output.Body = ioutil.NopCloser(io.LimitReader(output.Body, aws.Int64Value(output.ContentLength)/2))
+	return output, nil
+}
+
+// slowS3Client delays every object download, recording the maximal number of downloads in flight.
+type slowS3Client struct {
+	s3iface.S3API
+	delay       time.Duration
+	inFlight    int32
+	maxInFlight int32
+}
+
+func (c *slowS3Client) GetObjectWithContext(ctx aws.Context, input *s3.GetObjectInput, opts ...request.Option) (*s3.GetObjectOutput, error) {
+	n := atomic.AddInt32(&c.inFlight, 1)
+	defer atomic.AddInt32(&c.inFlight, -1)
+	for {
+		prev := atomic.LoadInt32(&c.maxInFlight)
+		if n <= prev || atomic.CompareAndSwapInt32(&c.maxInFlight, prev, n) {
+			break
+		}
+	}
+	time.Sleep(c.delay)
+	return c.S3API.GetObjectWithContext(ctx, input, opts...)
+}
+
+func TestMaxConcurrentDownloads(t *testing.T) {
+	svc := newTestInventoryBucket(t)
+	const numFiles = 8
+	const maxDownloads = 2
+	var keys []string
+	for i := 0; i < numFiles; i++ {
+		key := fmt.Sprintf("f%d.orc", i)
+		uploadFile(t, svc, inventoryBucketName, key, objs(10, []time.Time{time.Now()}))
+		keys = append(keys, key)
+	}
+	slow := &slowS3Client{S3API: svc, delay: 20 * time.Millisecond}
+	reader := NewReader(context.Background(), slow, logging.Default(), WithMaxConcurrentDownloads(maxDownloads))
+	var wg sync.WaitGroup
+	errs := make(chan error, numFiles)
+	for _, key := range keys {
+		wg.Add(1)
+		go func(key string) {
+			defer wg.Done()
+			fileReader, err := reader.GetFileReader(OrcFormatName, "", inventoryBucketName, key)
+			if err != nil {
+				errs <- err
+				return
+			}
+			errs <- fileReader.Close()
+		}(key)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if slow.maxInFlight > maxDownloads {
+		t.Fatalf("expected at most %d concurrent downloads, got %d", maxDownloads, slow.maxInFlight)
+	}
+	if slow.maxInFlight < maxDownloads {
+		t.Fatalf("expected downloads to run concurrently up to the limit, got %d", slow.maxInFlight)
+	}
+
+	// a download waiting for a slot gives up when its context is cancelled
+	reader = NewReader(context.Background(), svc, logging.Default(), WithMaxConcurrentDownloads(1))
+	reader.downloadSlots <- struct{}{}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	f, err := reader.tempFile(keys[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+	}()
+	if err = reader.download(ctx, OrcFormatName, f, inventoryBucketName, keys[0], 0); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected error %v, got %v", context.DeadlineExceeded, err)
+	}
+}
+
+// rangeRecordingS3Client records the range of every object download.
+type rangeRecordingS3Client struct {
+	s3iface.S3API
+	mu     sync.Mutex
+	ranges []string
+}
+
+func (c *rangeRecordingS3Client) GetObjectWithContext(ctx aws.Context, input *s3.GetObjectInput, opts ...request.Option) (*s3.GetObjectOutput, error) {
+	c.mu.Lock()
+	c.ranges = append(c.ranges, aws.StringValue(input.Range))
+	c.mu.Unlock()
+	return c.S3API.GetObjectWithContext(ctx, input, opts...)
+}
+
+func TestStreamOrc(t *testing.T) {
+	svc := newTestInventoryBucket(t)
+	uploadFile(t, svc, inventoryBucketName, "myFile.orc", objs(35000, []time.Time{time.Now()}))
+	head, err := svc.HeadObject(&s3.HeadObjectInput{Bucket: aws.String(inventoryBucketName), Key: aws.String("myFile.orc")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rangeRe := regexp.MustCompile(`^bytes=(\d+)-(\d+)$`)
+	rangedBytes := func(ranges []string) int64 {
+		var total int64
+		for _, r := range ranges {
+			m := rangeRe.FindStringSubmatch(r)
+			if m == nil {
+				t.Fatalf("expected a ranged read, got range %q", r)
+			}
+			from, _ := strconv.ParseInt(m[1], 10, 64)
+			to, _ := strconv.ParseInt(m[2], 10, 64)
+			total += to - from + 1
+		}
+		return total
+	}
+	recorder := &rangeRecordingS3Client{S3API: svc}
+	reader := NewReader(context.Background(), recorder, logging.Default(), WithStreamOrc(true))
+	fileReader, err := reader.GetFileReader(OrcFormatName, "", inventoryBucketName, "myFile.orc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fileReader.GetNumRows() != 35000 {
+		t.Fatalf("unexpected number of rows. expected=35000, got=%d", fileReader.GetNumRows())
+	}
+	if read := rangedBytes(recorder.ranges); read >= *head.ContentLength/2 {
+		t.Fatalf("expected only the tail of the file to be read when opening it, read %d of %d bytes", read, *head.ContentLength)
+	}
+	res := make([]InventoryObject, 12000)
+	if err = fileReader.Read(&res); err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 12000 || res[11999].Key != "f11999" {
+		t.Fatalf("unexpected objects read: %d objects", len(res))
+	}
+	if read := rangedBytes(recorder.ranges); read >= *head.ContentLength {
+		t.Fatalf("expected part of the file to be read for its first stripes, read %d of %d bytes", read, *head.ContentLength)
+	}
+	_ = fileReader.Close()
+}
+
+func TestDownloadChecksum(t *testing.T) {
+	svc := newTestInventoryBucket(t)
+	localOrcFile := generateOrc(t, objs(1000, []time.Time{time.Now()}))
+	data, err := ioutil.ReadFile(localOrcFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = os.Remove(localOrcFile)
+	uploadBytes(t, svc, "myFile.orc", data)
+	md5sum := md5.Sum(data) //nolint:gosec
+	checksum := FileChecksum{Size: int64(len(data)), MD5: hex.EncodeToString(md5sum[:])}
+	testdata := []struct {
+		name        string
+		svc         s3iface.S3API
+		checksum    *FileChecksum
+		expectedErr error
+	}{
+		{name: "no_checksum", svc: svc},
+		{name: "matching_checksum", svc: svc, checksum: &checksum},
+		{name: "size_only", svc: svc, checksum: &FileChecksum{Size: checksum.Size}},
+		{name: "wrong_size", svc: svc, checksum: &FileChecksum{Size: checksum.Size + 1}, expectedErr: ErrInventoryChecksumMismatch},
+		{name: "wrong_md5", svc: svc, checksum: &FileChecksum{MD5: "f11166069f1990abeb9c97ace9cdfabc"}, expectedErr: ErrInventoryChecksumMismatch},
+		{name: "truncated", svc: &truncatingS3Client{S3API: svc}, expectedErr: ErrInventoryChecksumMismatch},
+		{name: "truncated_with_checksum", svc: &truncatingS3Client{S3API: svc}, checksum: &checksum, expectedErr: ErrInventoryChecksumMismatch},
+	}
+	for _, test := range testdata {
+		t.Run(test.name, func(t *testing.T) {
+			var opts []ReaderOption
+			if test.checksum != nil {
+				opts = append(opts, WithFileChecksums(map[string]FileChecksum{"myFile.orc": *test.checksum}))
+			}
+			reader := NewReader(context.Background(), test.svc, logging.Default(), opts...)
+			fileReader, err := reader.GetFileReader(OrcFormatName, "", inventoryBucketName, "myFile.orc")
+			if test.expectedErr != nil {
+				if !errors.Is(err, test.expectedErr) || !errors.Is(err, ErrInventoryDownload) {
+					t.Fatalf("expected error %v, got %v", test.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fileReader.GetNumRows() != 1000 {
+				t.Fatalf("unexpected number of rows. expected=%d, got=%d", 1000, fileReader.GetNumRows())
+			}
+			if err = fileReader.Close(); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestReaderMultipleManifests(t *testing.T) {
+	svc, testServer := getS3Fake(t)
+	defer testServer.Close()
+	const key = "inventory/data/myFile.orc"
+	buckets := []string{"inventory-bucket-1", "inventory-bucket-2"}
+	numRows := []int{1000, 500}
+	checksums := make([]FileChecksum, len(buckets))
+	for i, bucket := range buckets {
+		if _, err := svc.CreateBucket(&s3.CreateBucketInput{Bucket: aws.String(bucket)}); err != nil {
+			t.Fatal(err)
+		}
+		localOrcFile := generateOrc(t, objs(numRows[i], []time.Time{time.Now()}))
+		data, err := ioutil.ReadFile(localOrcFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = os.Remove(localOrcFile)
+		_, err = s3manager.NewUploaderWithClient(svc).Upload(&s3manager.UploadInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(key),
+			Body:   bytes.NewReader(data),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		md5sum := md5.Sum(data) //nolint:gosec
+		checksums[i] = FileChecksum{Size: int64(len(data)), MD5: hex.EncodeToString(md5sum[:])}
+	}
+	reader := NewReader(context.Background(), svc, logging.Default())
+	readRows := func(bucket string) (int64, error) {
+		fileReader, err := reader.GetFileReader(OrcFormatName, "", bucket, key)
+		if err != nil {
+			return 0, err
+		}
+		defer func() {
+			_ = fileReader.Close()
+		}()
+		return fileReader.GetNumRows(), nil
+	}
+	// the checksums of the first manifest do not apply to the file with the same key in the second bucket
+	reader.AddFileChecksums(buckets[0], map[string]FileChecksum{key: checksums[0]})
+	for i, bucket := range buckets {
+		n, err := readRows(bucket)
+		if err != nil {
+			t.Fatalf("failed to read file of manifest %d: %v", i, err)
+		}
+		if n != int64(numRows[i]) {
+			t.Fatalf("unexpected number of rows for manifest %d. expected=%d, got=%d", i, numRows[i], n)
+		}
+	}
+	reader.AddFileChecksums(buckets[1], map[string]FileChecksum{key: checksums[0]})
+	if _, err := readRows(buckets[1]); !errors.Is(err, ErrInventoryChecksumMismatch) {
+		t.Fatalf("expected error %v, got %v", ErrInventoryChecksumMismatch, err)
+	}
+	reader.AddFileChecksums(buckets[1], map[string]FileChecksum{key: checksums[1]})
+	if _, err := readRows(buckets[1]); err != nil {
+		t.Fatalf("failed to read file of second manifest: %v", err)
+	}
+}
+
+func TestInventoryErrors(t *testing.T) {
+	svc := newTestInventoryBucket(t)
+	corrupt := bytes.Repeat([]byte("not an inventory file "), 100)
+	for _, filename := range []string{"corrupt.orc", "corrupt.parquet", "corrupt.csv.gz", "corrupt.avro"} {
+		uploadBytes(t, svc, filename, corrupt)
+	}
+	forbidden := awserr.NewRequestFailure(awserr.New("AccessDenied", "access denied", nil), http.StatusForbidden, "")
+	testdata := []struct {
+		format string
+		key    string
+	}{
+		{format: OrcFormatName, key: "corrupt.orc"},
+		{format: ParquetFormatName, key: "corrupt.parquet"},
+		{format: CSVFormatName, key: "corrupt.csv.gz"},
+		{format: AvroFormatName, key: "corrupt.avro"},
+	}
+	for _, test := range testdata {
+		t.Run(test.format, func(t *testing.T) {
+			client := &flakyS3Client{countingS3Client: countingS3Client{S3API: svc}, getObjectFailures: 1, err: forbidden}
+			reader := NewReader(context.Background(), client, logging.Default(), WithDownloadRetries(0))
+			_, err := reader.GetFileReader(test.format, "Bucket, Key", inventoryBucketName, test.key)
+			if !errors.Is(err, ErrInventoryDownload) || errors.Is(err, ErrInventoryParse) {
+				t.Fatalf("expected error %v, got %v", ErrInventoryDownload, err)
+			}
+			var reqErr awserr.RequestFailure
+			if !errors.As(err, &reqErr) || reqErr.StatusCode() != http.StatusForbidden {
+				t.Fatalf("expected download error to wrap the S3 error, got %v", err)
+			}
+
+			reader = NewReader(context.Background(), svc, logging.Default())
+			_, err = reader.GetFileReader(test.format, "Bucket, Key", inventoryBucketName, test.key)
+			if !errors.Is(err, ErrInventoryParse) || errors.Is(err, ErrInventoryDownload) {
+				t.Fatalf("expected error %v, got %v", ErrInventoryParse, err)
+			}
+		})
+	}
+
+	reader := NewReader(context.Background(), svc, logging.Default())
+	_, err := reader.GetFileReader("unknown", "", inventoryBucketName, "corrupt.orc")
+	if !errors.Is(err, ErrUnsupportedInventoryFormat) {
+		t.Fatalf("expected error %v, got %v", ErrUnsupportedInventoryFormat, err)
+	}
+}
+
+func TestInventoryIterator(t *testing.T) {
+	svc := newTestInventoryBucket(t)
+	fileSizes := []int{2500, 0, 1, 1200}
+	var keys []string
+	total := 0
+	for i, size := range fileSizes {
+		objects := make(chan *InventoryObject)
+		go func(from int, size int) {
+			defer close(objects)
+			for j := from; j < from+size; j++ {
+				objects <- &InventoryObject{
+					Bucket:             inventoryBucketName,
+					Key:                fmt.Sprintf("f%05d", j),
+					Size:               swag.Int64(500),
+					LastModifiedMillis: swag.Int64(time.Now().Unix() * 1000),
+					Checksum:           swag.String("abcdefg"),
+				}
+			}
+		}(total, size)
+		key := fmt.Sprintf("myFile%d.orc", i)
+		uploadFile(t, svc, inventoryBucketName, key, objects)
+		keys = append(keys, key)
+		total += size
+	}
+	reader := NewReader(context.Background(), svc, logging.Default())
+	it := NewInventoryIterator(reader, OrcFormatName, "", inventoryBucketName, keys)
+	count := 0
+	for it.Next() {
+		if it.Get().Key != fmt.Sprintf("f%05d", count) {
+			t.Fatalf("unexpected key at index %d. expected=%s, got=%s", count, fmt.Sprintf("f%05d", count), it.Get().Key)
+		}
+		count++
+	}
+	if it.Err() != nil {
+		t.Fatal(it.Err())
+	}
+	if count != total {
+		t.Fatalf("unexpected number of objects. expected=%d, got=%d", total, count)
+	}
+	if err := it.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	it = NewInventoryIterator(reader, OrcFormatName, "", inventoryBucketName, []string{keys[0], "missing.orc"})
+	count = 0
+	for it.Next() {
+		count++
+	}
+	if count != fileSizes[0] {
+		t.Fatalf("unexpected number of objects before error. expected=%d, got=%d", fileSizes[0], count)
+	}
+	if !errors.Is(it.Err(), ErrManifestFileNotFound) {
+		t.Fatalf("expected error %v, got %v", ErrManifestFileNotFound, it.Err())
+	}
+	if it.Next() {
+		t.Fatal("expected no more objects after error")
+	}
+	if err := it.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	it = NewInventoryIterator(reader, OrcFormatName, "", inventoryBucketName, nil)
+	if it.Next() {
+		t.Fatal("expected no objects from iterator over no files")
+	}
+	if it.Err() != nil {
+		t.Fatalf("unexpected error from iterator over no files: %v", it.Err())
+	}
+	if err := it.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestInventoryIteratorRemaining(t *testing.T) {
+	svc := newTestInventoryBucket(t)
+	fileSizes := []int{2500, 0, 1, 1200}
+	var keys []string
+	total := 0
+	for i, size := range fileSizes {
+		key := fmt.Sprintf("myFile%d.orc", i)
+		uploadFile(t, svc, inventoryBucketName, key, objs(size, []time.Time{time.Now()}))
+		keys = append(keys, key)
+		total += size
+	}
+	testdata := []struct {
+		name string
+		opts []ReaderOption
+	}{
+		{name: "all"},
+		{name: "filtered", opts: []ReaderOption{WithKeyPrefix("f0001")}},
+	}
+	for _, test := range testdata {
+		t.Run(test.name, func(t *testing.T) {
+			reader := NewReader(context.Background(), svc, logging.Default(), test.opts...)
+			it := NewInventoryIterator(reader, OrcFormatName, "", inventoryBucketName, keys)
+			defer func() {
+				_ = it.Close()
+			}()
+			remaining := it.Remaining()
+			if remaining != int64(total) {
+				t.Fatalf("unexpected initial remaining rows. expected=%d, got=%d", total, remaining)
+			}
+			for it.Next() {
+				current := it.Remaining()
+				if current > remaining {
+					t.Fatalf("remaining rows increased from %d to %d", remaining, current)
+				}
+				remaining = current
+			}
+			if it.Err() != nil {
+				t.Fatal(it.Err())
+			}
+			if remaining = it.Remaining(); remaining != 0 {
+				t.Fatalf("expected no remaining rows after iteration, got %d", remaining)
+			}
+		})
+	}
+}
+func TestManifestFileNotFound(t *testing.T) {
+	svc := newTestInventoryBucket(t)
+	reader := NewReader(context.Background(), svc, logging.Default(), WithDownloadRetries(0))
+	for _, format := range []string{OrcFormatName, ParquetFormatName, CSVFormatName, AvroFormatName} {
+		t.Run(format, func(t *testing.T) {
+			_, err := reader.GetFileReader(format, "Bucket, Key", inventoryBucketName, "missing")
+			if !errors.Is(err, ErrManifestFileNotFound) || errors.Is(err, ErrInventoryDownload) {
+				t.Fatalf("expected error %v, got %v", ErrManifestFileNotFound, err)
+			}
+			_, err = reader.GetMetadataReader(format, "Bucket, Key", inventoryBucketName, "missing")
+			if !errors.Is(err, ErrManifestFileNotFound) {
+				t.Fatalf("expected error %v from metadata reader, got %v", ErrManifestFileNotFound, err)
+			}
+		})
+	}
+}
+
+const assumeRoleResponse = `<AssumeRoleResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <AssumeRoleResult>
+    <Credentials>
+      <AccessKeyId>ASSUMED-ACCESSKEYID</AccessKeyId>
+      <SecretAccessKey>ASSUMED-SECRETACCESSKEY</SecretAccessKey>
+      <SessionToken>ASSUMED-SESSIONTOKEN</SessionToken>
+      <Expiration>%s</Expiration>
+    </Credentials>
+    <AssumedRoleUser>
+      <Arn>arn:aws:sts::123456789012:assumed-role/inventory-reader/session</Arn>
+      <AssumedRoleId>AROAEXAMPLE:session</AssumedRoleId>
+    </AssumedRoleUser>
+  </AssumeRoleResult>
+  <ResponseMetadata><RequestId>request-id</RequestId></ResponseMetadata>
+</AssumeRoleResponse>`
+
+func TestReaderWithRole(t *testing.T) {
+	const roleARN = "arn:aws:iam::123456789012:role/inventory-reader"
+	faker := gofakes3.New(s3mem.New()).Server()
+	var mu sync.Mutex
+	var assumedRoles []string
+	var credentialsUsed []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost && r.URL.Path == "/" {
+			if err := r.ParseForm(); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if r.PostForm.Get("Action") == "AssumeRole" {
+				mu.Lock()
+				assumedRoles = append(assumedRoles, r.PostForm.Get("RoleArn"))
+				mu.Unlock()
+				w.Header().Set("Content-Type", "text/xml")
+				_, _ = fmt.Fprintf(w, assumeRoleResponse, time.Now().Add(time.Hour).UTC().Format(time.RFC3339))
+				return
+			}
+		}
+		mu.Lock()
+		credentialsUsed = append(credentialsUsed, r.Header.Get("Authorization")+" token="+r.Header.Get("X-Amz-Security-Token"))
+		mu.Unlock()
+		faker.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+	cfg := &aws.Config{
+		Credentials:      credentials.NewStaticCredentials("YOUR-ACCESSKEYID", "YOUR-SECRETACCESSKEY", ""),
+		Endpoint:         aws.String(ts.URL),
+		Region:           aws.String("eu-central-1"),
+		DisableSSL:       aws.Bool(true),
+		S3ForcePathStyle: aws.Bool(true),
+	}
+	sess, err := session.NewSession(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc := s3.New(sess)
+	if _, err = svc.CreateBucket(&s3.CreateBucketInput{Bucket: aws.String(inventoryBucketName)}); err != nil {
+		t.Fatal(err)
+	}
+	uploadFile(t, svc, inventoryBucketName, "myFile.orc", objs(100, []time.Time{time.Now()}))
+	mu.Lock()
+	credentialsUsed = nil
+	mu.Unlock()
+
+	reader, err := newReaderWithRole(context.Background(), roleARN, cfg, logging.Default())
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileReader, err := reader.GetFileReader(OrcFormatName, "", inventoryBucketName, "myFile.orc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fileReader.GetNumRows() != 100 {
+		t.Fatalf("unexpected number of rows. expected=%d, got=%d", 100, fileReader.GetNumRows())
+	}
+	if err = fileReader.Close(); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if diff := deep.Equal(assumedRoles, []string{roleARN}); diff != nil {
+		t.Fatalf("unexpected roles assumed: %s", diff)
+	}
+	if len(credentialsUsed) == 0 {
+		t.Fatal("expected the inventory file to be read from S3")
+	}
+	for _, used := range credentialsUsed {
+		if !strings.Contains(used, "Credential=ASSUMED-ACCESSKEYID/") || !strings.HasSuffix(used, "token=ASSUMED-SESSIONTOKEN") {
+			t.Fatalf("expected requests to be signed with the credentials of the role, got %s", used)
+		}
+	}
+}
+
+func TestPlan(t *testing.T) {
+	svc := newTestInventoryBucket(t)
+	prefixes := []string{"a1/", "a2/", "b/"}
+	numRows := []int{300, 200, 100}
+	var keys []string
+	sizes := make(map[string]int64)
+	for i, prefix := range prefixes {
+		objects := make(chan *InventoryObject)
+		go func(prefix string, n int) {
+			defer close(objects)
+			for j := 0; j < n; j++ {
+				objects <- &InventoryObject{
+					Bucket:             inventoryBucketName,
+					Key:                fmt.Sprintf("%s%05d", prefix, j),
+					Size:               swag.Int64(500),
+					LastModifiedMillis: swag.Int64(time.Now().Unix() * 1000),
+					Checksum:           swag.String("abcdefg"),
+				}
+			}
+		}(prefix, numRows[i])
+		key := fmt.Sprintf("myFile%d.orc", i)
+		uploadFile(t, svc, inventoryBucketName, key, objects)
+		keys = append(keys, key)
+		headObject, err := svc.HeadObject(&s3.HeadObjectInput{Bucket: aws.String(inventoryBucketName), Key: aws.String(key)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		sizes[key] = aws.Int64Value(headObject.ContentLength)
+	}
+	testdata := []struct {
+		name          string
+		opts          []ReaderOption
+		expectedFiles []int
+	}{
+		{name: "no_filter", expectedFiles: []int{0, 1, 2}},
+		{name: "prefix", opts: []ReaderOption{WithKeyPrefix("a")}, expectedFiles: []int{0, 1}},
+		{name: "no_match", opts: []ReaderOption{WithKeyPrefix("c")}},
+	}
+	for _, test := range testdata {
+		t.Run(test.name, func(t *testing.T) {
+			reader := NewReader(context.Background(), svc, logging.Default(), test.opts...)
+			plan, err := reader.Plan(context.Background(), OrcFormatName, inventoryBucketName, keys)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var expected ImportPlan
+			for _, i := range test.expectedFiles {
+				expected.Files = append(expected.Files, PlannedFile{Key: keys[i], EstimatedRows: int64(numRows[i]), Size: sizes[keys[i]]})
+				expected.EstimatedRows += int64(numRows[i])
+				expected.EstimatedBytes += sizes[keys[i]]
+			}
+			if diff := deep.Equal(plan, expected); diff != nil {
+				t.Fatalf("unexpected plan: %s", diff)
+			}
+		})
+	}
+	csvPlan, err := NewReader(context.Background(), svc, logging.Default()).Plan(context.Background(), CSVFormatName, inventoryBucketName, keys[:1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(csvPlan.Files) != 1 || csvPlan.Files[0].EstimatedRows != -1 || csvPlan.EstimatedRows != 0 {
+		t.Fatalf("expected file with no statistics to be planned with unknown rows, got %+v", csvPlan)
+	}
+}
+
+func TestFileLocation(t *testing.T) {
+	testdata := []struct {
+		key            string
+		expectedBucket string
+		expectedKey    string
+	}{
+		{key: "inventory/data/f1.orc", expectedBucket: "manifest-bucket", expectedKey: "inventory/data/f1.orc"},
+		{key: "s3://other-bucket/inventory/data/f1.orc", expectedBucket: "other-bucket", expectedKey: "inventory/data/f1.orc"},
+		{key: "s3://other-bucket/f1.orc", expectedBucket: "other-bucket", expectedKey: "f1.orc"},
+		{key: "s3://no-key", expectedBucket: "manifest-bucket", expectedKey: "s3://no-key"},
+	}
+	for _, test := range testdata {
+		bucket, key := FileLocation("manifest-bucket", test.key)
+		if bucket != test.expectedBucket || key != test.expectedKey {
+			t.Fatalf("unexpected location of %s. expected=%s/%s, got=%s/%s", test.key, test.expectedBucket, test.expectedKey, bucket, key)
+		}
+	}
+}
+
+func TestReaderAbsoluteFileURIs(t *testing.T) {
+	svc, testServer := getS3Fake(t)
+	defer testServer.Close()
+	const otherBucket = "other-inventory-bucket"
+	for _, bucket := range []string{inventoryBucketName, otherBucket} {
+		if _, err := svc.CreateBucket(&s3.CreateBucketInput{Bucket: aws.String(bucket)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	uploadFile(t, svc, inventoryBucketName, "data/f1.orc", objs(100, []time.Time{time.Now()}))
+	uploadFile(t, svc, otherBucket, "data/f2.orc", objs(50, []time.Time{time.Now()}))
+	keys := []string{"data/f1.orc", "s3://" + otherBucket + "/data/f2.orc"}
+	reader := NewReader(context.Background(), svc, logging.Default())
+	it := NewInventoryIterator(reader, OrcFormatName, "", inventoryBucketName, keys)
+	count := 0
+	for it.Next() {
+		count++
+	}
+	if it.Err() != nil {
+		t.Fatal(it.Err())
+	}
+	if err := it.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if count != 150 {
+		t.Fatalf("unexpected number of objects. expected=%d, got=%d", 150, count)
+	}
+	total, err := reader.EstimatedTotalRows(OrcFormatName, "", inventoryBucketName, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 150 {
+		t.Fatalf("unexpected number of rows from metadata. expected=%d, got=%d", 150, total)
+	}
+}
+
+func TestDownloadPartSizeAndConcurrency(t *testing.T) {
+	const mb = 1024 * 1024
+	testdata := []struct {
+		name                string
+		opts                []ReaderOption
+		expectedPartSize    int64
+		expectedConcurrency int
+	}{
+		{name: "default", expectedPartSize: s3manager.DefaultDownloadPartSize, expectedConcurrency: s3manager.DefaultDownloadConcurrency},
+		{name: "configured", opts: []ReaderOption{WithDownloadPartSize(64 * mb), WithDownloadConcurrency(16)}, expectedPartSize: 64 * mb, expectedConcurrency: 16},
+		{name: "invalid", opts: []ReaderOption{WithDownloadPartSize(mb), WithDownloadConcurrency(0)}, expectedPartSize: s3manager.DefaultDownloadPartSize, expectedConcurrency: s3manager.DefaultDownloadConcurrency},
+	}
+	for _, test := range testdata {
+		t.Run(test.name, func(t *testing.T) {
+			reader := NewReader(context.Background(), nil, logging.Default(), test.opts...)
+			downloader := reader.newDownloader(nil)
+			if downloader.PartSize != test.expectedPartSize || downloader.Concurrency != test.expectedConcurrency {
+				t.Fatalf("unexpected downloader configuration. expected part size=%d, concurrency=%d, got part size=%d, concurrency=%d",
+					test.expectedPartSize, test.expectedConcurrency, downloader.PartSize, downloader.Concurrency)
+			}
+		})
+	}
+
+	svc := newTestInventoryBucket(t)
+	uploadFile(t, svc, inventoryBucketName, "myFile.orc", objs(1000, []time.Time{time.Now()}))
+	reader := NewReader(context.Background(), svc, logging.Default(), WithDownloadPartSize(8*mb), WithDownloadConcurrency(2))
+	fileReader, err := reader.GetFileReader(OrcFormatName, "", inventoryBucketName, "myFile.orc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fileReader.GetNumRows() != 1000 {
+		t.Fatalf("unexpected number of rows. expected=%d, got=%d", 1000, fileReader.GetNumRows())
+	}
+	if err = fileReader.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReaderParseMetrics(t *testing.T) {
+	svc := newTestInventoryBucket(t)
+	uploadFile(t, svc, inventoryBucketName, "myFile.orc", objs(100, []time.Time{time.Now()}))
+	uploadBytes(t, svc, "myFile.parquet", generateParquet(t, 5, objs(100, []time.Time{time.Now()})))
+	testdata := []struct {
+		format string
+		key    string
+	}{
+		{format: OrcFormatName, key: "myFile.orc"},
+		{format: ParquetFormatName, key: "myFile.parquet"},
+	}
+	for _, test := range testdata {
+		t.Run(test.format, func(t *testing.T) {
+			reg := prometheus.NewRegistry()
+			reader := NewReader(context.Background(), svc, logging.Default(), WithMetricsRegisterer(reg))
+			fileReader, err := reader.GetFileReader(test.format, "", inventoryBucketName, test.key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() {
+				_ = fileReader.Close()
+			}()
+			for i := 0; i < 2; i++ {
+				res := make([]InventoryObject, 30)
+				if err = fileReader.Read(&res); err != nil {
+					t.Fatal(err)
+				}
+			}
+			families, err := reg.Gather()
+			if err != nil {
+				t.Fatal(err)
+			}
+			labels := map[string]string{"format": test.format, "file": fileKeyHash(test.key)}
+			var rowsRead float64
+			var reads uint64
+			for _, family := range families {
+				for _, m := range family.GetMetric() {
+					for _, label := range m.GetLabel() {
+						if labels[label.GetName()] != label.GetValue() {
+							t.Fatalf("unexpected label %s=%s on %s", label.GetName(), label.GetValue(), family.GetName())
+						}
+					}
+					switch family.GetName() {
+					case "inventory_rows_read_total":
+						rowsRead += m.GetCounter().GetValue()
+					case "inventory_file_read_duration_seconds":
+						reads += m.GetHistogram().GetSampleCount()
+					}
+				}
+			}
+			if rowsRead != 60 {
+				t.Fatalf("unexpected number of rows read. expected=%d, got=%f", 60, rowsRead)
+			}
+			if reads != 2 {
+				t.Fatalf("unexpected number of observed reads. expected=%d, got=%d", 2, reads)
+			}
+		})
+	}
+}
+
+func TestForcePathStyle(t *testing.T) {
+	faker := gofakes3.New(s3mem.New())
+	var mu sync.Mutex
+	var paths []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		paths = append(paths, r.URL.Path)
+		mu.Unlock()
+		faker.Server().ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+	s3Config := &aws.Config{
+		Credentials: credentials.NewStaticCredentials("YOUR-ACCESSKEYID", "YOUR-SECRETACCESSKEY", ""),
+		Endpoint:    aws.String(ts.URL),
+		Region:      aws.String("eu-central-1"),
+		DisableSSL:  aws.Bool(true),
+	}
+	sess, err := session.NewSession(s3Config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the client of the caller addresses buckets in host names, which the fake endpoint cannot serve
+	svc := s3.New(sess)
+	uploader := s3.New(sess, aws.NewConfig().WithS3ForcePathStyle(true))
+	_, err = uploader.CreateBucket(&s3.CreateBucketInput{
+		Bucket: aws.String(inventoryBucketName),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	uploadFile(t, uploader, inventoryBucketName, "myFile.orc", objs(10, []time.Time{time.Now()}))
+	uploadBytes(t, uploader, "myFile.parquet", generateParquet(t, 5, objs(10, []time.Time{time.Now()})))
+	testdata := []struct {
+		format string
+		key    string
+	}{
+		{format: OrcFormatName, key: "myFile.orc"},
+		{format: ParquetFormatName, key: "myFile.parquet"},
+	}
+	for _, test := range testdata {
+		t.Run(test.format, func(t *testing.T) {
+			mu.Lock()
+			paths = nil
+			mu.Unlock()
+			reader := NewReader(context.Background(), svc, logging.Default(), WithForcePathStyle(), WithDownloadRetries(0))
+			fileReader, err := reader.GetFileReader(test.format, "", inventoryBucketName, test.key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() {
+				_ = fileReader.Close()
+			}()
+			res := make([]InventoryObject, 10)
+			if err = fileReader.Read(&res); err != nil {
+				t.Fatal(err)
+			}
+			if len(res) != 10 {
+				t.Fatalf("unexpected number of objects read. expected=%d, got=%d", 10, len(res))
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if len(paths) == 0 {
+				t.Fatal("expected inventory file to be read from the endpoint")
+			}
+			expectedPath := "/" + inventoryBucketName + "/" + test.key
+			for _, p := range paths {
+				if p != expectedPath {
+					t.Fatalf("unexpected request path. expected=%s, got=%s", expectedPath, p)
+				}
+			}
+			if svc.Config.S3ForcePathStyle != nil {
+				t.Fatal("expected the client of the caller not to be modified")
+			}
+		})
+	}
+}
+
+func TestInventoryIteratorSorted(t *testing.T) {
+	svc := newTestInventoryBucket(t)
+	// interleaved key ranges: file i holds the keys whose index is i modulo 3, and the last file a few more
+	fileSizes := []int{1000, 1000, 1500}
+	var keys []string
+	total := 0
+	for i, size := range fileSizes {
+		objects := make(chan *InventoryObject)
+		go func(i int, size int) {
+			defer close(objects)
+			for j := 0; j < size; j++ {
+				index := j*3 + i
+				if j >= 1000 {
+					index = 2000 + j
+				}
+				objects <- &InventoryObject{
+					Bucket:             inventoryBucketName,
+					Key:                fmt.Sprintf("f%05d", index),
+					Size:               swag.Int64(500),
+					LastModifiedMillis: swag.Int64(time.Now().Unix() * 1000),
+					Checksum:           swag.String("abcdefg"),
+				}
+			}
+		}(i, size)
+		key := fmt.Sprintf("myFile%d.orc", i)
+		uploadFile(t, svc, inventoryBucketName, key, objects)
+		keys = append(keys, key)
+		total += size
+	}
+	reader := NewReader(context.Background(), svc, logging.Default())
+	it := NewInventoryIterator(reader, OrcFormatName, "", inventoryBucketName, keys, WithSorted(true))
+	if remaining := it.Remaining(); remaining != int64(total) {
+		t.Fatalf("unexpected remaining rows. expected=%d, got=%d", total, remaining)
+	}
+	count := 0
+	for it.Next() {
+		if it.Get().Key != fmt.Sprintf("f%05d", count) {
+			t.Fatalf("unexpected key at index %d. expected=%s, got=%s", count, fmt.Sprintf("f%05d", count), it.Get().Key)
+		}
+		count++
+		if count == 10 {
+			if remaining := it.Remaining(); remaining != int64(total-count) {
+				t.Fatalf("unexpected remaining rows. expected=%d, got=%d", total-count, remaining)
+			}
+		}
+	}
+	if it.Err() != nil {
+		t.Fatal(it.Err())
+	}
+	if count != total {
+		t.Fatalf("unexpected number of objects. expected=%d, got=%d", total, count)
+	}
+	if err := it.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	it = NewInventoryIterator(reader, OrcFormatName, "", inventoryBucketName, []string{keys[0], "missing.orc"}, WithSorted(true))
+	if it.Next() {
+		t.Fatal("expected sorted iteration to fail opening all files")
+	}
+	if it.Err() == nil {
+		t.Fatal("expected an error for the missing file")
+	}
+	if err := it.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestInventoryIteratorDuplicates(t *testing.T) {
+	svc := newTestInventoryBucket(t)
+	fileKeys := [][]string{
+		{"a", "b", "b", "b", "d"},
+		{"c", "d", "e"},
+	}
+	var keys []string
+	for i, objectKeys := range fileKeys {
+		objects := make(chan *InventoryObject)
+		go func(objectKeys []string) {
+			defer close(objects)
+			for _, key := range objectKeys {
+				objects <- &InventoryObject{
+					Bucket:             inventoryBucketName,
+					Key:                key,
+					Size:               swag.Int64(500),
+					LastModifiedMillis: swag.Int64(time.Now().Unix() * 1000),
+					Checksum:           swag.String("abcdefg"),
+				}
+			}
+		}(objectKeys)
+		key := fmt.Sprintf("myFile%d.orc", i)
+		uploadFile(t, svc, inventoryBucketName, key, objects)
+		keys = append(keys, key)
+	}
+	reader := NewReader(context.Background(), svc, logging.Default())
+	testdata := []struct {
+		name     string
+		opts     []IteratorOption
+		expected []string
+	}{
+		{name: "disabled", opts: []IteratorOption{WithSorted(true)}},
+		{name: "sorted", opts: []IteratorOption{WithSorted(true), WithDetectDuplicates(true)}, expected: []string{"b", "d"}},
+		{name: "unsorted", opts: []IteratorOption{WithDetectDuplicates(true)}, expected: []string{"b"}},
+	}
+	for _, test := range testdata {
+		t.Run(test.name, func(t *testing.T) {
+			it := NewInventoryIterator(reader, OrcFormatName, "", inventoryBucketName, keys, test.opts...)
+			count := 0
+			for it.Next() {
+				count++
+			}
+			if it.Err() != nil {
+				t.Fatal(it.Err())
+			}
+			if count != 8 {
+				t.Fatalf("unexpected number of objects. expected=%d, got=%d", 8, count)
+			}
+			if diff := deep.Equal(it.Duplicates(), test.expected); diff != nil {
+				t.Fatalf("unexpected duplicates: %s", diff)
+			}
+			if err := it.Close(); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+// hangingS3Client blocks the first GetObject requests until their context is done.
+type hangingS3Client struct {
+	countingS3Client
+	hangs int32
+}
+
+func (c *hangingS3Client) GetObjectWithContext(ctx aws.Context, input *s3.GetObjectInput, opts ...request.Option) (*s3.GetObjectOutput, error) {
+	if atomic.AddInt32(&c.getObjectCalls, 1) <= c.hangs {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	return c.S3API.GetObjectWithContext(ctx, input, opts...)
+}
+
+func TestPerFileDownloadTimeout(t *testing.T) {
+	svc := newTestInventoryBucket(t)
+	uploadFile(t, svc, inventoryBucketName, "myFile.orc", objs(100, []time.Time{time.Now()}))
+	testdata := []struct {
+		name          string
+		hangs         int32
+		retries       int
+		expectedCalls int32
+		expectedErr   bool
+	}{
+		{name: "retried after timeout", hangs: 1, retries: 1, expectedCalls: 2},
+		{name: "timed out", hangs: 2, retries: 1, expectedCalls: 2, expectedErr: true},
+	}
+	for _, test := range testdata {
+		t.Run(test.name, func(t *testing.T) {
+			client := &hangingS3Client{countingS3Client: countingS3Client{S3API: svc}, hangs: test.hangs}
+			reader := NewReader(context.Background(), client, logging.Default(), WithPerFileDownloadTimeout(50*time.Millisecond),
+				WithDownloadRetries(test.retries), WithDownloadBackoff(time.Millisecond))
+			fileReader, err := reader.GetFileReader(OrcFormatName, "", inventoryBucketName, "myFile.orc")
+			if client.getObjectCalls != test.expectedCalls {
+				t.Fatalf("unexpected number of download attempts. expected=%d, got=%d", test.expectedCalls, client.getObjectCalls)
+			}
+			if test.expectedErr {
+				if !errors.Is(err, ErrDownloadTimeout) || !errors.Is(err, ErrInventoryDownload) {
+					t.Fatalf("expected error %v, got %v", ErrDownloadTimeout, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fileReader.GetNumRows() != 100 {
+				t.Fatalf("unexpected number of rows. expected=%d, got=%d", 100, fileReader.GetNumRows())
+			}
+			_ = fileReader.Close()
+		})
+	}
+}
+
+func TestIsVersionedSchema(t *testing.T) {
+	testdata := []struct {
+		schema   string
+		expected bool
+	}{
+		{schema: "struct<bucket:string,key:string,version_id:string,is_latest:boolean,size:bigint>", expected: true},
+		{schema: "message s3.inventory { required binary bucket (STRING); required binary key (STRING); optional binary version_id (STRING);}", expected: true},
+		{schema: "Bucket, Key, VersionId, IsLatest, IsDeleteMarker, Size", expected: true},
+		{schema: "struct<bucket:string,key:string,size:bigint,last_modified_date:timestamp,e_tag:string>"},
+		{schema: "Bucket, Key, Size, LastModifiedDate, ETag"},
+		{schema: ""},
+	}
+	for _, test := range testdata {
+		if versioned := IsVersionedSchema(test.schema); versioned != test.expected {
+			t.Errorf("unexpected versioned for schema %q. expected=%t, got=%t", test.schema, test.expected, versioned)
+		}
+	}
+}
+
+func TestVersionedInventory(t *testing.T) {
+	svc := newTestInventoryBucket(t)
+	uploadOrcWithSchema(t, svc, "versioned.orc", "struct<bucket:string,key:string,version_id:string,is_latest:boolean>",
+		[]interface{}{inventoryBucketName, "f00000", "v2", true},
+		[]interface{}{inventoryBucketName, "f00000", "v1", false},
+		[]interface{}{inventoryBucketName, "f00001", nil, true},
+	)
+	versions := []*InventoryObject{
+		{Bucket: inventoryBucketName, Key: "f00000", VersionID: swag.String("v2"), IsLatest: swag.Bool(true)},
+		{Bucket: inventoryBucketName, Key: "f00000", VersionID: swag.String("v1"), IsLatest: swag.Bool(false)},
+		{Bucket: inventoryBucketName, Key: "f00001", IsLatest: swag.Bool(true)},
+	}
+	objects := make(chan *InventoryObject, len(versions))
+	for _, obj := range versions {
+		objects <- obj
+	}
+	close(objects)
+	uploadBytes(t, svc, "versioned.parquet", generateParquet(t, 5, objects))
+	testdata := []struct {
+		format string
+		key    string
+	}{
+		{format: OrcFormatName, key: "versioned.orc"},
+		{format: ParquetFormatName, key: "versioned.parquet"},
+	}
+	for _, test := range testdata {
+		t.Run(test.format, func(t *testing.T) {
+			reader := NewReader(context.Background(), svc, logging.Default())
+			fileReader, err := reader.GetFileReader(test.format, "", inventoryBucketName, test.key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() {
+				_ = fileReader.Close()
+			}()
+			res := make([]InventoryObject, len(versions))
+			if err = fileReader.Read(&res); err != nil {
+				t.Fatal(err)
+			}
+			if len(res) != len(versions) {
+				t.Fatalf("unexpected number of objects. expected=%d, got=%d", len(versions), len(res))
+			}
+			for i, obj := range res {
+				if diff := deep.Equal(obj, *versions[i]); diff != nil {
+					t.Fatalf("unexpected object at index %d: %v", i, diff)
+				}
+			}
+		})
+	}
+}
+
+func TestBytesRead(t *testing.T) {
+	svc := newTestInventoryBucket(t)
+	lastModified := []time.Time{time.Now()}
+	uploadFile(t, svc, inventoryBucketName, "read.orc", objs(100, lastModified))
+	parquetRows := make([]interface{}, 0, 100)
+	for o := range objs(100, lastModified) {
+		parquetRows = append(parquetRows, *o)
+	}
+	uploadParquet(t, svc, "read.parquet", new(InventoryObject), parquetRows...)
+	files := map[string]string{
+		OrcFormatName:     "read.orc",
+		ParquetFormatName: "read.parquet",
+	}
+	for format, key := range files {
+		t.Run(format, func(t *testing.T) {
+			reader := NewReader(context.Background(), svc, logging.Default())
+			fileReader, err := reader.GetFileReader(format, "", inventoryBucketName, key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() {
+				_ = fileReader.Close()
+			}()
+			res := make([]InventoryObject, 100)
+			if err = fileReader.Read(&res); err != nil {
+				t.Fatal(err)
+			}
+			if len(res) != 100 {
+				t.Fatalf("read unexpected number of rows. expected=100, got=%d", len(res))
+			}
+			bytesRead := fileReader.BytesRead()
+			if bytesRead <= 0 {
+				t.Fatalf("expected bytes to be read, got %d", bytesRead)
+			}
+			if err = fileReader.Rewind(); err != nil {
+				t.Fatal(err)
+			}
+			if fileReader.BytesRead() < bytesRead {
+				t.Fatalf("bytes read decreased after rewind. before=%d, after=%d", bytesRead, fileReader.BytesRead())
+			}
+		})
+	}
+}
+
+func TestParseFileSchema(t *testing.T) {
+	testdata := []struct {
+		name     string
+		schema   string
+		expected []string
+		fail     bool
+	}{
+		{name: "simple", schema: "Bucket, Key, Size", expected: []string{"Bucket", "Key", "Size"}},
+		{name: "no spaces", schema: "Bucket,Key,Size", expected: []string{"Bucket", "Key", "Size"}},
+		{name: "extra whitespace", schema: " \tBucket ,  Key\n, Size  ", expected: []string{"Bucket", "Key", "Size"}},
+		{name: "single column", schema: "Key", expected: []string{"Key"}},
+		{name: "quoted", schema: `"Bucket", "Key"`, expected: []string{"Bucket", "Key"}},
+		{name: "quoted with spaces around", schema: `  "Bucket"  ,Key`, expected: []string{"Bucket", "Key"}},
+		{name: "quoted keeps inner spaces", schema: `" Bucket ", Key`, expected: []string{" Bucket ", "Key"}},
+		{name: "quoted comma", schema: `"Bucket,Name", Key`, expected: []string{"Bucket,Name", "Key"}},
+		{name: "escaped quote", schema: `"Say ""hi""", Key`, expected: []string{`Say "hi"`, "Key"}},
+		{name: "empty", schema: "", fail: true},
+		{name: "whitespace only", schema: " \t ", fail: true},
+		{name: "trailing comma", schema: "Bucket, Key,", fail: true},
+		{name: "leading comma", schema: ",Bucket, Key", fail: true},
+		{name: "double comma", schema: "Bucket,, Key", fail: true},
+		{name: "blank column", schema: "Bucket, , Key", fail: true},
+		{name: "empty quoted", schema: `Bucket, "", Key`, fail: true},
+		{name: "duplicate", schema: "Bucket, Key, Key", fail: true},
+		{name: "duplicate quoted", schema: `Key, "Key"`, fail: true},
+		{name: "unterminated quote", schema: `Bucket, "Key`, fail: true},
+		{name: "text after quote", schema: `"Bucket"x, Key`, fail: true},
+		{name: "quote inside name", schema: `Buc"ket", Key`, fail: true},
+		{name: "lone quote", schema: `"`, fail: true},
+	}
+	for _, test := range testdata {
+		t.Run(test.name, func(t *testing.T) {
+			names, err := parseFileSchema(test.schema)
+			if test.fail {
+				if !errors.Is(err, ErrMalformedFileSchema) {
+					t.Fatalf("expected error %v, got %v (names=%q)", ErrMalformedFileSchema, err, names)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("failed to parse schema %q: %v", test.schema, err)
+			}
+			if diff := deep.Equal(names, test.expected); diff != nil {
+				t.Fatalf("unexpected column names: %s", diff)
+			}
+		})
+	}
+}
+
+func TestStripeLogSampling(t *testing.T) {
+	svc := newTestInventoryBucket(t)
+	// the ORC writer starts a new stripe at most every 10000 rows
+	const numObjects = 75000
+	uploadFile(t, svc, inventoryBucketName, "stripes.orc", objs(numObjects, []time.Time{time.Now()}))
+	testdata := []struct {
+		name     string
+		opts     []ReaderOption
+		interval int
+	}{
+		{name: "default", interval: DefaultStripeLogInterval},
+		{name: "every 3", opts: []ReaderOption{WithStripeLogInterval(3)}, interval: 3},
+		{name: "every stripe", opts: []ReaderOption{WithStripeLogInterval(1)}, interval: 1},
+		{name: "invalid ignored", opts: []ReaderOption{WithStripeLogInterval(0)}, interval: DefaultStripeLogInterval},
+	}
+	for _, test := range testdata {
+		t.Run(test.name, func(t *testing.T) {
+			logger := newCapturingLogger()
+			reader := NewReader(context.Background(), svc, logger, test.opts...)
+			fileReader, err := reader.GetFileReader(OrcFormatName, "", inventoryBucketName, "stripes.orc")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() {
+				_ = fileReader.Close()
+			}()
+			numStripes, err := fileReader.(*OrcInventoryFileReader).reader.NumStripes()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if numStripes < 2*test.interval && test.interval < DefaultStripeLogInterval {
+				t.Fatalf("expected the file to have at least %d stripes, got %d", 2*test.interval, numStripes)
+			}
+			res := make([]InventoryObject, numObjects)
+			if err = fileReader.Read(&res); err != nil {
+				t.Fatal(err)
+			}
+			var logged []int
+			for _, entry := range *logger.entries {
+				if entry.message == "start new stripe" {
+					logged = append(logged, entry.fields["stripe"].(int))
+				}
+			}
+			var expected []int
+			for i := 0; i < numStripes; i++ {
+				if i%test.interval == 0 || i == numStripes-1 {
+					expected = append(expected, i)
+				}
+			}
+			if diff := deep.Equal(logged, expected); diff != nil {
+				t.Fatalf("unexpected stripes logged out of %d: %s", numStripes, diff)
+			}
+		})
+	}
+}
+
+func TestInventoryObjectEqualAndHash(t *testing.T) {
+	base := InventoryObject{Bucket: "b", Key: "k", Size: swag.Int64(10), LastModifiedMillis: swag.Int64(1000), Checksum: swag.String("etag")}
+	with := func(modify func(o *InventoryObject)) InventoryObject {
+		o := base
+		modify(&o)
+		return o
+	}
+	testdata := []struct {
+		name  string
+		other InventoryObject
+		equal bool
+	}{
+		{name: "same", other: with(func(o *InventoryObject) {}), equal: true},
+		{name: "same values, other pointers", other: with(func(o *InventoryObject) {
+			o.Size, o.LastModifiedMillis, o.Checksum = swag.Int64(10), swag.Int64(1000), swag.String("etag")
+		}), equal: true},
+		{name: "other bucket", other: with(func(o *InventoryObject) { o.Bucket = "other" }), equal: true},
+		{name: "other flags", other: with(func(o *InventoryObject) { o.IsLatest, o.VersionID = swag.Bool(false), swag.String("v1") }), equal: true},
+		{name: "other key", other: with(func(o *InventoryObject) { o.Key = "k2" })},
+		{name: "other size", other: with(func(o *InventoryObject) { o.Size = swag.Int64(11) })},
+		{name: "other last modified", other: with(func(o *InventoryObject) { o.LastModifiedMillis = swag.Int64(1001) })},
+		{name: "other etag", other: with(func(o *InventoryObject) { o.Checksum = swag.String("etag2") })},
+		{name: "nil size", other: with(func(o *InventoryObject) { o.Size = nil })},
+		{name: "nil last modified", other: with(func(o *InventoryObject) { o.LastModifiedMillis = nil })},
+		{name: "nil etag", other: with(func(o *InventoryObject) { o.Checksum = nil })},
+		{name: "zero size", other: with(func(o *InventoryObject) { o.Size = swag.Int64(0) })},
+		{name: "empty etag", other: with(func(o *InventoryObject) { o.Checksum = swag.String("") })},
+		{name: "key and etag shifted", other: with(func(o *InventoryObject) { o.Key, o.Checksum = "ke", swag.String("ktag") })},
+	}
+	for _, test := range testdata {
+		t.Run(test.name, func(t *testing.T) {
+			if equal := base.Equal(test.other); equal != test.equal {
+				t.Fatalf("unexpected equality. expected=%t, got=%t", test.equal, equal)
+			}
+			if equal := test.other.Equal(base); equal != test.equal {
+				t.Fatalf("unexpected reverse equality. expected=%t, got=%t", test.equal, equal)
+			}
+			if hashEqual := base.Hash() == test.other.Hash(); hashEqual != test.equal {
+				t.Fatalf("unexpected hash equality. expected=%t, got=%t", test.equal, hashEqual)
+			}
+		})
+	}
+	var empty InventoryObject
+	if !empty.Equal(InventoryObject{}) || empty.Hash() != (InventoryObject{}).Hash() {
+		t.Fatal("expected objects with no fields set to be equal")
+	}
+	if empty.Equal(InventoryObject{Size: swag.Int64(0)}) || empty.Hash() == (InventoryObject{Size: swag.Int64(0)}).Hash() {
+		t.Fatal("expected a missing size to differ from a zero size")
+	}
+	// the hash is persisted by callers, so it must not change
+	const expectedHash = 12926798386618916510
+	if hash := base.Hash(); hash != expectedHash {
+		t.Fatalf("unexpected hash. expected=%d, got=%d", uint64(expectedHash), hash)
+	}
+}
+
+// proxyTransport sends all requests to host, counting them, like a proxy would.
+type proxyTransport struct {
+	host     string
+	requests int32
+}
+
+func (p *proxyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	atomic.AddInt32(&p.requests, 1)
+	req = req.Clone(req.Context())
+	req.URL.Host = p.host
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestCustomHTTPClient(t *testing.T) {
+	svc, testServer := getS3Fake(t)
+	defer testServer.Close()
+	_, err := svc.CreateBucket(&s3.CreateBucketInput{
+		Bucket: aws.String(inventoryBucketName),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	uploadFile(t, svc, inventoryBucketName, "proxied.orc", objs(10, []time.Time{time.Now()}))
+	parquetRows := make([]interface{}, 0, 10)
+	for o := range objs(10, []time.Time{time.Now()}) {
+		parquetRows = append(parquetRows, *o)
+	}
+	uploadParquet(t, svc, "proxied.parquet", new(InventoryObject), parquetRows...)
+	csvRows := make([]string, 0, 10)
+	for i := 0; i < 10; i++ {
+		csvRows = append(csvRows, fmt.Sprintf(`"%s","f%05d"`, inventoryBucketName, i))
+	}
+	uploadCSV(t, svc, "proxied.csv.gz", csvRows)
+	files := map[string]string{
+		OrcFormatName:     "proxied.orc",
+		ParquetFormatName: "proxied.parquet",
+		CSVFormatName:     "proxied.csv.gz",
+	}
+	for format, key := range files {
+		t.Run(format, func(t *testing.T) {
+			// the endpoint is only reachable through the transport, so requests sent with another client fail
+			transport := &proxyTransport{host: strings.TrimPrefix(testServer.URL, "http://")}
+			cfg := aws.NewConfig().WithEndpoint("http://s3.proxied.invalid").WithHTTPClient(&http.Client{Transport: transport})
+			sess, err := session.NewSession(svc.(*s3.S3).Config.Copy())
+			if err != nil {
+				t.Fatal(err)
+			}
+			reader := NewReader(context.Background(), s3.New(sess, cfg), logging.Default())
+			fileReader, err := reader.GetFileReader(format, "Bucket, Key", inventoryBucketName, key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() {
+				_ = fileReader.Close()
+			}()
+			res := make([]InventoryObject, 10)
+			if err = fileReader.Read(&res); err != nil {
+				t.Fatal(err)
+			}
+			if len(res) != 10 {
+				t.Fatalf("read unexpected number of rows. expected=10, got=%d", len(res))
+			}
+			if atomic.LoadInt32(&transport.requests) == 0 {
+				t.Fatal("expected the inventory file to be read through the custom HTTP client")
+			}
+		})
+	}
+}
+
+func TestInventoryBucketUnavailable(t *testing.T) {
+	svc, testServer := getS3Fake(t)
+	defer testServer.Close()
+	if err := CheckBucket(context.Background(), svc, "no-such-bucket"); !errors.Is(err, ErrInventoryBucketUnavailable) {
+		t.Fatalf("expected error %v, got %v", ErrInventoryBucketUnavailable, err)
+	}
+	reader := NewReader(context.Background(), svc, logging.Default(), WithDownloadRetries(0))
+	_, err := reader.GetFileReader(OrcFormatName, "", "no-such-bucket", "myFile.orc")
+	var awsErr awserr.Error
+	if !errors.Is(err, ErrInventoryBucketUnavailable) || !errors.As(err, &awsErr) || awsErr.Code() != s3.ErrCodeNoSuchBucket {
+		t.Fatalf("expected error %v wrapping %s, got %v", ErrInventoryBucketUnavailable, s3.ErrCodeNoSuchBucket, err)
+	}
+	if errors.Is(err, ErrManifestFileNotFound) {
+		t.Fatalf("expected a missing bucket not to be reported as %v", ErrManifestFileNotFound)
+	}
+	_, err = svc.CreateBucket(&s3.CreateBucketInput{
+		Bucket: aws.String(inventoryBucketName),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = CheckBucket(context.Background(), svc, inventoryBucketName); err != nil {
+		t.Fatalf("unexpected error checking existing bucket: %v", err)
+	}
+}
+
+// storageClassParquetRow is a row of a parquet inventory with optional storage class columns, as written by S3.
+type storageClassParquetRow struct {
+	Bucket              string  `parquet:"name=bucket, type=UTF8"`
+	Key                 string  `parquet:"name=key, type=UTF8"`
+	StorageClass        *string `parquet:"name=storage_class, type=UTF8"`
+	IsMultipartUploaded *bool   `parquet:"name=is_multipart_uploaded, type=BOOLEAN"`
+}
+
+func TestStorageClass(t *testing.T) {
+	svc := newTestInventoryBucket(t)
+	uploadOrcWithSchema(t, svc, "classes.orc", "struct<bucket:string,key:string,storage_class:string,is_multipart_uploaded:boolean>",
+		[]interface{}{"b", "k1", "STANDARD", false},
+		[]interface{}{"b", "k2", "GLACIER", true},
+		[]interface{}{"b", "k3", nil, nil})
+	uploadParquet(t, svc, "classes.parquet", new(storageClassParquetRow),
+		storageClassParquetRow{Bucket: "b", Key: "k1", StorageClass: swag.String("STANDARD"), IsMultipartUploaded: swag.Bool(false)},
+		storageClassParquetRow{Bucket: "b", Key: "k2", StorageClass: swag.String("GLACIER"), IsMultipartUploaded: swag.Bool(true)},
+		storageClassParquetRow{Bucket: "b", Key: "k3"})
+	uploadCSV(t, svc, "classes.csv.gz", []string{`"b","k1","STANDARD","false"`, `"b","k2","GLACIER","true"`, `"b","k3","",""`})
+	uploadOrcWithSchema(t, svc, "no_classes.orc", "struct<bucket:string,key:string>", []interface{}{"b", "k1"})
+	testdata := []struct {
+		format   string
+		key      string
+		expected []InventoryObject
+	}{
+		{format: OrcFormatName, key: "classes.orc"},
+		{format: ParquetFormatName, key: "classes.parquet"},
+		{format: CSVFormatName, key: "classes.csv.gz"},
+		{format: OrcFormatName, key: "no_classes.orc", expected: []InventoryObject{{Bucket: "b", Key: "k1"}}},
+	}
+	for _, test := range testdata {
+		t.Run(test.key, func(t *testing.T) {
+			expected := test.expected
+			if expected == nil {
+				expected = []InventoryObject{
+					{Bucket: "b", Key: "k1", StorageClass: "STANDARD"},
+					{Bucket: "b", Key: "k2", StorageClass: "GLACIER", IsMultipartUploaded: true},
+					{Bucket: "b", Key: "k3"},
+				}
+			}
+			reader := NewReader(context.Background(), svc, logging.Default())
+			fileReader, err := reader.GetFileReader(test.format, "Bucket, Key, StorageClass, IsMultipartUploaded", inventoryBucketName, test.key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() {
+				_ = fileReader.Close()
+			}()
+			res := make([]InventoryObject, len(expected)+1)
+			if err = fileReader.Read(&res); err != nil {
+				t.Fatal(err)
+			}
+			if diff := deep.Equal(res, expected); diff != nil {
+				t.Fatalf("unexpected objects read: %s", diff)
+			}
+		})
+	}
+}
+
+func TestReaderCount(t *testing.T) {
+	svc := newTestInventoryBucket(t)
+	lastModified := []time.Time{time.Now()}
+	uploadFile(t, svc, inventoryBucketName, "count1.orc", objs(30, lastModified))
+	uploadFile(t, svc, inventoryBucketName, "count2.orc", objs(12, lastModified))
+	parquetRows := make([]interface{}, 0, 25)
+	for o := range objs(25, lastModified) {
+		parquetRows = append(parquetRows, *o)
+	}
+	uploadParquet(t, svc, "count.parquet", new(InventoryObject), parquetRows...)
+	uploadCSV(t, svc, "count.csv.gz", []string{`"b","k1","true"`, `"b","k1","false"`, `"b","k2","true"`})
+	uploadAvro(t, svc, "count.avro", goavro.CompressionNullLabel,
+		map[string]interface{}{"bucket": "b", "key": "k1"}, map[string]interface{}{"bucket": "b", "key": "k2"})
+	uploadOrcWithSchema(t, svc, "versions.orc", "struct<bucket:string,key:string,is_latest:boolean>",
+		[]interface{}{"b", "k1", true}, []interface{}{"b", "k1", false}, []interface{}{"b", "k2", true})
+	testdata := []struct {
+		name     string
+		format   string
+		keys     []string
+		opts     []ReaderOption
+		expected int64
+	}{
+		{name: "orc", format: OrcFormatName, keys: []string{"count1.orc", "count2.orc"}, expected: 42},
+		{name: "parquet", format: ParquetFormatName, keys: []string{"count.parquet"}, expected: 25},
+		{name: "csv", format: CSVFormatName, keys: []string{"count.csv.gz"}, expected: 3},
+		{name: "avro", format: AvroFormatName, keys: []string{"count.avro"}, expected: 2},
+		{name: "no files", format: OrcFormatName, expected: 0},
+		{name: "orc filtered", format: OrcFormatName, keys: []string{"versions.orc"}, opts: []ReaderOption{WithLatestOnly(true)}, expected: 2},
+		{name: "csv filtered", format: CSVFormatName, keys: []string{"count.csv.gz"}, opts: []ReaderOption{WithLatestOnly(true)}, expected: 2},
+	}
+	for _, test := range testdata {
+		t.Run(test.name, func(t *testing.T) {
+			reg := prometheus.NewRegistry()
+			reader := NewReader(context.Background(), svc, logging.Default(), append(test.opts, WithMetricsRegisterer(reg))...)
+			count, err := reader.Count(context.Background(), test.format, "Bucket, Key, IsLatest", inventoryBucketName, test.keys)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if count != test.expected {
+				t.Fatalf("unexpected count. expected=%d, got=%d", test.expected, count)
+			}
+			// unfiltered files are counted from their metadata, without reading their rows again
+			if rowsRead := rowsReadMetric(t, reg); len(test.opts) == 0 && rowsRead != 0 {
+				t.Fatalf("expected no rows to be read when counting unfiltered files, read %v", rowsRead)
+			}
+		})
+	}
+}
+
+func TestInventoryIteratorReadAhead(t *testing.T) {
+	svc := newTestInventoryBucket(t)
+	lastModified := []time.Time{time.Now()}
+	keys := []string{"ahead1.orc", "ahead2.orc", "ahead3.orc"}
+	for _, key := range keys {
+		uploadFile(t, svc, inventoryBucketName, key, objs(1500, lastModified))
+	}
+	reader := NewReader(context.Background(), svc, logging.Default())
+	it := NewInventoryIterator(reader, OrcFormatName, "", inventoryBucketName, keys, WithReadAhead(100))
+	count := 0
+	for it.Next() {
+		expectedKey := fmt.Sprintf("f%05d", count%1500)
+		if it.Get().Key != expectedKey {
+			t.Fatalf("unexpected key at index %d. expected=%s, got=%s", count, expectedKey, it.Get().Key)
+		}
+		count++
+	}
+	if it.Err() != nil {
+		t.Fatal(it.Err())
+	}
+	if count != 4500 {
+		t.Fatalf("unexpected number of objects. expected=%d, got=%d", 4500, count)
+	}
+	if err := it.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// closing before the files are read stops the background reads
+	it = NewInventoryIterator(reader, OrcFormatName, "", inventoryBucketName, keys, WithReadAhead(10))
+	for i := 0; i < 20; i++ {
+		if !it.Next() {
+			t.Fatalf("iteration stopped after %d objects: %v", i, it.Err())
+		}
+	}
+	ahead := it.ahead
+	if err := it.Close(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-ahead.finished:
+	case <-time.After(5 * time.Second):
+		t.Fatal("background reads still running after close")
+	}
+	if it.Next() {
+		t.Fatal("expected no objects after close")
+	}
+
+	// errors of the background reads are returned once the objects read before them are consumed
+	it = NewInventoryIterator(reader, OrcFormatName, "", inventoryBucketName, []string{keys[0], "missing.orc"}, WithReadAhead(10))
+	count = 0
+	for it.Next() {
+		count++
+	}
+	if count != 1500 || !errors.Is(it.Err(), ErrManifestFileNotFound) {
+		t.Fatalf("expected %d objects and error %v, got %d objects and error %v", 1500, ErrManifestFileNotFound, count, it.Err())
+	}
+	if err := it.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// blockingMetadataReader blocks reading the metadata of inventory files until release is closed, signaling started
+// once the first read is blocked.
+type blockingMetadataReader struct {
+	IReader
+	startOnce sync.Once
+	started   chan struct{}
+	release   chan struct{}
+}
+
+func (r *blockingMetadataReader) GetMetadataReader(format string, schema string, bucket string, key string) (MetadataReader, error) {
+	r.startOnce.Do(func() {
+		close(r.started)
+	})
+	<-r.release
+	return r.IReader.GetMetadataReader(format, schema, bucket, key)
+}
+
+func TestInventoryIteratorReadAheadRemaining(t *testing.T) {
+	svc := newTestInventoryBucket(t)
+	keys := []string{"remaining1.orc", "remaining2.orc", "remaining3.orc"}
+	for _, key := range keys {
+		uploadFile(t, svc, inventoryBucketName, key, objs(1500, []time.Time{time.Now()}))
+	}
+	reader := &blockingMetadataReader{
+		IReader: NewReader(context.Background(), svc, logging.Default()),
+		started: make(chan struct{}),
+		release: make(chan struct{}),
+	}
+	it := NewInventoryIterator(reader, OrcFormatName, "", inventoryBucketName, keys, WithReadAhead(10))
+	defer func() {
+		_ = it.Close()
+	}()
+	if !it.Next() {
+		t.Fatal(it.Err())
+	}
+	remaining := make(chan int64, 1)
+	go func() {
+		remaining <- it.Remaining()
+	}()
+	<-reader.started
+	// the background reads open the second file while the row counts of the others are read
+	consumed := make(chan error, 1)
+	go func() {
+		for i := 1; i < 1600; i++ {
+			if !it.Next() {
+				consumed <- fmt.Errorf("iteration stopped after %d objects: %v", i, it.Err())
+				return
+			}
+		}
+		consumed <- nil
+	}()
+	select {
+	case err := <-consumed:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("background reads blocked while reading the row counts of the files")
+	}
+	close(reader.release)
+	// one object may be in flight between the inner iterator and the objects read ahead
+	if n := <-remaining; n < 4500-1600-1 || n > 4500-1600 {
+		t.Fatalf("unexpected number of remaining objects. expected=%d, got=%d", 4500-1600, n)
+	}
+}
+
+// openedFilesReader records the inventory files opened for reading their objects.
+type openedFilesReader struct {
+	IReader
+	opened []string
+}
+
+func (r *openedFilesReader) GetFileReader(format string, schema string, bucket string, key string) (FileReader, error) {
+	r.opened = append(r.opened, key)
+	return r.IReader.GetFileReader(format, schema, bucket, key)
+}
+
+func TestInventoryIteratorSeekGE(t *testing.T) {
+	svc := newTestInventoryBucket(t)
+	// each file holds 1000 consecutive keys, f00000-f00999 in the first one
+	keysInRange := func(from, to int) <-chan *InventoryObject {
+		out := make(chan *InventoryObject)
+		go func() {
+			defer close(out)
+			for obj := range objs(to, []time.Time{time.Now()}) {
+				if obj.Key >= fmt.Sprintf("f%05d", from) {
+					out <- obj
+				}
+			}
+		}()
+		return out
+	}
+	orcKeys := []string{"seek1.orc", "seek2.orc", "seek3.orc"}
+	parquetKeys := []string{"seek1.parquet", "seek2.parquet", "seek3.parquet"}
+	for i := range orcKeys {
+		uploadFile(t, svc, inventoryBucketName, orcKeys[i], keysInRange(i*1000, (i+1)*1000))
+		var rows []interface{}
+		for j := i * 1000; j < (i+1)*1000; j++ {
+			rows = append(rows, parquetKeyOnlyRow{Bucket: inventoryBucketName, Key: fmt.Sprintf("f%05d", j)})
+		}
+		uploadParquet(t, svc, parquetKeys[i], new(parquetKeyOnlyRow), rows...)
+	}
+	reversed := []string{orcKeys[2], orcKeys[1], orcKeys[0]}
+	testdata := []struct {
+		name   string
+		format string
+		keys   []string
+		opts   []IteratorOption
+		seek   string
+		opened []string
+	}{
+		{name: "orc", format: OrcFormatName, keys: orcKeys, seek: "f01500", opened: orcKeys[1:]},
+		{name: "parquet", format: ParquetFormatName, keys: parquetKeys, seek: "f01500", opened: parquetKeys[1:]},
+		{name: "file boundary", format: OrcFormatName, keys: orcKeys, seek: "f00999z", opened: orcKeys[1:]},
+		{name: "between keys", format: OrcFormatName, keys: orcKeys, seek: "f01499z", opened: orcKeys[1:]},
+		{name: "sorted", format: OrcFormatName, keys: reversed, opts: []IteratorOption{WithSorted(true)}, seek: "f01500", opened: reversed[:2]},
+		{name: "before first", format: OrcFormatName, keys: orcKeys, seek: "a", opened: orcKeys},
+	}
+	for _, test := range testdata {
+		t.Run(test.name, func(t *testing.T) {
+			reader := &openedFilesReader{IReader: NewReader(context.Background(), svc, logging.Default())}
+			it := NewInventoryIterator(reader, test.format, "", inventoryBucketName, test.keys, test.opts...)
+			defer func() { _ = it.Close() }()
+			if err := it.SeekGE(test.seek); err != nil {
+				t.Fatal(err)
+			}
+			var keys []string
+			for it.Next() {
+				keys = append(keys, it.Get().Key)
+			}
+			if it.Err() != nil {
+				t.Fatal(it.Err())
+			}
+			expected := expectedSeekKeys(test.seek)
+			if diff := deep.Equal(keys, expected); diff != nil {
+				t.Fatalf("unexpected keys after seeking to %s: %v", test.seek, diff)
+			}
+			if diff := deep.Equal(reader.opened, test.opened); diff != nil {
+				t.Fatalf("unexpected files opened. expected=%v, got=%v", test.opened, reader.opened)
+			}
+		})
+	}
+
+	// seeking in the middle of the iteration moves forward only
+	reader := NewReader(context.Background(), svc, logging.Default())
+	it := NewInventoryIterator(reader, OrcFormatName, "", inventoryBucketName, orcKeys)
+	for i := 0; i < 10; i++ {
+		it.Next()
+	}
+	if err := it.SeekGE("f00005"); err != nil {
+		t.Fatal(err)
+	}
+	if !it.Next() || it.Get().Key != "f00010" {
+		t.Fatalf("unexpected key after seeking backwards. expected=f00010, got=%s", it.Get().Key)
+	}
+	if err := it.SeekGE("f02990"); err != nil {
+		t.Fatal(err)
+	}
+	if !it.Next() || it.Get().Key != "f02990" {
+		t.Fatalf("unexpected key after seeking. expected=f02990, got=%s", it.Get().Key)
+	}
+	if remaining := it.Remaining(); remaining != 9 {
+		t.Fatalf("unexpected remaining objects after seeking. expected=9, got=%d", remaining)
+	}
+	if err := it.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	it = NewInventoryIterator(reader, OrcFormatName, "", inventoryBucketName, orcKeys, WithReadAhead(10))
+	if err := it.SeekGE("f01500"); !errors.Is(err, ErrSeekWithReadAhead) {
+		t.Fatalf("expected %v seeking an iterator reading ahead, got %v", ErrSeekWithReadAhead, err)
+	}
+	if err := it.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// expectedSeekKeys returns the keys of TestInventoryIteratorSeekGE greater than or equal to key.
+func expectedSeekKeys(key string) []string {
+	var keys []string
+	for i := 0; i < 3000; i++ {
+		if k := fmt.Sprintf("f%05d", i); k >= key {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+func TestOrcOpenRetries(t *testing.T) {
+	svc := newTestInventoryBucket(t)
+	uploadFile(t, svc, inventoryBucketName, "flaky.orc", objs(10, []time.Time{time.Now()}))
+	transientErr := &os.PathError{Op: "read", Path: "flaky.orc", Err: syscall.EIO}
+	testdata := []struct {
+		name             string
+		failures         int
+		failure          error
+		retries          int
+		expectedAttempts int
+		expectedErr      error
+	}{
+		{name: "transient", failures: 1, failure: transientErr, retries: DefaultOrcOpenRetries, expectedAttempts: 2},
+		{name: "no_retries", failures: 1, failure: transientErr, retries: 0, expectedAttempts: 1, expectedErr: syscall.EIO},
+		{name: "exhausted", failures: 5, failure: transientErr, retries: 2, expectedAttempts: 3, expectedErr: syscall.EIO},
+		{name: "corrupt", failures: 1, failure: ErrMalformedOrcFile, retries: DefaultOrcOpenRetries, expectedAttempts: 1, expectedErr: ErrMalformedOrcFile},
+	}
+	for _, test := range testdata {
+		t.Run(test.name, func(t *testing.T) {
+			reader := NewReader(context.Background(), svc, logging.Default(), WithOrcOpenRetries(test.retries))
+			reader.orcOpenBackoff = time.Millisecond
+			attempts := 0
+			reader.openOrc = func(ctx context.Context, orcFile orcSource, columns map[string]bool) (*OrcInventoryFileReader, error) {
+				attempts++
+				if attempts <= test.failures {
+					return nil, test.failure
+				}
+				return newOrcInventoryFileReader(ctx, orcFile, columns)
+			}
+			fileReader, err := reader.GetFileReader(OrcFormatName, "", inventoryBucketName, "flaky.orc")
+			if attempts != test.expectedAttempts {
+				t.Fatalf("unexpected attempts to open the file. expected=%d, got=%d", test.expectedAttempts, attempts)
+			}
+			if test.expectedErr != nil {
+				if !errors.Is(err, test.expectedErr) || !errors.Is(err, ErrInventoryParse) {
+					t.Fatalf("expected error %v, got %v", test.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() { _ = fileReader.Close() }()
+			if numRows := fileReader.GetNumRows(); numRows != 10 {
+				t.Fatalf("unexpected number of rows. expected=10, got=%d", numRows)
+			}
+		})
+	}
+}
+
+func TestParquetReadOne(t *testing.T) {
+	svc := newTestInventoryBucket(t)
+	var rows []interface{}
+	for i := 0; i < 250; i++ {
+		rows = append(rows, InventoryObject{
+			Bucket:             "b",
+			Key:                fmt.Sprintf("k%03d", i),
+			Size:               swag.Int64(int64(i)),
+			LastModifiedMillis: swag.Int64(int64(i) * 1000),
+			Checksum:           swag.String(fmt.Sprintf("e%d", i)),
+			IsDeleteMarker:     swag.Bool(i%3 == 0),
+		})
+	}
+	uploadParquet(t, svc, "one.parquet", new(InventoryObject), rows...)
+	for _, opts := range [][]ReaderOption{nil, {WithSkipDeleteMarkers(true)}, {WithConcurrentParquetRowGroups(true)}} {
+		reader := NewReader(context.Background(), svc, logging.Default(), opts...)
+		batchReader, err := reader.GetFileReader(ParquetFormatName, "", inventoryBucketName, "one.parquet")
+		if err != nil {
+			t.Fatal(err)
+		}
+		batch := make([]InventoryObject, len(rows))
+		if err = batchReader.Read(&batch); err != nil {
+			t.Fatal(err)
+		}
+		_ = batchReader.Close()
+		fileReader, err := reader.GetFileReader(ParquetFormatName, "", inventoryBucketName, "one.parquet")
+		if err != nil {
+			t.Fatal(err)
+		}
+		rowReader, ok := fileReader.(RowReader)
+		if !ok {
+			t.Fatalf("parquet file reader %T does not implement RowReader", fileReader)
+		}
+		var res []InventoryObject
+		for {
+			obj, ok, err := rowReader.ReadOne()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !ok {
+				break
+			}
+			res = append(res, obj)
+		}
+		_ = fileReader.Close()
+		if len(batch) == 0 {
+			t.Fatal("expected objects read in a batch")
+		}
+		if diff := deep.Equal(res, batch); diff != nil {
+			t.Fatalf("unexpected objects read one at a time: %s", diff)
+		}
+	}
+}
+
+func TestParquetReadOneConcurrentRowGroups(t *testing.T) {
+	svc := newTestInventoryBucket(t)
+	uploadBytes(t, svc, "groups.parquet", generateParquet(t, 10, objs(45, []time.Time{time.Now()})))
+	reader := NewReader(context.Background(), svc, logging.Default(), WithConcurrentParquetRowGroups(true))
+	fileReader, err := reader.GetFileReader(ParquetFormatName, "", inventoryBucketName, "groups.parquet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = fileReader.Close() }()
+	expected := make([]InventoryObject, 45)
+	if err = fileReader.Read(&expected); err != nil {
+		t.Fatal(err)
+	}
+	if err = fileReader.Rewind(); err != nil {
+		t.Fatal(err)
+	}
+	pr := fileReader.(*ParquetInventoryFileReader)
+	var res []InventoryObject
+	for i := 0; i < 15; i++ {
+		obj, ok, err := pr.ReadOne()
+		if err != nil || !ok {
+			t.Fatalf("failed to read row %d: ok=%t, err=%v", i, ok, err)
+		}
+		res = append(res, obj)
+	}
+	if pr.rowGroups != nil {
+		t.Fatal("expected rows read one at a time not to start the row group pipeline")
+	}
+	// batches read after ReadOne continue from the same row
+	batch := make([]InventoryObject, len(expected))
+	if err = pr.Read(&batch); err != nil {
+		t.Fatal(err)
+	}
+	if pr.rowGroups != nil {
+		t.Fatal("expected batches read after ReadOne not to start the row group pipeline")
+	}
+	res = append(res, batch...)
+	if diff := deep.Equal(res, expected); diff != nil {
+		t.Fatalf("unexpected objects: %s", diff)
+	}
+
+	// once rewound, batches are read by the pipeline again
+	if err = pr.Rewind(); err != nil {
+		t.Fatal(err)
+	}
+	batch = make([]InventoryObject, 5)
+	if err = pr.Read(&batch); err != nil {
+		t.Fatal(err)
+	}
+	if pr.rowGroups == nil {
+		t.Fatal("expected batches read after rewinding to start the row group pipeline")
+	}
+	obj, ok, err := pr.ReadOne()
+	if err != nil || !ok {
+		t.Fatalf("failed to read row from the pipeline: ok=%t, err=%v", ok, err)
+	}
+	if diff := deep.Equal(obj, expected[5]); diff != nil {
+		t.Fatalf("unexpected object read from the pipeline: %s", diff)
+	}
+}
+
+func TestKeyFilter(t *testing.T) {
+	svc := newTestInventoryBucket(t)
+	keys := []string{"a/k1", "a/new\nline", "a/null\x00byte", "a/k2"}
+	orcRows := make([][]interface{}, 0, len(keys))
+	parquetRows := make([]interface{}, 0, len(keys))
+	for _, key := range keys {
+		orcRows = append(orcRows, []interface{}{"b", key})
+		parquetRows = append(parquetRows, parquetKeyOnlyRow{Bucket: "b", Key: key})
+	}
+	uploadOrcWithSchema(t, svc, "control.orc", "struct<bucket:string,key:string>", orcRows...)
+	uploadParquet(t, svc, "control.parquet", new(parquetKeyOnlyRow), parquetRows...)
+	replaceControl := func(key string) (string, bool) {
+		return strings.Map(func(r rune) rune {
+			if unicode.IsControl(r) {
+				return '_'
+			}
+			return r
+		}, key), true
+	}
+	testdata := []struct {
+		name             string
+		filter           KeyFilter
+		expectedKeys     []string
+		expectedRejected []string
+	}{
+		{name: "none", expectedKeys: keys},
+		{name: "reject", filter: RejectControlCharacters, expectedKeys: []string{"a/k1", "a/k2"}, expectedRejected: []string{"a/new\nline", "a/null\x00byte"}},
+		{name: "transform", filter: replaceControl, expectedKeys: []string{"a/k1", "a/new_line", "a/null_byte", "a/k2"}},
+	}
+	for _, test := range testdata {
+		for format, fileKey := range map[string]string{OrcFormatName: "control.orc", ParquetFormatName: "control.parquet"} {
+			t.Run(test.name+"_"+format, func(t *testing.T) {
+				var rejected []string
+				reader := NewReader(context.Background(), svc, logging.Default(), WithKeyPrefix("a/"), WithKeyFilter(test.filter),
+					WithOnRejectedKey(func(key string) { rejected = append(rejected, key) }))
+				fileReader, err := reader.GetFileReader(format, "", inventoryBucketName, fileKey)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer func() { _ = fileReader.Close() }()
+				res := make([]InventoryObject, len(keys))
+				if err = fileReader.Read(&res); err != nil {
+					t.Fatal(err)
+				}
+				var resKeys []string
+				for _, obj := range res {
+					resKeys = append(resKeys, obj.Key)
+				}
+				if diff := deep.Equal(resKeys, test.expectedKeys); diff != nil {
+					t.Fatalf("unexpected keys: %s", diff)
+				}
+				if diff := deep.Equal(rejected, test.expectedRejected); diff != nil {
+					t.Fatalf("unexpected rejected keys: %s", diff)
+				}
+			})
+		}
+	}
+}
+
+func TestPooledBuffers(t *testing.T) {
+	var buffers objectBuffers
+	buffers.pooled = true
+	buf := buffers.get(3)
+	buf = append(buf, InventoryObject{Key: "k1", Size: swag.Int64(1)}, InventoryObject{Key: "k2"})
+	if len(buf) != 2 || cap(buf) < 3 {
+		t.Fatalf("unexpected pooled slice: len=%d, cap=%d", len(buf), cap(buf))
+	}
+	for i := 0; i < 10; i++ {
+		buf = buffers.get(3)
+		if len(buf) != 0 {
+			t.Fatalf("expected an empty pooled slice, got %d objects", len(buf))
+		}
+		for j, obj := range buf[:cap(buf)] {
+			if !obj.Equal(InventoryObject{}) {
+				t.Fatalf("pooled slice holds object %v at index %d from a previous use", obj, j)
+			}
+		}
+		buf = append(buf, InventoryObject{Key: fmt.Sprintf("k%d", i), Checksum: swag.String("e")})
+	}
+	buffers.release()
+	if buffers.last != nil {
+		t.Fatal("expected no slice held after release")
+	}
+
+	// objects read with pooled buffers are those read without them, once copied
+	localOrcFile := generateOrc(t, objs(2500, []time.Time{time.Now()}))
+	defer func() {
+		_ = os.Remove(localOrcFile)
+	}()
+	readAll := func(pooled bool) []InventoryObject {
+		r := openLocalOrc(t, localOrcFile)
+		defer func() { _ = r.Close() }()
+		r.buffers.pooled = pooled
+		var all []InventoryObject
+		for {
+			res := make([]InventoryObject, 1000)
+			err := r.Read(&res)
+			if errors.Is(err, ErrNoMoreRows) {
+				return all
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			all = append(all, res...)
+		}
+	}
+	expected := readAll(false)
+	if len(expected) != 2500 {
+		t.Fatalf("unexpected number of objects. expected=2500, got=%d", len(expected))
+	}
+	if diff := deep.Equal(readAll(true), expected); diff != nil {
+		t.Fatalf("unexpected objects read with pooled buffers: %s", diff)
+	}
+}
+
+func BenchmarkPooledBuffers(b *testing.B) {
+	const numRows = 50000
+	const batchSize = 1000
+	localOrcFile := generateOrc(b, objs(numRows, []time.Time{time.Now()}))
+	defer func() {
+		_ = os.Remove(localOrcFile)
+	}()
+	for _, pooled := range []bool{false, true} {
+		b.Run(fmt.Sprintf("pooled=%t", pooled), func(b *testing.B) {
+			r := openLocalOrc(b, localOrcFile)
+			defer func() { _ = r.Close() }()
+			r.buffers.pooled = pooled
+			res := make([]InventoryObject, batchSize)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				res = res[:batchSize]
+				if err := r.Read(&res); err != nil {
+					b.Fatal(err)
+				}
+				if len(res) == 0 {
+					b.StopTimer()
+					if err := r.Rewind(); err != nil {
+						b.Fatal(err)
+					}
+					b.StartTimer()
+				}
+			}
+		})
+	}
+}
+
+// localOrcFilesReader reads every inventory file from the same local ORC file.
+type localOrcFilesReader struct {
+	tb     testing.TB
+	path   string
+	pooled bool
+}
+
+func (r *localOrcFilesReader) GetFileReader(string, string, string, string) (FileReader, error) {
+	fileReader := openLocalOrc(r.tb, r.path)
+	fileReader.buffers.pooled = r.pooled
+	return fileReader, nil
+}
+
+func (r *localOrcFilesReader) GetMetadataReader(format string, schema string, bucket string, key string) (MetadataReader, error) {
+	return r.GetFileReader(format, schema, bucket, key)
+}
+
+func BenchmarkPooledBuffersIterator(b *testing.B) {
+	const numRows = 20000
+	localOrcFile := generateOrc(b, objs(numRows, []time.Time{time.Now()}))
+	defer func() {
+		_ = os.Remove(localOrcFile)
+	}()
+	keys := []string{"f1.orc", "f2.orc", "f3.orc"}
+	for _, sorted := range []bool{false, true} {
+		for _, pooled := range []bool{false, true} {
+			b.Run(fmt.Sprintf("sorted=%t/pooled=%t", sorted, pooled), func(b *testing.B) {
+				reader := &localOrcFilesReader{tb: b, path: localOrcFile, pooled: pooled}
+				b.ReportAllocs()
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					it := NewInventoryIterator(reader, OrcFormatName, "", inventoryBucketName, keys, WithSorted(sorted))
+					count := 0
+					for it.Next() {
+						count++
+					}
+					if err := it.Err(); err != nil {
+						b.Fatal(err)
+					}
+					if err := it.Close(); err != nil {
+						b.Fatal(err)
+					}
+					if count != numRows*len(keys) {
+						b.Fatalf("unexpected number of objects. expected=%d, got=%d", numRows*len(keys), count)
+					}
+				}
+			})
+		}
+	}
+}
+
+// kmsDeniedS3Client fails reading objects as S3 does when the credentials are not allowed to use their KMS key.
+type kmsDeniedS3Client struct {
+	s3iface.S3API
+	err error
+}
+
+func (c *kmsDeniedS3Client) GetObjectWithContext(aws.Context, *s3.GetObjectInput, ...request.Option) (*s3.GetObjectOutput, error) {
+	return nil, c.err
+}
+
+func TestInventoryKMSAccessDenied(t *testing.T) {
+	svc := newTestInventoryBucket(t)
+	uploadFile(t, svc, inventoryBucketName, "encrypted.orc", objs(10, []time.Time{time.Now()}))
+	uploadParquet(t, svc, "encrypted.parquet", new(InventoryObject), InventoryObject{Bucket: "b", Key: "k1"})
+	kmsDenied := awserr.NewRequestFailure(awserr.New(errCodeAccessDenied,
+		"User: arn:aws:iam::123456789012:user/importer is not authorized to perform: kms:Decrypt on the resource associated with this ciphertext", nil),
+		http.StatusForbidden, "request-id")
+	testdata := []struct {
+		name      string
+		err       error
+		kmsDenied bool
+	}{
+		{name: "kms_decrypt", err: kmsDenied, kmsDenied: true},
+		{name: "kms_code", err: awserr.NewRequestFailure(awserr.New("KMS.DisabledException", "key is disabled", nil), http.StatusBadRequest, "request-id"), kmsDenied: true},
+		{name: "access_denied", err: awserr.NewRequestFailure(awserr.New(errCodeAccessDenied, "Access Denied", nil), http.StatusForbidden, "request-id")},
+	}
+	for _, test := range testdata {
+		for format, key := range map[string]string{OrcFormatName: "encrypted.orc", ParquetFormatName: "encrypted.parquet"} {
+			t.Run(test.name+"_"+format, func(t *testing.T) {
+				reader := NewReader(context.Background(), &kmsDeniedS3Client{S3API: svc, err: test.err}, logging.Default(), WithDownloadRetries(0))
+				fileReader, err := reader.GetFileReader(format, "", inventoryBucketName, key)
+				if err == nil {
+					_ = fileReader.Close()
+					t.Fatal("expected reading the file to fail")
+				}
+				if errors.Is(err, ErrInventoryKMSAccessDenied) != test.kmsDenied {
+					t.Fatalf("unexpected classification of %v as %v: expected %t", err, ErrInventoryKMSAccessDenied, test.kmsDenied)
+				}
+				var awsErr awserr.Error
+				if !errors.As(err, &awsErr) {
+					t.Fatalf("expected the S3 error to be wrapped, got %v", err)
+				}
+				if test.kmsDenied && errors.Is(err, ErrInventoryDownload) {
+					t.Fatalf("expected a denied KMS key not to be reported as %v", ErrInventoryDownload)
+				}
+			})
+		}
+	}
+}
+
+func TestMaxTempDiskBytes(t *testing.T) {
+	svc := newTestInventoryBucket(t)
+	keys := []string{"disk1.orc", "disk2.orc", "disk3.orc", "disk4.orc"}
+	for _, key := range keys {
+		uploadFile(t, svc, inventoryBucketName, key, objs(1000, []time.Time{time.Now()}))
+	}
+	headObject, err := svc.HeadObject(&s3.HeadObjectInput{Bucket: aws.String(inventoryBucketName), Key: aws.String(keys[0])})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// room for a single file at a time
+	maxBytes := *headObject.ContentLength * 3 / 2
+	reader := NewReader(context.Background(), svc, logging.Default(), WithMaxTempDiskBytes(maxBytes))
+	first, err := reader.GetFileReader(OrcFormatName, "", inventoryBucketName, keys[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	opened := make(chan FileReader)
+	go func() {
+		second, err := reader.GetFileReader(OrcFormatName, "", inventoryBucketName, keys[1])
+		if err != nil {
+			t.Error(err)
+		}
+		opened <- second
+	}()
+	select {
+	case <-opened:
+		t.Fatal("expected the second download to wait for the first file to be closed")
+	case <-time.After(100 * time.Millisecond):
+	}
+	if err = first.Close(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case second := <-opened:
+		if second != nil {
+			_ = second.Close()
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("second download still waiting after the first file was closed")
+	}
+
+	// downloads of readers opened at once are serialized
+	var open, maxOpen int32
+	var wg sync.WaitGroup
+	for _, key := range keys {
+		wg.Add(1)
+		go func(key string) {
+			defer wg.Done()
+			fileReader, err := reader.GetFileReader(OrcFormatName, "", inventoryBucketName, key)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			n := atomic.AddInt32(&open, 1)
+			for {
+				m := atomic.LoadInt32(&maxOpen)
+				if n <= m || atomic.CompareAndSwapInt32(&maxOpen, m, n) {
+					break
+				}
+			}
+			time.Sleep(10 * time.Millisecond)
+			atomic.AddInt32(&open, -1)
+			_ = fileReader.Close()
+		}(key)
+	}
+	wg.Wait()
+	if maxOpen != 1 {
+		t.Fatalf("unexpected number of files held at once. expected=1, got=%d", maxOpen)
+	}
+
+	// waiting for room stops once the context is done
+	held, err := reader.GetFileReader(OrcFormatName, "", inventoryBucketName, keys[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = held.Close() }()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	canceled := NewReader(ctx, svc, logging.Default())
+	canceled.tempDisk = reader.tempDisk
+	if _, err = canceled.GetFileReader(OrcFormatName, "", inventoryBucketName, keys[1]); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected error %v waiting for room, got %v", context.DeadlineExceeded, err)
+	}
+}
+
+func TestReadNoMoreRows(t *testing.T) {
+	svc := newTestInventoryBucket(t)
+	const numRows = 25
+	uploadFile(t, svc, inventoryBucketName, "myFile.orc", objs(numRows, []time.Time{time.Now()}))
+	uploadBytes(t, svc, "myFile.parquet", generateParquet(t, 10, objs(numRows, []time.Time{time.Now()})))
+	testdata := []struct {
+		format string
+		key    string
+	}{
+		{format: OrcFormatName, key: "myFile.orc"},
+		{format: ParquetFormatName, key: "myFile.parquet"},
+	}
+	for _, test := range testdata {
+		t.Run(test.format, func(t *testing.T) {
+			reader := NewReader(context.Background(), svc, logging.Default())
+			fileReader, err := reader.GetFileReader(test.format, "Bucket, Key", inventoryBucketName, test.key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() {
+				_ = fileReader.Close()
+			}()
+			// the last batch is partial, and is returned with no error
+			for _, expectedLen := range []int{10, 10, 5} {
+				res := make([]InventoryObject, 10)
+				if err = fileReader.Read(&res); err != nil {
+					t.Fatal(err)
+				}
+				if len(res) != expectedLen {
+					t.Fatalf("unexpected number of objects read. expected=%d, got=%d", expectedLen, len(res))
+				}
+			}
+			for i := 0; i < 2; i++ {
+				res := make([]InventoryObject, 10)
+				err = fileReader.Read(&res)
+				if !errors.Is(err, ErrNoMoreRows) {
+					t.Fatalf("expected error %v, got %v", ErrNoMoreRows, err)
+				}
+				if !errors.Is(err, io.EOF) {
+					t.Fatalf("expected error %v to match io.EOF", err)
+				}
+				if len(res) != 0 {
+					t.Fatalf("expected no objects read from an exhausted file, got %d", len(res))
+				}
+			}
+		})
+	}
+}
+
+func TestReaderConcurrentFileReaders(t *testing.T) {
+	svc := newTestInventoryBucket(t)
+	const numFiles = 8
+	const numRows = 100
+	keys := make([]string, 0, numFiles)
+	for i := 0; i < numFiles; i++ {
+		key := fmt.Sprintf("f%d.orc", i)
+		uploadFile(t, svc, inventoryBucketName, key, objs(numRows, []time.Time{time.Now()}))
+		keys = append(keys, key)
+	}
+	reader := NewReader(context.Background(), svc, logging.Default())
+	defer func() {
+		_ = reader.Close()
+	}()
+	var wg sync.WaitGroup
+	errs := make(chan error, numFiles+1)
+	// half of the files are prefetched while all of them are opened, read and closed
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		errs <- reader.PrefetchAll(context.Background(), inventoryBucketName, keys[:numFiles/2], 2)
+	}()
+	for _, key := range keys {
+		wg.Add(2)
+		go func(key string) {
+			defer wg.Done()
+			reader.AddFileChecksums(inventoryBucketName, map[string]FileChecksum{key: {}})
+		}(key)
+		go func(key string) {
+			defer wg.Done()
+			fileReader, err := reader.GetFileReader(OrcFormatName, "", inventoryBucketName, key)
+			if err != nil {
+				errs <- err
+				return
+			}
+			res := make([]InventoryObject, numRows)
+			err = fileReader.Read(&res)
+			if closeErr := fileReader.Close(); err == nil {
+				err = closeErr
+			}
+			if err == nil && len(res) != numRows {
+				err = fmt.Errorf("read unexpected number of rows from %s. expected=%d, got=%d", key, numRows, len(res))
+			}
+			errs <- err
+		}(key)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := reader.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if len(reader.orcFilesByKey) != 0 {
+		t.Fatalf("expected no prefetched files after close, got %d", len(reader.orcFilesByKey))
+	}
+}
+
+func TestParquetRowGroupStatistics(t *testing.T) {
+	writeParquet := func(t *testing.T, data []byte) string {
+		f, err := ioutil.TempFile("", "row_groups.parquet")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() {
+			_ = os.Remove(f.Name())
+		})
+		if _, err = f.Write(data); err != nil {
+			t.Fatal(err)
+		}
+		if err = f.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return f.Name()
+	}
+	openParquet := func(t *testing.T, filename string, filter objectFilter, layout fileLayout) *ParquetInventoryFileReader {
+		pf, err := local.NewLocalFileReader(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, err := newParquetInventoryFileReader(pf, filter, nil, DefaultParquetConcurrency, layout)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() {
+			_ = r.Close()
+		})
+		return r
+	}
+
+	// every row group holds objects last modified an hour after those of the previous one
+	const rowsPerGroup = 1000
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	millis := func(hours int) *int64 {
+		return swag.Int64(start.Add(time.Duration(hours)*time.Hour).Unix() * 1000)
+	}
+	ch := make(chan *InventoryObject)
+	go func() {
+		defer close(ch)
+		for i := 0; i < 3*rowsPerGroup; i++ {
+			ch <- &InventoryObject{
+				Bucket:             inventoryBucketName,
+				Key:                fmt.Sprintf("f%05d", i),
+				LastModifiedMillis: millis(i / rowsPerGroup),
+			}
+		}
+	}()
+	filename := writeParquet(t, generateParquet(t, rowsPerGroup, ch))
+	expected := []RowGroupStatistics{
+		{NumRows: rowsPerGroup, MinKey: "f00000", MaxKey: "f00999", MinLastModifiedMillis: millis(0), MaxLastModifiedMillis: millis(0)},
+		{NumRows: rowsPerGroup, MinKey: "f01000", MaxKey: "f01999", MinLastModifiedMillis: millis(1), MaxLastModifiedMillis: millis(1)},
+		{NumRows: rowsPerGroup, MinKey: "f02000", MaxKey: "f02999", MinLastModifiedMillis: millis(2), MaxLastModifiedMillis: millis(2)},
+	}
+	r := openParquet(t, filename, objectFilter{}, fileLayout{})
+	if diff := deep.Equal(r.RowGroupStatistics(), expected); diff != nil {
+		t.Fatalf("unexpected row group statistics: %s", diff)
+	}
+
+	testdata := []struct {
+		name            string
+		filter          objectFilter
+		expectedSkipped int
+	}{
+		{name: "prefix", filter: objectFilter{keyPrefix: "f01"}, expectedSkipped: 2},
+		{name: "modified_after", filter: objectFilter{modifiedAfter: start.Add(90 * time.Minute)}, expectedSkipped: 2},
+		{name: "modified_before", filter: objectFilter{modifiedBefore: start.Add(90 * time.Minute)}, expectedSkipped: 1},
+		{name: "prefix_and_modified", filter: objectFilter{keyPrefix: "f02", modifiedBefore: start.Add(90 * time.Minute)}, expectedSkipped: 3},
+	}
+	for _, test := range testdata {
+		t.Run(test.name, func(t *testing.T) {
+			r := openParquet(t, filename, test.filter, fileLayout{})
+			if r.skippedRowGroups != test.expectedSkipped {
+				t.Fatalf("unexpected number of skipped row groups. expected=%d, got=%d", test.expectedSkipped, r.skippedRowGroups)
+			}
+			// statistics and key range describe the whole file, regardless of the row groups skipped
+			if diff := deep.Equal(r.RowGroupStatistics(), expected); diff != nil {
+				t.Fatalf("unexpected row group statistics: %s", diff)
+			}
+			if first, last := r.FirstObjectKey(), r.LastObjectKey(); first != "f00000" || last != "f02999" {
+				t.Fatalf("unexpected key range. expected=f00000..f02999, got=%s..%s", first, last)
+			}
+			res := make([]InventoryObject, 3*rowsPerGroup)
+			if err := readBatch(r, &res); err != nil {
+				t.Fatal(err)
+			}
+			expectedRead := (len(expected) - test.expectedSkipped) * rowsPerGroup
+			if len(res) != expectedRead {
+				t.Fatalf("unexpected number of objects read. expected=%d, got=%d", expectedRead, len(res))
+			}
+		})
+	}
+
+	// statistics are looked up in the columns named by the layout of the file
+	t.Run("column_names", func(t *testing.T) {
+		var buf bytes.Buffer
+		pw, err := writer.NewParquetWriter(writerfile.NewWriterFile(&buf), new(parquetRenamedRow), 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, name := range []string{"a1", "a2", "b1", "b2"} {
+			if err = pw.Write(parquetRenamedRow{Name: name}); err != nil {
+				t.Fatal(err)
+			}
+			if name == "a2" {
+				if err = pw.Flush(true); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		if err = pw.WriteStop(); err != nil {
+			t.Fatal(err)
+		}
+		layout := fileLayout{columnNames: ColumnNames{"key": "Name"}, bucket: "container"}
+		r := openParquet(t, writeParquet(t, buf.Bytes()), objectFilter{keyPrefix: "b"}, layout)
+		if r.skippedRowGroups != 1 {
+			t.Fatalf("unexpected number of skipped row groups. expected=1, got=%d", r.skippedRowGroups)
+		}
+		expected := []RowGroupStatistics{{NumRows: 2, MinKey: "a1", MaxKey: "a2"}, {NumRows: 2, MinKey: "b1", MaxKey: "b2"}}
+		if diff := deep.Equal(r.RowGroupStatistics(), expected); diff != nil {
+			t.Fatalf("unexpected row group statistics: %s", diff)
+		}
+		if first, last := r.FirstObjectKey(), r.LastObjectKey(); first != "a1" || last != "b2" {
+			t.Fatalf("unexpected key range. expected=a1..b2, got=%s..%s", first, last)
+		}
+	})
+}
+
+func TestInventoryIteratorStream(t *testing.T) {
+	svc := newTestInventoryBucket(t)
+	lastModified := []time.Time{time.Now()}
+	keys := []string{"stream1.orc", "stream2.orc", "stream3.orc"}
+	for _, key := range keys {
+		uploadFile(t, svc, inventoryBucketName, key, objs(1500, lastModified))
+	}
+	reader := NewReader(context.Background(), svc, logging.Default())
+	it := NewInventoryIterator(reader, OrcFormatName, "", inventoryBucketName, keys)
+	objects, streamErr := it.Stream(context.Background(), 10)
+	count := 0
+	for obj := range objects {
+		expectedKey := fmt.Sprintf("f%05d", count%1500)
+		if obj.Key != expectedKey {
+			t.Fatalf("unexpected key at index %d. expected=%s, got=%s", count, expectedKey, obj.Key)
+		}
+		count++
+	}
+	if err := streamErr(); err != nil {
+		t.Fatal(err)
+	}
+	if count != 4500 {
+		t.Fatalf("unexpected number of objects. expected=%d, got=%d", 4500, count)
+	}
+
+	// errors are returned once the objects read before them are received
+	it = NewInventoryIterator(reader, OrcFormatName, "", inventoryBucketName, []string{keys[0], "missing.orc"})
+	objects, streamErr = it.Stream(context.Background(), 10)
+	count = 0
+	for range objects {
+		count++
+	}
+	if err := streamErr(); count != 1500 || !errors.Is(err, ErrManifestFileNotFound) {
+		t.Fatalf("expected %d objects and error %v, got %d objects and error %v", 1500, ErrManifestFileNotFound, count, err)
+	}
+
+	// consumers stop early by cancelling the context, which stops reading the files
+	ctx, cancel := context.WithCancel(context.Background())
+	it = NewInventoryIterator(reader, OrcFormatName, "", inventoryBucketName, keys)
+	objects, streamErr = it.Stream(ctx, 10)
+	for i := 0; i < 20; i++ {
+		if _, ok := <-objects; !ok {
+			t.Fatalf("stream closed after %d objects: %v", i, streamErr())
+		}
+	}
+	cancel()
+	if err := streamErr(); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected error %v, got %v", context.Canceled, err)
+	}
+	if it.current != nil {
+		t.Fatal("expected the iterator to be closed once the stream stopped")
+	}
+}
+
+func TestObjectSize(t *testing.T) {
+	testdata := []struct {
+		name     string
+		value    interface{}
+		expected int64
+		err      error
+	}{
+		{name: "int64", value: int64(500), expected: 500},
+		{name: "int32", value: int32(500), expected: 500},
+		{name: "float64", value: float64(500), expected: 500},
+		{name: "float32", value: float32(500), expected: 500},
+		{name: "orc_double", value: orc.Double(500), expected: 500},
+		{name: "orc_float", value: orc.Float(500), expected: 500},
+		{name: "decimal", value: orc.NewDecimal(big.NewInt(50000), 2), expected: 500},
+		{name: "decimal_no_scale", value: orc.NewDecimal(big.NewInt(500), 0), expected: 500},
+		{name: "string", value: "500", expected: 500},
+		{name: "string_spaces", value: " 500 ", expected: 500},
+		{name: "string_float", value: "500.0", expected: 500},
+		{name: "string_exponent", value: "5e2", expected: 500},
+		{name: "fractional_float", value: 500.5, err: ErrIndexMalformed},
+		{name: "nan", value: math.NaN(), err: ErrIndexMalformed},
+		{name: "infinity", value: math.Inf(1), err: ErrIndexMalformed},
+		{name: "float_overflow", value: float64(math.MaxInt64), err: ErrIndexMalformed},
+		{name: "fractional_decimal", value: orc.NewDecimal(big.NewInt(50050), 2), err: ErrIndexMalformed},
+		{name: "decimal_overflow", value: orc.NewDecimal(new(big.Int).Lsh(big.NewInt(1), 70), 0), err: ErrIndexMalformed},
+		{name: "empty_decimal", value: orc.Decimal{}, err: ErrIndexMalformed},
+		{name: "fractional_string", value: "500.5", err: ErrIndexMalformed},
+		{name: "non_numeric_string", value: "500 bytes", err: ErrIndexMalformed},
+		{name: "empty_string", value: "", err: ErrIndexMalformed},
+		{name: "boolean", value: true, err: ErrIndexMalformed},
+	}
+	for _, test := range testdata {
+		t.Run(test.name, func(t *testing.T) {
+			size, err := objectSize(test.value)
+			if test.err != nil {
+				if !errors.Is(err, test.err) {
+					t.Fatalf("expected error %v for size %v, got size %d and error %v", test.err, test.value, size, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if size != test.expected {
+				t.Fatalf("unexpected size. expected=%d, got=%d", test.expected, size)
+			}
+		})
+	}
+}
+
+func TestParquetSizeTypes(t *testing.T) {
+	svc := newTestInventoryBucket(t)
+	testdata := []struct {
+		name string
+		obj  interface{}
+		row  interface{}
+		err  error
+	}{
+		{name: "int32", obj: new(parquetInt32SizeRow), row: parquetInt32SizeRow{Bucket: "b", Key: "k", Size: swag.Int32(500)}},
+		{name: "float", obj: new(parquetFloatSizeRow), row: parquetFloatSizeRow{Bucket: "b", Key: "k", Size: swag.Float32(500)}},
+		{name: "double", obj: new(parquetDoubleSizeRow), row: parquetDoubleSizeRow{Bucket: "b", Key: "k", Size: swag.Float64(500)}},
+		{name: "string", obj: new(parquetStringSizeRow), row: parquetStringSizeRow{Bucket: "b", Key: "k", Size: swag.String("500")}},
+		{name: "float_string", obj: new(parquetStringSizeRow), row: parquetStringSizeRow{Bucket: "b", Key: "k", Size: swag.String("5e2")}},
+		{name: "null", obj: new(parquetStringSizeRow), row: parquetStringSizeRow{Bucket: "b", Key: "k"}},
+		{name: "fractional_double", obj: new(parquetDoubleSizeRow), row: parquetDoubleSizeRow{Bucket: "b", Key: "k", Size: swag.Float64(500.5)}, err: ErrIndexMalformed},
+		{name: "non_numeric_string", obj: new(parquetStringSizeRow), row: parquetStringSizeRow{Bucket: "b", Key: "k", Size: swag.String("five hundred")}, err: ErrIndexMalformed},
+	}
+	for _, test := range testdata {
+		key := test.name + ".parquet"
+		uploadParquet(t, svc, key, test.obj, test.row)
+		for _, concurrent := range []bool{false, true} {
+			t.Run(fmt.Sprintf("%s/concurrent=%t", test.name, concurrent), func(t *testing.T) {
+				reader := NewReader(context.Background(), svc, logging.Default(), WithConcurrentParquetRowGroups(concurrent))
+				fileReader, err := reader.GetFileReader(ParquetFormatName, "", inventoryBucketName, key)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer func() {
+					_ = fileReader.Close()
+				}()
+				res := make([]InventoryObject, 1)
+				err = fileReader.Read(&res)
+				if test.err != nil {
+					if !errors.Is(err, test.err) {
+						t.Fatalf("expected error %v, got %v", test.err, err)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				var expectedSize *int64
+				if test.name != "null" {
+					expectedSize = swag.Int64(500)
+				}
+				if diff := deep.Equal(res, []InventoryObject{{Bucket: "b", Key: "k", Size: expectedSize}}); diff != nil {
+					t.Fatalf("unexpected objects: %s", diff)
+				}
+			})
+		}
+	}
+}
+
+func TestOrcSizeKinds(t *testing.T) {
+	svc := newTestInventoryBucket(t)
+	testdata := []struct {
+		sizeType string
+		value    interface{}
+		err      error
+	}{
+		{sizeType: "int", value: int64(500)},
+		{sizeType: "bigint", value: int64(500)},
+		{sizeType: "double", value: float64(500)},
+		{sizeType: "float", value: float32(500)},
+		{sizeType: "string", value: "500"},
+		{sizeType: "string", value: "five hundred", err: ErrIndexMalformed},
+		{sizeType: "double", value: 500.5, err: ErrIndexMalformed},
+	}
+	reader := NewReader(context.Background(), svc, logging.Default())
+	for i, test := range testdata {
+		t.Run(fmt.Sprintf("%s_%v", test.sizeType, test.value), func(t *testing.T) {
+			key := fmt.Sprintf("size%d.orc", i)
+			uploadOrcWithSchema(t, svc, key, "struct<bucket:string,key:string,size:"+test.sizeType+">", []interface{}{"b", "k", test.value})
+			fileReader, err := reader.GetFileReader(OrcFormatName, "", inventoryBucketName, key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() {
+				_ = fileReader.Close()
+			}()
+			res := make([]InventoryObject, 1)
+			err = fileReader.Read(&res)
+			if test.err != nil {
+				if !errors.Is(err, test.err) || !errors.Is(err, ErrMalformedOrcRow) {
+					t.Fatalf("expected errors %v and %v, got %v", test.err, ErrMalformedOrcRow, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(res) != 1 || res[0].Size == nil || *res[0].Size != 500 {
+				t.Fatalf("expected a single object of size 500, got %+v", res)
+			}
+		})
+	}
+}
+
+func TestAvroSizeTypes(t *testing.T) {
+	svc := newTestInventoryBucket(t)
+	testdata := []struct {
+		sizeType string
+		value    interface{}
+		err      error
+	}{
+		{sizeType: "long", value: int64(500)},
+		{sizeType: "int", value: int32(500)},
+		{sizeType: "double", value: float64(500)},
+		{sizeType: "string", value: "500"},
+		{sizeType: "string", value: "five hundred", err: ErrIndexMalformed},
+		{sizeType: "double", value: 500.5, err: ErrIndexMalformed},
+	}
+	reader := NewReader(context.Background(), svc, logging.Default())
+	for i, test := range testdata {
+		t.Run(fmt.Sprintf("%s_%v", test.sizeType, test.value), func(t *testing.T) {
+			key := fmt.Sprintf("size%d.avro", i)
+			schema := `{"type": "record", "name": "inventory", "fields": [
+				{"name": "bucket", "type": "string"},
+				{"name": "key", "type": "string"},
+				{"name": "size", "type": ["null", "` + test.sizeType + `"], "default": null}
+			]}`
+			uploadAvroWithSchema(t, svc, key, schema, goavro.CompressionNullLabel,
+				map[string]interface{}{"bucket": "b", "key": "k", "size": goavro.Union(test.sizeType, test.value)})
+			// the records are scanned when the file is opened, so a malformed size fails GetFileReader
+			fileReader, err := reader.GetFileReader(AvroFormatName, "", inventoryBucketName, key)
+			res := make([]InventoryObject, 1)
+			if err == nil {
+				defer func() {
+					_ = fileReader.Close()
+				}()
+				err = fileReader.Read(&res)
+			}
+			if test.err != nil {
+				if !errors.Is(err, test.err) || !errors.Is(err, ErrIncompatibleInventoryColumn) {
+					t.Fatalf("expected errors %v and %v, got %v", test.err, ErrIncompatibleInventoryColumn, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(res) != 1 || res[0].Size == nil || *res[0].Size != 500 {
+				t.Fatalf("expected a single object of size 500, got %+v", res)
+			}
+		})
+	}
+}
@@ -0,0 +1,156 @@
+package s3
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+const (
+	permanentRedirectErrorCode = "PermanentRedirect"
+	bucketRegionErrorCode      = "BucketRegionError"
+)
+
+var ErrNoRegionalClient = errors.New("cannot create S3 client for bucket region")
+
+// RegionalClientFactory returns an S3 client for accessing buckets in the given region.
+type RegionalClientFactory func(region string) (s3iface.S3API, error)
+
+// WithRegionalClientFactory sets the function creating clients for inventory buckets found in a region other than
+// the one of the reader's client. By default clients are created with the configuration of the reader's client,
+// if it is an *s3.S3.
+func WithRegionalClientFactory(newClient RegionalClientFactory) ReaderOption {
+	return func(o *Reader) {
+		o.regions.newClient = newClient
+	}
+}
+
+// WithForcePathStyle addresses inventory buckets in the path of request URLs rather than in their host names, as
+// S3-compatible stores such as MinIO commonly require. All requests reading inventory files, including Parquet reads
+// and requests to clients created for other regions, are sent by a copy of the reader's client, if it is an *s3.S3.
+// Other clients are used as they are, and should be configured for path-style addressing by the caller.
+func WithForcePathStyle() ReaderOption {
+	return func(o *Reader) {
+		svc, ok := o.regions.S3API.(*s3.S3)
+		if !ok {
+			return
+		}
+		c := *svc.Client
+		c.Config = *c.Config.Copy(aws.NewConfig().WithS3ForcePathStyle(true))
+		c.Handlers = c.Handlers.Copy()
+		o.regions.S3API = &s3.S3{Client: &c}
+	}
+}
+
+// bucketRegionClient sends the requests reading inventory files to the region of their bucket.
+// Requests are sent with the wrapped client until S3 redirects them, in which case the region of the bucket is
+// looked up, and the request is retried once with a client for that region. Clients are kept by bucket.
+type bucketRegionClient struct {
+	s3iface.S3API
+	newClient RegionalClientFactory
+	mu        sync.Mutex
+	byBucket  map[string]s3iface.S3API
+}
+
+func newBucketRegionClient(svc s3iface.S3API) *bucketRegionClient {
+	c := &bucketRegionClient{S3API: svc, byBucket: make(map[string]s3iface.S3API)}
+	c.newClient = c.defaultClient
+	return c
+}
+
+func (c *bucketRegionClient) defaultClient(region string) (s3iface.S3API, error) {
+	svc, ok := c.S3API.(*s3.S3)
+	if !ok {
+		return nil, fmt.Errorf("%w %s: client is a %T", ErrNoRegionalClient, region, c.S3API)
+	}
+	sess, err := session.NewSession(svc.Config.Copy(aws.NewConfig().WithRegion(region)))
+	if err != nil {
+		return nil, fmt.Errorf("%w %s: %s", ErrNoRegionalClient, region, err)
+	}
+	return s3.New(sess), nil
+}
+
+func (c *bucketRegionClient) clientFor(bucket string) s3iface.S3API {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if svc, ok := c.byBucket[bucket]; ok {
+		return svc
+	}
+	return c.S3API
+}
+
+// resolve looks up the region of the bucket, and returns a client for it to retry a redirected request with.
+// It returns false if the request should not be retried.
+func (c *bucketRegionClient) resolve(ctx aws.Context, bucket string, redirected s3iface.S3API) (s3iface.S3API, bool) {
+	if redirected != c.S3API {
+		// already sent to the resolved region
+		return nil, false
+	}
+	location, err := c.S3API.GetBucketLocationWithContext(ctx, &s3.GetBucketLocationInput{Bucket: aws.String(bucket)})
+	if err != nil {
+		return nil, false
+	}
+	svc, err := c.newClient(s3.NormalizeBucketLocation(aws.StringValue(location.LocationConstraint)))
+	if err != nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	c.byBucket[bucket] = svc
+	c.mu.Unlock()
+	return svc, true
+}
+
+// isRegionMismatchError returns true if S3 rejected a request for being sent to a region other than the bucket's.
+// Responses to HEAD requests carry no error code, so they are recognized by their status code.
+func isRegionMismatchError(err error) bool {
+	var reqErr awserr.RequestFailure
+	if errors.As(err, &reqErr) && reqErr.StatusCode() == http.StatusMovedPermanently {
+		return true
+	}
+	var awsErr awserr.Error
+	return errors.As(err, &awsErr) && (awsErr.Code() == permanentRedirectErrorCode || awsErr.Code() == bucketRegionErrorCode)
+}
+
+func (c *bucketRegionClient) GetObject(input *s3.GetObjectInput) (*s3.GetObjectOutput, error) {
+	return c.GetObjectWithContext(aws.BackgroundContext(), input)
+}
+
+func (c *bucketRegionClient) GetObjectWithContext(ctx aws.Context, input *s3.GetObjectInput, opts ...request.Option) (*s3.GetObjectOutput, error) {
+	bucket := aws.StringValue(input.Bucket)
+	svc := c.clientFor(bucket)
+	output, err := svc.GetObjectWithContext(ctx, input, opts...)
+	if !isRegionMismatchError(err) {
+		return output, err
+	}
+	regional, ok := c.resolve(ctx, bucket, svc)
+	if !ok {
+		return output, err
+	}
+	return regional.GetObjectWithContext(ctx, input, opts...)
+}
+
+func (c *bucketRegionClient) HeadObject(input *s3.HeadObjectInput) (*s3.HeadObjectOutput, error) {
+	return c.HeadObjectWithContext(aws.BackgroundContext(), input)
+}
+
+func (c *bucketRegionClient) HeadObjectWithContext(ctx aws.Context, input *s3.HeadObjectInput, opts ...request.Option) (*s3.HeadObjectOutput, error) {
+	bucket := aws.StringValue(input.Bucket)
+	svc := c.clientFor(bucket)
+	output, err := svc.HeadObjectWithContext(ctx, input, opts...)
+	if !isRegionMismatchError(err) {
+		return output, err
+	}
+	regional, ok := c.resolve(ctx, bucket, svc)
+	if !ok {
+		return output, err
+	}
+	return regional.HeadObjectWithContext(ctx, input, opts...)
+}
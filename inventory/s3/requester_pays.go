@@ -0,0 +1,42 @@
+package s3
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+// WithRequesterPays sets whether the reader agrees to pay for the requests reading inventory files.
+// It is required for reading inventories stored in requester pays buckets, which otherwise deny access.
+func WithRequesterPays(requesterPays bool) ReaderOption {
+	return func(o *Reader) {
+		if requesterPays {
+			o.svc = &requesterPaysClient{S3API: o.svc}
+		}
+	}
+}
+
+// requesterPaysClient sets the request payer on the requests reading inventory files.
+// It wraps the S3 client so that it is also set by readers which access S3 directly, such as the Parquet reader.
+type requesterPaysClient struct {
+	s3iface.S3API
+}
+
+func (c *requesterPaysClient) GetObject(input *s3.GetObjectInput) (*s3.GetObjectOutput, error) {
+	return c.GetObjectWithContext(aws.BackgroundContext(), input)
+}
+
+func (c *requesterPaysClient) GetObjectWithContext(ctx aws.Context, input *s3.GetObjectInput, opts ...request.Option) (*s3.GetObjectOutput, error) {
+	input.RequestPayer = aws.String(s3.RequestPayerRequester)
+	return c.S3API.GetObjectWithContext(ctx, input, opts...)
+}
+
+func (c *requesterPaysClient) HeadObject(input *s3.HeadObjectInput) (*s3.HeadObjectOutput, error) {
+	return c.HeadObjectWithContext(aws.BackgroundContext(), input)
+}
+
+func (c *requesterPaysClient) HeadObjectWithContext(ctx aws.Context, input *s3.HeadObjectInput, opts ...request.Option) (*s3.HeadObjectOutput, error) {
+	input.RequestPayer = aws.String(s3.RequestPayerRequester)
+	return c.S3API.HeadObjectWithContext(ctx, input, opts...)
+}
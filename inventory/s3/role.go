@@ -0,0 +1,30 @@
+package s3
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/treeverse/lakefs/logging"
+)
+
+// NewReaderWithRole returns a reader of inventory files stored in region, which reads them with the credentials of
+// the given role, such as a role of another account owning the inventory bucket. The role is assumed with the default
+// credentials of the environment, and its temporary credentials are renewed by the SDK before they expire.
+func NewReaderWithRole(ctx context.Context, roleARN string, region string, logger logging.Logger, opts ...ReaderOption) (*Reader, error) {
+	return newReaderWithRole(ctx, roleARN, aws.NewConfig().WithRegion(region), logger, opts...)
+}
+
+// newReaderWithRole is NewReaderWithRole with the configuration of the session assuming the role, which is also used
+// for reading the inventory files.
+func newReaderWithRole(ctx context.Context, roleARN string, cfg *aws.Config, logger logging.Logger, opts ...ReaderOption) (*Reader, error) {
+	sess, err := session.NewSession(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create session for assuming role %s: %w", roleARN, err)
+	}
+	svc := s3.New(sess, aws.NewConfig().WithCredentials(stscreds.NewCredentials(sess, roleARN)))
+	return NewReader(ctx, svc, logger, opts...), nil
+}
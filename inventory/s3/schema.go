@@ -0,0 +1,396 @@
+package s3
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/scritchley/orc"
+	"github.com/scritchley/orc/proto"
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/reader"
+	"github.com/xitongsys/parquet-go/source"
+)
+
+var (
+	ErrMissingInventoryColumn      = errors.New("inventory missing required column")
+	ErrIncompatibleInventoryColumn = errors.New("inventory column has incompatible type")
+	ErrUnknownInventoryColumn      = errors.New("unknown inventory column")
+	ErrMalformedFileSchema         = errors.New("malformed inventory file schema")
+	ErrIndexMalformed              = errors.New("malformed inventory object size")
+)
+
+// inventoryColumn describes a column of an inventory file which is read into an InventoryObject.
+type inventoryColumn struct {
+	name        string
+	required    bool
+	orcKinds    []proto.Type_Kind
+	parquetType parquet.Type
+	parquetTag  string // parquet-go schema tag used to decode the column into its InventoryObject field, without its name
+	// parquetRawTags are the tags used to decode the column into a field of parquetRawSizeRow when it has another type
+	// than parquetType, by the type of the column
+	parquetRawTags map[parquet.Type]string
+}
+
+var (
+	orcStringKinds  = []proto.Type_Kind{proto.Type_STRING, proto.Type_VARCHAR, proto.Type_CHAR}
+	orcIntegerKinds = []proto.Type_Kind{proto.Type_SHORT, proto.Type_INT, proto.Type_LONG}
+	// sizes are stored as strings or as decimal and floating point numbers by some inventory versions
+	orcSizeKinds = append(append([]proto.Type_Kind{proto.Type_FLOAT, proto.Type_DOUBLE, proto.Type_DECIMAL}, orcIntegerKinds...), orcStringKinds...)
+)
+
+var inventoryColumns = []inventoryColumn{
+	{name: "bucket", required: true, orcKinds: orcStringKinds, parquetType: parquet.Type_BYTE_ARRAY, parquetTag: "inname=Bucket, type=UTF8, repetitiontype=REQUIRED"},
+	{name: "key", required: true, orcKinds: orcStringKinds, parquetType: parquet.Type_BYTE_ARRAY, parquetTag: "inname=Key, type=UTF8, repetitiontype=REQUIRED"},
+	{name: "size", orcKinds: orcSizeKinds, parquetType: parquet.Type_INT64, parquetTag: "inname=Size, type=INT_64, repetitiontype=OPTIONAL",
+		parquetRawTags: map[parquet.Type]string{
+			parquet.Type_INT32:      "inname=SizeInt32, type=INT32, repetitiontype=OPTIONAL",
+			parquet.Type_FLOAT:      "inname=SizeFloat, type=FLOAT, repetitiontype=OPTIONAL",
+			parquet.Type_DOUBLE:     "inname=SizeDouble, type=DOUBLE, repetitiontype=OPTIONAL",
+			parquet.Type_BYTE_ARRAY: "inname=SizeString, type=UTF8, repetitiontype=OPTIONAL",
+		}},
+	{name: "last_modified_date", orcKinds: []proto.Type_Kind{proto.Type_TIMESTAMP}, parquetType: parquet.Type_INT64, parquetTag: "inname=LastModifiedMillis, type=TIMESTAMP_MILLIS, repetitiontype=OPTIONAL"},
+	{name: "e_tag", orcKinds: orcStringKinds, parquetType: parquet.Type_BYTE_ARRAY, parquetTag: "inname=Checksum, type=UTF8, repetitiontype=OPTIONAL"},
+	{name: "is_delete_marker", orcKinds: []proto.Type_Kind{proto.Type_BOOLEAN}, parquetType: parquet.Type_BOOLEAN, parquetTag: "inname=IsDeleteMarker, type=BOOLEAN, repetitiontype=OPTIONAL"},
+	{name: "is_latest", orcKinds: []proto.Type_Kind{proto.Type_BOOLEAN}, parquetType: parquet.Type_BOOLEAN, parquetTag: "inname=IsLatest, type=BOOLEAN, repetitiontype=OPTIONAL"},
+	{name: "version_id", orcKinds: orcStringKinds, parquetType: parquet.Type_BYTE_ARRAY, parquetTag: "inname=VersionID, type=UTF8, repetitiontype=OPTIONAL"},
+	{name: "storage_class", orcKinds: orcStringKinds, parquetType: parquet.Type_BYTE_ARRAY, parquetTag: "inname=StorageClass, type=UTF8, repetitiontype=OPTIONAL"},
+	{name: "is_multipart_uploaded", orcKinds: []proto.Type_Kind{proto.Type_BOOLEAN}, parquetType: parquet.Type_BOOLEAN, parquetTag: "inname=IsMultipartUploaded, type=BOOLEAN, repetitiontype=OPTIONAL"},
+}
+
+// objectSize returns the size held by a value of the size column, which is an integer, a floating point or decimal
+// number with no fractional part, or a string holding one of them. Other values fail with ErrIndexMalformed.
+// Parquet files with an INT64 size column are decoded by parquet-go straight into InventoryObject, with no conversion.
+func objectSize(value interface{}) (int64, error) {
+	switch v := value.(type) {
+	case int64:
+		return v, nil
+	case int32:
+		return int64(v), nil
+	case float64:
+		return floatObjectSize(v)
+	case float32:
+		return floatObjectSize(float64(v))
+	case orc.Double:
+		return floatObjectSize(float64(v))
+	case orc.Float:
+		return floatObjectSize(float64(v))
+	case orc.Decimal:
+		return decimalObjectSize(v)
+	case string:
+		s := strings.TrimSpace(v)
+		if size, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return size, nil
+		}
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return 0, fmt.Errorf("%w: column %q has non-numeric value %q", ErrIndexMalformed, "size", v)
+		}
+		return floatObjectSize(f)
+	default:
+		return 0, fmt.Errorf("%w: column %q has type %T", ErrIndexMalformed, "size", value)
+	}
+}
+
+// rowError reports a malformed value in a row of an inventory file. It matches kind, the row error of the format, as
+// well as the errors wrapped by err, such as ErrIndexMalformed for sizes.
+type rowError struct {
+	kind error
+	err  error
+}
+
+func (e *rowError) Error() string {
+	return fmt.Sprintf("%s: %s", e.kind, e.err)
+}
+
+func (e *rowError) Unwrap() error {
+	return e.err
+}
+
+func (e *rowError) Is(target error) bool {
+	return target == e.kind
+}
+
+func floatObjectSize(f float64) (int64, error) {
+	// float64(math.MaxInt64) rounds up to 2^63, which is out of range
+	if f != math.Trunc(f) || f < math.MinInt64 || f >= math.MaxInt64 {
+		return 0, fmt.Errorf("%w: column %q has value %v, which is not an integral size", ErrIndexMalformed, "size", f)
+	}
+	return int64(f), nil
+}
+
+func decimalObjectSize(d orc.Decimal) (int64, error) {
+	// ORC decimals have a scale between 0 and 38
+	if d.Int == nil || d.Scale < 0 {
+		return 0, fmt.Errorf("%w: column %q has malformed decimal value", ErrIndexMalformed, "size")
+	}
+	r := new(big.Rat).SetFrac(d.Int, new(big.Int).Exp(big.NewInt(10), big.NewInt(d.Scale), nil))
+	if !r.IsInt() || !r.Num().IsInt64() {
+		return 0, fmt.Errorf("%w: column %q has value %s, which is not an integral size", ErrIndexMalformed, "size", d)
+	}
+	return r.Num().Int64(), nil
+}
+
+// IsVersionedSchema returns true if the fileSchema declared by an inventory manifest includes the version_id column,
+// only found in inventories listing all object versions. CSV schemas name the column VersionId.
+func IsVersionedSchema(schema string) bool {
+	fields := strings.FieldsFunc(schema, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_'
+	})
+	for _, field := range fields {
+		if field == "version_id" || field == csvVersionIDColumn {
+			return true
+		}
+	}
+	return false
+}
+
+// parseFileSchema parses the fileSchema declared by a CSV inventory manifest, a comma separated list of column names.
+// Names are trimmed, and may be enclosed in double quotes, in which case they may contain commas and doubled quotes.
+// An empty or duplicate name, or a quote which is not closed or followed by other characters, makes the schema malformed.
+func parseFileSchema(s string) ([]string, error) {
+	var names []string
+	var name strings.Builder
+	quoted := false // inside a quoted name
+	closed := false // after the closing quote of the current name
+	add := func(pos int) error {
+		n := name.String()
+		if !closed {
+			n = strings.TrimSpace(n)
+		}
+		if n == "" {
+			return fmt.Errorf("%w: empty column name at offset %d", ErrMalformedFileSchema, pos)
+		}
+		for _, existing := range names {
+			if existing == n {
+				return fmt.Errorf("%w: duplicate column name %q", ErrMalformedFileSchema, n)
+			}
+		}
+		names = append(names, n)
+		name.Reset()
+		closed = false
+		return nil
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quoted && c == '"' && i+1 < len(s) && s[i+1] == '"':
+			name.WriteByte(c)
+			i++
+		case quoted && c == '"':
+			quoted = false
+			closed = true
+		case quoted:
+			name.WriteByte(c)
+		case c == ',':
+			if err := add(i); err != nil {
+				return nil, err
+			}
+		case closed:
+			if !unicode.IsSpace(rune(c)) {
+				return nil, fmt.Errorf("%w: unexpected %q after quoted column name at offset %d", ErrMalformedFileSchema, c, i)
+			}
+		case c == '"':
+			if strings.TrimSpace(name.String()) != "" {
+				return nil, fmt.Errorf("%w: unexpected quote at offset %d", ErrMalformedFileSchema, i)
+			}
+			name.Reset()
+			quoted = true
+		default:
+			name.WriteByte(c)
+		}
+	}
+	if quoted {
+		return nil, fmt.Errorf("%w: unterminated quoted column name", ErrMalformedFileSchema)
+	}
+	if err := add(len(s)); err != nil {
+		return nil, err
+	}
+	return names, nil
+}
+
+// ColumnNames maps inventory columns, named as in S3 inventories, to the names of the columns holding them in files
+// produced by other tools. Columns missing from the map keep their S3 names.
+type ColumnNames map[string]string
+
+// fileLayout describes how inventory columns are stored in files which do not follow the S3 inventory layout.
+type fileLayout struct {
+	columnNames ColumnNames
+	bucket      string // bucket of all listed objects, for files with no bucket column
+}
+
+// fileColumnName returns the name of the column holding the given inventory column in the file.
+func (l *fileLayout) fileColumnName(column string) string {
+	if name, ok := l.columnNames[column]; ok {
+		return name
+	}
+	return column
+}
+
+// inventoryColumnName returns the inventory column held by the given column of the file, or the name of the file
+// column if it holds none.
+func (l *fileLayout) inventoryColumnName(fileColumn string) string {
+	for column, name := range l.columnNames {
+		if name == fileColumn {
+			return column
+		}
+	}
+	return fileColumn
+}
+
+// LocalFileOption configures the reading of local inventory files produced by tools other than S3.
+type LocalFileOption func(*fileLayout)
+
+// WithColumnNames sets the names of the columns holding the inventory columns in ORC and Parquet files.
+func WithColumnNames(names ColumnNames) LocalFileOption {
+	return func(l *fileLayout) {
+		l.columnNames = names
+	}
+}
+
+// WithBucket sets the bucket of the objects listed in ORC and Parquet files with no bucket column, which are then
+// accepted.
+func WithBucket(bucket string) LocalFileOption {
+	return func(l *fileLayout) {
+		l.bucket = bucket
+	}
+}
+
+// projectionColumns returns the set of inventory columns to read for the given projection, which always includes the
+// required columns. It returns nil if the projection is empty, meaning that all columns are read.
+func projectionColumns(projection []string, required []string) (map[string]bool, error) {
+	if len(projection) == 0 {
+		return nil, nil
+	}
+	known := make(map[string]bool, len(inventoryColumns))
+	for _, column := range inventoryColumns {
+		known[column.name] = true
+	}
+	res := make(map[string]bool, len(projection)+len(required))
+	for _, column := range required {
+		res[column] = true
+	}
+	for _, column := range projection {
+		if !known[column] {
+			return nil, fmt.Errorf("%w: %q", ErrUnknownInventoryColumn, column)
+		}
+		res[column] = true
+	}
+	return res, nil
+}
+
+func orcKindAllowed(kind proto.Type_Kind, allowed []proto.Type_Kind) bool {
+	for _, k := range allowed {
+		if kind == k {
+			return true
+		}
+	}
+	return false
+}
+
+// validateOrcSchema checks that the required inventory columns exist in the given ORC schema,
+// and that all known columns present in the schema have types which can be read into an InventoryObject.
+// Columns are looked up in the schema according to layout.
+func validateOrcSchema(typeDescription *orc.TypeDescription, layout fileLayout) error {
+	fields := make(map[string]bool)
+	for _, field := range typeDescription.Columns() {
+		fields[field] = true
+	}
+	for _, column := range inventoryColumns {
+		name := layout.fileColumnName(column.name)
+		if !fields[name] {
+			if column.required && (column.name != "bucket" || layout.bucket == "") {
+				return fmt.Errorf("%w: %q", ErrMissingInventoryColumn, column.name)
+			}
+			continue
+		}
+		field, err := typeDescription.GetField(name)
+		if err != nil {
+			return err
+		}
+		if kind := field.Type().GetKind(); !orcKindAllowed(kind, column.orcKinds) {
+			return fmt.Errorf("%w: column %q has type %s", ErrIncompatibleInventoryColumn, column.name, kind)
+		}
+	}
+	return nil
+}
+
+type parquetSchemaItem struct {
+	Tag    string
+	Fields []*parquetSchemaItem `json:",omitempty"`
+}
+
+// getParquetSchema validates the schema found in the footer of the given parquet file, in the same manner as validateOrcSchema.
+// It returns a parquet-go JSON schema including only the inventory columns present in the file, to be used for reading it.
+// If columns is not nil, only the columns it contains are included. Columns are looked up in the file according to layout.
+// It also returns whether the schema decodes the size column into parquetRawSizeRow, for files storing it in a column
+// of another type than INT64.
+func getParquetSchema(footer *parquet.FileMetaData, columns map[string]bool, layout fileLayout) (string, bool, error) {
+	columnsByName := make(map[string]inventoryColumn)
+	for _, column := range inventoryColumns {
+		columnsByName[layout.fileColumnName(column.name)] = column
+	}
+	root := parquetSchemaItem{Tag: "name=parquet_go_root, repetitiontype=REQUIRED"}
+	found := make(map[string]bool)
+	rawSize := false
+	// keep the order of the columns in the file
+	for _, element := range footer.GetSchema() {
+		column, ok := columnsByName[element.GetName()]
+		if !ok {
+			continue
+		}
+		tag := column.parquetTag
+		raw := false
+		if !element.IsSetType() || element.GetType() != column.parquetType {
+			rawTag, ok := column.parquetRawTags[element.GetType()]
+			if !element.IsSetType() || !ok {
+				return "", false, fmt.Errorf("%w: column %q has type %s", ErrIncompatibleInventoryColumn, column.name, element.GetType())
+			}
+			tag, raw = rawTag, true
+		}
+		found[column.name] = true
+		if columns != nil && !columns[column.name] {
+			continue
+		}
+		rawSize = rawSize || raw
+		root.Fields = append(root.Fields, &parquetSchemaItem{Tag: "name=" + element.GetName() + ", " + tag})
+	}
+	for _, column := range inventoryColumns {
+		if column.name == "bucket" && layout.bucket != "" {
+			continue
+		}
+		if column.required && !found[column.name] {
+			return "", false, fmt.Errorf("%w: %q", ErrMissingInventoryColumn, column.name)
+		}
+	}
+	res, err := json.Marshal(root)
+	if err != nil {
+		return "", false, err
+	}
+	return string(res), rawSize, nil
+}
+
+// parquetLeafCount returns the number of columns holding values in a parquet schema, excluding groups.
+func parquetLeafCount(elements []*parquet.SchemaElement) int {
+	n := 0
+	for _, element := range elements {
+		if element.GetNumChildren() == 0 {
+			n++
+		}
+	}
+	return n
+}
+
+// readParquetFooter reads the footer of a parquet file, in the same way the parquet-go reader does.
+func readParquetFooter(pf source.ParquetFile) (*parquet.FileMetaData, error) {
+	pr := &reader.ParquetReader{PFile: pf}
+	if err := pr.ReadFooter(); err != nil {
+		return nil, err
+	}
+	return pr.Footer, nil
+}
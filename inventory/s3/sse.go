@@ -0,0 +1,196 @@
+package s3
+
+import (
+	"context"
+	"crypto/md5" //nolint:gosec // MD5 of the key is required by the S3 API
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/treeverse/lakefs/logging"
+)
+
+const (
+	sseCustomerAlgorithm = "AES256"
+	sseCustomerKeyLength = 32
+
+	sseKMSAlgorithm = "aws:kms"
+)
+
+var (
+	ErrInvalidSSECustomerKey = errors.New("invalid SSE-C customer key")
+	ErrInvalidSSEKMSKeyID    = errors.New("invalid SSE-KMS key id")
+	ErrSSEKMSKeyMismatch     = errors.New("inventory file is not encrypted with the expected SSE-KMS key")
+)
+
+// WithSSECustomerKey sets the key used to read inventory files encrypted with a customer provided key (SSE-C).
+// The key must be 32 bytes long, otherwise reading any inventory file fails with ErrInvalidSSECustomerKey. Use
+// NewReaderWithSSECustomerKey to have an invalid key rejected when the reader is created.
+// Files encrypted with SSE-S3 need no configuration, use WithSSEKMSKeyID for files encrypted with SSE-KMS.
+func WithSSECustomerKey(key []byte) ReaderOption {
+	return func(o *Reader) {
+		o.svc = newSSECustomerKeyClient(o.svc, key)
+	}
+}
+
+// WithSSEKMSKeyID sets the KMS key with which inventory files are expected to be encrypted. S3 decrypts files encrypted
+// with SSE-KMS by itself, provided the credentials are allowed kms:Decrypt on their key, so no key is sent when reading
+// them. Instead, the key reported by S3 for every file read is compared with keyID, which is either the id or the ARN of
+// the key, and files encrypted otherwise fail with ErrSSEKMSKeyMismatch. An empty key id makes reading any inventory
+// file fail with ErrInvalidSSEKMSKeyID.
+func WithSSEKMSKeyID(keyID string) ReaderOption {
+	return func(o *Reader) {
+		o.svc = newSSEKMSKeyClient(o.svc, keyID)
+	}
+}
+
+// NewReaderWithSSECustomerKey returns a reader of inventory files using svc, which reads them with the given SSE-C key
+// as set by WithSSECustomerKey. It returns ErrInvalidSSECustomerKey if the key is not 32 bytes long.
+func NewReaderWithSSECustomerKey(ctx context.Context, svc s3iface.S3API, logger logging.Logger, key []byte, opts ...ReaderOption) (*Reader, error) {
+	if err := validateSSECustomerKey(key); err != nil {
+		return nil, err
+	}
+	return NewReader(ctx, svc, logger, append([]ReaderOption{WithSSECustomerKey(key)}, opts...)...), nil
+}
+
+// NewReaderWithSSEKMSKeyID returns a reader of inventory files using svc, which checks that they are encrypted with
+// the given KMS key as set by WithSSEKMSKeyID. It returns ErrInvalidSSEKMSKeyID if the key id is empty.
+func NewReaderWithSSEKMSKeyID(ctx context.Context, svc s3iface.S3API, logger logging.Logger, keyID string, opts ...ReaderOption) (*Reader, error) {
+	if err := validateSSEKMSKeyID(keyID); err != nil {
+		return nil, err
+	}
+	return NewReader(ctx, svc, logger, append([]ReaderOption{WithSSEKMSKeyID(keyID)}, opts...)...), nil
+}
+
+func validateSSECustomerKey(key []byte) error {
+	if len(key) != sseCustomerKeyLength {
+		return fmt.Errorf("%w: expected %d bytes, got %d", ErrInvalidSSECustomerKey, sseCustomerKeyLength, len(key))
+	}
+	return nil
+}
+
+func validateSSEKMSKeyID(keyID string) error {
+	if keyID == "" {
+		return fmt.Errorf("%w: expected a key id or ARN", ErrInvalidSSEKMSKeyID)
+	}
+	return nil
+}
+
+// sseCustomerKeyClient adds SSE-C headers to the requests reading inventory files.
+// It wraps the S3 client so that they are also sent by readers which access S3 directly, such as the Parquet reader.
+type sseCustomerKeyClient struct {
+	s3iface.S3API
+	key    *string
+	keyMD5 *string
+	err    error
+}
+
+func newSSECustomerKeyClient(svc s3iface.S3API, key []byte) *sseCustomerKeyClient {
+	if err := validateSSECustomerKey(key); err != nil {
+		return &sseCustomerKeyClient{S3API: svc, err: err}
+	}
+	keyMD5 := md5.Sum(key) //nolint:gosec
+	return &sseCustomerKeyClient{
+		S3API:  svc,
+		key:    aws.String(string(key)),
+		keyMD5: aws.String(base64.StdEncoding.EncodeToString(keyMD5[:])),
+	}
+}
+
+func (c *sseCustomerKeyClient) GetObject(input *s3.GetObjectInput) (*s3.GetObjectOutput, error) {
+	return c.GetObjectWithContext(aws.BackgroundContext(), input)
+}
+
+func (c *sseCustomerKeyClient) GetObjectWithContext(ctx aws.Context, input *s3.GetObjectInput, opts ...request.Option) (*s3.GetObjectOutput, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+	input.SSECustomerAlgorithm = aws.String(sseCustomerAlgorithm)
+	input.SSECustomerKey = c.key
+	input.SSECustomerKeyMD5 = c.keyMD5
+	return c.S3API.GetObjectWithContext(ctx, input, opts...)
+}
+
+func (c *sseCustomerKeyClient) HeadObject(input *s3.HeadObjectInput) (*s3.HeadObjectOutput, error) {
+	return c.HeadObjectWithContext(aws.BackgroundContext(), input)
+}
+
+func (c *sseCustomerKeyClient) HeadObjectWithContext(ctx aws.Context, input *s3.HeadObjectInput, opts ...request.Option) (*s3.HeadObjectOutput, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+	input.SSECustomerAlgorithm = aws.String(sseCustomerAlgorithm)
+	input.SSECustomerKey = c.key
+	input.SSECustomerKeyMD5 = c.keyMD5
+	return c.S3API.HeadObjectWithContext(ctx, input, opts...)
+}
+
+// sseKMSKeyClient checks that the inventory files it reads are encrypted with the expected KMS key, as reported by S3
+// in the responses reading them.
+type sseKMSKeyClient struct {
+	s3iface.S3API
+	keyID string
+	err   error
+}
+
+func newSSEKMSKeyClient(svc s3iface.S3API, keyID string) *sseKMSKeyClient {
+	return &sseKMSKeyClient{S3API: svc, keyID: keyID, err: validateSSEKMSKeyID(keyID)}
+}
+
+// checkKey returns ErrSSEKMSKeyMismatch unless the object was encrypted with the expected key. S3 reports the ARN of
+// the key, which is matched by the ARN or the id of the expected key.
+func (c *sseKMSKeyClient) checkKey(bucket *string, key *string, encryption *string, keyID *string) error {
+	if aws.StringValue(encryption) != sseKMSAlgorithm {
+		return fmt.Errorf("%w: s3://%s/%s is not encrypted with SSE-KMS", ErrSSEKMSKeyMismatch, aws.StringValue(bucket), aws.StringValue(key))
+	}
+	actual := aws.StringValue(keyID)
+	if actual != c.keyID && !strings.HasSuffix(actual, ":key/"+c.keyID) {
+		return fmt.Errorf("%w: s3://%s/%s is encrypted with key %s, expected %s", ErrSSEKMSKeyMismatch,
+			aws.StringValue(bucket), aws.StringValue(key), actual, c.keyID)
+	}
+	return nil
+}
+
+func (c *sseKMSKeyClient) GetObject(input *s3.GetObjectInput) (*s3.GetObjectOutput, error) {
+	return c.GetObjectWithContext(aws.BackgroundContext(), input)
+}
+
+func (c *sseKMSKeyClient) GetObjectWithContext(ctx aws.Context, input *s3.GetObjectInput, opts ...request.Option) (*s3.GetObjectOutput, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+	output, err := c.S3API.GetObjectWithContext(ctx, input, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.checkKey(input.Bucket, input.Key, output.ServerSideEncryption, output.SSEKMSKeyId); err != nil {
+		if output.Body != nil {
+			_ = output.Body.Close()
+		}
+		return nil, err
+	}
+	return output, nil
+}
+
+func (c *sseKMSKeyClient) HeadObject(input *s3.HeadObjectInput) (*s3.HeadObjectOutput, error) {
+	return c.HeadObjectWithContext(aws.BackgroundContext(), input)
+}
+
+func (c *sseKMSKeyClient) HeadObjectWithContext(ctx aws.Context, input *s3.HeadObjectInput, opts ...request.Option) (*s3.HeadObjectOutput, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+	output, err := c.S3API.HeadObjectWithContext(ctx, input, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.checkKey(input.Bucket, input.Key, output.ServerSideEncryption, output.SSEKMSKeyId); err != nil {
+		return nil, err
+	}
+	return output, nil
+}
@@ -0,0 +1,125 @@
+package s3
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"reflect"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// readerMetrics instruments the downloads and reads of inventory files, labeled by inventory format.
+// Reads are also labeled by the hash of the file key, see fileKeyHash.
+type readerMetrics struct {
+	filesDownloaded  *prometheus.CounterVec
+	bytesDownloaded  *prometheus.GaugeVec
+	downloadDuration *prometheus.HistogramVec
+	rowsRead         *prometheus.CounterVec
+	readDuration     *prometheus.HistogramVec
+}
+
+// newReaderMetrics registers the metrics of inventory readers to reg. Readers sharing reg share their metrics, which
+// are registered by the first of them.
+func newReaderMetrics(reg prometheus.Registerer) *readerMetrics {
+	return &readerMetrics{
+		filesDownloaded: register(reg, prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "inventory_files_downloaded_total",
+			Help: "number of inventory files downloaded",
+		}, []string{"format"})).(*prometheus.CounterVec),
+		bytesDownloaded: register(reg, prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "inventory_downloaded_bytes",
+			Help: "bytes of inventory files downloaded",
+		}, []string{"format"})).(*prometheus.GaugeVec),
+		downloadDuration: register(reg, prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name: "inventory_file_download_duration_seconds",
+			Help: "durations of inventory file downloads",
+		}, []string{"format"})).(*prometheus.HistogramVec),
+		rowsRead: register(reg, prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "inventory_rows_read_total",
+			Help: "number of rows read from inventory files",
+		}, []string{"format", "file"})).(*prometheus.CounterVec),
+		readDuration: register(reg, prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name: "inventory_file_read_duration_seconds",
+			Help: "durations of reading and parsing rows from inventory files",
+		}, []string{"format", "file"})).(*prometheus.HistogramVec),
+	}
+}
+
+// register registers c to reg, unless reg is nil, returning the collector already registered in its place if any.
+// Like promauto, it panics if c cannot be registered for any other reason.
+func register(reg prometheus.Registerer, c prometheus.Collector) prometheus.Collector {
+	if reg == nil {
+		return c
+	}
+	if err := reg.Register(c); err != nil {
+		var alreadyRegistered prometheus.AlreadyRegisteredError
+		if errors.As(err, &alreadyRegistered) {
+			return alreadyRegistered.ExistingCollector
+		}
+		panic(err)
+	}
+	return c
+}
+
+func (m *readerMetrics) reportDownload(format string, start time.Time, sizeBytes int64) {
+	if m == nil {
+		return
+	}
+	m.filesDownloaded.WithLabelValues(format).Inc()
+	m.bytesDownloaded.WithLabelValues(format).Add(float64(sizeBytes))
+	m.downloadDuration.WithLabelValues(format).Observe(time.Since(start).Seconds())
+}
+
+// fileKeyHash returns a short hash of an inventory file key, used as a metric label since keys are long and unique.
+func fileKeyHash(key string) string {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return fmt.Sprintf("%08x", h.Sum32())
+}
+
+// fileReadMetrics counts the rows read from a single inventory file, and times its reads. Inventory file readers
+// record their reads themselves, rather than being wrapped, so that their optional methods remain available.
+type fileReadMetrics struct {
+	rowsRead     prometheus.Counter
+	readDuration prometheus.Observer
+}
+
+// forFile returns the metrics of reading the given file, or nil if no metrics are collected.
+func (m *readerMetrics) forFile(format string, key string) *fileReadMetrics {
+	if m == nil {
+		return nil
+	}
+	file := fileKeyHash(key)
+	return &fileReadMetrics{
+		rowsRead:     m.rowsRead.WithLabelValues(format, file),
+		readDuration: m.readDuration.WithLabelValues(format, file),
+	}
+}
+
+// observeRead records a read started at start into dstInterface, a pointer to a slice of the objects read, which
+// returned err.
+func (m *fileReadMetrics) observeRead(start time.Time, dstInterface interface{}, err error) {
+	if m == nil {
+		return
+	}
+	m.readDuration.Observe(time.Since(start).Seconds())
+	if err == nil {
+		m.rowsRead.Add(float64(reflect.ValueOf(dstInterface).Elem().Len()))
+	}
+}
+
+// setReadMetrics sets the metrics recorded by the reads of fileReader.
+func setReadMetrics(fileReader FileReader, m *fileReadMetrics) {
+	switch r := fileReader.(type) {
+	case *OrcInventoryFileReader:
+		r.metrics = m
+	case *ParquetInventoryFileReader:
+		r.metrics = m
+	case *CSVInventoryFileReader:
+		r.metrics = m
+	case *AvroInventoryFileReader:
+		r.metrics = m
+	}
+}
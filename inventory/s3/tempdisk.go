@@ -0,0 +1,110 @@
+package s3
+
+import (
+	"context"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// WithMaxTempDiskBytes limits the total size of the local copies of inventory files downloaded to the temp dir by the
+// reader, including prefetched files, to maxBytes. A download which would exceed the limit waits until enough of the
+// files already downloaded are freed, once their file readers are closed or the prefetched files are removed, or until
+// the context of the reader is done. A file larger than the limit is downloaded once no other file is held, so that it
+// does not wait forever. Files in the cache dir are not counted. Values smaller than 1 are ignored.
+// Iterating WithSorted keeps every file open at once, so the limit must then fit all of them.
+func WithMaxTempDiskBytes(maxBytes int64) ReaderOption {
+	return func(o *Reader) {
+		if maxBytes >= 1 {
+			o.tempDisk = newTempDiskBudget(maxBytes)
+		}
+	}
+}
+
+// tempDiskBudget tracks the bytes held by local copies of inventory files against a limit.
+type tempDiskBudget struct {
+	limit int64
+	mu    sync.Mutex
+	used  int64
+	freed chan struct{} // closed, and replaced, whenever bytes are released
+}
+
+func newTempDiskBudget(limit int64) *tempDiskBudget {
+	return &tempDiskBudget{limit: limit, freed: make(chan struct{})}
+}
+
+// acquire waits until n bytes fit in the budget, and reserves them.
+func (b *tempDiskBudget) acquire(ctx context.Context, n int64) (*diskReservation, error) {
+	for {
+		b.mu.Lock()
+		if b.used == 0 || b.used+n <= b.limit {
+			b.used += n
+			b.mu.Unlock()
+			return &diskReservation{budget: b, n: n}, nil
+		}
+		freed := b.freed
+		b.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-freed:
+		}
+	}
+}
+
+func (b *tempDiskBudget) release(n int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.used -= n
+	close(b.freed)
+	b.freed = make(chan struct{})
+}
+
+// diskReservation holds bytes of a tempDiskBudget for a local file, until it is released. A nil reservation, made
+// with no budget, releases nothing.
+type diskReservation struct {
+	budget *tempDiskBudget
+	n      int64
+	once   sync.Once
+}
+
+// release returns the bytes of the reservation to its budget. Only the first call has an effect.
+func (r *diskReservation) release() {
+	if r == nil {
+		return
+	}
+	r.once.Do(func() {
+		r.budget.release(r.n)
+	})
+}
+
+// reserveTempDisk reserves room in the temp disk budget of the reader for downloading the given file from the given
+// byte to its end. The size of the file is the one declared by its manifest, if known, or the one reported by S3.
+func (o *Reader) reserveTempDisk(ctx context.Context, bucket string, key string, fromByte int64) (*diskReservation, error) {
+	if o.tempDisk == nil {
+		return nil, nil
+	}
+	size := o.fileChecksum(bucket, key).Size
+	if size <= 0 {
+		headObject, err := o.svc.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(key),
+		})
+		if err != nil {
+			return nil, downloadError(err)
+		}
+		size = aws.Int64Value(headObject.ContentLength)
+	}
+	if fromByte > 0 {
+		size -= fromByte
+	}
+	if size < 0 {
+		size = 0
+	}
+	reservation, err := o.tempDisk.acquire(ctx, size)
+	if err != nil {
+		return nil, downloadError(err)
+	}
+	return reservation, nil
+}
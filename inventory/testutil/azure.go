@@ -0,0 +1,57 @@
+package testutil
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+
+	"github.com/Azure/azure-storage-blob-go/azblob"
+)
+
+// NewFakeAzureServiceURL returns the URL of a fake Azure Blob Storage service, serving the blobs read by the inventory
+// readers: each key of blobs is the path of a blob, as /container/key, and its value the content of the blob.
+// Ranged downloads are served for the x-ms-range header. The server is closed once the test is done.
+func NewFakeAzureServiceURL(tb testing.TB, blobs map[string][]byte) azblob.ServiceURL {
+	tb.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, ok := blobs[r.URL.Path]
+		if !ok {
+			w.Header().Set("x-ms-error-code", string(azblob.ServiceCodeBlobNotFound))
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("ETag", `"etag"`)
+		if r.Method == http.MethodHead {
+			w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		status := http.StatusOK
+		if rangeHeader := r.Header.Get("x-ms-range"); rangeHeader != "" {
+			var start, end int
+			if _, err := fmt.Sscanf(rangeHeader, "bytes=%d-%d", &start, &end); err != nil || start > end || start >= len(data) {
+				w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+				return
+			}
+			if end >= len(data) {
+				end = len(data) - 1
+			}
+			data = data[start : end+1]
+			status = http.StatusPartialContent
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.WriteHeader(status)
+		_, _ = w.Write(data)
+	}))
+	tb.Cleanup(server.Close)
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return azblob.NewServiceURL(*u, azblob.NewPipeline(azblob.NewAnonymousCredential(), azblob.PipelineOptions{
+		Retry: azblob.RetryOptions{MaxTries: 1},
+	}))
+}
@@ -0,0 +1,79 @@
+package testutil
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/option"
+)
+
+// GCSServedBytes counts the bytes of the objects served by a fake Google Cloud Storage server, by object path.
+type GCSServedBytes struct {
+	mu    sync.Mutex
+	bytes map[string]int64
+}
+
+// Get returns the number of bytes of the object at path served so far.
+func (s *GCSServedBytes) Get(path string) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.bytes[path]
+}
+
+func (s *GCSServedBytes) add(path string, n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.bytes[path] += int64(n)
+}
+
+// countingResponseWriter counts the bytes of the object at path written to the response.
+type countingResponseWriter struct {
+	http.ResponseWriter
+	path   string
+	served *GCSServedBytes
+}
+
+func (w *countingResponseWriter) Write(p []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(p)
+	w.served.add(w.path, n)
+	return n, err
+}
+
+// NewFakeGCSClient returns a client of a fake Google Cloud Storage server, serving the objects read by the inventory
+// readers: each key of objects is the path of an object, as /bucket/key, and its value the content of the object.
+// Ranged reads are served for the Range header. The server is closed once the test is done.
+func NewFakeGCSClient(tb testing.TB, objects map[string][]byte) *storage.Client {
+	tb.Helper()
+	client, _ := NewFakeGCSClientCountingBytes(tb, objects)
+	return client
+}
+
+// NewFakeGCSClientCountingBytes is like NewFakeGCSClient, and also returns the count of the bytes served for each
+// object, by which tests check the parts of the objects read.
+func NewFakeGCSClientCountingBytes(tb testing.TB, objects map[string][]byte) (*storage.Client, *GCSServedBytes) {
+	tb.Helper()
+	served := &GCSServedBytes{bytes: make(map[string]int64)}
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, ok := objects[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		http.ServeContent(&countingResponseWriter{ResponseWriter: w, path: r.URL.Path, served: served}, r, r.URL.Path, time.Time{}, bytes.NewReader(data))
+	}))
+	tb.Cleanup(server.Close)
+	client, err := storage.NewClient(context.Background(), option.WithEndpoint(server.URL), option.WithHTTPClient(server.Client()))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() {
+		_ = client.Close()
+	})
+	return client, served
+}
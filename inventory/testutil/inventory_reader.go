@@ -0,0 +1,128 @@
+package testutil
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	inventorys3 "github.com/treeverse/lakefs/inventory/s3"
+)
+
+var ErrMockFileNotFound = errors.New("inventory file not found in mock")
+
+// MockInventoryReader is an in-memory inventorys3.IReader, serving the objects it was created with.
+// Format, schema and bucket are ignored: files are identified by their key only.
+type MockInventoryReader struct {
+	objects   map[string][]inventorys3.InventoryObject
+	mu        sync.Mutex
+	openFiles map[string]int
+}
+
+// NewMockInventoryReader returns a reader serving, for each key in objects, a file holding the given objects in order.
+func NewMockInventoryReader(objects map[string][]inventorys3.InventoryObject) *MockInventoryReader {
+	return &MockInventoryReader{objects: objects, openFiles: make(map[string]int)}
+}
+
+func (m *MockInventoryReader) GetFileReader(_ string, _ string, _ string, key string) (inventorys3.FileReader, error) {
+	objects, ok := m.objects[key]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrMockFileNotFound, key)
+	}
+	m.mu.Lock()
+	m.openFiles[key]++
+	m.mu.Unlock()
+	return &MockFileReader{objects: objects, key: key, mgr: m}, nil
+}
+
+func (m *MockInventoryReader) GetMetadataReader(format string, schema string, bucket string, key string) (inventorys3.MetadataReader, error) {
+	return m.GetFileReader(format, schema, bucket, key)
+}
+
+// OpenFiles returns the number of readers opened for each key which were not closed yet.
+func (m *MockInventoryReader) OpenFiles() map[string]int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	res := make(map[string]int, len(m.openFiles))
+	for key, n := range m.openFiles {
+		if n > 0 {
+			res[key] = n
+		}
+	}
+	return res
+}
+
+// MockFileReader reads the objects of a single file of a MockInventoryReader.
+type MockFileReader struct {
+	objects []inventorys3.InventoryObject
+	nextIdx int
+	key     string
+	mgr     *MockInventoryReader
+	closed  bool
+}
+
+func (r *MockFileReader) Read(dstInterface interface{}) error {
+	dst := dstInterface.(*[]inventorys3.InventoryObject)
+	end := r.nextIdx + len(*dst)
+	if end > len(r.objects) {
+		end = len(r.objects)
+	}
+	res := make([]inventorys3.InventoryObject, end-r.nextIdx)
+	copy(res, r.objects[r.nextIdx:end])
+	r.nextIdx = end
+	*dst = res
+	return nil
+}
+
+func (r *MockFileReader) Rewind() error {
+	r.nextIdx = 0
+	return nil
+}
+
+// SkipRows advances the reader past the next num objects.
+func (r *MockFileReader) SkipRows(num int64) error {
+	r.nextIdx += int(num)
+	if r.nextIdx > len(r.objects) {
+		r.nextIdx = len(r.objects)
+	}
+	return nil
+}
+
+// BytesRead returns 0, as the objects of mock files are held in memory.
+func (r *MockFileReader) BytesRead() int64 {
+	return 0
+}
+
+func (r *MockFileReader) GetNumRows() int64 {
+	return int64(len(r.objects))
+}
+
+func (r *MockFileReader) FirstObjectKey() string {
+	var min string
+	for i, obj := range r.objects {
+		if i == 0 || obj.Key < min {
+			min = obj.Key
+		}
+	}
+	return min
+}
+
+func (r *MockFileReader) LastObjectKey() string {
+	var max string
+	for _, obj := range r.objects {
+		if obj.Key > max {
+			max = obj.Key
+		}
+	}
+	return max
+}
+
+func (r *MockFileReader) Close() error {
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+	r.mgr.mu.Lock()
+	r.mgr.openFiles[r.key]--
+	r.mgr.mu.Unlock()
+	return nil
+}
@@ -0,0 +1,39 @@
+package testutil_test
+
+import (
+	"fmt"
+
+	inventorys3 "github.com/treeverse/lakefs/inventory/s3"
+	"github.com/treeverse/lakefs/inventory/testutil"
+)
+
+func ExampleNewMockInventoryReader() {
+	manifestFiles := []string{"f1.orc", "f2.orc"}
+	reader := testutil.NewMockInventoryReader(map[string][]inventorys3.InventoryObject{
+		"f1.orc": {{Bucket: "b", Key: "a/1"}, {Bucket: "b", Key: "a/2"}},
+		"f2.orc": {{Bucket: "b", Key: "b/1"}, {Bucket: "b", Key: "b/2"}, {Bucket: "b", Key: "b/3"}},
+	})
+	for _, key := range manifestFiles {
+		fileReader, err := reader.GetFileReader(inventorys3.OrcFormatName, "", "b", key)
+		if err != nil {
+			panic(err)
+		}
+		if err = fileReader.(*testutil.MockFileReader).SkipRows(1); err != nil {
+			panic(err)
+		}
+		objects := make([]inventorys3.InventoryObject, fileReader.GetNumRows())
+		if err = fileReader.Read(&objects); err != nil {
+			panic(err)
+		}
+		for _, obj := range objects {
+			fmt.Println(obj.GetPhysicalAddress())
+		}
+		_ = fileReader.Close()
+	}
+	fmt.Println("open files:", len(reader.OpenFiles()))
+	// Output:
+	// s3://b/a/2
+	// s3://b/b/2
+	// s3://b/b/3
+	// open files: 0
+}